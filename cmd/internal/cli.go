@@ -6,14 +6,24 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"sort"
+	"strings"
+	"time"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+	"github.com/amenzhinsky/golang-iothub/iotutil"
 )
 
 // ErrInvalidUsage when returned by a Handler the usage message is displayed.
 var ErrInvalidUsage = errors.New("invalid usage")
 
 // Command is a cli subcommand.
+//
+// A command that has Subcommands is a group, e.g. "device" in
+// "device list", its Handler is only called when there are arguments
+// that don't start with one of the subcommand names.
 type Command struct {
 	Name      string
 	Alias     string
@@ -21,6 +31,15 @@ type Command struct {
 	Desc      string
 	Handler   HandlerFunc
 	ParseFunc func(*flag.FlagSet)
+
+	// Examples are printed in the command usage, one per line.
+	Examples []string
+
+	// Subcommands makes the command a group of nested commands.
+	Subcommands []*Command
+
+	// Hidden commands work but are not listed, e.g. deprecated names.
+	Hidden bool
 }
 
 // HandlerFunc is a subcommand handler, fs is already parsed.
@@ -34,27 +53,74 @@ type CLI struct {
 	desc string
 	cmds []*Command
 	main FlagFunc
+
+	// global flags available to all subcommands
+	debug   bool
+	timeout time.Duration
+	query   string
 }
 
+// outputQuery is applied to everything printed by OutputJSON
+// and OutputMessage, it's set by the global query flag.
+var outputQuery string
+
 // New creates new cli executor.
 func New(desc string, f FlagFunc, cmds []*Command) (*CLI, error) {
-	r := &CLI{
+	if err := validateCommands(cmds); err != nil {
+		return nil, err
+	}
+	return &CLI{
 		desc: desc,
-		cmds: make([]*Command, len(cmds)),
+		cmds: sortCommands(cmds),
 		main: f,
+	}, nil
+}
+
+func validateCommands(cmds []*Command) error {
+	seen := map[string]bool{}
+	for _, cmd := range cmds {
+		for _, k := range []string{cmd.Name, cmd.Alias} {
+			if k == "" {
+				continue
+			}
+			if seen[k] {
+				return fmt.Errorf("duplicate command name %q", k)
+			}
+			seen[k] = true
+		}
+		if cmd.Subcommands == nil && cmd.Handler == nil {
+			return fmt.Errorf("command %q has no handler", cmd.Name)
+		}
+		if err := validateCommands(cmd.Subcommands); err != nil {
+			return err
+		}
 	}
-	copy(r.cmds, cmds)
+	return nil
+}
 
-	// sort subcommands alphabetically
-	sort.Slice(r.cmds, func(i, j int) bool {
-		return r.cmds[i].Name < r.cmds[j].Name
+// sortCommands returns a copy of cmds with commands
+// of all levels sorted alphabetically.
+func sortCommands(cmds []*Command) []*Command {
+	if cmds == nil {
+		return nil
+	}
+	l := make([]*Command, len(cmds))
+	for i, cmd := range cmds {
+		c := *cmd
+		c.Subcommands = sortCommands(cmd.Subcommands)
+		l[i] = &c
+	}
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].Name < l[j].Name
 	})
-	return r, nil
+	return l
 }
 
 const (
 	commonUsage  = "usage: %s [FLAGS...] {COMMAND} [FLAGS...] [ARGS]...\n\n%s\n\ncommands:\n"
-	commandUsage = "usage: %s [FLAGS...] %s [FLAGS....] %s\n\nflags:\n"
+	groupUsage   = "usage: %s [FLAGS...] %s {COMMAND} [FLAGS...] [ARGS]...\n\n%s\n\ncommands:\n"
+	commandUsage = "usage: %s [FLAGS...] %s [FLAGS....] %s\n\n%s\n\nflags:\n"
+	exitUsage    = "exit codes: 1 error, %d not found, %d unauthorized, %d throttled, %d timeout\n"
 )
 
 // Run runs one or the given commands based on argv.
@@ -69,18 +135,24 @@ func (r *CLI) Run(ctx context.Context, argv ...string) error {
 	}
 
 	sm := flag.NewFlagSet(argv[0], flag.ContinueOnError)
+	sm.BoolVar(&r.debug, "debug", r.debug, "log requests and transport frames to stderr")
+	sm.DurationVar(&r.timeout, "timeout", r.timeout, "abort the command after the given period, zero means no timeout")
+	sm.StringVar(&r.query, "query", r.query, "print only the given dotted path of json output, e.g. [].deviceId")
 	if r.main != nil {
 		r.main(sm)
 	}
-	sm.Usage = func() {
-		fmt.Fprintf(os.Stderr, commonUsage, sm.Name(), r.desc)
-		for _, cmd := range r.cmds {
-			fmt.Fprintf(os.Stderr, "  %-22s %s\n", cmd.Name+","+cmd.Alias, cmd.Desc)
-		}
+	commonFlags := func() {
 		fmt.Fprintln(os.Stderr)
 		fmt.Fprintln(os.Stderr, "common flags: ")
 		sm.PrintDefaults()
 	}
+	sm.Usage = func() {
+		fmt.Fprintf(os.Stderr, commonUsage, sm.Name(), r.desc)
+		printCommands(r.cmds)
+		commonFlags()
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintf(os.Stderr, exitUsage, ExitNotFound, ExitUnauthorized, ExitThrottled, ExitTimeout)
+	}
 
 	if err := sm.Parse(argv[1:]); err != nil {
 		if err == flag.ErrHelp {
@@ -89,34 +161,72 @@ func (r *CLI) Run(ctx context.Context, argv ...string) error {
 		return err
 	}
 
-	if sm.NArg() == 0 {
-		sm.Usage()
-		return ErrInvalidUsage
-	}
-
-	cmd := r.findCommand(sm.Arg(0))
-	if cmd == nil {
-		sm.Usage()
-		return ErrInvalidUsage
+	// walk down the groups tree until a leaf command is found
+	var (
+		args = sm.Args()
+		path []string
+		cmds = r.cmds
+		cmd  *Command
+	)
+	for {
+		if len(args) == 0 || findCommand(cmds, args[0]) == nil {
+			if cmd != nil && cmd.Handler != nil && len(args) != 0 {
+				break // group that is a command itself
+			}
+			if cmd == nil {
+				sm.Usage()
+			} else {
+				fmt.Fprintf(os.Stderr, groupUsage, sm.Name(), strings.Join(path, " "), cmd.Desc)
+				printCommands(cmd.Subcommands)
+				commonFlags()
+			}
+			return ErrInvalidUsage
+		}
+		cmd = findCommand(cmds, args[0])
+		path = append(path, args[0])
+		args = args[1:]
+		if cmd.Subcommands == nil {
+			break
+		}
+		cmds = cmd.Subcommands
 	}
 
-	sc := flag.NewFlagSet(sm.Arg(0), flag.ContinueOnError)
+	name := strings.Join(path, " ")
+	sc := flag.NewFlagSet(name, flag.ContinueOnError)
 	sc.Usage = func() {
-		fmt.Fprintf(os.Stderr, commandUsage, sm.Name(), sm.Arg(0), cmd.Help)
+		fmt.Fprintf(os.Stderr, commandUsage, sm.Name(), name, cmd.Help, cmd.Desc)
 		sc.PrintDefaults()
-		fmt.Fprintln(os.Stderr)
-		fmt.Fprintln(os.Stderr, "common flags: ")
-		sm.PrintDefaults()
+		if len(cmd.Examples) != 0 {
+			fmt.Fprintln(os.Stderr)
+			fmt.Fprintln(os.Stderr, "examples:")
+			for _, ex := range cmd.Examples {
+				fmt.Fprintf(os.Stderr, "  %s %s\n", sm.Name(), ex)
+			}
+		}
+		commonFlags()
 	}
 	if cmd.ParseFunc != nil {
 		cmd.ParseFunc(sc)
 	}
-	if err := sc.Parse(sm.Args()[1:]); err != nil {
+	if err := sc.Parse(args); err != nil {
 		if err == flag.ErrHelp {
 			return ErrInvalidUsage
 		}
 		return err
 	}
+
+	if r.query != "" {
+		if _, err := parseQuery(r.query); err != nil {
+			return err
+		}
+		outputQuery = r.query
+	}
+	ctx = context.WithValue(ctx, debugKey{}, r.debug)
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
 	if err := cmd.Handler(ctx, sc); err != nil {
 		if err == ErrInvalidUsage {
 			sc.Usage()
@@ -126,8 +236,30 @@ func (r *CLI) Run(ctx context.Context, argv ...string) error {
 	return nil
 }
 
-func (r *CLI) findCommand(k string) *Command {
-	for _, cmd := range r.cmds {
+func printCommands(cmds []*Command) {
+	for _, cmd := range cmds {
+		if cmd.Hidden {
+			continue
+		}
+		name := cmd.Name
+		if cmd.Alias != "" {
+			name += "," + cmd.Alias
+		}
+		fmt.Fprintf(os.Stderr, "  %-22s %s\n", name, cmd.Desc)
+	}
+}
+
+type debugKey struct{}
+
+// Debug reports whether the global debug flag is set for
+// the command executed with ctx.
+func Debug(ctx context.Context) bool {
+	v, _ := ctx.Value(debugKey{}).(bool)
+	return v
+}
+
+func findCommand(cmds []*Command, k string) *Command {
+	for _, cmd := range cmds {
 		if cmd.Name == k || cmd.Alias == k {
 			return cmd
 		}
@@ -154,12 +286,104 @@ func OutputLine(format string) error {
 	return err
 }
 
-// OutputJSON prints indented json to stdout.
+// OutputJSON prints indented json to stdout, when the global query flag
+// is set only the queried part is printed and strings are printed as is.
 func OutputJSON(v interface{}) error {
+	if outputQuery == "" {
+		return WriteJSON(os.Stdout, v)
+	}
+	r, err := Query(v, outputQuery)
+	if err != nil {
+		return err
+	}
+	if s, ok := r.(string); ok {
+		return OutputLine(s)
+	}
+	return WriteJSON(os.Stdout, r)
+}
+
+// WriteJSON writes indented json to w appending a new-line char.
+func WriteJSON(w io.Writer, v interface{}) error {
 	b, err := json.MarshalIndent(v, "", "\t")
 	if err != nil {
 		return err
 	}
-	fmt.Println(string(b))
-	return nil
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+// OutputMessage prints the given message as indented json, when format
+// is not empty the payload is excluded and printed after it formatted
+// with iotutil.FormatPayload truncated to max bytes.
+func OutputMessage(msg *common.Message, format string, max int) error {
+	if format == "" {
+		return OutputJSON(msg)
+	}
+	return WriteMessage(os.Stdout, msg, format, max)
+}
+
+// WriteMessage is OutputMessage that writes to w.
+func WriteMessage(w io.Writer, msg *common.Message, format string, max int) error {
+	if format == "" {
+		return WriteJSON(w, msg)
+	}
+	f, err := iotutil.ParsePayloadFormat(format)
+	if err != nil {
+		return err
+	}
+	m := *msg
+	m.Payload = nil
+	if err = WriteJSON(w, &m); err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, iotutil.FormatPayload(msg.Payload,
+		iotutil.WithFormat(f),
+		iotutil.WithMaxLength(max),
+	))
+	return err
+}
+
+// ReadMessages decodes a stream of json messages, in the format
+// OutputMessage produces without a payload format, calling fn for each.
+func ReadMessages(r io.Reader, fn func(msg *common.Message) error) error {
+	dec := json.NewDecoder(r)
+	for {
+		var msg common.Message
+		if err := dec.Decode(&msg); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := fn(&msg); err != nil {
+			return err
+		}
+	}
+}
+
+// Exit codes of failures that scripts can branch on,
+// other errors exit with 1.
+const (
+	ExitNotFound     = 3
+	ExitUnauthorized = 4
+	ExitThrottled    = 5
+	ExitTimeout      = 6
+)
+
+// ExitCode returns the process exit code for the given command error.
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, common.ErrNotFound):
+		return ExitNotFound
+	case errors.Is(err, common.ErrUnauthorized):
+		return ExitUnauthorized
+	case errors.Is(err, common.ErrThrottled):
+		return ExitThrottled
+	case errors.Is(err, common.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return ExitTimeout
+	default:
+		return 1
+	}
 }
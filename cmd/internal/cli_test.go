@@ -3,12 +3,15 @@ package internal
 import (
 	"context"
 	"flag"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/amenzhinsky/golang-iothub/common"
 )
 
 func TestRun(t *testing.T) {
@@ -22,13 +25,14 @@ func TestRun(t *testing.T) {
 			f.StringVar(&commonFlag, "c", "", "common flag")
 		}, []*Command{
 			{
-				"test", "t",
-				"test A B",
-				"just a test",
-				func(_ context.Context, f *flag.FlagSet) error {
+				Name:  "test",
+				Alias: "t",
+				Help:  "test A B",
+				Desc:  "just a test",
+				Handler: func(_ context.Context, f *flag.FlagSet) error {
 					return OutputLine(strings.Join(f.Args(), ""))
 				},
-				func(fs *flag.FlagSet) {
+				ParseFunc: func(fs *flag.FlagSet) {
 					fs.StringVar(&commandFlag, "s", "", "command flag")
 				},
 			},
@@ -57,6 +61,90 @@ func TestRun(t *testing.T) {
 	}
 }
 
+func TestRunGlobalFlags(t *testing.T) {
+	t.Parallel()
+
+	var (
+		debug    bool
+		deadline bool
+	)
+	cli, err := New("test desc", nil, []*Command{
+		{
+			Name:  "test",
+			Alias: "t",
+			Desc:  "just a test",
+			Handler: func(ctx context.Context, f *flag.FlagSet) error {
+				debug = Debug(ctx)
+				_, deadline = ctx.Deadline()
+				return nil
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cli.Run(context.Background(), "run", "-debug", "-timeout", "1m", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if !debug || !deadline {
+		t.Errorf("debug = %t, deadline = %t, want both set", debug, deadline)
+	}
+}
+
+func TestRunGroups(t *testing.T) {
+	t.Parallel()
+
+	var called []string
+	handler := func(name string) HandlerFunc {
+		return func(_ context.Context, f *flag.FlagSet) error {
+			called = append(called, name+strings.Join(f.Args(), ""))
+			return nil
+		}
+	}
+	cli, err := New("test desc", nil, []*Command{
+		{
+			Name:    "device",
+			Alias:   "d",
+			Handler: handler("device"),
+			Subcommands: []*Command{
+				{Name: "list", Alias: "ls", Handler: handler("list")},
+			},
+		},
+		{
+			Name: "job",
+			Subcommands: []*Command{
+				{Name: "get", Handler: handler("get")},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, argv := range [][]string{
+		{"run", "device", "list"},
+		{"run", "d", "ls", "a"},
+		{"run", "device", "mydevice"},
+		{"run", "job", "get", "b"},
+	} {
+		if err = cli.Run(context.Background(), argv...); err != nil {
+			t.Fatalf("Run(%v) error = %v", argv, err)
+		}
+	}
+	if want := []string{"list", "lista", "devicemydevice", "getb"}; !reflect.DeepEqual(called, want) {
+		t.Errorf("called = %v, want %v", called, want)
+	}
+	if err = cli.Run(context.Background(), "run", "job", "unknown"); err != ErrInvalidUsage {
+		t.Errorf("Run(job unknown) error = %v, want %v", err, ErrInvalidUsage)
+	}
+
+	if _, err = New("", nil, []*Command{
+		{Name: "a", Handler: handler("a")},
+		{Name: "b", Alias: "a", Handler: handler("b")},
+	}); err == nil {
+		t.Error("expected duplicate command names error")
+	}
+}
+
 // capture stdout
 func capture(fn func() error) ([]byte, error) {
 	f, err := ioutil.TempFile("", "")
@@ -95,3 +183,46 @@ func TestArgsToMap(t *testing.T) {
 		}
 	}
 }
+
+func TestReadMessages(t *testing.T) {
+	t.Parallel()
+
+	var b strings.Builder
+	for _, mid := range []string{"1", "2"} {
+		if err := WriteMessage(&b, &common.Message{
+			MessageID: mid,
+			Payload:   []byte("hello"),
+		}, "", 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	if err := ReadMessages(strings.NewReader(b.String()), func(msg *common.Message) error {
+		got = append(got, msg.MessageID+":"+string(msg.Payload))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1:hello", "2:hello"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("messages = %v, want %v", got, want)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	t.Parallel()
+
+	for err, want := range map[error]int{
+		nil:                                      0,
+		ErrInvalidUsage:                          1,
+		common.ErrNotFound:                       ExitNotFound,
+		common.ErrUnauthorized:                   ExitUnauthorized,
+		common.ErrThrottled:                      ExitThrottled,
+		context.DeadlineExceeded:                 ExitTimeout,
+		fmt.Errorf("get: %w", common.ErrTimeout): ExitTimeout,
+	} {
+		if got := ExitCode(err); got != want {
+			t.Errorf("ExitCode(%v) = %d, want %d", err, got, want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Query extracts a part of the json representation of v.
+//
+// The query is a dotted path of object keys and list indexes,
+// e.g. "properties.reported.version" or "[0].deviceId",
+// an empty index "[]" applies the rest of the path to every
+// element of a list, e.g. "[].deviceId". Missing keys yield nil.
+func Query(v interface{}, q string) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var x interface{}
+	if err = json.Unmarshal(b, &x); err != nil {
+		return nil, err
+	}
+	path, err := parseQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	return query(x, path)
+}
+
+// parseQuery splits a query into keys and index segments,
+// indexes are kept in brackets to be distinguished from keys.
+func parseQuery(q string) ([]string, error) {
+	var path []string
+	for _, part := range strings.Split(q, ".") {
+		if part == "" {
+			continue
+		}
+		key := part
+		if i := strings.IndexByte(part, '['); i != -1 {
+			key = part[:i]
+		}
+		if key != "" {
+			path = append(path, key)
+		}
+		for rest := part[len(key):]; rest != ""; {
+			i := strings.IndexByte(rest, ']')
+			if rest[0] != '[' || i == -1 {
+				return nil, fmt.Errorf("malformed query segment %q", part)
+			}
+			path = append(path, rest[:i+1])
+			rest = rest[i+1:]
+		}
+	}
+	return path, nil
+}
+
+func query(v interface{}, path []string) (interface{}, error) {
+	for i, seg := range path {
+		if v == nil {
+			return nil, nil
+		}
+		if !strings.HasPrefix(seg, "[") {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("cannot get %q of a non-object", seg)
+			}
+			v = m[seg]
+			continue
+		}
+
+		l, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("cannot index a non-list with %s", seg)
+		}
+		if seg == "[]" {
+			r := make([]interface{}, 0, len(l))
+			for _, e := range l {
+				x, err := query(e, path[i+1:])
+				if err != nil {
+					return nil, err
+				}
+				r = append(r, x)
+			}
+			return r, nil
+		}
+		n, err := strconv.Atoi(seg[1 : len(seg)-1])
+		if err != nil {
+			return nil, fmt.Errorf("malformed index %s", seg)
+		}
+		if n < 0 {
+			n += len(l)
+		}
+		if n < 0 || n >= len(l) {
+			return nil, nil
+		}
+		v = l[n]
+	}
+	return v, nil
+}
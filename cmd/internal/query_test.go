@@ -0,0 +1,39 @@
+package internal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestQuery(t *testing.T) {
+	t.Parallel()
+
+	v := []map[string]interface{}{
+		{"deviceId": "a", "tags": map[string]interface{}{"floor": 1}},
+		{"deviceId": "b", "list": []int{1, 2, 3}},
+	}
+	for q, want := range map[string]interface{}{
+		"[0].deviceId":   "a",
+		"[-1].deviceId":  "b",
+		"[5]":            nil,
+		"[].deviceId":    []interface{}{"a", "b"},
+		"[0].tags.floor": float64(1),
+		"[1].list[1]":    float64(2),
+		"[].missing.key": []interface{}{nil, nil},
+	} {
+		got, err := Query(v, q)
+		if err != nil {
+			t.Errorf("Query(%q) error = %v", q, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Query(%q) = %#v, want %#v", q, got, want)
+		}
+	}
+
+	for _, q := range []string{"deviceId", "[0].deviceId[0]", "[x]", "[0"} {
+		if _, err := Query(v, q); err == nil {
+			t.Errorf("Query(%q) expected an error", q)
+		}
+	}
+}
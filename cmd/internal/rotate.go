@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// RotatingFile is an append-only file writer that moves the current file
+// aside when it grows over a size limit or gets older than a time limit.
+//
+// Rotated files are named after the original file with a UTC timestamp
+// suffix, and are optionally gzip compressed.
+type RotatingFile struct {
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+	gzip    bool
+
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	created time.Time
+	now     func() time.Time
+}
+
+// NewRotatingFile opens the named file for appending, zero maxSize
+// or maxAge disable the corresponding rotation condition.
+func NewRotatingFile(path string, maxSize int64, maxAge time.Duration, compress bool) (*RotatingFile, error) {
+	if path == "" {
+		return nil, errors.New("path is empty")
+	}
+	r := &RotatingFile{
+		path:    path,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+		gzip:    compress,
+		now:     time.Now,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f = f
+	r.size = fi.Size()
+	r.created = r.now()
+	return nil
+}
+
+// Write writes b to the current file, rotating it first when
+// b doesn't fit or the file is too old. A single write that is larger
+// than the size limit still goes to a single file.
+func (r *RotatingFile) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return 0, os.ErrClosed
+	}
+	if r.size > 0 &&
+		(r.maxSize > 0 && r.size+int64(len(b)) > r.maxSize ||
+			r.maxAge > 0 && r.now().Sub(r.created) >= r.maxAge) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(b)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *RotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	r.f = nil
+	name := r.path + "." + r.now().UTC().Format("20060102T150405.000000000")
+	if err := os.Rename(r.path, name); err != nil {
+		return err
+	}
+	if r.gzip {
+		if err := compressFile(name); err != nil {
+			return err
+		}
+	}
+	return r.open()
+}
+
+// compressFile replaces the named file with its gzipped version.
+func compressFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(name+".gz", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := gzip.NewWriter(dst)
+	if _, err = io.Copy(w, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err = w.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+// Close closes the current file, rotated files are left intact.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return nil
+	}
+	err := r.f.Close()
+	r.f = nil
+	return err
+}
@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRotatingFile(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range map[string]struct {
+		maxSize int64
+		maxAge  time.Duration
+		gzip    bool
+		files   int
+	}{
+		"size":  {maxSize: 10, files: 3},
+		"age":   {maxAge: time.Minute, files: 3},
+		"gzip":  {maxSize: 10, gzip: true, files: 3},
+		"never": {files: 1},
+	} {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			dir, err := ioutil.TempDir("", "rotate")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			r, err := NewRotatingFile(filepath.Join(dir, "out"), tc.maxSize, tc.maxAge, tc.gzip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			now := time.Now()
+			r.now = func() time.Time { return now }
+			for i := 0; i < 3; i++ {
+				if _, err = r.Write([]byte("0123456789")); err != nil {
+					t.Fatal(err)
+				}
+				now = now.Add(time.Minute)
+			}
+			if err = r.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			names, err := filepath.Glob(filepath.Join(dir, "*"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(names)
+			if len(names) != tc.files {
+				t.Fatalf("files = %v, want %d files", names, tc.files)
+			}
+			for _, name := range names {
+				b, err := readFile(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := 10 * (4 - tc.files); len(b) != want {
+					t.Errorf("%s size = %d, want %d", filepath.Base(name), len(b), want)
+				}
+			}
+		})
+	}
+}
+
+func readFile(name string) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if filepath.Ext(name) != ".gz" {
+		return ioutil.ReadAll(f)
+	}
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
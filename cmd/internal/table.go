@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// OutputTable prints items as a table to stdout, see WriteTable.
+func OutputTable(items interface{}, columns []string, sortBy string) error {
+	return WriteTable(os.Stdout, items, columns, sortBy)
+}
+
+// WriteTable writes a slice of json-serializable items as a table.
+//
+// Columns are json field names, nested fields are separated by dots,
+// e.g. "authentication.type". Rows are sorted by the sortBy column
+// when it's not empty, a leading minus reverses the order.
+// Numeric columns are compared numerically.
+func WriteTable(w io.Writer, items interface{}, columns []string, sortBy string) error {
+	if len(columns) == 0 {
+		return errors.New("no columns given")
+	}
+	rows, err := tableRows(items, columns)
+	if err != nil {
+		return err
+	}
+	if sortBy != "" {
+		desc := strings.HasPrefix(sortBy, "-")
+		col := -1
+		for i, c := range columns {
+			if c == strings.TrimPrefix(sortBy, "-") {
+				col = i
+			}
+		}
+		if col == -1 {
+			return fmt.Errorf("unknown sort column %q", strings.TrimPrefix(sortBy, "-"))
+		}
+		sort.SliceStable(rows, func(i, j int) bool {
+			if desc {
+				return lessCell(rows[j][col], rows[i][col])
+			}
+			return lessCell(rows[i][col], rows[j][col])
+		})
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	header := make([]string, len(columns))
+	for i, c := range columns {
+		header[i] = strings.ToUpper(c)
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// ParseColumns splits a comma-separated columns list.
+func ParseColumns(s string) []string {
+	var cols []string
+	for _, c := range strings.Split(s, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			cols = append(cols, c)
+		}
+	}
+	return cols
+}
+
+func tableRows(items interface{}, columns []string) ([][]string, error) {
+	b, err := json.Marshal(items)
+	if err != nil {
+		return nil, err
+	}
+	var l []map[string]interface{}
+	if err = json.Unmarshal(b, &l); err != nil {
+		return nil, errors.New("items must be a list of objects")
+	}
+	rows := make([][]string, 0, len(l))
+	for _, m := range l {
+		row := make([]string, len(columns))
+		for i, c := range columns {
+			row[i] = formatCell(lookup(m, c))
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func lookup(m map[string]interface{}, path string) interface{} {
+	var v interface{} = m
+	for _, k := range strings.Split(path, ".") {
+		o, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = o[k]
+	}
+	return v
+}
+
+func formatCell(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "-"
+	case string:
+		if v == "" {
+			return "-"
+		}
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+}
+
+func lessCell(a, b string) bool {
+	x, err1 := strconv.ParseFloat(a, 64)
+	y, err2 := strconv.ParseFloat(b, 64)
+	if err1 == nil && err2 == nil {
+		return x < y
+	}
+	return a < b
+}
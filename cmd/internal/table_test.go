@@ -0,0 +1,45 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteTable(t *testing.T) {
+	t.Parallel()
+
+	items := []map[string]interface{}{
+		{"id": "b", "count": 10, "auth": map[string]string{"type": "sas"}},
+		{"id": "a", "count": 9},
+		{"id": "c", "count": 100, "auth": map[string]string{"type": "selfSigned"}},
+	}
+	for sortBy, want := range map[string]string{
+		"": `ID  COUNT  AUTH.TYPE
+b   10     sas
+a   9      -
+c   100    selfSigned
+`,
+		"count": `ID  COUNT  AUTH.TYPE
+a   9      -
+b   10     sas
+c   100    selfSigned
+`,
+		"-id": `ID  COUNT  AUTH.TYPE
+c   100    selfSigned
+b   10     sas
+a   9      -
+`,
+	} {
+		var b strings.Builder
+		if err := WriteTable(&b, items, ParseColumns("id, count,auth.type"), sortBy); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != want {
+			t.Errorf("sort by %q:\n%s\nwant:\n%s", sortBy, b.String(), want)
+		}
+	}
+
+	if err := WriteTable(&strings.Builder{}, items, []string{"id"}, "missing"); err == nil {
+		t.Error("expected unknown sort column error")
+	}
+}
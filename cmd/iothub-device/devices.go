@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/amenzhinsky/golang-iothub/cmd/internal"
+	"github.com/amenzhinsky/golang-iothub/common"
+	"github.com/amenzhinsky/golang-iothub/iotdevice"
+)
+
+// deviceCredentials is an entry of a multi-device credentials file.
+type deviceCredentials struct {
+	DeviceID         string `json:"deviceId"`
+	ConnectionString string `json:"connectionString"`
+}
+
+// loadDevices reads device connection strings from the named file.
+//
+// Json files contain a list of {"connectionString": "..."} objects,
+// csv files have connection strings in the first column,
+// a header row and lines that start with # are skipped.
+func loadDevices(path string) ([]*deviceCredentials, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var l []*deviceCredentials
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		if err = json.NewDecoder(f).Decode(&l); err != nil {
+			return nil, err
+		}
+	} else {
+		r := csv.NewReader(f)
+		r.Comment = '#'
+		r.FieldsPerRecord = -1
+		for {
+			rec, err := r.Read()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			if cs := strings.TrimSpace(rec[0]); strings.HasPrefix(cs, "HostName=") {
+				l = append(l, &deviceCredentials{ConnectionString: cs})
+			}
+		}
+	}
+	if len(l) == 0 {
+		return nil, fmt.Errorf("no devices found in %s", path)
+	}
+	for _, d := range l {
+		creds, err := common.ParseConnectionString(d.ConnectionString)
+		if err != nil {
+			return nil, err
+		}
+		d.DeviceID = creds.DeviceID
+	}
+	return l, nil
+}
+
+// runMulti runs fn for every device of the devices file,
+// at most concurrencyFlag devices run at the same time.
+func runMulti(
+	ctx context.Context,
+	f *flag.FlagSet,
+	fn func(context.Context, *flag.FlagSet, *iotdevice.Client) error,
+) error {
+	if concurrencyFlag <= 0 {
+		return errors.New("concurrency must be positive")
+	}
+	devices, err := loadDevices(devicesFlag)
+	if err != nil {
+		return err
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+		sem  = make(chan struct{}, concurrencyFlag)
+	)
+loop:
+	for _, d := range devices {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			break loop
+		}
+		wg.Add(1)
+		go func(d *deviceCredentials) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			err := func() error {
+				c, err := newClient(ctx, iotdevice.WithConnectionString(d.ConnectionString))
+				if err != nil {
+					return err
+				}
+				defer c.Close()
+				return fn(ctx, f, c)
+			}()
+			if err != nil && err != context.Canceled {
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("%s: %w", d.DeviceID, err))
+				mu.Unlock()
+			}
+		}(d)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+var simulateIntervalFlag = 5 * time.Second
+
+func simulateFlags(f *flag.FlagSet) {
+	f.DurationVar(&simulateIntervalFlag, "interval", simulateIntervalFlag, "interval between messages")
+}
+
+// simulate sends the given payload or a generated one every interval.
+func simulate(ctx context.Context, f *flag.FlagSet, c *iotdevice.Client) error {
+	if f.NArg() > 1 {
+		return internal.ErrInvalidUsage
+	}
+	if simulateIntervalFlag <= 0 {
+		return errors.New("interval must be positive")
+	}
+	t := time.NewTicker(simulateIntervalFlag)
+	defer t.Stop()
+	for seq := 0; ; seq++ {
+		b := []byte(f.Arg(0))
+		if len(b) == 0 {
+			var err error
+			if b, err = json.Marshal(map[string]interface{}{
+				"seq":  seq,
+				"time": time.Now().UTC(),
+			}); err != nil {
+				return err
+			}
+		}
+		if err := c.SendEvent(ctx, b); err != nil {
+			return err
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
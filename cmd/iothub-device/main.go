@@ -9,39 +9,59 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/amenzhinsky/golang-iothub/cmd/internal"
 	"github.com/amenzhinsky/golang-iothub/common"
 	"github.com/amenzhinsky/golang-iothub/iotdevice"
 	"github.com/amenzhinsky/golang-iothub/iotdevice/transport"
 	"github.com/amenzhinsky/golang-iothub/iotdevice/transport/mqtt"
+	"github.com/amenzhinsky/golang-iothub/iotutil"
+	paho "github.com/eclipse/paho.mqtt.golang"
 )
 
-var transports = map[string]func() (transport.Transport, error){
-	"mqtt": func() (transport.Transport, error) {
-		return mqtt.New(mqtt.WithLogger(mklog("[mqtt]   "))), nil
+var transports = map[string]func(debug bool) (transport.Transport, error){
+	"mqtt": func(debug bool) (transport.Transport, error) {
+		if debug {
+			// frame-level logs of the underlying client
+			paho.DEBUG = mklog(debug, "[paho]   ")
+		}
+		return mqtt.New(mqtt.WithLogger(mklog(debug, "[mqtt]   "))), nil
 	},
-	"amqp": func() (transport.Transport, error) {
+	"amqp": func(debug bool) (transport.Transport, error) {
 		return nil, errors.New("not implemented")
 	},
-	"http": func() (transport.Transport, error) {
+	"http": func(debug bool) (transport.Transport, error) {
 		return nil, errors.New("not implemented")
 	},
 }
 
 var (
-	debugFlag     = false
 	quiteFlag     = false
 	transportFlag = "mqtt"
 	midFlag       = ""
 	cidFlag       = ""
+	ctFlag        = ""
+	ceFlag        = ""
+
+	// watch-events and watch
+	payloadFormatFlag = ""
+	maxPayloadFlag    = 0
+	formatFlag        = "json"
+	ackFlag           = "complete"
 
 	// x509 flags
 	tlsCertFlag  = ""
 	tlsKeyFlag   = ""
 	deviceIDFlag = ""
 	hostnameFlag = ""
+
+	// multi-device
+	devicesFlag     = ""
+	concurrencyFlag = 10
 )
 
 func main() {
@@ -49,7 +69,7 @@ func main() {
 		if err != internal.ErrInvalidUsage {
 			fmt.Fprintf(os.Stderr, "error: %s\n", err)
 		}
-		os.Exit(1)
+		os.Exit(internal.ExitCode(err))
 	}
 }
 
@@ -58,59 +78,87 @@ The $DEVICE_CONNECTION_STRING environment variable is required unless you use x5
 
 func run() error {
 	cli, err := internal.New(help, func(f *flag.FlagSet) {
-		f.BoolVar(&debugFlag, "debug", debugFlag, "enable debug mode")
 		f.StringVar(&transportFlag, "transport", transportFlag, "transport to use <mqtt|amqp|http>")
 		f.StringVar(&tlsCertFlag, "tls-cert", tlsCertFlag, "path to x509 cert file")
 		f.StringVar(&tlsKeyFlag, "tls-key", tlsKeyFlag, "path to x509 key file")
 		f.StringVar(&deviceIDFlag, "device-id", deviceIDFlag, "device id, required for x509")
 		f.StringVar(&hostnameFlag, "hostname", hostnameFlag, "hostname to connect to, required for x509")
+		f.StringVar(&devicesFlag, "devices", devicesFlag, "run the command for every device in the given csv or json credentials file")
+		f.IntVar(&concurrencyFlag, "concurrency", concurrencyFlag, "maximum number of devices running the command at once")
 	}, []*internal.Command{
 		{
-			"send", "s",
-			"PAYLOAD [KEY VALUE]...",
-			"send a message to the cloud (D2C)",
-			wrap(send),
-			func(f *flag.FlagSet) {
+			Name:    "send",
+			Alias:   "s",
+			Help:    "PAYLOAD [KEY VALUE]...",
+			Desc:    "send a message to the cloud (D2C)",
+			Handler: wrap(send),
+			ParseFunc: func(f *flag.FlagSet) {
 				f.StringVar(&midFlag, "mid", midFlag, "identifier for the message")
 				f.StringVar(&cidFlag, "cid", cidFlag, "message identifier in a request-reply")
+				f.StringVar(&ctFlag, "ct", ctFlag, "payload content type, e.g. application/json")
+				f.StringVar(&ceFlag, "ce", ceFlag, "payload content encoding, e.g. utf-8")
 			},
 		},
 		{
-			"watch-events", "we",
-			"",
-			"subscribe to messages sent from the cloud (C2D)",
-			wrap(watchEvents),
-			nil,
+			Name:      "watch-events",
+			Alias:     "we",
+			Desc:      "subscribe to messages sent from the cloud (C2D)",
+			Handler:   wrap(watchEvents),
+			ParseFunc: watchFlags,
+		},
+		{
+			Name:    "watch",
+			Alias:   "w",
+			Desc:    "inspect messages sent from the cloud (C2D) settling them as requested",
+			Handler: wrap(watch),
+			ParseFunc: func(f *flag.FlagSet) {
+				watchFlags(f)
+				f.StringVar(&ackFlag, "ack", ackFlag, "settle received messages with <complete|abandon|reject>, mqtt supports only complete")
+			},
 		},
 		{
-			"watch-twin", "wt",
-			"",
-			"subscribe to desired twin state updates",
-			wrap(watchTwin),
-			nil,
+			Name:    "watch-twin",
+			Alias:   "wt",
+			Desc:    "subscribe to desired twin state updates",
+			Handler: wrap(watchTwin),
 		},
 		{
-			"direct-method", "dm",
-			"NAME",
-			"handle the named direct method, reads responses from STDIN",
-			wrap(directMethod),
-			func(f *flag.FlagSet) {
+			Name:    "direct-method",
+			Alias:   "dm",
+			Help:    "NAME",
+			Desc:    "handle the named direct method, reads responses from STDIN",
+			Handler: wrap(directMethod),
+			ParseFunc: func(f *flag.FlagSet) {
 				f.BoolVar(&quiteFlag, "quite", quiteFlag, "disable additional hints")
 			},
 		},
 		{
-			"twin-state", "ts",
-			"",
-			"retrieve desired and reported states",
-			wrap(twin),
-			nil,
+			Name:    "twin-state",
+			Alias:   "ts",
+			Desc:    "retrieve desired and reported states",
+			Handler: wrap(twin),
 		},
 		{
-			"update-twin", "ut",
-			"[KEY VALUE]...",
-			"updates the twin device deported state, null means delete the key",
-			wrap(updateTwin),
-			nil,
+			Name:      "report",
+			Alias:     "r",
+			Desc:      "periodically publish system stats as reported properties",
+			Handler:   wrap(report),
+			ParseFunc: reportFlags,
+		},
+		{
+			Name:      "simulate",
+			Alias:     "sim",
+			Help:      "[PAYLOAD]",
+			Desc:      "send the given or generated telemetry periodically",
+			Handler:   wrap(simulate),
+			ParseFunc: simulateFlags,
+		},
+		{
+			Name:    "update-twin",
+			Alias:   "ut",
+			Help:    "[KEY VALUE]...",
+			Desc:    "updates the twin device deported state, null means delete the key",
+			Handler: wrap(updateTwin),
 		},
 	})
 	if err != nil {
@@ -121,6 +169,10 @@ func run() error {
 
 func wrap(fn func(context.Context, *flag.FlagSet, *iotdevice.Client) error) internal.HandlerFunc {
 	return func(ctx context.Context, f *flag.FlagSet) error {
+		if devicesFlag != "" {
+			return runMulti(ctx, f, fn)
+		}
+
 		var auth iotdevice.ClientOption
 		if tlsCertFlag != "" && tlsKeyFlag != "" {
 			if hostnameFlag == "" {
@@ -138,34 +190,43 @@ func wrap(fn func(context.Context, *flag.FlagSet, *iotdevice.Client) error) inte
 			}
 			auth = iotdevice.WithConnectionString(cs)
 		}
-
-		mk, ok := transports[transportFlag]
-		if !ok {
-			return fmt.Errorf("unknown transport %q", transportFlag)
-		}
-		t, err := mk()
-		if err != nil {
-			return err
-		}
-		c, err := iotdevice.NewClient(
-			iotdevice.WithDebug(debugFlag),
-			iotdevice.WithLogger(mklog("[iothub] ")),
-			iotdevice.WithTransport(t),
-			auth,
-		)
+		c, err := newClient(ctx, auth)
 		if err != nil {
 			return err
 		}
-		if err := c.ConnectInBackground(ctx); err != nil {
-			return err
-		}
 		return fn(ctx, f, c)
 	}
 }
 
+// newClient creates a client connecting in background with the selected transport.
+func newClient(ctx context.Context, auth iotdevice.ClientOption) (*iotdevice.Client, error) {
+	mk, ok := transports[transportFlag]
+	if !ok {
+		return nil, fmt.Errorf("unknown transport %q", transportFlag)
+	}
+	debug := internal.Debug(ctx)
+	t, err := mk(debug)
+	if err != nil {
+		return nil, err
+	}
+	c, err := iotdevice.NewClient(
+		iotdevice.WithDebug(debug),
+		iotdevice.WithLogger(mklog(debug, "[iothub] ")),
+		iotdevice.WithTransport(t),
+		auth,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.ConnectInBackground(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
 // mklog enables logging only when debug mode is on
-func mklog(prefix string) *log.Logger {
-	if !debugFlag {
+func mklog(debug bool, prefix string) *log.Logger {
+	if !debug {
 		return nil
 	}
 	return log.New(os.Stderr, prefix, 0)
@@ -187,6 +248,8 @@ func send(ctx context.Context, f *flag.FlagSet, c *iotdevice.Client) error {
 		iotdevice.WithSendProperties(props),
 		iotdevice.WithSendMessageID(midFlag),
 		iotdevice.WithSendCorrelationID(cidFlag),
+		iotdevice.WithSendContentType(ctFlag),
+		iotdevice.WithSendContentEncoding(ceFlag),
 	)
 }
 
@@ -194,10 +257,25 @@ func watchEvents(ctx context.Context, f *flag.FlagSet, c *iotdevice.Client) erro
 	if f.NArg() != 0 {
 		return internal.ErrInvalidUsage
 	}
+	var output func(msg *common.Message) error
+	switch formatFlag {
+	case "json":
+		output = func(msg *common.Message) error {
+			return internal.OutputMessage(msg, payloadFormatFlag, maxPayloadFlag)
+		}
+	case "text":
+		output = outputMessageLine
+	default:
+		return fmt.Errorf("unknown format %q", formatFlag)
+	}
+
 	errc := make(chan error, 1)
 	if err := c.SubscribeEvents(ctx, func(msg *common.Message) {
-		if err := internal.OutputJSON(msg); err != nil {
-			errc <- err
+		if err := output(msg); err != nil {
+			select {
+			case errc <- err:
+			default:
+			}
 		}
 	}); err != nil {
 		return err
@@ -205,6 +283,46 @@ func watchEvents(ctx context.Context, f *flag.FlagSet, c *iotdevice.Client) erro
 	return <-errc
 }
 
+// watch is watch-events that settles messages as requested with -ack.
+func watch(ctx context.Context, f *flag.FlagSet, c *iotdevice.Client) error {
+	switch ackFlag {
+	case "complete":
+		// the mqtt client acknowledges messages with PUBACK on receipt
+	case "abandon", "reject":
+		// the only messages settlement mqtt has is PUBACK, which completes them,
+		// abandoning and rejecting need the amqp transport that's not implemented
+		return fmt.Errorf("-ack %s is not supported by the %s transport, it completes messages on receipt", ackFlag, transportFlag)
+	default:
+		return fmt.Errorf("unknown ack type %q", ackFlag)
+	}
+	return watchEvents(ctx, f, c)
+}
+
+// outputMessageLine prints a one-line message summary.
+func outputMessageLine(msg *common.Message) error {
+	var b strings.Builder
+	if msg.EnqueuedTime != nil {
+		b.WriteString(msg.EnqueuedTime.Format(time.RFC3339) + " ")
+	}
+	if msg.MessageID != "" {
+		b.WriteString("[" + msg.MessageID + "] ")
+	}
+	for _, k := range sortedKeys(msg.Properties) {
+		b.WriteString(k + "=" + msg.Properties[k] + " ")
+	}
+	b.WriteString(iotutil.FormatPayload(msg.Payload, iotutil.WithMaxLength(maxPayloadFlag)))
+	return internal.OutputLine(b.String())
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func watchTwin(ctx context.Context, f *flag.FlagSet, c *iotdevice.Client) error {
 	if f.NArg() != 0 {
 		return internal.ErrInvalidUsage
@@ -312,3 +430,13 @@ func updateTwin(ctx context.Context, f *flag.FlagSet, c *iotdevice.Client) error
 	fmt.Printf("version: %d\n", ver)
 	return nil
 }
+
+func payloadFlags(f *flag.FlagSet) {
+	f.StringVar(&payloadFormatFlag, "payload-format", payloadFormatFlag, "print payload separately <auto|string|hex|hexdump>")
+	f.IntVar(&maxPayloadFlag, "max-payload", maxPayloadFlag, "truncate printed payload to the given number of bytes")
+}
+
+func watchFlags(f *flag.FlagSet) {
+	payloadFlags(f)
+	f.StringVar(&formatFlag, "format", formatFlag, "output format <json|text>")
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/amenzhinsky/golang-iothub/cmd/internal"
+	"github.com/amenzhinsky/golang-iothub/iotdevice"
+)
+
+var (
+	intervalFlag = time.Minute
+	keyFlag      = "health"
+	diskFlag     = "/"
+	execFlag     = execList{}
+)
+
+// execList is a repeatable NAME=COMMAND flag.
+type execList map[string]string
+
+func (l execList) String() string {
+	return fmt.Sprint(map[string]string(l))
+}
+
+func (l execList) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 || i == len(s)-1 {
+		return errors.New("exec must be in NAME=COMMAND format")
+	}
+	l[s[:i]] = s[i+1:]
+	return nil
+}
+
+func reportFlags(f *flag.FlagSet) {
+	f.DurationVar(&intervalFlag, "interval", intervalFlag, "reporting interval")
+	f.StringVar(&keyFlag, "key", keyFlag, "reported property the stats are stored under")
+	f.StringVar(&diskFlag, "disk", diskFlag, "path to report disk usage of")
+	f.Var(execFlag, "exec", "report output of a shell command as NAME=COMMAND, can be repeated")
+}
+
+var started = time.Now()
+
+func report(ctx context.Context, f *flag.FlagSet, c *iotdevice.Client) error {
+	if f.NArg() != 0 {
+		return internal.ErrInvalidUsage
+	}
+	if intervalFlag <= 0 {
+		return errors.New("interval must be positive")
+	}
+	for {
+		ver, err := c.UpdateTwinState(ctx, iotdevice.TwinState{
+			keyFlag: collectStats(ctx),
+		})
+		if err != nil {
+			return err
+		}
+		if err = internal.OutputLine(fmt.Sprintf("version: %d", ver)); err != nil {
+			return err
+		}
+		select {
+		case <-time.After(intervalFlag):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// collectStats gathers system stats, the ones that
+// cannot be retrieved are reported as errors.
+func collectStats(ctx context.Context) map[string]interface{} {
+	s := map[string]interface{}{
+		"time":          time.Now().UTC().Format(time.RFC3339),
+		"processUptime": int64(time.Since(started) / time.Second),
+	}
+	if v, err := os.Hostname(); err == nil {
+		s["hostname"] = v
+	}
+	if v, err := systemUptime(); err == nil {
+		s["uptime"] = int64(v / time.Second)
+	}
+	if v, err := addrs(); err == nil {
+		s["ips"] = v
+	}
+	if total, free, err := diskUsage(diskFlag); err == nil {
+		s["disk"] = map[string]interface{}{
+			"path":  diskFlag,
+			"total": total,
+			"free":  free,
+		}
+	}
+	if len(execFlag) != 0 {
+		m := make(map[string]interface{}, len(execFlag))
+		for name, cmd := range execFlag {
+			b, err := exec.CommandContext(ctx, shell[0], append(shell[1:], cmd)...).Output()
+			if err != nil {
+				m[name] = "error: " + err.Error()
+				continue
+			}
+			m[name] = strings.TrimSpace(string(b))
+		}
+		s["exec"] = m
+	}
+	return s
+}
+
+// addrs returns non-loopback addresses of the host.
+func addrs() ([]string, error) {
+	l, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]string, 0, len(l))
+	for _, a := range l {
+		if n, ok := a.(*net.IPNet); ok && !n.IP.IsLoopback() {
+			ips = append(ips, n.IP.String())
+		}
+	}
+	return ips, nil
+}
@@ -0,0 +1,27 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"errors"
+	"runtime"
+	"time"
+)
+
+var shell = []string{"/bin/sh", "-c"}
+
+func init() {
+	if runtime.GOOS == "windows" {
+		shell = []string{"cmd", "/C"}
+	}
+}
+
+var errUnsupported = errors.New("not supported on this platform")
+
+func systemUptime() (time.Duration, error) {
+	return 0, errUnsupported
+}
+
+func diskUsage(path string) (total, free uint64, err error) {
+	return 0, 0, errUnsupported
+}
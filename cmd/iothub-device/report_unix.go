@@ -0,0 +1,34 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var shell = []string{"/bin/sh", "-c"}
+
+// systemUptime reads uptime from procfs, so it's available only on linux.
+func systemUptime() (time.Duration, error) {
+	b, err := ioutil.ReadFile("/proc/uptime")
+	if err != nil {
+		return 0, err
+	}
+	f, err := strconv.ParseFloat(strings.Fields(string(b))[0], 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(f * float64(time.Second)), nil
+}
+
+func diskUsage(path string) (total, free uint64, err error) {
+	var st syscall.Statfs_t
+	if err = syscall.Statfs(path, &st); err != nil {
+		return 0, 0, err
+	}
+	return st.Blocks * uint64(st.Bsize), st.Bavail * uint64(st.Bsize), nil
+}
@@ -1,12 +1,15 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
 	"time"
 
@@ -21,6 +24,7 @@ var (
 	midFlag             = ""
 	cidFlag             = ""
 	expFlag             = time.Duration(0)
+	ttlFlag             = time.Duration(0)
 	ackFlag             = ""
 	connectTimeoutFlag  = 0
 	responseTimeoutFlag = 30
@@ -34,8 +38,26 @@ var (
 	primaryThumbprintFlag   = ""
 	secondaryThumbprintFlag = ""
 
-	// common flags
-	debugFlag = false
+	// disable device
+	reasonFlag = ""
+
+	// watch-events
+	payloadFormatFlag  = ""
+	maxPayloadFlag     = 0
+	outputFileFlag     = ""
+	rotateSizeFlag     = int64(0)
+	rotateIntervalFlag = time.Duration(0)
+	gzipFlag           = false
+
+	// listings
+	tableFlag   = false
+	columnsFlag = ""
+	sortByFlag  = ""
+
+	// replay
+	toFlag    = ""
+	urlFlag   = ""
+	speedFlag = float64(0)
 
 	// sas and connection string
 	secondaryFlag = false
@@ -50,7 +72,7 @@ func main() {
 		if err != internal.ErrInvalidUsage {
 			fmt.Fprintf(os.Stderr, "error: %s\n", err)
 		}
-		os.Exit(1)
+		os.Exit(internal.ExitCode(err))
 	}
 }
 
@@ -58,139 +80,280 @@ const help = `Helps with interacting and managing your iothub devices.
 The $SERVICE_CONNECTION_STRING environment variable is required for authentication.`
 
 func run() error {
-	cli, err := internal.New(help, func(f *flag.FlagSet) {
-		f.BoolVar(&debugFlag, "debug", debugFlag, "enable debug mode")
-	}, []*internal.Command{
+	deviceCmds := []*internal.Command{
 		{
-			"send", "s",
-			"DEVICE PAYLOAD [KEY VALUE]...",
-			"send a message to the named device (C2D)",
-			wrap(send),
-			func(f *flag.FlagSet) {
-				f.StringVar(&ackFlag, "ack", ackFlag, "type of ack feedback")
-				f.StringVar(&uidFlag, "uid", uidFlag, "origin of the message")
-				f.StringVar(&midFlag, "mid", midFlag, "identifier for the message")
-				f.StringVar(&cidFlag, "cid", cidFlag, "message identifier in a request-reply")
-				f.DurationVar(&expFlag, "exp", expFlag, "message lifetime")
+			Name:    "get",
+			Help:    "DEVICE",
+			Desc:    "get device information",
+			Handler: wrap(device),
+		},
+		{
+			Name:      "list",
+			Alias:     "ls",
+			Desc:      "list all available devices",
+			Handler:   wrap(devices),
+			ParseFunc: listFlags("deviceId,status,connectionState,lastActivityTime,authentication.type"),
+			Examples: []string{
+				"device list -table -sort-by lastActivityTime",
+				"device list -table -columns deviceId,status",
 			},
 		},
 		{
-			"watch-events", "we",
-			"", "subscribe to device messages (D2C)",
-			wrap(watchEvents),
-			nil,
+			Name:    "create",
+			Help:    "DEVICE",
+			Desc:    "creates a new device",
+			Handler: wrap(createDevice),
+			ParseFunc: func(f *flag.FlagSet) {
+				f.BoolVar(&autoGenerateFlag, "auto", false, "auto generate keys")
+				keyFlags(f)
+			},
+			Examples: []string{
+				"device create -auto mydevice",
+				"device create -primary-thumbprint 1A2B... mydevice",
+			},
 		},
 		{
-			"watch-feedback", "wf",
-			"", "monitor message feedback send by devices",
-			wrap(watchFeedback),
-			nil,
+			Name:      "update",
+			Help:      "DEVICE",
+			Desc:      "updates the named device",
+			Handler:   wrap(updateDevice),
+			ParseFunc: keyFlags,
 		},
 		{
-			"call", "c",
-			"DEVICE METHOD PAYLOAD", "call a direct method on a device",
-			wrap(call),
-			func(f *flag.FlagSet) {
-				f.IntVar(&connectTimeoutFlag, "c", connectTimeoutFlag, "connect timeout in seconds")
-				f.IntVar(&responseTimeoutFlag, "r", responseTimeoutFlag, "response timeout in seconds")
+			Name:    "delete",
+			Help:    "DEVICE",
+			Desc:    "delete the named device",
+			Handler: wrap(deleteDevice),
+		},
+		{
+			Name:    "enable",
+			Help:    "DEVICE",
+			Desc:    "allow the named device to connect",
+			Handler: wrap(enableDevice),
+		},
+		{
+			Name:    "disable",
+			Help:    "DEVICE",
+			Desc:    "prevent the named device from connecting",
+			Handler: wrap(disableDevice),
+			ParseFunc: func(f *flag.FlagSet) {
+				f.StringVar(&reasonFlag, "reason", reasonFlag, "status reason")
 			},
 		},
 		{
-			"device", "d",
-			"DEVICE", "get device information",
-			wrap(device),
-			nil,
+			Name:    "purge-queue",
+			Alias:   "pq",
+			Help:    "DEVICE",
+			Desc:    "delete pending cloud-to-device messages of the named device",
+			Handler: wrap(purgeQueue),
 		},
 		{
-			"devices", "ds",
-			"", "list all available devices",
-			wrap(devices),
-			nil,
+			Name:      "modules",
+			Alias:     "ms",
+			Help:      "DEVICE",
+			Desc:      "list modules of the named device",
+			Handler:   wrap(modules),
+			ParseFunc: listFlags("moduleId,connectionState,lastActivityTime,managedBy"),
 		},
 		{
-			"create-device", "cd",
-			"DEVICE", "creates a new device",
-			wrap(createDevice),
-			func(f *flag.FlagSet) {
-				f.BoolVar(&autoGenerateFlag, "auto", false, "auto generate keys")
-				f.StringVar(&primaryKeyFlag, "primary-key", "", "primary key (base64)")
-				f.StringVar(&secondaryKeyFlag, "secondary-key", "", "secondary key (base64)")
-				f.StringVar(&primaryThumbprintFlag, "primary-thumbprint", "", "x509 primary thumbprint")
-				f.StringVar(&secondaryThumbprintFlag, "secondary-thumbprint", "", "x509 secondary thumbprint")
+			Name:    "connection-string",
+			Alias:   "cs",
+			Help:    "DEVICE",
+			Desc:    "get a device's connection string",
+			Handler: wrap(connectionString),
+			ParseFunc: func(f *flag.FlagSet) {
+				f.BoolVar(&secondaryFlag, "secondary", secondaryFlag, "use the secondary key instead")
 			},
 		},
 		{
-			"update-device", "ud",
-			"DEVICE", "updates the named device",
-			wrap(updateDevice),
-			func(f *flag.FlagSet) {
-				f.StringVar(&primaryKeyFlag, "primary-key", "", "primary key (base64)")
-				f.StringVar(&secondaryKeyFlag, "secondary-key", "", "secondary key (base64)")
-				f.StringVar(&primaryThumbprintFlag, "primary-thumbprint", "", "x509 primary thumbprint")
-				f.StringVar(&secondaryThumbprintFlag, "secondary-thumbprint", "", "x509 secondary thumbprint")
+			Name:    "access-signature",
+			Alias:   "as",
+			Help:    "DEVICE",
+			Desc:    "generate a SAS token",
+			Handler: wrap(sas),
+			ParseFunc: func(f *flag.FlagSet) {
+				f.StringVar(&uriFlag, "uri", uriFlag, "storage resource uri")
+				f.DurationVar(&durationFlag, "duration", durationFlag, "token validity time")
+				f.BoolVar(&secondaryFlag, "secondary", secondaryFlag, "use the secondary key instead")
+			},
+			Examples: []string{
+				"device access-signature -duration 24h mydevice",
 			},
 		},
+	}
+	twinCmds := []*internal.Command{
 		{
-			"delete-device", "dd",
-			"DEVICE", "delete the named device",
-			wrap(deleteDevice),
-			nil,
+			Name:    "get",
+			Help:    "DEVICE",
+			Desc:    "inspect the named twin device",
+			Handler: wrap(twin),
 		},
 		{
-			"twin", "t",
-			"", "inspect the named twin device",
-			wrap(twin),
-			nil,
+			Name:    "update",
+			Help:    "DEVICE [KEY VALUE]...",
+			Desc:    "update the named twin device",
+			Handler: wrap(updateTwin),
+			Examples: []string{
+				"twin update mydevice interval 10",
+			},
 		},
+	}
+	jobCmds := []*internal.Command{
 		{
-			"update-twin", "ut",
-			"DEVICE [KEY VALUE]...", "update the named twin device",
-			wrap(updateTwin),
-			nil,
+			Name:      "list",
+			Alias:     "ls",
+			Desc:      "list the last import/export jobs",
+			Handler:   wrap(jobs),
+			ParseFunc: listFlags("jobId,type,status,startTimeUtc,endTimeUtc"),
 		},
 		{
-			"stats", "st",
-			"", "get statistics about the devices",
-			wrap(stats),
-			nil,
+			Name:    "get",
+			Help:    "ID",
+			Desc:    "get the status of a import/export job",
+			Handler: wrap(job),
 		},
 		{
-			"jobs", "js",
-			"", "list the last import/export jobs",
-			wrap(jobs),
-			nil,
+			Name:    "cancel",
+			Help:    "ID",
+			Desc:    "cancel a import/export job",
+			Handler: wrap(cancelJob),
 		},
+	}
+	configurationCmds := []*internal.Command{
 		{
-			"job", "j",
-			"ID", "get the status of a import/export job",
-			wrap(job),
-			nil,
+			Name:      "list",
+			Alias:     "ls",
+			Desc:      "list all configurations",
+			Handler:   wrap(configurations),
+			ParseFunc: listFlags("id,priority,targetCondition,lastUpdatedTimeUtc"),
 		},
+	}
+
+	cmds := []*internal.Command{
 		{
-			"cancel-job", "cj",
-			"", "cancel a import/export job",
-			wrap(cancelJob),
-			nil,
+			Name:    "send",
+			Alias:   "s",
+			Help:    "DEVICE PAYLOAD [KEY VALUE]...",
+			Desc:    "send a message to the named device (C2D)",
+			Handler: wrap(send),
+			ParseFunc: func(f *flag.FlagSet) {
+				f.StringVar(&ackFlag, "ack", ackFlag, "type of ack feedback")
+				f.StringVar(&uidFlag, "uid", uidFlag, "origin of the message")
+				f.StringVar(&midFlag, "mid", midFlag, "identifier for the message")
+				f.StringVar(&cidFlag, "cid", cidFlag, "message identifier in a request-reply")
+				f.DurationVar(&expFlag, "exp", expFlag, "message lifetime")
+				f.DurationVar(&ttlFlag, "ttl", ttlFlag, "time the message waits in the device queue")
+			},
 		},
 		{
-			"connection-string", "cs",
-			"DEVICE", "get a device's connection string",
-			wrap(connectionString),
-			func(f *flag.FlagSet) {
-				f.BoolVar(&secondaryFlag, "secondary", secondaryFlag, "use the secondary key instead")
+			Name:    "watch-events",
+			Alias:   "we",
+			Desc:    "subscribe to device messages (D2C)",
+			Handler: wrap(watchEvents),
+			ParseFunc: func(f *flag.FlagSet) {
+				payloadFlags(f)
+				f.StringVar(&outputFileFlag, "output-file", outputFileFlag, "write events to the named file instead of stdout")
+				f.Int64Var(&rotateSizeFlag, "rotate-size", rotateSizeFlag, "rotate output file when it exceeds the given number of bytes")
+				f.DurationVar(&rotateIntervalFlag, "rotate-interval", rotateIntervalFlag, "rotate output file after the given period")
+				f.BoolVar(&gzipFlag, "gzip", gzipFlag, "gzip rotated output files")
 			},
 		},
 		{
-			"access-signature", "as",
-			"DEVICE", "generate a SAS token",
-			wrap(sas),
-			func(f *flag.FlagSet) {
-				f.StringVar(&uriFlag, "uri", uriFlag, "storage resource uri")
-				f.DurationVar(&durationFlag, "duration", durationFlag, "token validity time")
-				f.BoolVar(&secondaryFlag, "secondary", secondaryFlag, "use the secondary key instead")
+			Name:    "replay",
+			Alias:   "r",
+			Help:    "FILE",
+			Desc:    "re-send events captured by watch-events as C2D messages",
+			Handler: replay,
+			ParseFunc: func(f *flag.FlagSet) {
+				f.StringVar(&toFlag, "to", toFlag, "send all messages to the named device instead of their origin")
+				f.StringVar(&urlFlag, "url", urlFlag, "POST messages as json to the given url instead, e.g. a local fake hub")
+				f.Float64Var(&speedFlag, "speed", speedFlag, "replay speed relative to enqueued times, 0 sends without delays")
 			},
 		},
-	})
+		{
+			Name:    "watch-feedback",
+			Alias:   "wf",
+			Desc:    "monitor message feedback send by devices",
+			Handler: wrap(watchFeedback),
+		},
+		{
+			Name:    "call",
+			Alias:   "c",
+			Help:    "DEVICE METHOD PAYLOAD",
+			Desc:    "call a direct method on a device",
+			Handler: wrap(call),
+			ParseFunc: func(f *flag.FlagSet) {
+				f.IntVar(&connectTimeoutFlag, "c", connectTimeoutFlag, "connect timeout in seconds")
+				f.IntVar(&responseTimeoutFlag, "r", responseTimeoutFlag, "response timeout in seconds")
+			},
+		},
+		{
+			Name:    "stats",
+			Alias:   "st",
+			Desc:    "get statistics about the devices",
+			Handler: wrap(stats),
+		},
+		{
+			Name:        "device",
+			Alias:       "d",
+			Help:        "DEVICE",
+			Desc:        "manage device identities, gets the named device without a subcommand",
+			Handler:     wrap(device),
+			Subcommands: deviceCmds,
+		},
+		{
+			Name:        "twin",
+			Alias:       "t",
+			Help:        "DEVICE",
+			Desc:        "manage device twins, gets the named twin without a subcommand",
+			Handler:     wrap(twin),
+			Subcommands: twinCmds,
+		},
+		{
+			Name:        "job",
+			Alias:       "j",
+			Help:        "ID",
+			Desc:        "manage import/export jobs, gets the named job without a subcommand",
+			Handler:     wrap(job),
+			Subcommands: jobCmds,
+		},
+		{
+			Name:        "configuration",
+			Alias:       "cfg",
+			Desc:        "manage automatic device configurations",
+			Subcommands: configurationCmds,
+		},
+	}
+
+	// names the commands had before they were grouped
+	for _, d := range []struct {
+		name, alias string
+		group       []*internal.Command
+		sub         string
+	}{
+		{"devices", "ds", deviceCmds, "list"},
+		{"create-device", "cd", deviceCmds, "create"},
+		{"update-device", "ud", deviceCmds, "update"},
+		{"delete-device", "dd", deviceCmds, "delete"},
+		{"purge-queue", "pq", deviceCmds, "purge-queue"},
+		{"modules", "ms", deviceCmds, "modules"},
+		{"connection-string", "cs", deviceCmds, "connection-string"},
+		{"access-signature", "as", deviceCmds, "access-signature"},
+		{"update-twin", "ut", twinCmds, "update"},
+		{"jobs", "js", jobCmds, "list"},
+		{"cancel-job", "cj", jobCmds, "cancel"},
+		{"configurations", "cfs", configurationCmds, "list"},
+	} {
+		for _, cmd := range d.group {
+			if cmd.Name == d.sub {
+				c := *cmd
+				c.Name, c.Alias, c.Hidden = d.name, d.alias, true
+				cmds = append(cmds, &c)
+			}
+		}
+	}
+
+	cli, err := internal.New(help, nil, cmds)
 	if err != nil {
 		return err
 	}
@@ -199,23 +362,7 @@ func run() error {
 
 func wrap(fn func(context.Context, *flag.FlagSet, *iotservice.Client) error) internal.HandlerFunc {
 	return func(ctx context.Context, f *flag.FlagSet) error {
-		// accept only from environment
-		cs := os.Getenv("SERVICE_CONNECTION_STRING")
-		if cs == "" {
-			return errors.New("SERVICE_CONNECTION_STRING is blank")
-		}
-
-		var logger *log.Logger
-		if debugFlag {
-			logger = log.New(os.Stderr, "[iotservice] ", 0)
-		}
-
-		c, err := iotservice.NewClient(
-			iotservice.WithLogger(nil), // disable logging
-			iotservice.WithConnectionString(cs),
-			iotservice.WithLogger(logger),
-			iotservice.WithDebug(debugFlag),
-		)
+		c, err := newClient(ctx)
 		if err != nil {
 			return err
 		}
@@ -224,6 +371,24 @@ func wrap(fn func(context.Context, *flag.FlagSet, *iotservice.Client) error) int
 	}
 }
 
+func newClient(ctx context.Context) (*iotservice.Client, error) {
+	// accept only from environment
+	cs := os.Getenv("SERVICE_CONNECTION_STRING")
+	if cs == "" {
+		return nil, errors.New("SERVICE_CONNECTION_STRING is blank")
+	}
+
+	var logger *log.Logger
+	if internal.Debug(ctx) {
+		logger = log.New(os.Stderr, "[iotservice] ", 0)
+	}
+	return iotservice.NewClient(
+		iotservice.WithConnectionString(cs),
+		iotservice.WithLogger(logger),
+		iotservice.WithDebug(internal.Debug(ctx)),
+	)
+}
+
 func device(ctx context.Context, f *flag.FlagSet, c *iotservice.Client) error {
 	if f.NArg() != 1 {
 		return internal.ErrInvalidUsage
@@ -243,7 +408,29 @@ func devices(ctx context.Context, f *flag.FlagSet, c *iotservice.Client) error {
 	if err != nil {
 		return err
 	}
-	return internal.OutputJSON(d)
+	return outputList(d)
+}
+
+func modules(ctx context.Context, f *flag.FlagSet, c *iotservice.Client) error {
+	if f.NArg() != 1 {
+		return internal.ErrInvalidUsage
+	}
+	m, err := c.ListModules(ctx, f.Arg(0))
+	if err != nil {
+		return err
+	}
+	return outputList(m)
+}
+
+func configurations(ctx context.Context, f *flag.FlagSet, c *iotservice.Client) error {
+	if f.NArg() != 0 {
+		return internal.ErrInvalidUsage
+	}
+	v, err := c.ListConfigurations(ctx)
+	if err != nil {
+		return err
+	}
+	return outputList(v)
 }
 
 func createDevice(ctx context.Context, f *flag.FlagSet, c *iotservice.Client) error {
@@ -326,6 +513,39 @@ func deleteDevice(ctx context.Context, f *flag.FlagSet, c *iotservice.Client) er
 	return c.DeleteDevice(ctx, f.Arg(0))
 }
 
+func enableDevice(ctx context.Context, f *flag.FlagSet, c *iotservice.Client) error {
+	if f.NArg() != 1 {
+		return internal.ErrInvalidUsage
+	}
+	d, err := c.EnableDevice(ctx, f.Arg(0))
+	if err != nil {
+		return err
+	}
+	return internal.OutputJSON(d)
+}
+
+func disableDevice(ctx context.Context, f *flag.FlagSet, c *iotservice.Client) error {
+	if f.NArg() != 1 {
+		return internal.ErrInvalidUsage
+	}
+	d, err := c.DisableDevice(ctx, f.Arg(0), reasonFlag)
+	if err != nil {
+		return err
+	}
+	return internal.OutputJSON(d)
+}
+
+func purgeQueue(ctx context.Context, f *flag.FlagSet, c *iotservice.Client) error {
+	if f.NArg() != 1 {
+		return internal.ErrInvalidUsage
+	}
+	r, err := c.PurgeQueue(ctx, f.Arg(0))
+	if err != nil {
+		return err
+	}
+	return internal.OutputJSON(r)
+}
+
 func stats(ctx context.Context, f *flag.FlagSet, c *iotservice.Client) error {
 	if f.NArg() != 0 {
 		return internal.ErrInvalidUsage
@@ -413,14 +633,18 @@ func send(ctx context.Context, f *flag.FlagSet, c *iotservice.Client) error {
 	if expFlag != 0 {
 		expiryTime = time.Now().Add(expFlag)
 	}
-	if err := c.SendEvent(ctx, f.Arg(0), []byte(f.Arg(1)),
+	opts := []iotservice.SendOption{
 		iotservice.WithSendMessageID(midFlag),
 		iotservice.WithSendAck(ackFlag),
 		iotservice.WithSendProperties(props),
 		iotservice.WithSendUserID(uidFlag),
 		iotservice.WithSendCorrelationID(cidFlag),
 		iotservice.WithSentExpiryTime(expiryTime),
-	); err != nil {
+	}
+	if ttlFlag != 0 {
+		opts = append(opts, iotservice.WithSendTTL(ttlFlag))
+	}
+	if err := c.SendEvent(ctx, f.Arg(0), []byte(f.Arg(1)), opts...); err != nil {
 		return err
 	}
 	return nil
@@ -430,10 +654,31 @@ func watchEvents(ctx context.Context, f *flag.FlagSet, c *iotservice.Client) err
 	if f.NArg() != 0 {
 		return internal.ErrInvalidUsage
 	}
+	var w io.Writer = os.Stdout
+	if outputFileFlag != "" {
+		out, err := internal.NewRotatingFile(
+			outputFileFlag, rotateSizeFlag, rotateIntervalFlag, gzipFlag,
+		)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		w = out
+	}
 	errc := make(chan error, 1)
 	if err := c.SubscribeEvents(ctx, func(msg *common.Message) {
-		if err := internal.OutputJSON(msg); err != nil {
-			errc <- err
+		// records are written with a single call so concurrent
+		// ones don't interleave and files rotate between records
+		var b bytes.Buffer
+		err := internal.WriteMessage(&b, msg, payloadFormatFlag, maxPayloadFlag)
+		if err == nil {
+			_, err = w.Write(b.Bytes())
+		}
+		if err != nil {
+			select {
+			case errc <- err:
+			default:
+			}
 		}
 	}); err != nil {
 		return err
@@ -441,6 +686,91 @@ func watchEvents(ctx context.Context, f *flag.FlagSet, c *iotservice.Client) err
 	return <-errc
 }
 
+func replay(ctx context.Context, f *flag.FlagSet) error {
+	if f.NArg() != 1 {
+		return internal.ErrInvalidUsage
+	}
+	if speedFlag < 0 {
+		return errors.New("speed cannot be negative")
+	}
+	file, err := os.Open(f.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var send func(deviceID string, msg *common.Message) error
+	if urlFlag != "" {
+		send = func(deviceID string, msg *common.Message) error {
+			return postMessage(ctx, urlFlag, deviceID, msg)
+		}
+	} else {
+		c, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		send = func(deviceID string, msg *common.Message) error {
+			return c.SendEvent(ctx, deviceID, msg.Payload,
+				iotservice.WithSendMessageID(msg.MessageID),
+				iotservice.WithSendCorrelationID(msg.CorrelationID),
+				iotservice.WithSendProperties(msg.Properties),
+			)
+		}
+	}
+
+	var last *time.Time
+	return internal.ReadMessages(file, func(msg *common.Message) error {
+		if speedFlag > 0 && last != nil && msg.EnqueuedTime != nil {
+			if d := msg.EnqueuedTime.Sub(*last); d > 0 {
+				select {
+				case <-time.After(time.Duration(float64(d) / speedFlag)):
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+		if msg.EnqueuedTime != nil {
+			last = msg.EnqueuedTime
+		}
+
+		deviceID := toFlag
+		if deviceID == "" {
+			deviceID = msg.ConnectionDeviceID
+		}
+		if deviceID == "" {
+			return fmt.Errorf("message %q has no origin device, use -to", msg.MessageID)
+		}
+		return send(deviceID, msg)
+	})
+}
+
+// postMessage posts msg as json to the given url
+// passing the destination in the device-id query parameter.
+func postMessage(ctx context.Context, url, deviceID string, msg *common.Message) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	q := req.URL.Query()
+	q.Set("device-id", deviceID)
+	req.URL.RawQuery = q.Encode()
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("%s: unexpected status %s", url, res.Status)
+	}
+	return nil
+}
+
 func watchFeedback(ctx context.Context, f *flag.FlagSet, c *iotservice.Client) error {
 	if f.NArg() != 0 {
 		return internal.ErrInvalidUsage
@@ -464,7 +794,7 @@ func jobs(ctx context.Context, f *flag.FlagSet, c *iotservice.Client) error {
 	if err != nil {
 		return err
 	}
-	return internal.OutputJSON(v)
+	return outputList(v)
 }
 
 func job(ctx context.Context, f *flag.FlagSet, c *iotservice.Client) error {
@@ -519,3 +849,32 @@ func sas(ctx context.Context, f *flag.FlagSet, c *iotservice.Client) error {
 	}
 	return internal.OutputLine(sas)
 }
+
+func keyFlags(f *flag.FlagSet) {
+	f.StringVar(&primaryKeyFlag, "primary-key", "", "primary key (base64)")
+	f.StringVar(&secondaryKeyFlag, "secondary-key", "", "secondary key (base64)")
+	f.StringVar(&primaryThumbprintFlag, "primary-thumbprint", "", "x509 primary thumbprint")
+	f.StringVar(&secondaryThumbprintFlag, "secondary-thumbprint", "", "x509 secondary thumbprint")
+}
+
+func payloadFlags(f *flag.FlagSet) {
+	f.StringVar(&payloadFormatFlag, "payload-format", payloadFormatFlag, "print payload separately <auto|string|hex|hexdump>")
+	f.IntVar(&maxPayloadFlag, "max-payload", maxPayloadFlag, "truncate printed payload to the given number of bytes")
+}
+
+// listFlags registers table output flags of listing commands.
+func listFlags(columns string) func(f *flag.FlagSet) {
+	return func(f *flag.FlagSet) {
+		columnsFlag = columns
+		f.BoolVar(&tableFlag, "table", tableFlag, "print a table instead of json")
+		f.StringVar(&columnsFlag, "columns", columnsFlag, "comma-separated table columns, nested fields are separated by dots")
+		f.StringVar(&sortByFlag, "sort-by", sortByFlag, "sort table by the given column, prefix with - to reverse")
+	}
+}
+
+func outputList(v interface{}) error {
+	if !tableFlag {
+		return internal.OutputJSON(v)
+	}
+	return internal.OutputTable(v, internal.ParseColumns(columnsFlag), sortByFlag)
+}
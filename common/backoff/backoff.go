@@ -0,0 +1,160 @@
+// Package backoff provides retry delay policies and helpers.
+package backoff
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Policy computes a delay before the given retry attempt, starting from 1.
+type Policy interface {
+	Delay(attempt int) time.Duration
+}
+
+// Constant is a policy that always waits the same amount of time.
+type Constant time.Duration
+
+// Delay implements Policy.
+func (c Constant) Delay(attempt int) time.Duration {
+	return time.Duration(c)
+}
+
+// Exponential is a policy that multiplies delays with every attempt
+// until they reach Max, Jitter randomizes them in [d-d*Jitter, d+d*Jitter].
+type Exponential struct {
+	Initial    time.Duration
+	Max        time.Duration // zero means no limit
+	Multiplier float64       // 2 when zero
+	Jitter     float64       // from 0 to 1
+}
+
+// Default is the default retry policy.
+var Default Policy = &Exponential{
+	Initial: 100 * time.Millisecond,
+	Max:     30 * time.Second,
+	Jitter:  0.2,
+}
+
+var (
+	rndMu sync.Mutex
+	rnd   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// Delay implements Policy.
+func (e *Exponential) Delay(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	m := e.Multiplier
+	if m == 0 {
+		m = 2
+	}
+	d := float64(e.Initial) * math.Pow(m, float64(attempt-1))
+	if e.Max > 0 && d > float64(e.Max) {
+		d = float64(e.Max)
+	}
+	if e.Jitter > 0 {
+		rndMu.Lock()
+		d += d * e.Jitter * (2*rnd.Float64() - 1)
+		rndMu.Unlock()
+	}
+	return time.Duration(d)
+}
+
+// Wait sleeps for d or returns ctx's error when it's done first.
+func Wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+// Permanent wraps err to stop Retry immediately, Retry returns the original error.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+type retryAfterError struct {
+	err error
+	d   time.Duration
+}
+
+func (e *retryAfterError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryAfterError) Unwrap() error {
+	return e.err
+}
+
+// RetryAfter attaches a server-issued hint to wait at least d
+// before retrying to err, it's honored by Retry and Delay.
+func RetryAfter(err error, d time.Duration) error {
+	if err == nil {
+		return nil
+	}
+	return &retryAfterError{err: err, d: d}
+}
+
+// RetryAfterHint returns the retry-after hint attached to err with RetryAfter.
+func RetryAfterHint(err error) (time.Duration, bool) {
+	var e *retryAfterError
+	if errors.As(err, &e) {
+		return e.d, true
+	}
+	return 0, false
+}
+
+// Delay returns the policy's delay before the given attempt
+// or the retry-after hint of err when it's longer.
+func Delay(p Policy, attempt int, err error) time.Duration {
+	d := p.Delay(attempt)
+	if h, ok := RetryAfterHint(err); ok && h > d {
+		d = h
+	}
+	return d
+}
+
+// Retry calls fn until it succeeds, returns a Permanent error, ctx is done
+// or maxAttempts are made, zero maxAttempts means no limit.
+// Retry-after hints of returned errors take precedence over
+// shorter policy delays. The last fn's error is returned.
+func Retry(ctx context.Context, p Policy, maxAttempts int, fn func(attempt int) error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn(attempt)
+		if err == nil {
+			return nil
+		}
+		if pe, ok := err.(*permanentError); ok {
+			return pe.err
+		}
+		if maxAttempts > 0 && attempt >= maxAttempts {
+			return err
+		}
+		if werr := Wait(ctx, Delay(p, attempt, err)); werr != nil {
+			return err
+		}
+	}
+}
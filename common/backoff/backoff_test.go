@@ -0,0 +1,88 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestExponential(t *testing.T) {
+	t.Parallel()
+
+	p := &Exponential{Initial: time.Second, Max: 5 * time.Second}
+	for attempt, w := range map[int]time.Duration{
+		1: time.Second,
+		2: 2 * time.Second,
+		3: 4 * time.Second,
+		4: 5 * time.Second,
+	} {
+		if g := p.Delay(attempt); g != w {
+			t.Errorf("Delay(%d) = %s, want %s", attempt, g, w)
+		}
+	}
+
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if g := p.Delay(1); g < 500*time.Millisecond || g > 1500*time.Millisecond {
+			t.Fatalf("Delay(1) = %s, want in [500ms, 1.5s]", g)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	t.Parallel()
+
+	errTest := errors.New("test")
+	n := 0
+	if err := Retry(context.Background(), Constant(0), 3, func(int) error {
+		n++
+		return errTest
+	}); err != errTest || n != 3 {
+		t.Errorf("Retry() = %v, %d attempts, want %v, %d attempts", err, n, errTest, 3)
+	}
+
+	n = 0
+	if err := Retry(context.Background(), Constant(0), 0, func(int) error {
+		n++
+		return Permanent(errTest)
+	}); err != errTest || n != 1 {
+		t.Errorf("Retry() = %v, %d attempts, want %v, %d attempts", err, n, errTest, 1)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	errTest := errors.New("test")
+	err := RetryAfter(errTest, time.Second)
+	if !errors.Is(err, errTest) {
+		t.Errorf("errors.Is(%v, %v) = false", err, errTest)
+	}
+	if d, ok := RetryAfterHint(err); !ok || d != time.Second {
+		t.Errorf("RetryAfterHint() = %s, %t, want %s, true", d, ok, time.Second)
+	}
+	if _, ok := RetryAfterHint(errTest); ok {
+		t.Error("RetryAfterHint() of a plain error = true")
+	}
+	for p, w := range map[Policy]time.Duration{
+		Constant(time.Millisecond): time.Second,
+		Constant(time.Minute):      time.Minute,
+	} {
+		if g := Delay(p, 1, err); g != w {
+			t.Errorf("Delay(%v) = %s, want %s", p, g, w)
+		}
+	}
+
+	n := 0
+	start := time.Now()
+	if err := Retry(context.Background(), Constant(0), 2, func(int) error {
+		n++
+		return RetryAfter(errTest, 50*time.Millisecond)
+	}); !errors.Is(err, errTest) || n != 2 {
+		t.Errorf("Retry() = %v, %d attempts, want %v, %d attempts", err, n, errTest, 2)
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("Retry() waited %s, want at least 50ms", d)
+	}
+}
@@ -0,0 +1,26 @@
+package common
+
+import "encoding/json"
+
+// Codec encodes and decodes JSON documents such as twins and payloads.
+//
+// Implementations have to honor encoding/json struct tags and
+// json.RawMessage, so drop-in replacements of encoding/json
+// like json-iterator can be used as is.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(b []byte, v interface{}) error
+}
+
+// JSONCodec is the default encoding/json codec.
+type JSONCodec struct{}
+
+// Marshal encodes v with json.Marshal.
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes b with json.Unmarshal.
+func (JSONCodec) Unmarshal(b []byte, v interface{}) error {
+	return json.Unmarshal(b, v)
+}
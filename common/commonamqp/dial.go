@@ -0,0 +1,31 @@
+package commonamqp
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+	"pack.ag/amqp"
+)
+
+// Dial connects to the named host over amqps with the given dialer,
+// unlike amqp.Dial it resolves the host on every call and dials
+// dual-stack hosts happy eyeballs style, see common.Dialer.
+func Dial(
+	ctx context.Context, d *common.Dialer, host string, tlsConfig *tls.Config, opts ...amqp.ConnOption,
+) (*amqp.Client, error) {
+	if d == nil {
+		d = common.DefaultDialer
+	}
+	conn, err := d.DialTLS(ctx, net.JoinHostPort(host, "5671"), tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	c, err := amqp.New(conn, append([]amqp.ConnOption{amqp.ConnServerHostname(host)}, opts...)...)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
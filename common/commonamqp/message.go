@@ -1,7 +1,6 @@
 package commonamqp
 
 import (
-	"fmt"
 	"time"
 
 	"github.com/amenzhinsky/golang-iothub/common"
@@ -10,59 +9,145 @@ import (
 
 // FromAMQPMessage converts a amqp.Message into common.Message.
 func FromAMQPMessage(msg *amqp.Message) *common.Message {
-	m := &common.Message{
-		Payload:    msg.Data[0],
-		Properties: make(map[string]string, len(msg.ApplicationProperties)+5),
+	m := FromAMQPMessageLazy(msg)
+	m.LoadProperties()
+	return m
+}
+
+// FromAMQPMessageLazy is like FromAMQPMessage but custom annotations and
+// application properties are converted into Properties only when they're
+// accessed, see common.Message.LoadProperties, system properties are
+// looked up directly, so consumers that only need them don't pay for it.
+func FromAMQPMessageLazy(msg *amqp.Message) *common.Message {
+	m := &common.Message{}
+	if len(msg.Data) != 0 {
+		m.Payload = msg.Data[0]
 	}
 	if msg.Properties != nil {
 		m.UserID = string(msg.Properties.UserID)
-		if msg.Properties.MessageID != nil {
-			m.MessageID = msg.Properties.MessageID.(string)
+		m.MessageID = common.FormatPropertyValue(msg.Properties.MessageID)
+		m.CorrelationID = common.FormatPropertyValue(msg.Properties.CorrelationID)
+		m.To = msg.Properties.To
+		m.ContentType = msg.Properties.ContentType
+		m.ContentEncoding = msg.Properties.ContentEncoding
+		if !msg.Properties.AbsoluteExpiryTime.IsZero() {
+			t := msg.Properties.AbsoluteExpiryTime.UTC()
+			m.ExpiryTime = &t
 		}
-		if msg.Properties.CorrelationID != nil {
-			m.CorrelationID = msg.Properties.CorrelationID.(string)
+	}
+	if msg.Header != nil {
+		m.DeliveryCount = msg.Header.DeliveryCount
+	}
+	if len(msg.Annotations) != 0 {
+		if t, ok := parseTime(msg.Annotations[common.AMQPEnqueuedTime]); ok {
+			m.EnqueuedTime = &t
 		}
-		m.To = msg.Properties.To
-		m.ExpiryTime = &msg.Properties.AbsoluteExpiryTime
+		m.ConnectionDeviceID = annotation(msg, common.AMQPConnectionDeviceID)
+		m.ConnectionDeviceGenerationID = annotation(msg, common.AMQPConnectionDeviceGenerationID)
+		m.ConnectionAuthMethod = annotation(msg, common.AMQPConnectionAuthMethod)
+		m.MessageSource = annotation(msg, common.AMQPMessageSource)
 	}
+	if t, ok := parseTime(msg.ApplicationProperties[common.AMQPCreationTime]); ok {
+		m.CreationTime = &t
+	}
+	m.SetLazyProperties(func() map[string]string {
+		return properties(msg)
+	})
+	return m
+}
+
+func annotation(msg *amqp.Message, k string) string {
+	if v, ok := msg.Annotations[k]; ok {
+		return common.FormatPropertyValue(v)
+	}
+	return ""
+}
+
+// systemAnnotations are annotations converted into system properties.
+var systemAnnotations = map[string]bool{
+	common.AMQPEnqueuedTime:                 true,
+	common.AMQPConnectionDeviceID:           true,
+	common.AMQPConnectionDeviceGenerationID: true,
+	common.AMQPConnectionAuthMethod:         true,
+	common.AMQPMessageSource:                true,
+}
+
+// properties converts custom annotations and application properties.
+func properties(msg *amqp.Message) map[string]string {
+	m := make(map[string]string, len(msg.ApplicationProperties)+len(msg.Annotations))
 	for k, v := range msg.Annotations {
-		switch k {
-		case "iothub-enqueuedtime":
-			t, _ := v.(time.Time)
-			m.EnqueuedTime = &t
-		case "iothub-connection-device-id":
-			m.ConnectionDeviceID = v.(string)
-		case "iothub-connection-auth-generation-id":
-			m.ConnectionDeviceGenerationID = v.(string)
-		case "iothub-connection-auth-method":
-			m.ConnectionAuthMethod = v.(string)
-		case "iothub-message-source":
-			m.MessageSource = v.(string)
-		default:
-			m.Properties[k.(string)] = fmt.Sprint(v)
+		if s, ok := k.(string); ok && systemAnnotations[s] {
+			continue
 		}
+		m[common.FormatPropertyValue(k)] = common.FormatPropertyValue(v)
 	}
 	for k, v := range msg.ApplicationProperties {
-		m.Properties[k] = v.(string)
+		if k == common.AMQPCreationTime {
+			if _, ok := parseTime(v); ok {
+				continue
+			}
+		}
+		m[k] = common.FormatPropertyValue(v)
 	}
 	return m
 }
 
+// parseTime converts a timestamp that can be either
+// an AMQP timestamp or a string into a UTC time.
+func parseTime(v interface{}) (time.Time, bool) {
+	switch v := v.(type) {
+	case time.Time:
+		return v.UTC(), true
+	case string:
+		t, err := common.ParseTime(v)
+		return t, err == nil
+	default:
+		return time.Time{}, false
+	}
+}
+
 // ToAMQPMessage converts amqp.Message into common.Message.
 func ToAMQPMessage(msg *common.Message) *amqp.Message {
-	props := make(map[string]interface{}, len(msg.Properties))
+	props := make(map[string]interface{}, len(msg.Properties)+1)
 	for k, v := range msg.Properties {
 		props[k] = v
 	}
-	return &amqp.Message{
+	if msg.CreationTime != nil && !msg.CreationTime.IsZero() {
+		props[common.AMQPCreationTime] = common.FormatTime(*msg.CreationTime)
+	}
+	m := &amqp.Message{
 		Data: [][]byte{msg.Payload},
 		Properties: &amqp.MessageProperties{
-			To:                 msg.To,
-			UserID:             []byte(msg.UserID),
-			MessageID:          msg.MessageID,
-			CorrelationID:      msg.CorrelationID,
-			AbsoluteExpiryTime: *msg.ExpiryTime,
+			To:              msg.To,
+			UserID:          []byte(msg.UserID),
+			MessageID:       msg.MessageID,
+			CorrelationID:   msg.CorrelationID,
+			ContentType:     msg.ContentType,
+			ContentEncoding: msg.ContentEncoding,
 		},
 		ApplicationProperties: props,
 	}
+	if msg.ExpiryTime != nil {
+		m.Properties.AbsoluteExpiryTime = *msg.ExpiryTime
+	}
+	if ttl, ok := msg.TransportOptions[TTLOption].(time.Duration); ok {
+		m.Header = &amqp.MessageHeader{TTL: ttl}
+	}
+	if a, ok := msg.TransportOptions[AnnotationsOption].(map[string]interface{}); ok {
+		m.Annotations = make(amqp.Annotations, len(a))
+		for k, v := range a {
+			m.Annotations[k] = v
+		}
+	}
+	return m
 }
+
+const (
+	// TTLOption is a message TransportOptions key holding
+	// a time.Duration that's set as the AMQP header TTL.
+	TTLOption = "amqp-ttl"
+
+	// AnnotationsOption is a message TransportOptions key holding
+	// a map[string]interface{} of AMQP message annotations.
+	AnnotationsOption = "amqp-annotations"
+)
@@ -0,0 +1,195 @@
+package commonamqp
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+	"pack.ag/amqp"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	exp := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	ctime := time.Date(2020, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	msg := &common.Message{
+		MessageID:       "mid",
+		CorrelationID:   "cid",
+		UserID:          "uid",
+		To:              "/devices/dev/messages/deviceBound",
+		ContentType:     "application/json",
+		ContentEncoding: "utf-8",
+		ExpiryTime:      &exp,
+		CreationTime:    &ctime,
+		Payload:         []byte("hello"),
+		Properties: map[string]string{
+			"a&b":   "c=d",
+			"space": "a b",
+			"empty": "",
+		},
+	}
+	if got := FromAMQPMessage(ToAMQPMessage(msg)); !reflect.DeepEqual(got, msg) {
+		t.Errorf("round trip = %+v, want %+v", got, msg)
+	}
+}
+
+func TestToAMQPMessageTransportOptions(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range map[string]struct {
+		opts   map[string]interface{}
+		header *amqp.MessageHeader
+		annots amqp.Annotations
+		props  map[string]string
+	}{
+		"none": {
+			props: map[string]string{},
+		},
+		"ttl": {
+			opts:   map[string]interface{}{TTLOption: 5 * time.Second},
+			header: &amqp.MessageHeader{TTL: 5 * time.Second},
+			props:  map[string]string{},
+		},
+		"annotations": {
+			opts: map[string]interface{}{
+				AnnotationsOption: map[string]interface{}{"x-opt-a": "b", "x-opt-n": int64(1)},
+			},
+			annots: amqp.Annotations{"x-opt-a": "b", "x-opt-n": int64(1)},
+			props:  map[string]string{"x-opt-a": "b", "x-opt-n": "1"},
+		},
+		"wrong types": {
+			opts: map[string]interface{}{
+				TTLOption:         5,
+				AnnotationsOption: map[string]string{"x-opt-a": "b"},
+			},
+			props: map[string]string{},
+		},
+	} {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			m := ToAMQPMessage(&common.Message{TransportOptions: tc.opts})
+			if !reflect.DeepEqual(m.Header, tc.header) {
+				t.Errorf("Header = %+v, want %+v", m.Header, tc.header)
+			}
+			if !reflect.DeepEqual(m.Annotations, tc.annots) {
+				t.Errorf("Annotations = %v, want %v", m.Annotations, tc.annots)
+			}
+			if got := FromAMQPMessage(m).Properties; !reflect.DeepEqual(got, tc.props) {
+				t.Errorf("round trip properties = %v, want %v", got, tc.props)
+			}
+		})
+	}
+}
+
+func TestFromAMQPMessage(t *testing.T) {
+	t.Parallel()
+
+	enq := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	ctime := time.Date(2020, 1, 2, 3, 4, 5, 123456700, time.UTC)
+	got := FromAMQPMessage(&amqp.Message{
+		Data: [][]byte{[]byte("x")},
+		Properties: &amqp.MessageProperties{
+			MessageID:     uint64(42),
+			CorrelationID: []byte("cid"),
+		},
+		Annotations: amqp.Annotations{
+			common.AMQPEnqueuedTime:       enq,
+			common.AMQPConnectionDeviceID: "dev",
+			"x-opt-sequence-number":       int64(7),
+		},
+		ApplicationProperties: map[string]interface{}{
+			"s":                     "v",
+			"i":                     int32(-1),
+			"b":                     true,
+			common.AMQPCreationTime: "2020-01-02T03:04:05.1234567",
+		},
+	})
+	want := &common.Message{
+		MessageID:          "42",
+		CorrelationID:      "cid",
+		EnqueuedTime:       &enq,
+		CreationTime:       &ctime,
+		ConnectionDeviceID: "dev",
+		Payload:            []byte("x"),
+		Properties: map[string]string{
+			"x-opt-sequence-number": "7",
+			"s":                     "v",
+			"i":                     "-1",
+			"b":                     "true",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FromAMQPMessage() = %+v, want %+v", got, want)
+	}
+}
+
+func TestFromAMQPMessageLazy(t *testing.T) {
+	t.Parallel()
+
+	msg := FromAMQPMessageLazy(&amqp.Message{
+		Annotations: amqp.Annotations{
+			common.AMQPConnectionDeviceID: "dev",
+			"x-opt-sequence-number":       int64(7),
+		},
+		ApplicationProperties: map[string]interface{}{
+			"s": "v",
+		},
+	})
+	if msg.ConnectionDeviceID != "dev" {
+		t.Errorf("ConnectionDeviceID = %q, want %q", msg.ConnectionDeviceID, "dev")
+	}
+	if msg.Properties != nil {
+		t.Fatalf("Properties = %v, want them parsed lazily", msg.Properties)
+	}
+
+	// properties set before loading take precedence
+	msg.Properties = map[string]string{"s": "w"}
+	if v, ok := msg.Property("s"); !ok || v != "w" {
+		t.Errorf("Property(%q) = %q, %t, want %q, true", "s", v, ok, "w")
+	}
+	want := map[string]string{"x-opt-sequence-number": "7", "s": "w"}
+	if got := msg.LoadProperties(); !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadProperties() = %v, want %v", got, want)
+	}
+}
+
+func benchmarkMessage() *amqp.Message {
+	msg := &amqp.Message{
+		Data: [][]byte{[]byte(`{"temperature":21.5}`)},
+		Annotations: amqp.Annotations{
+			common.AMQPEnqueuedTime:                 time.Now(),
+			common.AMQPConnectionDeviceID:           "dev",
+			common.AMQPConnectionDeviceGenerationID: "636000000000000000",
+			common.AMQPConnectionAuthMethod:         `{"scope":"device","type":"sas","issuer":"iothub"}`,
+			common.AMQPMessageSource:                "Telemetry",
+			"x-opt-sequence-number":                 int64(7),
+			"x-opt-offset":                          "1024",
+		},
+		ApplicationProperties: map[string]interface{}{},
+	}
+	for i := 0; i < 10; i++ {
+		msg.ApplicationProperties["p"+strconv.Itoa(i)] = int64(i)
+	}
+	return msg
+}
+
+func BenchmarkFromAMQPMessage(b *testing.B) {
+	msg := benchmarkMessage()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		FromAMQPMessage(msg)
+	}
+}
+
+func BenchmarkFromAMQPMessageLazy(b *testing.B) {
+	msg := benchmarkMessage()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		FromAMQPMessageLazy(msg)
+	}
+}
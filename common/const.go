@@ -2,3 +2,18 @@ package common
 
 // APIVersion is yet to figure out what it implies.
 const APIVersion = "2018-01-16"
+
+// SDKName identifies the library in user agents.
+const SDKName = "golang-iothub"
+
+// SDKVersion is the library version, it's bumped on every release.
+const SDKVersion = "0.1.0"
+
+// UserAgent returns the user agent string with
+// the given application product info prepended if it's set.
+func UserAgent(product string) string {
+	if product == "" {
+		return SDKName
+	}
+	return product + " " + SDKName
+}
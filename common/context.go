@@ -0,0 +1,19 @@
+package common
+
+import "context"
+
+// WithDone returns a copy of ctx that's also canceled when done is closed,
+// e.g. to cancel handlers contexts when their client is closed.
+func WithDone(ctx context.Context, done <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	if done != nil {
+		go func() {
+			select {
+			case <-done:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	return ctx, cancel
+}
@@ -0,0 +1,22 @@
+package common
+
+import (
+	"context"
+	"testing"
+)
+
+func TestWithDone(t *testing.T) {
+	t.Parallel()
+
+	done := make(chan struct{})
+	ctx, cancel := WithDone(context.Background(), done)
+	defer cancel()
+	if ctx.Err() != nil {
+		t.Fatal("context is canceled before done is closed")
+	}
+	close(done)
+	<-ctx.Done()
+	if ctx.Err() != context.Canceled {
+		t.Errorf("err = %v, want %v", ctx.Err(), context.Canceled)
+	}
+}
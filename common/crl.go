@@ -0,0 +1,129 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrRevoked is returned when a server certificate chain
+// contains a certificate revoked by its issuer.
+var ErrRevoked = errors.New("tls: server certificate is revoked")
+
+// CRLChecker checks server certificate chains against certificate
+// revocation lists of their issuers, lists are cached until their
+// next update time.
+//
+// Stapled OCSP responses are not verified, because parsing them
+// requires golang.org/x/crypto/ocsp that's not a dependency.
+type CRLChecker struct {
+	client   *http.Client
+	softFail bool
+
+	mu    sync.Mutex
+	cache map[string]*x509.RevocationList
+}
+
+// NewCRLChecker creates a checker, in soft-fail mode certificates which
+// revocation lists cannot be fetched or verified are considered valid,
+// otherwise such connections are rejected.
+func NewCRLChecker(softFail bool) *CRLChecker {
+	return &CRLChecker{
+		client:   &http.Client{Timeout: 10 * time.Second},
+		softFail: softFail,
+		cache:    map[string]*x509.RevocationList{},
+	}
+}
+
+// TLSConfig returns a copy of cfg that additionally checks revocation
+// status of the verified chain, it returns cfg as is when ch is nil.
+func (ch *CRLChecker) TLSConfig(cfg *tls.Config) *tls.Config {
+	if ch == nil {
+		return cfg
+	}
+	return addVerifier(cfg, func(chains [][]*x509.Certificate) error {
+		// checking the first verified chain is enough
+		if len(chains) == 0 {
+			return nil
+		}
+		return ch.Check(context.Background(), chains[0])
+	})
+}
+
+// Check checks every certificate of the chain except the root,
+// the chain starts with the leaf and every next certificate is the issuer.
+func (ch *CRLChecker) Check(ctx context.Context, chain []*x509.Certificate) error {
+	for i := 0; i < len(chain)-1; i++ {
+		crt, issuer := chain[i], chain[i+1]
+		if len(crt.CRLDistributionPoints) == 0 {
+			continue
+		}
+		l, err := ch.fetch(ctx, crt.CRLDistributionPoints, issuer)
+		if err != nil {
+			if ch.softFail {
+				continue
+			}
+			return err
+		}
+		for _, r := range l.RevokedCertificateEntries {
+			if r.SerialNumber.Cmp(crt.SerialNumber) == 0 {
+				return ErrRevoked
+			}
+		}
+	}
+	return nil
+}
+
+// fetch returns the first list that can be fetched
+// from the given distribution points and verified.
+func (ch *CRLChecker) fetch(ctx context.Context, urls []string, issuer *x509.Certificate) (*x509.RevocationList, error) {
+	var err error
+	for _, u := range urls {
+		ch.mu.Lock()
+		l, ok := ch.cache[u]
+		ch.mu.Unlock()
+		if ok && time.Now().Before(l.NextUpdate) {
+			return l, nil
+		}
+		if l, err = ch.download(ctx, u, issuer); err == nil {
+			ch.mu.Lock()
+			ch.cache[u] = l
+			ch.mu.Unlock()
+			return l, nil
+		}
+	}
+	return nil, err
+}
+
+func (ch *CRLChecker) download(ctx context.Context, u string, issuer *x509.Certificate) (*x509.RevocationList, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := ch.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("crl: %s returned %s", u, res.Status)
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	l, err := x509.ParseRevocationList(b)
+	if err != nil {
+		return nil, err
+	}
+	if err = l.CheckSignatureFrom(issuer); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,101 @@
+package common
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCRLChecker(t *testing.T) {
+	t.Parallel()
+
+	var crl []byte
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if crl == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(crl)
+	}))
+	defer s.Close()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	b, err := x509.CreateCertificate(rand.Reader, caTpl, caTpl, caKey.Public(), caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf := func(serial int64) *x509.Certificate {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber:          big.NewInt(serial),
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			CRLDistributionPoints: []string{s.URL},
+		}, ca, key.Public(), caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		crt, err := x509.ParseCertificate(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return crt
+	}
+
+	ctx := context.Background()
+	good, revoked := leaf(2), leaf(3)
+	if err = NewCRLChecker(false).Check(ctx, []*x509.Certificate{good, ca}); err == nil {
+		t.Error("missing crl is ignored in hard-fail mode")
+	}
+	if err = NewCRLChecker(true).Check(ctx, []*x509.Certificate{good, ca}); err != nil {
+		t.Errorf("missing crl is not ignored in soft-fail mode: %s", err)
+	}
+
+	crl, err = x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now(),
+		NextUpdate: time.Now().Add(time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{
+			{SerialNumber: revoked.SerialNumber, RevocationTime: time.Now()},
+		},
+	}, ca, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := NewCRLChecker(false)
+	if err = ch.Check(ctx, []*x509.Certificate{good, ca}); err != nil {
+		t.Errorf("Check(good) = %v, want nil", err)
+	}
+	if err = ch.Check(ctx, []*x509.Certificate{revoked, ca}); err != ErrRevoked {
+		t.Errorf("Check(revoked) = %v, want %v", err, ErrRevoked)
+	}
+}
@@ -0,0 +1,166 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"time"
+)
+
+// Resolver looks up host addresses, *net.Resolver implements it.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// Dialer dials hosts resolving their addresses on every call, so
+// reconnects follow DNS changes, e.g. hub IPs moving during failovers.
+//
+// Dual-stack hosts are dialed happy eyeballs style: IPv6 and IPv4
+// addresses are interleaved and each next one is tried after
+// FallbackDelay without waiting for the previous attempt to fail.
+type Dialer struct {
+	Resolver      Resolver      // net.DefaultResolver when nil
+	Timeout       time.Duration // of the whole dial, no limit when zero
+	FallbackDelay time.Duration // 300ms when zero
+}
+
+// DefaultDialer is the dialer used by the transports by default.
+var DefaultDialer = &Dialer{Timeout: 30 * time.Second}
+
+// DialContext connects to addr on the named tcp network.
+func (d *Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+
+	var ips []net.IPAddr
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IPAddr{{IP: ip}}
+	} else {
+		r := d.Resolver
+		if r == nil {
+			r = net.DefaultResolver
+		}
+		if ips, err = r.LookupIPAddr(ctx, host); err != nil {
+			return nil, err
+		}
+	}
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return d.dialParallel(ctx, network, interleave(ips), port)
+}
+
+// DialTLS connects to addr and performs a TLS handshake,
+// the config's ServerName defaults to the addr's host.
+func (d *Dialer) DialTLS(ctx context.Context, addr string, config *tls.Config) (net.Conn, error) {
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		host, _, _ := net.SplitHostPort(addr)
+		config = config.Clone()
+		config.ServerName = host
+	}
+	tc := tls.Client(conn, config)
+	if err = tc.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+func (d *Dialer) dialParallel(ctx context.Context, network string, ips []net.IPAddr, port string) (net.Conn, error) {
+	delay := d.FallbackDelay
+	if delay == 0 {
+		delay = 300 * time.Millisecond
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialResult, len(ips))
+	dial := func(ip net.IPAddr) {
+		var nd net.Dialer
+		conn, err := nd.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		results <- dialResult{conn, err}
+	}
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+
+	var errs []error
+	next, pending := 0, 0
+	for {
+		if next < len(ips) && pending == 0 {
+			go dial(ips[next])
+			next, pending = next+1, pending+1
+			t.Reset(delay)
+		}
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				// close connections of the attempts that succeed later
+				go func(n int) {
+					for ; n > 0; n-- {
+						if r := <-results; r.conn != nil {
+							r.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+			errs = append(errs, r.err)
+			if pending == 0 && next == len(ips) {
+				return nil, errors.Join(errs...)
+			}
+		case <-t.C:
+			if next < len(ips) {
+				go dial(ips[next])
+				next, pending = next+1, pending+1
+				t.Reset(delay)
+			}
+		}
+	}
+}
+
+// interleave alternates IPv6 and IPv4 addresses starting from
+// the family of the first one, preserving the resolver's order.
+func interleave(ips []net.IPAddr) []net.IPAddr {
+	var a, b []net.IPAddr
+	first := ips[0].IP.To4() == nil
+	for _, ip := range ips {
+		if (ip.IP.To4() == nil) == first {
+			a = append(a, ip)
+		} else {
+			b = append(b, ip)
+		}
+	}
+	l := make([]net.IPAddr, 0, len(ips))
+	for i := 0; i < len(a) || i < len(b); i++ {
+		if i < len(a) {
+			l = append(l, a[i])
+		}
+		if i < len(b) {
+			l = append(l, b[i])
+		}
+	}
+	return l
+}
@@ -0,0 +1,87 @@
+package common
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type testResolver struct {
+	mu  sync.Mutex
+	ips []net.IPAddr
+}
+
+func (r *testResolver) set(ips ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ips = r.ips[:0]
+	for _, ip := range ips {
+		r.ips = append(r.ips, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+}
+
+func (r *testResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]net.IPAddr(nil), r.ips...), nil
+}
+
+func TestDialerReresolves(t *testing.T) {
+	t.Parallel()
+
+	l1, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l1.Close()
+	_, port, _ := net.SplitHostPort(l1.Addr().String())
+	l2, err := net.Listen("tcp", "127.0.0.2:"+port)
+	if err != nil {
+		t.Skipf("cannot listen on the second loopback address: %s", err)
+	}
+	defer l2.Close()
+
+	r := &testResolver{}
+	d := &Dialer{Resolver: r}
+	dial := func(want string) {
+		t.Helper()
+		conn, err := d.DialContext(context.Background(), "tcp", net.JoinHostPort("hub.example", port))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		if host, _, _ := net.SplitHostPort(conn.RemoteAddr().String()); host != want {
+			t.Errorf("connected to %s, want %s", host, want)
+		}
+	}
+
+	// failover moves the hub to a different address
+	r.set("127.0.0.1")
+	dial("127.0.0.1")
+	r.set("127.0.0.2")
+	dial("127.0.0.2")
+
+	// the first address is unreachable, the next family is tried
+	l1.Close()
+	r.set("::1", "127.0.0.1", "127.0.0.2")
+	dial("127.0.0.2")
+}
+
+func TestInterleave(t *testing.T) {
+	t.Parallel()
+
+	var ips []net.IPAddr
+	for _, ip := range []string{"::1", "::2", "::3", "10.0.0.1", "10.0.0.2"} {
+		ips = append(ips, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	var got []string
+	for _, ip := range interleave(ips) {
+		got = append(got, ip.String())
+	}
+	want := []string{"::1", "10.0.0.1", "::2", "10.0.0.2", "::3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("interleave() = %v, want %v", got, want)
+	}
+}
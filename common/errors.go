@@ -0,0 +1,32 @@
+package common
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Error classes that request errors of both clients can be matched
+// against with errors.Is regardless of the transport they came from.
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrThrottled    = errors.New("throttled")
+	ErrTimeout      = errors.New("timeout")
+)
+
+// StatusClass returns the error class of the given http status code,
+// nil means that the status code doesn't belong to any of them.
+func StatusClass(code int) error {
+	switch code {
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrUnauthorized
+	case http.StatusTooManyRequests:
+		return ErrThrottled
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return ErrTimeout
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,49 @@
+package common
+
+import (
+	"crypto/fips140"
+	"crypto/tls"
+)
+
+// FIPSEnabled reports whether the library restricts itself to FIPS 140
+// approved TLS parameters, that's the case when it's built with the fips
+// tag or when Go's FIPS 140 module is enabled with GODEBUG=fips140=on.
+//
+// The fips tag only narrows TLS versions, ciphers and curves down,
+// cryptographic operations go through the validated module only when
+// it's enabled with GODEBUG=fips140=on or built with GOFIPS140,
+// that's what deployments requiring FIPS 140 validation must do.
+//
+// Credentials rely only on HMAC-SHA256 and crypto/rand, SHA-1 is used
+// only for certificate thumbprints the hub identifies certificates by,
+// not for security, and MD5 is not used at all.
+func FIPSEnabled() bool {
+	return fipsBuild || fips140.Enabled()
+}
+
+// FIPSTLSConfig returns a copy of cfg restricted to FIPS approved
+// parameters when FIPSEnabled, otherwise it returns cfg as is.
+func FIPSTLSConfig(cfg *tls.Config) *tls.Config {
+	if !FIPSEnabled() {
+		return cfg
+	}
+	return fipsTLSConfig(cfg)
+}
+
+func fipsTLSConfig(cfg *tls.Config) *tls.Config {
+	cfg = cfg.Clone()
+	cfg.MinVersion = tls.VersionTLS12
+	if !fips140.Enabled() {
+		// TLS 1.3 cipher suites are not configurable, ChaCha20-Poly1305
+		// is offered unless the FIPS 140 module restricts them itself
+		cfg.MaxVersion = tls.VersionTLS12
+	}
+	cfg.CipherSuites = []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	}
+	cfg.CurvePreferences = []tls.CurveID{tls.CurveP256, tls.CurveP384}
+	return cfg
+}
@@ -0,0 +1,30 @@
+package common
+
+import (
+	"crypto/fips140"
+	"crypto/tls"
+	"testing"
+)
+
+func TestFIPSTLSConfig(t *testing.T) {
+	t.Parallel()
+
+	cfg := &tls.Config{ServerName: "test"}
+	g := fipsTLSConfig(cfg)
+	if g == cfg {
+		t.Fatal("config is not copied")
+	}
+	if g.MinVersion != tls.VersionTLS12 || g.ServerName != "test" {
+		t.Errorf("unexpected config: min version = %x, server name = %q", g.MinVersion, g.ServerName)
+	}
+	if !fips140.Enabled() && g.MaxVersion != tls.VersionTLS12 {
+		t.Errorf("max version = %x, want TLS 1.2 outside of the FIPS 140 module", g.MaxVersion)
+	}
+	for _, id := range g.CipherSuites {
+		for _, cs := range tls.InsecureCipherSuites() {
+			if cs.ID == id {
+				t.Errorf("insecure cipher suite %s is allowed", cs.Name)
+			}
+		}
+	}
+}
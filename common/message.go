@@ -1,6 +1,7 @@
 package common
 
 import (
+	"sync"
 	"time"
 )
 
@@ -20,6 +21,14 @@ type Message struct {
 	// EnqueuedTime is time the Cloud-to-Device message was received by IoT Hub.
 	EnqueuedTime *time.Time `json:"EnqueuedTime,omitempty"`
 
+	// CreationTime is time the message was created by its sender,
+	// unlike EnqueuedTime it's set by the device, e.g. for buffered telemetry.
+	CreationTime *time.Time `json:"CreationTimeUtc,omitempty"`
+
+	// DeliveryCount is the number of times a cloud-to-device message
+	// was delivered before, it's zero for transports that don't report it.
+	DeliveryCount uint32 `json:"DeliveryCount,omitempty"`
+
 	// CorrelationID is a string property in a response message that typically
 	// contains the MessageId of the request, in request-reply patterns.
 	CorrelationID string `json:"CorrelationId,omitempty"`
@@ -27,6 +36,13 @@ type Message struct {
 	// UserID is an ID used to specify the origin of messages.
 	UserID string `json:"UserId,omitempty"`
 
+	// ContentType is the payload MIME type, along with ContentEncoding it
+	// has to be set for routing queries to be able to access the payload.
+	ContentType string `json:"ContentType,omitempty"`
+
+	// ContentEncoding is the payload character encoding, e.g. utf-8.
+	ContentEncoding string `json:"ContentEncoding,omitempty"`
+
 	// ConnectionDeviceID is an ID set by IoT Hub on device-to-cloud messages.
 	// It contains the deviceId of the device that sent the message.
 	ConnectionDeviceID string `json:"ConnectionDeviceId,omitempty"`
@@ -41,6 +57,12 @@ type Message struct {
 	// the authentication method used to authenticate the device sending the message.
 	ConnectionAuthMethod string `json:"ConnectionAuthMethod,omitempty"`
 
+	// InputName is the edge module input the message is received on.
+	InputName string `json:"InputName,omitempty"`
+
+	// OutputName is the edge module output the message is sent to.
+	OutputName string `json:"OutputName,omitempty"`
+
 	// MessageSource determines a device-to-cloud message transport.
 	MessageSource string `json:"MessageSource,omitempty"`
 
@@ -48,8 +70,61 @@ type Message struct {
 	Payload []byte `json:"Payload,omitempty"`
 
 	// Properties are custom message properties (property bags).
+	//
+	// They can be parsed lazily by the transport, in which case
+	// LoadProperties has to be called before accessing the field directly.
 	Properties map[string]string `json:"Properties,omitempty"`
 
 	// TransportOptions transport specific options.
 	TransportOptions map[string]interface{} `json:"-"`
+
+	lazy *lazyProperties
+}
+
+type lazyProperties struct {
+	once sync.Once
+	fn   func() map[string]string
+	m    map[string]string
+}
+
+// SetLazyProperties makes Properties be parsed by fn on the first access
+// through Property or LoadProperties, it's meant for transports.
+func (msg *Message) SetLazyProperties(fn func() map[string]string) {
+	msg.lazy = &lazyProperties{fn: fn}
+}
+
+// LoadProperties parses lazily parsed Properties, if they are,
+// and returns them. It's safe to call multiple times.
+func (msg *Message) LoadProperties() map[string]string {
+	if l := msg.lazy; l != nil {
+		l.once.Do(func() {
+			if l.m = l.fn(); l.m == nil {
+				l.m = map[string]string{}
+			}
+			l.fn = nil
+		})
+		for k, v := range msg.Properties {
+			l.m[k] = v // set before loading
+		}
+		msg.Properties, msg.lazy = l.m, nil
+	}
+	return msg.Properties
+}
+
+// Property returns the named custom property.
+func (msg *Message) Property(k string) (string, bool) {
+	v, ok := msg.LoadProperties()[k]
+	return v, ok
+}
+
+// DedupeKeyProperty is the application property carrying a key that stays
+// the same when a message is resent, so consumers can de-duplicate it.
+const DedupeKeyProperty = "dedupe-key"
+
+// DedupeKey returns the message dedupe key falling back to its id.
+func (msg *Message) DedupeKey() string {
+	if k, _ := msg.Property(DedupeKeyProperty); k != "" {
+		return k
+	}
+	return msg.MessageID
 }
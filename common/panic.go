@@ -0,0 +1,41 @@
+package common
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+)
+
+// PanicError is a panic recovered from a user handler.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panic: %v", e.Value)
+}
+
+// Recover recovers a panic of a handler and passes it to fn as a *PanicError,
+// so handlers cannot crash the process from library-owned goroutines,
+// it's logged when fn is nil. It has to be deferred directly:
+//
+//	defer common.Recover(fn)
+func Recover(fn func(err error)) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	err := &PanicError{Value: v, Stack: debug.Stack()}
+	if fn == nil {
+		log.Printf("%s\n%s", err, err.Stack)
+		return
+	}
+	fn(err)
+}
+
+// Guard calls fn recovering its panic, see Recover.
+func Guard(onPanic func(err error), fn func()) {
+	defer Recover(onPanic)
+	fn()
+}
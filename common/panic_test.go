@@ -0,0 +1,32 @@
+package common
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestGuard(t *testing.T) {
+	t.Parallel()
+
+	var err error
+	Guard(func(e error) {
+		err = e
+	}, func() {
+		panic("boom")
+	})
+	var pe *PanicError
+	if !errors.As(err, &pe) {
+		t.Fatalf("err = %v, want a *PanicError", err)
+	}
+	if pe.Value != "boom" || len(pe.Stack) == 0 {
+		t.Errorf("err = %+v", pe)
+	}
+
+	err = nil
+	Guard(func(e error) {
+		err = e
+	}, func() {})
+	if err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+}
@@ -0,0 +1,205 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MQTT topic names of message system properties.
+const (
+	MQTTMessageID          = "$.mid"
+	MQTTCorrelationID      = "$.cid"
+	MQTTUserID             = "$.uid"
+	MQTTTo                 = "$.to"
+	MQTTContentType        = "$.ct"
+	MQTTContentEncoding    = "$.ce"
+	MQTTExpiryTime         = "$.exp"
+	MQTTConnectionDeviceID = "$.cdid"
+	MQTTOutputName         = "$.on"
+	MQTTCreationTime       = "$.ctime"
+)
+
+// AMQP annotation names of message system properties set by the hub.
+const (
+	AMQPEnqueuedTime                 = "iothub-enqueuedtime"
+	AMQPConnectionDeviceID           = "iothub-connection-device-id"
+	AMQPConnectionDeviceGenerationID = "iothub-connection-auth-generation-id"
+	AMQPConnectionAuthMethod         = "iothub-connection-auth-method"
+	AMQPMessageSource                = "iothub-message-source"
+)
+
+// AMQPCreationTime is the application property holding message creation time.
+const AMQPCreationTime = "iothub-creation-time-utc"
+
+// FormatPropertyValue converts a non-string AMQP property or annotation
+// value into the string form it has in Message.Properties, the conversion
+// is deterministic so equal values always produce equal strings.
+func FormatPropertyValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.FormatInt(int64(v), 10)
+	case int8:
+		return strconv.FormatInt(int64(v), 10)
+	case int16:
+		return strconv.FormatInt(int64(v), 10)
+	case int32:
+		return strconv.FormatInt(int64(v), 10)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case uint:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint8:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint16:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint32:
+		return strconv.FormatUint(uint64(v), 10)
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// EncodeMQTTProperties encodes system and application properties
+// of the message into the MQTT topic property bag format.
+//
+// Keys and values are percent-encoded, including spaces, and
+// properties are sorted, so the result is stable for a message.
+func EncodeMQTTProperties(msg *Message) string {
+	var b strings.Builder
+	add := func(k, v string) {
+		if b.Len() != 0 {
+			b.WriteByte('&')
+		}
+		b.WriteString(escapeProperty(k))
+		b.WriteByte('=')
+		b.WriteString(escapeProperty(v))
+	}
+	for _, p := range []struct{ k, v string }{
+		{MQTTMessageID, msg.MessageID},
+		{MQTTCorrelationID, msg.CorrelationID},
+		{MQTTUserID, msg.UserID},
+		{MQTTTo, msg.To},
+		{MQTTContentType, msg.ContentType},
+		{MQTTContentEncoding, msg.ContentEncoding},
+		{MQTTConnectionDeviceID, msg.ConnectionDeviceID},
+		{MQTTOutputName, msg.OutputName},
+	} {
+		if p.v != "" {
+			add(p.k, p.v)
+		}
+	}
+	if msg.ExpiryTime != nil && !msg.ExpiryTime.IsZero() {
+		add(MQTTExpiryTime, FormatTime(*msg.ExpiryTime))
+	}
+	if msg.CreationTime != nil && !msg.CreationTime.IsZero() {
+		add(MQTTCreationTime, FormatTime(*msg.CreationTime))
+	}
+
+	keys := make([]string, 0, len(msg.Properties))
+	for k := range msg.Properties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		add(k, msg.Properties[k])
+	}
+	return b.String()
+}
+
+func escapeProperty(s string) string {
+	// QueryEscape encodes spaces as pluses that the hub
+	// doesn't decode, literal pluses are escaped by it.
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+// DecodeMQTTProperties decodes the MQTT topic property bag s
+// into the message system properties and application properties.
+// Every key-value pair is unescaped exactly once.
+func DecodeMQTTProperties(msg *Message, s string) error {
+	if s == "" {
+		return nil
+	}
+	seen := map[string]bool{}
+	for _, kv := range strings.Split(s, "&") {
+		if kv == "" {
+			continue
+		}
+		k, v := kv, ""
+		if i := strings.IndexByte(kv, '='); i != -1 {
+			k, v = kv[:i], kv[i+1:]
+		}
+		k, err := url.QueryUnescape(k)
+		if err != nil {
+			return err
+		}
+		if v, err = url.QueryUnescape(v); err != nil {
+			return err
+		}
+		if seen[k] {
+			return fmt.Errorf("duplicate property %q", k)
+		}
+		seen[k] = true
+
+		switch k {
+		case "":
+			return errors.New("property name is empty")
+		case MQTTMessageID:
+			msg.MessageID = v
+		case MQTTCorrelationID:
+			msg.CorrelationID = v
+		case MQTTUserID:
+			msg.UserID = v
+		case MQTTTo:
+			msg.To = v
+		case MQTTContentType:
+			msg.ContentType = v
+		case MQTTContentEncoding:
+			msg.ContentEncoding = v
+		case MQTTConnectionDeviceID:
+			msg.ConnectionDeviceID = v
+		case MQTTOutputName:
+			msg.OutputName = v
+		case MQTTExpiryTime:
+			t, err := ParseTime(v)
+			if err != nil {
+				return err
+			}
+			msg.ExpiryTime = &t
+		case MQTTCreationTime:
+			t, err := ParseTime(v)
+			if err != nil {
+				return err
+			}
+			msg.CreationTime = &t
+		default:
+			if msg.Properties == nil {
+				msg.Properties = map[string]string{}
+			}
+			msg.Properties[k] = v
+		}
+	}
+	return nil
+}
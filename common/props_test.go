@@ -0,0 +1,111 @@
+package common
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMQTTPropertiesRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	exp := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	ctime := time.Date(2020, 1, 2, 3, 4, 5, 6000000, time.UTC)
+	for name, msg := range map[string]*Message{
+		"empty": {},
+		"system": {
+			MessageID:          "mid",
+			CorrelationID:      "cid",
+			UserID:             "uid",
+			To:                 "/devices/dev/messages/deviceBound",
+			ContentType:        "application/json",
+			ContentEncoding:    "utf-8",
+			ConnectionDeviceID: "dev",
+			OutputName:         "out",
+			ExpiryTime:         &exp,
+			CreationTime:       &ctime,
+		},
+		"escaping": {
+			MessageID: "a&b=c",
+			Properties: map[string]string{
+				"amp&":      "x&y",
+				"eq=":       "a=b",
+				"percent":   "100%",
+				"encoded":   "%26%3D",
+				"plus":      "1+1",
+				"space":     "a b",
+				"slash":     "a/b?c#d",
+				"unicode":   "привет, 世界",
+				"empty":     "",
+				"$.notsys":  "x",
+				"multi\nln": "a\r\nb",
+			},
+		},
+	} {
+		s := EncodeMQTTProperties(msg)
+		if strings.ContainsAny(s, " +") {
+			t.Errorf("%s: encoded %q contains spaces or pluses", name, s)
+		}
+		got := &Message{}
+		if err := DecodeMQTTProperties(got, s); err != nil {
+			t.Errorf("%s: decode %q error = %v", name, s, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, msg) {
+			t.Errorf("%s: round trip through %q = %+v, want %+v", name, s, got, msg)
+		}
+		if s2 := EncodeMQTTProperties(got); s2 != s {
+			t.Errorf("%s: encoding is not stable: %q != %q", name, s2, s)
+		}
+	}
+}
+
+func TestDecodeMQTTProperties(t *testing.T) {
+	t.Parallel()
+
+	for s, ok := range map[string]bool{
+		"a=1&b":       true,
+		"a=1&a=2":     false,
+		"=1":          false,
+		"a=%zz":       false,
+		"%24.exp=bad": false,
+		"a=%2B+%20":   true,
+	} {
+		msg := &Message{}
+		if err := DecodeMQTTProperties(msg, s); (err == nil) != ok {
+			t.Errorf("DecodeMQTTProperties(%q) error = %v, want ok = %t", s, err, ok)
+		}
+	}
+
+	msg := &Message{}
+	if err := DecodeMQTTProperties(msg, "a=%2B+%20&b"); err != nil {
+		t.Fatal(err)
+	}
+	if w := map[string]string{"a": "+  ", "b": ""}; !reflect.DeepEqual(msg.Properties, w) {
+		t.Errorf("properties = %q, want %q", msg.Properties, w)
+	}
+}
+
+func TestFormatPropertyValue(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		v    interface{}
+		want string
+	}{
+		{nil, ""},
+		{"s", "s"},
+		{[]byte("b"), "b"},
+		{true, "true"},
+		{int8(-1), "-1"},
+		{uint64(18446744073709551615), "18446744073709551615"},
+		{float32(0.1), "0.1"},
+		{1.5, "1.5"},
+		{time.Date(2020, 1, 2, 3, 4, 5, 6, time.FixedZone("x", 3600)), "2020-01-02T02:04:05.000000006Z"},
+	} {
+		if got := FormatPropertyValue(tc.v); got != tc.want {
+			t.Errorf("FormatPropertyValue(%#v) = %q, want %q", tc.v, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Package ratelimit implements a token bucket rate limiter.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// New creates a bucket that's refilled with rate tokens per second
+// and holds at most burst tokens, it's initially full.
+func New(rate float64, burst int) *Bucket {
+	if rate <= 0 {
+		panic("rate must be positive")
+	}
+	if burst < 1 {
+		panic("burst must be positive")
+	}
+	return &Bucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Bucket is a token bucket, it's safe for concurrent use.
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// refill adds tokens accumulated since the last call, mu has to be locked.
+func (b *Bucket) refill() {
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// Allow takes n tokens when they're available without blocking.
+func (b *Bucket) Allow(n int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// Wait blocks until n tokens are available and takes them or ctx is done.
+func (b *Bucket) Wait(ctx context.Context, n int) error {
+	if float64(n) > b.burst {
+		return fmt.Errorf("ratelimit: %d exceeds burst size %d", n, int(b.burst))
+	}
+	for {
+		b.mu.Lock()
+		b.refill()
+		if b.tokens >= float64(n) {
+			b.tokens -= float64(n)
+			b.mu.Unlock()
+			return nil
+		}
+		d := time.Duration((float64(n) - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	t.Parallel()
+
+	b := New(100, 2)
+	if !b.Allow(2) {
+		t.Fatal("Allow(2) = false, want true")
+	}
+	if b.Allow(1) {
+		t.Fatal("Allow(1) = true, want false")
+	}
+
+	now := time.Now()
+	if err := b.Wait(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(now); d < 5*time.Millisecond {
+		t.Errorf("Wait(1) took %s, want at least %s", d, 5*time.Millisecond)
+	}
+	if err := b.Wait(context.Background(), 3); err == nil {
+		t.Error("Wait(3) = nil, want an error")
+	}
+}
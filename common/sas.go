@@ -14,9 +14,10 @@ import (
 
 // ParseConnectionString parses the given string into a Credentials struct.
 // If you use a shared access policy DeviceId is needed to be added manually.
+// Edge module connection strings also include ModuleId and GatewayHostName.
 func ParseConnectionString(cs string) (*Credentials, error) {
 	chunks := strings.Split(cs, ";")
-	if len(chunks) != 3 && len(chunks) != 4 {
+	if len(chunks) < 3 || len(chunks) > 5 {
 		return nil, errors.New("malformed connection string")
 	}
 
@@ -28,6 +29,10 @@ func ParseConnectionString(cs string) (*Credentials, error) {
 			m.HostName = c[1]
 		case "DeviceId":
 			m.DeviceID = c[1]
+		case "ModuleId":
+			m.ModuleID = c[1]
+		case "GatewayHostName":
+			m.GatewayHostName = c[1]
 		case "SharedAccessKey":
 			m.SharedAccessKey = c[1]
 		case "SharedAccessKeyName":
@@ -42,6 +47,8 @@ func ParseConnectionString(cs string) (*Credentials, error) {
 type Credentials struct {
 	HostName            string
 	DeviceID            string
+	ModuleID            string
+	GatewayHostName     string
 	SharedAccessKey     string
 	SharedAccessKeyName string
 
@@ -15,6 +15,13 @@ func TestParseConnectionString(t *testing.T) {
 			SharedAccessKey:     "c2VjcmV0",
 			SharedAccessKeyName: "",
 		},
+		"HostName=test.azure-devices.net;DeviceId=edge;ModuleId=mod;SharedAccessKey=c2VjcmV0;GatewayHostName=edge.local": {
+			HostName:        "test.azure-devices.net",
+			DeviceID:        "edge",
+			ModuleID:        "mod",
+			GatewayHostName: "edge.local",
+			SharedAccessKey: "c2VjcmV0",
+		},
 		"HostName=test.azure-devices.net;SharedAccessKeyName=device;SharedAccessKey=c2VjcmV0": {
 			HostName:            "test.azure-devices.net",
 			DeviceID:            "",
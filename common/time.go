@@ -0,0 +1,37 @@
+package common
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimeLayout is the layout timestamps are sent to the hub in,
+// UTC with millisecond precision.
+const TimeLayout = "2006-01-02T15:04:05.000Z"
+
+// timeLayouts are accepted timestamp variants, fractional
+// seconds of any precision are accepted by all of them.
+var timeLayouts = []string{
+	time.RFC3339,                // 2019-02-27T15:39:55.1437263Z
+	"2006-01-02T15:04:05",       // 2019-02-27T15:39:55.1437263, UTC implied
+	"2006-01-02T15:04:05Z0700",  // 2019-02-27T15:39:55+0000
+	"2006-01-02 15:04:05Z07:00", // 2019-02-27 15:39:55Z
+	"2006-01-02 15:04:05",       // 2019-02-27 15:39:55
+}
+
+// ParseTime parses RFC3339 and ISO 8601 timestamps the hub produces,
+// including ones without a timezone that are treated as UTC.
+// The result is always in UTC.
+func ParseTime(s string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("malformed timestamp %q", s)
+}
+
+// FormatTime formats t in UTC with millisecond precision, see TimeLayout.
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(TimeLayout)
+}
@@ -0,0 +1,36 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	t.Parallel()
+
+	want := time.Date(2019, 2, 27, 15, 39, 55, 143726300, time.UTC)
+	for _, s := range []string{
+		"2019-02-27T15:39:55.1437263Z",
+		"2019-02-27T15:39:55.1437263",
+		"2019-02-27T17:39:55.1437263+02:00",
+		"2019-02-27T13:39:55.1437263-0200",
+		"2019-02-27 15:39:55.1437263Z",
+		"2019-02-27 15:39:55.1437263",
+	} {
+		got, err := ParseTime(s)
+		if err != nil {
+			t.Errorf("ParseTime(%q) error = %v", s, err)
+			continue
+		}
+		if !got.Equal(want) || got.Location() != time.UTC {
+			t.Errorf("ParseTime(%q) = %v, want %v", s, got, want)
+		}
+	}
+	if _, err := ParseTime("27/02/2019"); err == nil {
+		t.Error("malformed timestamp is accepted")
+	}
+
+	if s := FormatTime(time.Date(2019, 2, 27, 17, 39, 55, 143726300, time.FixedZone("", 7200))); s != "2019-02-27T15:39:55.143Z" {
+		t.Errorf("FormatTime() = %q, want %q", s, "2019-02-27T15:39:55.143Z")
+	}
+}
@@ -1,7 +1,13 @@
 package common
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"math/big"
 	"net/http"
 	"testing"
 )
@@ -23,3 +29,61 @@ func TestTLSConfig(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestPinTLSConfig(t *testing.T) {
+	t.Parallel()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{SerialNumber: big.NewInt(1)}
+	b, err := x509.CreateCertificate(rand.Reader, tpl, tpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt, err := x509.ParseCertificate(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chains := [][]*x509.Certificate{{crt}}
+
+	other := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	for _, tc := range []struct {
+		pins []string
+		err  error
+	}{
+		{[]string{SPKIHash(crt)}, nil},
+		{[]string{other, SPKIHash(crt)}, nil},
+		{[]string{other}, ErrPinMismatch},
+	} {
+		if err := ValidatePins(tc.pins); err != nil {
+			t.Fatal(err)
+		}
+		cfg := PinTLSConfig(&tls.Config{}, tc.pins)
+		if err := cfg.VerifyPeerCertificate(nil, chains); err != tc.err {
+			t.Errorf("VerifyPeerCertificate with pins %v = %v, want %v", tc.pins, err, tc.err)
+		}
+	}
+	if err := ValidatePins([]string{"c2VjcmV0"}); err == nil {
+		t.Error("ValidatePins accepts a short pin")
+	}
+}
+
+func TestBundledRootCAs(t *testing.T) {
+	t.Parallel()
+
+	names := RootCANames()
+	if len(names) != len(rootCerts) {
+		t.Fatalf("RootCANames() returned %d names, want %d", len(names), len(rootCerts))
+	}
+	if _, err := BundledRootCAs(names...); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := BundledRootCAs(RootDigiCertGlobalG2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := BundledRootCAs("unknown"); err == nil {
+		t.Error("unknown root is accepted")
+	}
+}
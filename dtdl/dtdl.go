@@ -0,0 +1,315 @@
+// Package dtdl resolves Digital Twins Definition Language models
+// from the Azure device models repository or a local folder.
+//
+// See: https://github.com/Azure/iot-plugandplay-models
+package dtdl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// DefaultRepository is the public Azure device models repository.
+const DefaultRepository = "https://devicemodels.azure.com"
+
+// Content kinds of an interface.
+const (
+	KindTelemetry    = "Telemetry"
+	KindProperty     = "Property"
+	KindCommand      = "Command"
+	KindComponent    = "Component"
+	KindRelationship = "Relationship"
+)
+
+var dtmiRegexp = regexp.MustCompile(`^dtmi:[A-Za-z](?:[A-Za-z0-9_]*[A-Za-z0-9])?(?::[A-Za-z](?:[A-Za-z0-9_]*[A-Za-z0-9])?)*;[1-9][0-9]{0,8}$`)
+
+// IsValidDTMI reports whether s is a valid digital twin model identifier.
+func IsValidDTMI(s string) bool {
+	return dtmiRegexp.MatchString(s)
+}
+
+// DTMIToPath converts the given model identifier into a repository path,
+// e.g. dtmi:com:example:Thermostat;1 becomes dtmi/com/example/thermostat-1.json.
+func DTMIToPath(dtmi string) (string, error) {
+	if !IsValidDTMI(dtmi) {
+		return "", fmt.Errorf("invalid dtmi %q", dtmi)
+	}
+	s := strings.ToLower(dtmi)
+	s = strings.Replace(s, ":", "/", -1)
+	s = strings.Replace(s, ";", "-", -1)
+	return s + ".json", nil
+}
+
+// Interface is a DTDL interface with all the inherited contents included.
+type Interface struct {
+	ID          string          `json:"@id"`
+	Type        json.RawMessage `json:"@type,omitempty"`
+	DisplayName json.RawMessage `json:"displayName,omitempty"`
+	Extends     json.RawMessage `json:"extends,omitempty"`
+	Contents    []*Content      `json:"contents,omitempty"`
+}
+
+// Content is a single interface element, e.g. telemetry or a property.
+type Content struct {
+	Type     json.RawMessage `json:"@type"`
+	Name     string          `json:"name"`
+	Schema   json.RawMessage `json:"schema,omitempty"`
+	Writable bool            `json:"writable,omitempty"`
+}
+
+// Kind returns content kind, see Kind* constants.
+// It's needed because @type can be a list that includes semantic types.
+func (c *Content) Kind() string {
+	for _, t := range types(c.Type) {
+		switch t {
+		case KindTelemetry, KindProperty, KindCommand, KindComponent, KindRelationship:
+			return t
+		}
+	}
+	return ""
+}
+
+// types decodes a @type value that's either a string or a list of strings.
+func types(b json.RawMessage) []string {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		return []string{s}
+	}
+	var l []string
+	if err := json.Unmarshal(b, &l); err == nil {
+		return l
+	}
+	return nil
+}
+
+// Find returns the named content of the given kind or nil.
+func (i *Interface) Find(kind, name string) *Content {
+	for _, c := range i.Contents {
+		if c.Name == name && c.Kind() == kind {
+			return c
+		}
+	}
+	return nil
+}
+
+// ValidateTelemetry checks that all keys of the given
+// telemetry message are declared by the interface.
+func (i *Interface) ValidateTelemetry(v map[string]interface{}) error {
+	return i.validate(KindTelemetry, v)
+}
+
+// ValidateProperties checks that all keys of the given
+// properties map are declared by the interface.
+func (i *Interface) ValidateProperties(v map[string]interface{}) error {
+	return i.validate(KindProperty, v)
+}
+
+func (i *Interface) validate(kind string, v map[string]interface{}) error {
+	for k := range v {
+		if strings.HasPrefix(k, "$") { // metadata like $version
+			continue
+		}
+		if i.Find(kind, k) == nil {
+			return fmt.Errorf("%s %q is not declared by %s", strings.ToLower(kind), k, i.ID)
+		}
+	}
+	return nil
+}
+
+// TelemetryValidator returns a function that checks that a JSON telemetry
+// payload is an object with only declared fields that have values matching
+// primitive schemas, complex schemas are not checked.
+func (i *Interface) TelemetryValidator() func(payload []byte) error {
+	return func(payload []byte) error {
+		var v map[string]interface{}
+		if err := json.Unmarshal(payload, &v); err != nil {
+			return err
+		}
+		if err := i.ValidateTelemetry(v); err != nil {
+			return err
+		}
+		for k, val := range v {
+			if c := i.Find(KindTelemetry, k); c != nil && !matchesSchema(c.Schema, val) {
+				return fmt.Errorf("telemetry %q doesn't match schema %s", k, c.Schema)
+			}
+		}
+		return nil
+	}
+}
+
+// matchesSchema checks v against a primitive schema, other schemas always match.
+func matchesSchema(schema json.RawMessage, v interface{}) bool {
+	var s string
+	if err := json.Unmarshal(schema, &s); err != nil {
+		return true
+	}
+	switch s {
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "double", "float":
+		_, ok := v.(float64)
+		return ok
+	case "integer", "long":
+		f, ok := v.(float64)
+		return ok && f == float64(int64(f))
+	case "string", "date", "dateTime", "time", "duration":
+		_, ok := v.(string)
+		return ok
+	default:
+		return true
+	}
+}
+
+// ResolverOption is a resolver configuration option.
+type ResolverOption func(r *Resolver)
+
+// WithRepository sets repository base url, default is DefaultRepository.
+func WithRepository(url string) ResolverOption {
+	return func(r *Resolver) {
+		r.repo = strings.TrimRight(url, "/")
+	}
+}
+
+// WithLocalDir makes resolver read models from the given directory
+// with the same layout as the repository instead of fetching them.
+func WithLocalDir(dir string) ResolverOption {
+	return func(r *Resolver) {
+		r.dir = dir
+	}
+}
+
+// WithHTTPClient changes the http client used to access the repository.
+func WithHTTPClient(c *http.Client) ResolverOption {
+	return func(r *Resolver) {
+		r.http = c
+	}
+}
+
+// NewResolver creates a new models resolver.
+func NewResolver(opts ...ResolverOption) *Resolver {
+	r := &Resolver{
+		repo:  DefaultRepository,
+		http:  http.DefaultClient,
+		cache: map[string]*Interface{},
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Resolver fetches and caches interfaces expanding their inheritance.
+type Resolver struct {
+	repo string
+	dir  string
+	http *http.Client
+
+	mu    sync.Mutex
+	cache map[string]*Interface
+}
+
+// Resolve returns the named interface including
+// contents of all the interfaces it extends.
+func (r *Resolver) Resolve(ctx context.Context, dtmi string) (*Interface, error) {
+	return r.resolve(ctx, dtmi, map[string]bool{})
+}
+
+func (r *Resolver) resolve(ctx context.Context, dtmi string, seen map[string]bool) (*Interface, error) {
+	r.mu.Lock()
+	i, ok := r.cache[dtmi]
+	r.mu.Unlock()
+	if ok {
+		return i, nil
+	}
+	if seen[dtmi] {
+		return nil, fmt.Errorf("inheritance cycle detected at %s", dtmi)
+	}
+	seen[dtmi] = true
+
+	b, err := r.fetch(ctx, dtmi)
+	if err != nil {
+		return nil, err
+	}
+	i = &Interface{}
+	if err = json.Unmarshal(b, i); err != nil {
+		return nil, fmt.Errorf("%s: %s", dtmi, err)
+	}
+	if err = r.expand(ctx, i, i.Extends, seen); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.cache[dtmi] = i
+	r.mu.Unlock()
+	return i, nil
+}
+
+// expand appends contents of the extended interfaces to i,
+// extends is either an id, an inline interface or a list of them.
+func (r *Resolver) expand(ctx context.Context, i *Interface, extends json.RawMessage, seen map[string]bool) error {
+	if len(extends) == 0 {
+		return nil
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(extends, &items); err != nil {
+		items = []json.RawMessage{extends}
+	}
+	for _, item := range items {
+		var id string
+		if err := json.Unmarshal(item, &id); err == nil {
+			base, err := r.resolve(ctx, id, seen)
+			if err != nil {
+				return err
+			}
+			i.Contents = append(i.Contents, base.Contents...)
+			continue
+		}
+		inline := &Interface{}
+		if err := json.Unmarshal(item, inline); err != nil {
+			return fmt.Errorf("%s: malformed extends: %s", i.ID, err)
+		}
+		if err := r.expand(ctx, inline, inline.Extends, seen); err != nil {
+			return err
+		}
+		i.Contents = append(i.Contents, inline.Contents...)
+	}
+	return nil
+}
+
+func (r *Resolver) fetch(ctx context.Context, dtmi string) ([]byte, error) {
+	p, err := DTMIToPath(dtmi)
+	if err != nil {
+		return nil, err
+	}
+	if r.dir != "" {
+		return ioutil.ReadFile(filepath.Join(r.dir, filepath.FromSlash(p)))
+	}
+
+	req, err := http.NewRequest(http.MethodGet, r.repo+"/"+p, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := r.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusOK:
+		return ioutil.ReadAll(res.Body)
+	case http.StatusNotFound:
+		return nil, os.ErrNotExist
+	default:
+		return nil, errors.New(res.Status)
+	}
+}
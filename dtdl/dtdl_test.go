@@ -0,0 +1,104 @@
+package dtdl
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDTMIToPath(t *testing.T) {
+	t.Parallel()
+
+	for s, w := range map[string]string{
+		"dtmi:com:example:Thermostat;1":                    "dtmi/com/example/thermostat-1.json",
+		"dtmi:azure:DeviceManagement:DeviceInformation;12": "dtmi/azure/devicemanagement/deviceinformation-12.json",
+		"dtmi:com:example:Thermostat":                      "", // errors
+		"com:example:Thermostat;1":                         "",
+	} {
+		g, _ := DTMIToPath(s)
+		if g != w {
+			t.Errorf("DTMIToPath(%q) = %q, want %q", s, g, w)
+		}
+	}
+}
+
+func TestResolver(t *testing.T) {
+	t.Parallel()
+
+	dir, err := ioutil.TempDir("", "dtdl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for p, s := range map[string]string{
+		"dtmi/com/example/base-1.json": `{
+			"@id": "dtmi:com:example:Base;1",
+			"@type": "Interface",
+			"contents": [{"@type": "Property", "name": "serial", "schema": "string"}]
+		}`,
+		"dtmi/com/example/thermostat-1.json": `{
+			"@id": "dtmi:com:example:Thermostat;1",
+			"@type": "Interface",
+			"extends": ["dtmi:com:example:Base;1", {
+				"@type": "Interface",
+				"contents": [{"@type": "Command", "name": "reboot"}]
+			}],
+			"contents": [{"@type": ["Telemetry", "Temperature"], "name": "temp", "schema": "double"}]
+		}`,
+	} {
+		p = filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	i, err := NewResolver(WithLocalDir(dir)).Resolve(context.Background(), "dtmi:com:example:Thermostat;1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for kind, name := range map[string]string{
+		KindTelemetry: "temp",
+		KindProperty:  "serial",
+		KindCommand:   "reboot",
+	} {
+		if i.Find(kind, name) == nil {
+			t.Errorf("Find(%q, %q) = nil", kind, name)
+		}
+	}
+	if err = i.ValidateTelemetry(map[string]interface{}{"temp": 1}); err != nil {
+		t.Errorf("ValidateTelemetry() = %v, want nil", err)
+	}
+	if err = i.ValidateTelemetry(map[string]interface{}{"humidity": 1}); err == nil {
+		t.Error("ValidateTelemetry() = nil, want an error")
+	}
+}
+
+func TestTelemetryValidator(t *testing.T) {
+	t.Parallel()
+
+	i := &Interface{
+		ID: "dtmi:com:example:Sensor;1",
+		Contents: []*Content{
+			{Type: []byte(`"Telemetry"`), Name: "temp", Schema: []byte(`"double"`)},
+			{Type: []byte(`"Telemetry"`), Name: "count", Schema: []byte(`"integer"`)},
+		},
+	}
+	fn := i.TelemetryValidator()
+	for s, ok := range map[string]bool{
+		`{"temp":20.5,"count":3}`: true,
+		`{"temp":"hot"}`:          false,
+		`{"count":1.5}`:           false,
+		`{"humidity":1}`:          false,
+		`[1]`:                     false,
+	} {
+		if err := fn([]byte(s)); (err == nil) != ok {
+			t.Errorf("validate(%s) = %v", s, err)
+		}
+	}
+}
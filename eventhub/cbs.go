@@ -0,0 +1,69 @@
+package eventhub
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+	"pack.ag/amqp"
+)
+
+// PutTokenResult is an outcome of a claims-based security put-token operation.
+type PutTokenResult struct {
+	Audience    string
+	StatusCode  int    // zero when no response is received
+	Description string // status description sent by the server
+	Duration    time.Duration
+	Err         error
+}
+
+// PutTokenHandler handles put-token outcomes, e.g. to log or count them.
+type PutTokenHandler func(r *PutTokenResult)
+
+// SetPutTokenHandler sets the handler of put-token outcomes.
+func (c *Client) SetPutTokenHandler(fn PutTokenHandler) {
+	c.mu.Lock()
+	c.onPutToken = fn
+	c.mu.Unlock()
+}
+
+// PutTokenError is a put-token rejection by the server.
+type PutTokenError struct {
+	Audience    string
+	StatusCode  int
+	Description string
+}
+
+func (e *PutTokenError) Error() string {
+	return fmt.Sprintf("put token for %q failed: code = %d, description = %q",
+		e.Audience, e.StatusCode, e.Description,
+	)
+}
+
+// Is implements errors.Is interface, it matches common error classes,
+// e.g. common.ErrUnauthorized when the token is rejected.
+func (e *PutTokenError) Is(target error) bool {
+	class := common.StatusClass(e.StatusCode)
+	return class != nil && target == class
+}
+
+// cbsStatus returns status code and description of a cbs response.
+func cbsStatus(msg *amqp.Message) (int, string, bool) {
+	rc, ok := msg.ApplicationProperties["status-code"].(int32)
+	if !ok {
+		return 0, "", false
+	}
+	rd, _ := msg.ApplicationProperties["status-description"].(string)
+	return int(rc), rd, true
+}
+
+// linkErrorDescription returns the remote error description
+// of link errors that happen before any response is received.
+func linkErrorDescription(err error) string {
+	var e amqp.DetachError
+	if errors.As(err, &e) && e.RemoteError != nil {
+		return fmt.Sprintf("%s: %s", e.RemoteError.Condition, e.RemoteError.Description)
+	}
+	return ""
+}
@@ -0,0 +1,32 @@
+package eventhub
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+	"pack.ag/amqp"
+)
+
+func TestCBSStatus(t *testing.T) {
+	t.Parallel()
+
+	rc, rd, ok := cbsStatus(&amqp.Message{ApplicationProperties: map[string]interface{}{
+		"status-code":        int32(401),
+		"status-description": "InvalidSignature",
+	}})
+	if !ok || rc != 401 || rd != "InvalidSignature" {
+		t.Errorf("cbsStatus() = %d, %q, %t, want 401, %q, true", rc, rd, ok, "InvalidSignature")
+	}
+	if _, _, ok = cbsStatus(&amqp.Message{}); ok {
+		t.Error("cbsStatus() of a message without status = true")
+	}
+
+	err := error(&PutTokenError{StatusCode: rc, Description: rd})
+	if !errors.Is(err, common.ErrUnauthorized) {
+		t.Errorf("errors.Is(%v, ErrUnauthorized) = false", err)
+	}
+	if errors.Is(err, common.ErrThrottled) {
+		t.Errorf("errors.Is(%v, ErrThrottled) = true", err)
+	}
+}
@@ -10,14 +10,23 @@ import (
 	"sync"
 	"time"
 
+	"github.com/amenzhinsky/golang-iothub/common"
+	"github.com/amenzhinsky/golang-iothub/common/backoff"
+	"github.com/amenzhinsky/golang-iothub/common/commonamqp"
 	"pack.ag/amqp"
 )
 
 // Dial connects to the named amqp broker and returns an eventhub client.
-func Dial(hostname string, tlsConfig *tls.Config) (*Client, error) {
-	conn, err := amqp.Dial("amqps://"+hostname,
-		amqp.ConnTLSConfig(tlsConfig),
-	)
+func Dial(hostname string, tlsConfig *tls.Config, opts ...amqp.ConnOption) (*Client, error) {
+	return DialContext(context.Background(), nil, hostname, tlsConfig, opts...)
+}
+
+// DialContext is like Dial but uses the given dialer,
+// nil means common.DefaultDialer.
+func DialContext(
+	ctx context.Context, d *common.Dialer, hostname string, tlsConfig *tls.Config, opts ...amqp.ConnOption,
+) (*Client, error) {
+	conn, err := commonamqp.Dial(ctx, d, hostname, tlsConfig, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -39,6 +48,8 @@ type Client struct {
 	conn *amqp.Client
 	sess *amqp.Session
 	done chan struct{}
+
+	onPutToken PutTokenHandler
 }
 
 func (c *Client) Sess() *amqp.Session {
@@ -51,6 +62,11 @@ func (c *Client) SubscribePartitions(ctx context.Context, name, group string, f
 	return SubscribePartitions(ctx, c.sess, name, group, f)
 }
 
+// SubscribePartitions subscribes to all partitions of the named event hub.
+//
+// f is called sequentially in the order messages arrive, so messages of
+// the same partition are handled in order, it shouldn't block for long
+// since it delays delivery of messages from all partitions.
 func SubscribePartitions(ctx context.Context, sess *amqp.Session, name, group string, f func(*amqp.Message)) error {
 	ids, err := getPartitionIDs(ctx, sess, name)
 	if err != nil {
@@ -93,7 +109,7 @@ func SubscribePartitions(ctx context.Context, sess *amqp.Session, name, group st
 	for {
 		select {
 		case msg := <-msgc:
-			go f(msg)
+			f(msg)
 		case err := <-errc:
 			return err
 		}
@@ -115,7 +131,9 @@ func (c *Client) PutTokenContinuously(
 		for {
 			select {
 			case <-time.After(time.Hour): // TODO: bigger update interval
-				if err := c.PutToken(ctx, audience, token); err != nil {
+				if err := backoff.Retry(ctx, backoff.Default, 5, func(int) error {
+					return c.PutToken(ctx, audience, token)
+				}); err != nil {
 					log.Printf("put token error: %s", err)
 					return
 				}
@@ -127,21 +145,37 @@ func (c *Client) PutTokenContinuously(
 	return nil
 }
 
+// PutToken authorizes access to the audience with the token, a rejection
+// is returned as *PutTokenError and reported to the put-token handler.
 func (c *Client) PutToken(ctx context.Context, audience, token string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	start := time.Now()
+	r := &PutTokenResult{Audience: audience}
+	r.StatusCode, r.Description, r.Err = c.putToken(ctx, audience, token)
+	if r.Err != nil && r.StatusCode == 0 {
+		r.Description = linkErrorDescription(r.Err)
+	}
+	if c.onPutToken != nil {
+		r.Duration = time.Since(start)
+		c.onPutToken(r)
+	}
+	return r.Err
+}
+
+func (c *Client) putToken(ctx context.Context, audience, token string) (int, string, error) {
 	send, err := c.sess.NewSender(
 		amqp.LinkTargetAddress("$cbs"),
 	)
 	if err != nil {
-		return err
+		return 0, "", err
 	}
 	defer send.Close()
 
 	recv, err := c.sess.NewReceiver(amqp.LinkSourceAddress("$cbs"))
 	if err != nil {
-		return err
+		return 0, "", err
 	}
 	defer recv.Close()
 
@@ -157,18 +191,22 @@ func (c *Client) PutToken(ctx context.Context, audience, token string) error {
 			"name":      audience,
 		},
 	}); err != nil {
-		return err
+		return 0, "", err
 	}
 
 	msg, err := recv.Receive(ctx)
 	if err != nil {
-		return err
-	}
-	if err = CheckMessageResponse(msg); err != nil {
-		return err
+		return 0, "", err
 	}
 	msg.Accept()
-	return nil
+	rc, rd, ok := cbsStatus(msg)
+	if !ok {
+		return 0, "", errors.New("unable to typecast status-code")
+	}
+	if rc != 200 {
+		return rc, rd, &PutTokenError{Audience: audience, StatusCode: rc, Description: rd}
+	}
+	return rc, rd, nil
 }
 
 // Close closes amqp session and connection.
@@ -3,14 +3,22 @@ package iotdevice
 import (
 	"context"
 	"crypto/tls"
-	"encoding/json"
+	"crypto/x509"
 	"errors"
+	"fmt"
 	"log"
+	"math"
+	"net/http"
 	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/amenzhinsky/golang-iothub/common"
+	"github.com/amenzhinsky/golang-iothub/common/backoff"
+	"github.com/amenzhinsky/golang-iothub/common/ratelimit"
 	"github.com/amenzhinsky/golang-iothub/iotdevice/transport"
+	"github.com/amenzhinsky/golang-iothub/iotutil"
 )
 
 // ClientOption is a client configuration option.
@@ -33,6 +41,303 @@ func WithLogger(l *log.Logger) ClientOption {
 	}
 }
 
+// WithMessageIDGenerator makes the client generate message ids with fn
+// for sent messages that don't have one, e.g. iotutil.UUID or iotutil.ULID.
+func WithMessageIDGenerator(fn iotutil.IDGenerator) ClientOption {
+	return func(c *Client) error {
+		c.midgen = fn
+		return nil
+	}
+}
+
+// WithDefaultProperties sets application properties added to every sent
+// message, properties set per message with WithSendProperty take precedence.
+func WithDefaultProperties(m map[string]string) ClientOption {
+	return func(c *Client) error {
+		c.props = make(map[string]string, len(m))
+		for k, v := range m {
+			c.props[k] = v
+		}
+		return nil
+	}
+}
+
+// WithCompression enables compression of sent messages payloads that are
+// at least threshold bytes long with the given encoding, iotutil.EncodingGzip
+// or iotutil.EncodingDeflate, and sets their content encoding accordingly.
+//
+// Messages with content encoding set explicitly are sent as is.
+func WithCompression(encoding string, threshold int) ClientOption {
+	return func(c *Client) error {
+		if !iotutil.IsCompressed(encoding) {
+			return fmt.Errorf("unsupported encoding %q", encoding)
+		}
+		c.compression = encoding
+		c.compressMin = threshold
+		return nil
+	}
+}
+
+// MessageTypeProperty is the application property
+// that selects a validator, see WithValidator.
+const MessageTypeProperty = "messageType"
+
+// PayloadValidator validates outgoing messages payloads.
+type PayloadValidator func(payload []byte) error
+
+// WithValidator registers fn validating payloads of sent messages that
+// have the MessageTypeProperty equal to msgType, empty msgType matches
+// messages without the property. Invalid messages are not sent,
+// e.g. with a validator obtained from dtdl.Interface.TelemetryValidator.
+func WithValidator(msgType string, fn PayloadValidator) ClientOption {
+	if fn == nil {
+		panic("fn is nil")
+	}
+	return func(c *Client) error {
+		if c.validators == nil {
+			c.validators = map[string]PayloadValidator{}
+		}
+		c.validators[msgType] = fn
+		return nil
+	}
+}
+
+// WithSendQueue enables the outgoing messages queue of the given
+// capacity that's used by EnqueueEvent, messages are sent in the
+// background in priority order and kept while the client is offline.
+func WithSendQueue(size int) ClientOption {
+	return func(c *Client) error {
+		if size <= 0 {
+			return errors.New("queue size must be positive")
+		}
+		c.queue = newSendQueue(size)
+		return nil
+	}
+}
+
+// WithTwinCache enables the local twin cache, see Client.Twin.
+func WithTwinCache(enabled bool) ClientOption {
+	return func(c *Client) error {
+		if enabled {
+			c.twin = newTwinCache()
+		} else {
+			c.twin = nil
+		}
+		return nil
+	}
+}
+
+// WithTwinCoalescing makes UpdateTwinState calls made within the given
+// interval merged into a single reported state update to reduce
+// the number of round trips and twin operations throttling.
+//
+// Every call blocks until the merged update is sent and
+// returns its version, zero interval disables coalescing.
+func WithTwinCoalescing(interval time.Duration) ClientOption {
+	return func(c *Client) error {
+		if interval < 0 {
+			return errors.New("interval is negative")
+		}
+		if interval == 0 {
+			c.coalescer = nil
+			return nil
+		}
+		c.coalescer = &twinCoalescer{
+			interval: interval,
+			send:     c.updateTwinState,
+			context:  c.coalesceContext,
+		}
+		return nil
+	}
+}
+
+// WithDefaultTimeout bounds duration of sending messages, twin operations
+// and closing when the passed context has no deadline.
+func WithDefaultTimeout(d time.Duration) ClientOption {
+	return func(c *Client) error {
+		if d < 0 {
+			return errors.New("timeout is negative")
+		}
+		c.timeout = d
+		return nil
+	}
+}
+
+// WithProductInfo adds the application name and version to the user agent
+// reported to the hub, so connections can be identified in diagnostics.
+func WithProductInfo(name, version string) ClientOption {
+	return func(c *Client) error {
+		if name == "" {
+			return errors.New("name is empty")
+		}
+		c.product = name + "/" + version
+		return nil
+	}
+}
+
+// WithHTTPClient sets the client used for all REST interactions with the hub
+// or an edge gateway, e.g. to route them through a proxy or instrument them.
+//
+// The default client trusts only the bundled CAs and presents the device
+// certificate when X.509 authentication is used, a custom client has to
+// configure TLS on its own, see transport.Credentials.TLSConfig.
+func WithHTTPClient(client *http.Client) ClientOption {
+	return func(c *Client) error {
+		if client == nil {
+			return errors.New("http client is nil")
+		}
+		c.http = client
+		return nil
+	}
+}
+
+// WithRootCAs overrides the root CA certificates server certificates
+// are validated against, see common.BundledRootCAs.
+func WithRootCAs(p *x509.CertPool) ClientOption {
+	return func(c *Client) error {
+		if p == nil {
+			return errors.New("pool is nil")
+		}
+		c.roots = p
+		return nil
+	}
+}
+
+// WithSystemCertPool makes the client trust the host's root CA certificates
+// in addition to the bundled ones, so rotation of Azure roots can be
+// handled by updating the operating system certificate store.
+func WithSystemCertPool() ClientOption {
+	return func(c *Client) error {
+		p, err := common.SystemRootCAs()
+		if err != nil {
+			return err
+		}
+		c.roots = p
+		return nil
+	}
+}
+
+// WithRevocationCheck enables checking revocation status of server
+// certificates with the given checker, see common.NewCRLChecker.
+func WithRevocationCheck(ch *common.CRLChecker) ClientOption {
+	return func(c *Client) error {
+		if ch == nil {
+			return errors.New("checker is nil")
+		}
+		c.crl = ch
+		return nil
+	}
+}
+
+// WithPinnedServerCertificates requires the server certificate chain of
+// the hub or an edge gateway to contain one of the given public keys
+// in addition to the regular CA validation, pins are base64 encoded
+// SHA-256 hashes of subject public key info, see common.SPKIHash.
+func WithPinnedServerCertificates(pins ...string) ClientOption {
+	return func(c *Client) error {
+		if err := common.ValidatePins(pins); err != nil {
+			return err
+		}
+		c.pins = pins
+		return nil
+	}
+}
+
+// WithAuditHandler sets the handler of security-relevant events: authentication
+// attempts, token issuing, connection establishment and loss and direct method
+// invocations, so devices in regulated environments can keep an audit trail.
+func WithAuditHandler(fn transport.AuditHandler) ClientOption {
+	return func(c *Client) error {
+		c.audit = fn
+		return nil
+	}
+}
+
+// WithFailoverHostnames sets hostnames of hubs the client fails over to
+// in order when the current one is unreachable or unavailable while
+// connecting or reconnecting after a connection loss, e.g. a geo-paired
+// hub the device is registered in as well. Failing over on reconnects
+// requires a transport the client can restore lost connections of,
+// see transport.ConnectionLossNotifier.
+//
+// SAS tokens are issued for the hub being connected to. Hub hostnames
+// are re-resolved on every connect, so manual and Microsoft-initiated
+// failovers of a single hub are followed without this option.
+func WithFailoverHostnames(hostnames ...string) ClientOption {
+	return func(c *Client) error {
+		for _, h := range hostnames {
+			if h == "" {
+				return errors.New("failover hostname is empty")
+			}
+		}
+		c.failover = hostnames
+		return nil
+	}
+}
+
+// WithConnectionStateHandler sets the handler of connection state changes.
+//
+// Connection rejections and losses come with a *transport.ConnectError
+// telling the reason, e.g. common.ErrUnauthorized matches errors that need
+// new credentials and common.ErrThrottled ones that need waiting.
+func WithConnectionStateHandler(fn transport.ConnectionStateHandler) ClientOption {
+	return func(c *Client) error {
+		c.onState = fn
+		return nil
+	}
+}
+
+// WithErrorHandler sets the handler of errors that occur in library-owned
+// goroutines, e.g. connection losses, background connection and twin sync
+// failures and panics of user handlers that are reported as *common.PanicError
+// instead of crashing the process. It's called synchronously, so it must not block.
+func WithErrorHandler(fn func(err error)) ClientOption {
+	if fn == nil {
+		panic("fn is nil")
+	}
+	return func(c *Client) error {
+		c.onError = fn
+		return nil
+	}
+}
+
+// errorStateHandler wraps fn to report connection losses to the error handler.
+func (c *Client) errorStateHandler(fn transport.ConnectionStateHandler) transport.ConnectionStateHandler {
+	return func(state transport.ConnectionState, err error) {
+		if fn != nil {
+			fn(state, err)
+		}
+		if state == transport.Disconnected && err != nil {
+			c.onError(err)
+		}
+	}
+}
+
+// maxMessageSize is the maximum device-to-cloud message size.
+const maxMessageSize = 256 * 1024
+
+// WithRateLimit limits the number of device-to-cloud messages and payload bytes
+// sent per second, zero disables either limit. Sending blocks until
+// it's allowed, so a runaway loop cannot exhaust the daily quota.
+//
+// The bytes limit allows bursts of a maximum message size
+// so a single large message never exceeds it.
+func WithRateLimit(msgsPerSec, bytesPerSec float64) ClientOption {
+	return func(c *Client) error {
+		if msgsPerSec < 0 || bytesPerSec < 0 {
+			return errors.New("rate limit cannot be negative")
+		}
+		c.msgRate, c.byteRate = nil, nil
+		if msgsPerSec > 0 {
+			c.msgRate = ratelimit.New(msgsPerSec, int(math.Max(1, math.Floor(msgsPerSec))))
+		}
+		if bytesPerSec > 0 {
+			c.byteRate = ratelimit.New(bytesPerSec, int(math.Max(maxMessageSize, bytesPerSec)))
+		}
+		return nil
+	}
+}
+
 // WithTransport changes default transport.
 func WithTransport(tr transport.Transport) ClientOption {
 	return func(c *Client) error {
@@ -88,6 +393,19 @@ func WithX509FromFile(deviceID, hostname, certFile, keyFile string) ClientOption
 	}
 }
 
+// auditMethod reports a direct method invocation.
+func (c *Client) auditMethod(method string, rc int, err error) {
+	c.audit(&transport.AuditRecord{
+		Event:      transport.AuditMethodInvoked,
+		Time:       time.Now(),
+		DeviceID:   c.DeviceID(),
+		ModuleID:   c.ModuleID(),
+		Method:     method,
+		StatusCode: rc,
+		Err:        err,
+	})
+}
+
 // errNotConnected is the initial connection state.
 var errNotConnected = errors.New("not connected")
 
@@ -95,9 +413,13 @@ var errNotConnected = errors.New("not connected")
 func NewClient(opts ...ClientOption) (*Client, error) {
 	c := &Client{
 		done:    make(chan struct{}),
+		fatal:   make(chan error, 1),
+		lost:    make(chan error, 1),
 		debug:   os.Getenv("DEBUG") != "",
 		connErr: errNotConnected,
+		codec:   common.JSONCodec{},
 	}
+	c.dmMux.done = c.done
 	for _, opt := range opts {
 		if err := opt(c); err != nil {
 			return nil, err
@@ -109,27 +431,112 @@ func NewClient(opts ...ClientOption) (*Client, error) {
 	if c.tr == nil {
 		return nil, errors.New("transport required")
 	}
+	c.tuMux.codec = c.codec
+	c.creds = c.wrapCredentials(c.creds)
+	if c.audit != nil {
+		if a, ok := c.tr.(transport.Auditor); ok {
+			a.SetAuditHandler(c.audit)
+		}
+		c.dmMux.audit = c.auditMethod
+	}
+	c.cmMux.onPanic = c.reportError
+	c.dmMux.onPanic = c.reportError
+	c.tuMux.onPanic = c.reportError
+	c.inMux.onPanic = c.reportError
+
+	if n, ok := c.tr.(transport.ConnectionLossNotifier); ok {
+		if _, ok = c.tr.(transport.Reconnector); ok {
+			n.SetConnectionLossHandler(c.connectionLost)
+			c.restores = true
+		}
+	}
+	onState := c.onState
+	if !c.restores {
+		// refusals on automatic reconnects are seen only here
+		onState = c.fatalStateHandler(onState)
+	}
+	if c.reprov != nil {
+		onState = c.stateHandler(onState)
+	}
+	if c.onError != nil {
+		onState = c.errorStateHandler(onState)
+	}
+	if onState != nil {
+		if n, ok := c.tr.(transport.ConnectionStateNotifier); ok {
+			n.SetConnectionStateHandler(onState)
+		}
+	}
+	if s, ok := c.tr.(transport.UserAgentSetter); ok {
+		s.SetUserAgent(common.UserAgent(c.product))
+	}
+	if c.queue != nil {
+		go c.drainQueue()
+	}
 	return c, nil
 }
 
 // Client is iothub device client.
 type Client struct {
-	creds transport.Credentials
-	tr    transport.Transport
+	credsMu sync.RWMutex
+	creds   transport.Credentials
+	tr      transport.Transport
+
+	logger  *log.Logger
+	debug   bool
+	timeout time.Duration
+	product string
+	midgen  iotutil.IDGenerator
+	props   map[string]string
+	pins    []string
+	roots   *x509.CertPool
+	crl     *common.CRLChecker
+	audit   transport.AuditHandler
+	onState transport.ConnectionStateHandler
+	onError func(err error)
+	reprov  *reprovisioner
+
+	msgRate  *ratelimit.Bucket
+	byteRate *ratelimit.Bucket
 
-	logger *log.Logger
-	debug  bool
+	compression string
+	compressMin int
+	validators  map[string]PayloadValidator
+	queue       *sendQueue
+	http        *http.Client
 
-	mu   sync.RWMutex
-	done chan struct{}
+	suspendMu sync.Mutex
+	resume    chan struct{} // not nil when sending is suspended
+	suspBuf   *Priority     // not nil when suspended sends are queued
+
+	twin  *TwinCache
+	codec TwinCodec
+	info  *DeviceInfo // reported on connect when not nil
+
+	coalescer *twinCoalescer
+
+	mu    sync.RWMutex
+	done  chan struct{}
+	fatal chan error // see Run
+	lost  chan error // lost connections restored by Run
 
 	connCh  chan struct{}
 	connMu  sync.RWMutex
 	connErr error // nil means successfully connected
 
+	connCreds transport.Credentials // the connection's credentials
+
+	failover []string // failover hub hostnames
+	hostIdx  int      // current hub, zero is the primary one
+
+	backoff   backoff.Policy // of the last Connect, reused by reconnects
+	restores  bool           // lost connections are restored by the client
+	restoring int32          // non-zero when reconnecting in the background
+	running   int32          // non-zero when Run restores connections
+
 	cmMux messageMux
 	dmMux methodMux
 	tuMux stateMux
+	inMux inputMux
 }
 
 // MessageHandler handles cloud-to-device events.
@@ -138,16 +545,92 @@ type MessageHandler func(msg *common.Message)
 // DirectMethodHandler handles direct method invocations.
 type DirectMethodHandler func(p map[string]interface{}) (map[string]interface{}, error)
 
+// DirectMethodContextHandler handles direct method invocations with raw
+// JSON payloads that can be of any type, not only objects, returned
+// bytes must be valid JSON as well, nil is replaced with an empty object.
+//
+// The context is canceled when the client is closed.
+type DirectMethodContextHandler func(ctx context.Context, b []byte) ([]byte, error)
+
 // TwinUpdateHandler handles twin desired state changes.
 type TwinUpdateHandler func(state TwinState)
 
+// MessageContextHandler handles cloud-to-device events,
+// the context is canceled when the subscription ends.
+type MessageContextHandler func(ctx context.Context, msg *common.Message)
+
+// TwinUpdateContextHandler handles twin desired state changes,
+// the context is canceled when the subscription ends.
+type TwinUpdateContextHandler func(ctx context.Context, state TwinState)
+
 // DeviceID returns iothub device id.
 func (c *Client) DeviceID() string {
-	return c.creds.DeviceID()
+	return c.credentials().DeviceID()
+}
+
+// wrapCredentials applies the client's TLS settings to creds.
+func (c *Client) wrapCredentials(creds transport.Credentials) transport.Credentials {
+	if c.roots != nil || c.pins != nil || c.crl != nil {
+		return tlsCredentials(creds, c.roots, c.pins, c.crl)
+	}
+	return creds
+}
+
+func (c *Client) credentials() transport.Credentials {
+	c.credsMu.RLock()
+	defer c.credsMu.RUnlock()
+	return c.creds
+}
+
+// UpdateCredentials replaces credentials used for token renewals and
+// TLS handshakes, e.g. after a key rotation or reprovisioning the device
+// to another hub, the primary hub is tried first on next connections.
+//
+// A connected client reconnects once with the new credentials keeping its
+// subscriptions, otherwise they are used by the next connection attempt.
+// It's an error when the transport cannot reconnect. When reconnecting
+// fails the client is left disconnected, unless it restores lost
+// connections on its own, see WithFailoverHostnames.
+func (c *Client) UpdateCredentials(ctx context.Context, creds transport.Credentials) error {
+	if creds == nil {
+		panic("creds is nil")
+	}
+	creds = c.wrapCredentials(creds)
+	c.credsMu.Lock()
+	c.creds = creds
+	c.credsMu.Unlock()
+
+	// HTTPS requests use the credentials TLS config
+	c.mu.Lock()
+	c.http = nil
+	c.mu.Unlock()
+
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	c.hostIdx = 0
+	if c.connErr != nil || c.connCreds == creds {
+		return nil
+	}
+	r, ok := c.tr.(transport.Reconnector)
+	if !ok {
+		return errors.New("transport cannot reconnect with new credentials")
+	}
+	if err := r.Reconnect(ctx, creds); err != nil {
+		// the transport is left disconnected
+		c.connErr = err
+		if c.restores {
+			c.restoreLater(err)
+		}
+		return err
+	}
+	c.connCreds = creds
+	c.logf("reconnected with new credentials")
+	return nil
 }
 
 type connection struct {
 	ignoreNetErrors bool
+	backoff         backoff.Policy
 }
 
 // ConnOption is a connection option.
@@ -155,31 +638,198 @@ type ConnOption func(c *connection)
 
 // WithConnIgnoreNetErrors when a network error occurs while connecting
 // it's just ignored and connection reestablished until it succeeds.
+// The same applies to throttling, in which case the hub's retry-after
+// hint takes precedence over shorter backoff delays.
 func WithConnIgnoreNetErrors(ignore bool) ConnOption {
 	return func(c *connection) {
 		c.ignoreNetErrors = ignore
 	}
 }
 
+// WithConnBackoff sets the delay policy between reconnection attempts
+// made when network errors are ignored, default is backoff.Default.
+func WithConnBackoff(p backoff.Policy) ConnOption {
+	return func(c *connection) {
+		c.backoff = p
+	}
+}
+
 // Connect connects to the iothub.
+//
+// When the twin cache is enabled it's synchronized as well,
+// device info is reported when it's enabled with WithDeviceInfo,
+// failing to report it doesn't fail connecting and the error is
+// passed to the error handler, see WithErrorHandler.
 func (c *Client) Connect(ctx context.Context, opts ...ConnOption) error {
+	if err := c.connect(ctx, opts...); err != nil {
+		return err
+	}
+	if err := c.reportDeviceInfo(ctx); err != nil {
+		c.reportError(fmt.Errorf("device info report error: %w", err))
+	}
+	if c.twin != nil {
+		return c.SyncTwin(ctx)
+	}
+	return nil
+}
+
+func (c *Client) connect(ctx context.Context, opts ...ConnOption) error {
 	c.connMu.Lock()
 	defer c.connMu.Unlock()
 
-	conn := &connection{}
+	conn := &connection{backoff: backoff.Default}
 	for _, opt := range opts {
 		opt(conn)
 	}
+	c.backoff = conn.backoff
 
-Retry:
-	c.connErr = c.tr.Connect(ctx, c.creds)
-	if c.connErr != nil && conn.ignoreNetErrors && c.tr.IsNetworkError(c.connErr) {
-		c.logf("couldn't connect, reconnecting")
-		goto Retry
-	}
+	var reprovisioned bool // reprovision only once per connection
+	c.connErr = backoff.Retry(ctx, conn.backoff, 0, func(attempt int) error {
+		return c.dial(ctx, c.tr.Connect, conn.ignoreNetErrors, attempt, &reprovisioned)
+	})
 	return c.connErr
 }
 
+// dial makes a connection attempt with fn to the current hub switching
+// to the next one on transient errors, they are returned as is when retry
+// is true or not all hubs are tried yet, c.connMu must be held.
+func (c *Client) dial(
+	ctx context.Context,
+	fn func(ctx context.Context, creds transport.Credentials) error,
+	retry bool,
+	attempt int,
+	reprovisioned *bool,
+) error {
+	base := c.credentials()
+	creds := base
+	if c.hostIdx != 0 {
+		creds = hostCredentials(base, c.failover[c.hostIdx-1])
+	}
+	err := fn(ctx, creds)
+	if err == nil {
+		c.connCreds = base
+		return nil
+	}
+	if c.reprov != nil && !*reprovisioned && isRefused(err) {
+		// the device may have been reassigned to another hub
+		*reprovisioned = true
+		creds, perr := c.reprovision(ctx, err)
+		if perr != nil {
+			return backoff.Permanent(perr)
+		}
+		c.credsMu.Lock()
+		c.creds = c.wrapCredentials(creds)
+		c.credsMu.Unlock()
+		c.hostIdx = 0
+		return err
+	}
+	if !isTransient(c.tr, err) {
+		return backoff.Permanent(err)
+	}
+	if len(c.failover) != 0 {
+		c.hostIdx = (c.hostIdx + 1) % (len(c.failover) + 1)
+		c.logf("couldn't connect to %s: %s", creds.Hostname(), err)
+
+		// every hub is tried at least once
+		if retry || c.hostIdx != 0 {
+			return err
+		}
+	}
+	if retry {
+		c.logf("couldn't connect (attempt %d), reconnecting", attempt)
+		return err
+	}
+	return backoff.Permanent(err)
+}
+
+// connectionLost is called by transports that leave restoring
+// lost connections to the client, see reconnect.
+func (c *Client) connectionLost(err error) {
+	c.connMu.Lock()
+	c.connErr = err
+	c.connMu.Unlock()
+	c.restoreLater(err)
+}
+
+// restoreLater makes Run restore the connection when it's running,
+// otherwise it's restored in the background.
+func (c *Client) restoreLater(err error) {
+	if atomic.LoadInt32(&c.running) != 0 {
+		select {
+		case c.lost <- err:
+		default:
+			// Run is about to reconnect anyway
+		}
+		return
+	}
+	go c.restore(err)
+}
+
+// restore reconnects in the background, a failure is
+// reported and becomes the client's connection error.
+func (c *Client) restore(cause error) {
+	if !atomic.CompareAndSwapInt32(&c.restoring, 0, 1) {
+		return // already in progress
+	}
+	defer atomic.StoreInt32(&c.restoring, 0)
+
+	ctx, cancel := common.WithDone(context.Background(), c.done)
+	defer cancel()
+	c.logf("connection lost, reconnecting: %s", cause)
+	if err := c.reconnect(ctx); err != nil && ctx.Err() == nil {
+		c.reportError(fmt.Errorf("reconnection error: %w", err))
+	}
+}
+
+// reconnect restores the lost connection keeping subscriptions, transient
+// errors are retried trying every hub in turn, unlike automatic reconnects
+// of transports that stick to the hub they connected to first.
+func (c *Client) reconnect(ctx context.Context) error {
+	r := c.tr.(transport.Reconnector)
+	c.connMu.RLock()
+	p := c.backoff
+	c.connMu.RUnlock()
+	if p == nil {
+		p = backoff.Default
+	}
+
+	var reprovisioned bool
+	err := backoff.Retry(ctx, p, 0, func(attempt int) error {
+		c.connMu.Lock()
+		defer c.connMu.Unlock()
+		if c.connErr == nil {
+			return nil // restored meanwhile, e.g. by UpdateCredentials
+		}
+		if err := c.dial(ctx, r.Reconnect, true, attempt, &reprovisioned); err != nil {
+			return err
+		}
+		c.connErr = nil
+		c.logf("reconnected to %s", c.hostname())
+		return nil
+	})
+	if err != nil {
+		c.connMu.Lock()
+		c.connErr = err
+		c.connMu.Unlock()
+	}
+	return err
+}
+
+// hostname returns hostname of the current hub, c.connMu must be held.
+func (c *Client) hostname() string {
+	if c.hostIdx != 0 {
+		return c.failover[c.hostIdx-1]
+	}
+	return c.credentials().Hostname()
+}
+
+// isTransient reports whether err is a network or a throttling error
+// that is worth retrying.
+func isTransient(tr transport.Transport, err error) bool {
+	_, ok := backoff.RetryAfterHint(err)
+	return ok || tr.IsNetworkError(err)
+}
+
 // ConnectInBackground returns immediately connects in the background.
 // Methods that require connection are blocked until it's established.
 //
@@ -193,16 +843,33 @@ func (c *Client) ConnectInBackground(ctx context.Context, opts ...ConnOption) er
 	c.connCh = make(chan struct{})
 	c.connMu.Unlock()
 	go func() {
-		if err := c.Connect(ctx, opts...); err != nil {
-			c.logf("background connection error: %s", err)
+		err := c.connect(ctx, opts...)
+		if err != nil {
+			c.reportError(fmt.Errorf("background connection error: %w", err))
 		}
 		c.connMu.Lock()
 		close(c.connCh)
 		c.connMu.Unlock()
+		if err == nil {
+			c.setup(ctx)
+		}
 	}()
 	return nil
 }
 
+// setup reports device info and synchronizes the twin cache of a connected
+// client, errors are only reported because the connection is usable anyway.
+func (c *Client) setup(ctx context.Context) {
+	if err := c.reportDeviceInfo(ctx); err != nil {
+		c.reportError(fmt.Errorf("device info report error: %w", err))
+	}
+	if c.twin != nil {
+		if err := c.SyncTwin(ctx); err != nil {
+			c.reportError(fmt.Errorf("twin cache sync error: %w", err))
+		}
+	}
+}
+
 // ConnectionError blocks until the connection process is
 // finished and returns its error, see `ConnectInBackground` method.
 //
@@ -219,16 +886,18 @@ func (c *Client) ConnectInBackground(ctx context.Context, opts ...ConnOption) er
 // 	}()
 func (c *Client) ConnectionError(ctx context.Context) error {
 	c.connMu.RLock()
-	w := c.connCh
+	w, err := c.connCh, c.connErr
 	c.connMu.RUnlock()
 
 	// non-background connection
 	if w == nil {
-		return c.connErr
+		return err
 	}
 
 	select {
 	case <-w:
+		c.connMu.RLock()
+		defer c.connMu.RUnlock()
 		return c.connErr
 	case <-ctx.Done():
 		return ctx.Err()
@@ -238,15 +907,96 @@ func (c *Client) ConnectionError(ctx context.Context) error {
 }
 
 // SubscribeEvents subscribes to cloud-to-device events and blocks until ctx is canceled.
+//
+// Module clients receive messages addressed to the module,
+// the destination address is available in the message To field.
 func (c *Client) SubscribeEvents(ctx context.Context, fn MessageHandler) error {
+	if err := c.subscribeEvents(ctx); err != nil {
+		return err
+	}
+	c.cmMux.add(fn)
+	return nil
+}
+
+// subscribeEvents subscribes the messages mux to the transport once.
+func (c *Client) subscribeEvents(ctx context.Context) error {
 	if err := c.ConnectionError(ctx); err != nil {
 		return err
 	}
-	c.cmMux.once(func() error {
+	return c.cmMux.once(func() error {
 		return c.tr.SubscribeEvents(ctx, &c.cmMux)
 	})
-	c.cmMux.add(fn)
-	return nil
+}
+
+// EventSubscription is a channel-based cloud-to-device messages subscription.
+type EventSubscription struct {
+	mux    *messageMux
+	mu     sync.RWMutex
+	ch     chan *common.Message
+	closed bool
+	done   chan struct{}
+	once   sync.Once
+}
+
+// C returns messages channel, it's closed when the subscription stops.
+func (s *EventSubscription) C() <-chan *common.Message {
+	return s.ch
+}
+
+// Unsubscribe stops messages delivery and closes the channel.
+func (s *EventSubscription) Unsubscribe() {
+	s.once.Do(func() {
+		close(s.done) // unblock delivery if it's in progress
+		s.mux.removeSub(s)
+		s.mu.Lock()
+		s.closed = true
+		close(s.ch)
+		s.mu.Unlock()
+	})
+}
+
+// deliver blocks until the channel has room for msg or the subscription stops.
+func (s *EventSubscription) deliver(msg *common.Message) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.ch <- msg:
+	case <-s.done:
+	}
+}
+
+// SubscribeEventsChan is an alternative to SubscribeEvents that delivers
+// cloud-to-device messages to a channel with the given buffer size.
+//
+// The subscription stops and the channel is closed when ctx is done,
+// the client is closed or Unsubscribe is called. When the buffer is full
+// delivery to the channel blocks, other handlers are called meanwhile.
+func (c *Client) SubscribeEventsChan(ctx context.Context, size int) (*EventSubscription, error) {
+	if size < 0 {
+		panic("size is negative")
+	}
+	if err := c.subscribeEvents(ctx); err != nil {
+		return nil, err
+	}
+	s := &EventSubscription{
+		mux:  &c.cmMux,
+		ch:   make(chan *common.Message, size),
+		done: make(chan struct{}),
+	}
+	c.cmMux.addSub(s)
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Unsubscribe()
+		case <-c.done:
+			s.Unsubscribe()
+		case <-s.done:
+		}
+	}()
+	return s, nil
 }
 
 // UnsubscribeEvents unsubscribes the given handler from cloud-to-device events.
@@ -254,11 +1004,61 @@ func (c *Client) UnsubscribeEvents(fn MessageHandler) {
 	c.cmMux.remove(fn)
 }
 
+// SubscribeEventsContext is like SubscribeEvents but the subscription lasts
+// until ctx is done, fn is called with a context derived from ctx that's
+// also canceled when the client is closed.
+func (c *Client) SubscribeEventsContext(ctx context.Context, fn MessageContextHandler) error {
+	if fn == nil {
+		panic("fn is nil")
+	}
+	if err := c.subscribeEvents(ctx); err != nil {
+		return err
+	}
+	hctx, cancel := common.WithDone(ctx, c.done)
+	unsubscribe := c.cmMux.subscribe(func(msg *common.Message) {
+		fn(hctx, msg)
+	})
+	go func() {
+		<-hctx.Done()
+		cancel()
+		unsubscribe()
+	}()
+	return nil
+}
+
 // RegisterMethod registers the given direct method handler,
 // returns an error when method is already registered.
 // If f returns an error and empty body its error string
 // used as value of the error attribute in the result json.
+//
+// Registration doesn't block, invocations are served by the
+// transport until the method is unregistered or replaced.
 func (c *Client) RegisterMethod(ctx context.Context, name string, fn DirectMethodHandler) error {
+	return c.registerMethod(ctx, name, wrapMethodHandler(fn), false)
+}
+
+// RegisterMethodContext is like RegisterMethod but registers a raw payload handler.
+func (c *Client) RegisterMethodContext(ctx context.Context, name string, fn DirectMethodContextHandler) error {
+	return c.registerMethod(ctx, name, fn, false)
+}
+
+// ReplaceMethod is like RegisterMethod but it replaces
+// the named method's handler if it's already registered.
+func (c *Client) ReplaceMethod(ctx context.Context, name string, fn DirectMethodHandler) error {
+	return c.registerMethod(ctx, name, wrapMethodHandler(fn), true)
+}
+
+// ReplaceMethodContext is like ReplaceMethod but registers a raw payload handler.
+func (c *Client) ReplaceMethodContext(ctx context.Context, name string, fn DirectMethodContextHandler) error {
+	return c.registerMethod(ctx, name, fn, true)
+}
+
+func (c *Client) registerMethod(
+	ctx context.Context,
+	name string,
+	fn DirectMethodContextHandler,
+	replace bool,
+) error {
 	if err := c.ConnectionError(ctx); err != nil {
 		return err
 	}
@@ -271,12 +1071,17 @@ func (c *Client) RegisterMethod(ctx context.Context, name string, fn DirectMetho
 	}); err != nil {
 		return err
 	}
-	return c.dmMux.handle(name, fn)
+	if replace {
+		c.dmMux.replace(name, fn)
+		return nil
+	}
+	return c.dmMux.handleContext(name, fn)
 }
 
-// UnregisterMethod unregisters the named method.
-func (c *Client) UnregisterMethod(name string) {
-	c.dmMux.remove(name)
+// UnregisterMethod unregisters the named method so its invocations
+// start failing, it reports whether the method was registered.
+func (c *Client) UnregisterMethod(name string) bool {
+	return c.dmMux.remove(name)
 }
 
 // ErrClosed returned by methods when client closes.
@@ -296,6 +1101,8 @@ func (s TwinState) Version() int {
 
 // RetrieveTwinState returns desired and reported twin device states.
 func (c *Client) RetrieveTwinState(ctx context.Context) (desired TwinState, reported TwinState, err error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
 	if err := c.ConnectionError(ctx); err != nil {
 		return nil, nil, err
 	}
@@ -307,7 +1114,7 @@ func (c *Client) RetrieveTwinState(ctx context.Context) (desired TwinState, repo
 		Desired  TwinState `json:"desired"`
 		Reported TwinState `json:"reported"`
 	}
-	if err := json.Unmarshal(b, &v); err != nil {
+	if err := c.codec.Unmarshal(b, &v); err != nil {
 		return nil, nil, err
 	}
 	return v.Desired, v.Reported, nil
@@ -316,35 +1123,78 @@ func (c *Client) RetrieveTwinState(ctx context.Context) (desired TwinState, repo
 // UpdateTwinState updates twin device's state and returns new version.
 // To remove any attribute set its value to nil.
 func (c *Client) UpdateTwinState(ctx context.Context, s TwinState) (int, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
 	if err := c.ConnectionError(ctx); err != nil {
 		return 0, err
 	}
-	b, err := json.Marshal(s)
+	if c.coalescer != nil {
+		return c.coalescer.update(ctx, s)
+	}
+	return c.updateTwinState(ctx, s)
+}
+
+func (c *Client) updateTwinState(ctx context.Context, s TwinState) (int, error) {
+	b, err := c.codec.Marshal(s)
+	if err != nil {
+		return 0, err
+	}
+	ver, err := c.tr.UpdateTwinProperties(ctx, b)
 	if err != nil {
 		return 0, err
 	}
-	return c.tr.UpdateTwinProperties(ctx, b)
+	if c.twin != nil {
+		c.twin.report(s, ver)
+	}
+	return ver, nil
 }
 
 // SubscribeTwinUpdates registers fn as a desired state changes handler.
 func (c *Client) SubscribeTwinUpdates(ctx context.Context, fn TwinUpdateHandler) error {
-	if err := c.ConnectionError(ctx); err != nil {
-		return err
-	}
-	if err := c.tuMux.once(func() error {
-		return c.tr.SubscribeTwinUpdates(ctx, &c.tuMux)
-	}); err != nil {
+	if err := c.subscribeTwinUpdates(ctx); err != nil {
 		return err
 	}
 	c.tuMux.add(fn)
 	return nil
 }
 
+// subscribeTwinUpdates subscribes the twin updates mux to the transport once.
+func (c *Client) subscribeTwinUpdates(ctx context.Context) error {
+	if err := c.ConnectionError(ctx); err != nil {
+		return err
+	}
+	return c.tuMux.once(func() error {
+		return c.tr.SubscribeTwinUpdates(ctx, &c.tuMux)
+	})
+}
+
 // UnsubscribeTwinUpdates unsubscribes the given handler from twin state updates.
 func (c *Client) UnsubscribeTwinUpdates(fn TwinUpdateHandler) {
 	c.tuMux.remove(fn)
 }
 
+// SubscribeTwinUpdatesContext is like SubscribeTwinUpdates but the subscription
+// lasts until ctx is done, fn is called with a context derived from ctx
+// that's also canceled when the client is closed.
+func (c *Client) SubscribeTwinUpdatesContext(ctx context.Context, fn TwinUpdateContextHandler) error {
+	if fn == nil {
+		panic("fn is nil")
+	}
+	if err := c.subscribeTwinUpdates(ctx); err != nil {
+		return err
+	}
+	hctx, cancel := common.WithDone(ctx, c.done)
+	unsubscribe := c.tuMux.subscribe(func(state TwinState) {
+		fn(hctx, state)
+	})
+	go func() {
+		<-hctx.Done()
+		cancel()
+		unsubscribe()
+	}()
+	return nil
+}
+
 // SendOption is a send event options.
 type SendOption func(msg *common.Message) error
 
@@ -376,6 +1226,13 @@ func WithSendCorrelationID(cid string) SendOption {
 	}
 }
 
+// WithSendDedupeKey sets the key that identifies the message across
+// resends, see common.DedupeKeyProperty. It's useful when the same
+// logical message can be sent by the application more than once.
+func WithSendDedupeKey(key string) SendOption {
+	return WithSendProperty(common.DedupeKeyProperty, key)
+}
+
 // WithSendTo sets message destination.
 func WithSendTo(to string) SendOption {
 	return func(msg *common.Message) error {
@@ -384,6 +1241,43 @@ func WithSendTo(to string) SendOption {
 	}
 }
 
+// WithSendContentType sets payload content type, IoT Hub
+// routing queries can access message body only when it's
+// application/json and content encoding is set as well.
+func WithSendContentType(ct string) SendOption {
+	return func(msg *common.Message) error {
+		msg.ContentType = ct
+		return nil
+	}
+}
+
+// WithSendContentEncoding sets payload content encoding, e.g. utf-8.
+func WithSendContentEncoding(ce string) SendOption {
+	return func(msg *common.Message) error {
+		msg.ContentEncoding = ce
+		return nil
+	}
+}
+
+// WithSendCreationTime sets the time the message was created at,
+// it's useful for messages buffered before they are sent.
+func WithSendCreationTime(t time.Time) SendOption {
+	return func(msg *common.Message) error {
+		msg.CreationTime = &t
+		return nil
+	}
+}
+
+// WithSendRoutableJSON marks the payload as UTF-8 encoded JSON
+// making it accessible in routing queries with $body.
+func WithSendRoutableJSON() SendOption {
+	return func(msg *common.Message) error {
+		msg.ContentType = "application/json"
+		msg.ContentEncoding = "utf-8"
+		return nil
+	}
+}
+
 // TODO: seems like has no effect.
 //func WithSendUserID(uid string) SendOption {
 //	return func(msg *common.Message) error {
@@ -427,15 +1321,72 @@ func WithSendProperties(m map[string]string) SendOption {
 // SendEvent sends a device-to-cloud message.
 // Panics when event is nil.
 func (c *Client) SendEvent(ctx context.Context, payload []byte, opts ...SendOption) error {
+	if ok, prio := c.suspended(); ok {
+		if prio == nil {
+			return ErrSendingSuspended
+		}
+		return c.EnqueueEvent(*prio, payload, opts...)
+	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
 	if err := c.ConnectionError(ctx); err != nil {
 		return err
 	}
+	msg, err := c.newMessage(payload, opts)
+	if err != nil {
+		return err
+	}
+	return c.send(ctx, msg)
+}
+
+// newMessage creates a message ready to be sent applying
+// the given options and the client's configuration.
+func (c *Client) newMessage(payload []byte, opts []SendOption) (*common.Message, error) {
 	if payload == nil {
-		return errors.New("payload is nil")
+		return nil, errors.New("payload is nil")
 	}
 	msg := &common.Message{Payload: payload}
+	if len(c.props) != 0 {
+		msg.Properties = make(map[string]string, len(c.props))
+		for k, v := range c.props {
+			msg.Properties[k] = v
+		}
+	}
 	for _, opt := range opts {
 		if err := opt(msg); err != nil {
+			return nil, err
+		}
+	}
+	if fn, ok := c.validators[msg.Properties[MessageTypeProperty]]; ok {
+		if err := fn(msg.Payload); err != nil {
+			return nil, fmt.Errorf("invalid message: %s", err)
+		}
+	}
+	if c.compression != "" && msg.ContentEncoding == "" && len(msg.Payload) >= c.compressMin {
+		b, err := iotutil.Compress(c.compression, msg.Payload)
+		if err != nil {
+			return nil, err
+		}
+		msg.Payload = b
+		msg.ContentEncoding = c.compression
+	}
+	if msg.MessageID == "" && c.midgen != nil {
+		var err error
+		if msg.MessageID, err = c.midgen(); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+func (c *Client) send(ctx context.Context, msg *common.Message) error {
+	if c.msgRate != nil {
+		if err := c.msgRate.Wait(ctx, 1); err != nil {
+			return err
+		}
+	}
+	if c.byteRate != nil && len(msg.Payload) != 0 {
+		if err := c.byteRate.Wait(ctx, len(msg.Payload)); err != nil {
 			return err
 		}
 	}
@@ -450,14 +1401,53 @@ func (c *Client) SendEvent(ctx context.Context, payload []byte, opts ...SendOpti
 	return nil
 }
 
+// reportError logs errors of library-owned goroutines
+// and passes them to the error handler when it's set.
+func (c *Client) reportError(err error) {
+	c.logf("%s", err)
+	if c.onError != nil {
+		c.onError(err)
+	}
+}
+
 func (c *Client) logf(format string, v ...interface{}) {
 	if c.logger != nil {
-		c.logger.Printf(format, v...)
+		c.logger.Print(iotutil.Redact(fmt.Sprintf(format, v...)))
 	}
 }
 
-// Close closes transport connection.
+// withTimeout applies the default timeout to ctx if it has no deadline.
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || c.timeout == 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.timeout)
+}
+
+// Close closes transport connection, it's bounded
+// by the default timeout if it's set.
 func (c *Client) Close() error {
+	ctx, cancel := c.withTimeout(context.Background())
+	defer cancel()
+	return c.CloseWithContext(ctx)
+}
+
+// CloseWithContext closes transport connection but stops
+// waiting for it to be closed gracefully when ctx is done.
+func (c *Client) CloseWithContext(ctx context.Context) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.close()
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Client) close() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	select {
@@ -0,0 +1,381 @@
+package iotdevice
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+	"github.com/amenzhinsky/golang-iothub/common/backoff"
+	"github.com/amenzhinsky/golang-iothub/iotdevice/transport"
+)
+
+func TestWithRateLimit(t *testing.T) {
+	t.Parallel()
+
+	c := &Client{}
+	if err := WithRateLimit(-1, 0)(c); err == nil {
+		t.Fatal("negative rate is accepted")
+	}
+	if err := WithRateLimit(1, 10)(c); err != nil {
+		t.Fatal(err)
+	}
+	if c.msgRate == nil || c.byteRate == nil {
+		t.Fatal("limits are not set")
+	}
+
+	// a maximum size message fits in even when the bytes rate is lower
+	if err := c.byteRate.Wait(context.Background(), maxMessageSize); err != nil {
+		t.Fatal(err)
+	}
+	if c.byteRate.Allow(1) {
+		t.Error("bytes limit is not exhausted")
+	}
+	if !c.msgRate.Allow(1) || c.msgRate.Allow(1) {
+		t.Error("messages limit burst is not 1")
+	}
+}
+
+var errTestNetwork = errors.New("network error")
+
+// connectTransport fails connections to the down hubs.
+type connectTransport struct {
+	transport.Transport
+	down   map[string]bool
+	hosts  []string
+	tokens []string
+}
+
+func (tr *connectTransport) Connect(ctx context.Context, creds transport.Credentials) error {
+	if sn := creds.TLSConfig().ServerName; sn != creds.Hostname() {
+		return errors.New("server name = " + sn)
+	}
+	token, err := creds.Token(ctx, creds.Hostname(), time.Hour)
+	if err != nil {
+		return err
+	}
+	tr.hosts = append(tr.hosts, creds.Hostname())
+	tr.tokens = append(tr.tokens, token)
+	if tr.down[creds.Hostname()] {
+		return errTestNetwork
+	}
+	return nil
+}
+
+func (tr *connectTransport) IsNetworkError(err error) bool {
+	return err == errTestNetwork
+}
+
+func TestWithFailoverHostnames(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range map[string]struct {
+		down  map[string]bool
+		hosts []string
+		err   error
+	}{
+		"primary": {
+			hosts: []string{"a.net"},
+		},
+		"secondary": {
+			down:  map[string]bool{"a.net": true},
+			hosts: []string{"a.net", "b.net"},
+		},
+		"all down": {
+			down:  map[string]bool{"a.net": true, "b.net": true},
+			hosts: []string{"a.net", "b.net"},
+			err:   errTestNetwork,
+		},
+	} {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			tr := &connectTransport{down: tc.down}
+			c, err := NewClient(
+				WithTransport(tr),
+				WithConnectionString("HostName=a.net;DeviceId=dev;SharedAccessKey=c2VjcmV0"),
+				WithFailoverHostnames("b.net"),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = c.connect(context.Background(), WithConnBackoff(backoff.Constant(0))); err != tc.err {
+				t.Fatalf("connect() = %v, want %v", err, tc.err)
+			}
+			if !reflect.DeepEqual(tr.hosts, tc.hosts) {
+				t.Errorf("hosts = %v, want %v", tr.hosts, tc.hosts)
+			}
+			if len(tr.tokens) == 2 && tr.tokens[0] == tr.tokens[1] {
+				t.Error("token is not reissued for the failover hub")
+			}
+		})
+	}
+}
+
+// reconnectTransport records hubs it reconnects to.
+type reconnectTransport struct {
+	connectTransport
+}
+
+func (tr *reconnectTransport) Reconnect(ctx context.Context, creds transport.Credentials) error {
+	return tr.Connect(ctx, creds)
+}
+
+func TestUpdateCredentials(t *testing.T) {
+	t.Parallel()
+
+	tr := &reconnectTransport{}
+	c, err := NewClient(
+		WithTransport(tr),
+		WithConnectionString("HostName=a.net;DeviceId=dev;SharedAccessKey=c2VjcmV0"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := NewSASCredentials("HostName=b.net;DeviceId=dev;SharedAccessKey=c2VjcmV0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// not connected yet, so the next connection picks them up
+	ctx := context.Background()
+	if err = c.UpdateCredentials(ctx, creds); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.UpdateCredentials(ctx, creds); err != nil {
+		t.Fatal(err)
+	}
+	if w := []string{"b.net"}; !reflect.DeepEqual(tr.hosts, w) {
+		t.Fatalf("hosts = %v, want %v", tr.hosts, w)
+	}
+
+	if creds, err = NewSASCredentials("HostName=c.net;DeviceId=dev;SharedAccessKey=c2VjcmV0"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.UpdateCredentials(ctx, creds); err != nil {
+		t.Fatal(err)
+	}
+	if w := []string{"b.net", "c.net"}; !reflect.DeepEqual(tr.hosts, w) {
+		t.Errorf("hosts = %v, want %v", tr.hosts, w)
+	}
+	if c.credentials().Hostname() != "c.net" {
+		t.Errorf("hostname = %q, want %q", c.credentials().Hostname(), "c.net")
+	}
+}
+
+// lossTransport leaves restoring lost connections to the client.
+type lossTransport struct {
+	reconnectTransport
+	lost chan<- error
+	done chan string
+}
+
+func (tr *lossTransport) SetConnectionLossHandler(fn func(err error)) {
+	lost := make(chan error)
+	go func() {
+		for err := range lost {
+			fn(err)
+		}
+	}()
+	tr.lost = lost
+}
+
+func (tr *lossTransport) Reconnect(ctx context.Context, creds transport.Credentials) error {
+	if err := tr.Connect(ctx, creds); err != nil {
+		return err
+	}
+	tr.done <- creds.Hostname()
+	return nil
+}
+
+func TestConnectionLost(t *testing.T) {
+	t.Parallel()
+
+	tr := &lossTransport{done: make(chan string, 1)}
+	c, err := NewClient(
+		WithTransport(tr),
+		WithConnectionString("HostName=a.net;DeviceId=dev;SharedAccessKey=c2VjcmV0"),
+		WithFailoverHostnames("b.net"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.connect(context.Background(), WithConnBackoff(backoff.Constant(0))); err != nil {
+		t.Fatal(err)
+	}
+
+	// the primary hub goes down along with the connection to it
+	tr.down = map[string]bool{"a.net": true}
+	tr.lost <- errTestNetwork
+	select {
+	case host := <-tr.done:
+		if host != "b.net" {
+			t.Fatalf("reconnected to %q, want %q", host, "b.net")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not reconnected")
+	}
+	if w := []string{"a.net", "a.net", "b.net"}; !reflect.DeepEqual(tr.hosts, w) {
+		t.Errorf("hosts = %v, want %v", tr.hosts, w)
+	}
+}
+
+func TestConnectionLost_ConnectionError(t *testing.T) {
+	t.Parallel()
+
+	tr := &lossTransport{done: make(chan string, 1)}
+	c, err := NewClient(
+		WithTransport(tr),
+		WithConnectionString("HostName=a.net;DeviceId=dev;SharedAccessKey=c2VjcmV0"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.connect(context.Background(), WithConnBackoff(backoff.Constant(0))); err != nil {
+		t.Fatal(err)
+	}
+
+	// the connection state is checked by every operation while it's restored
+	tr.lost <- errTestNetwork
+	timeout := time.After(time.Second)
+	for {
+		c.ConnectionError(context.Background())
+		select {
+		case <-tr.done:
+			return
+		case <-timeout:
+			t.Fatal("not reconnected")
+		default:
+		}
+	}
+}
+
+func TestUpdateCredentials_ReconnectError(t *testing.T) {
+	t.Parallel()
+
+	tr := &lossTransport{done: make(chan string, 1)}
+	c, err := NewClient(
+		WithTransport(tr),
+		WithConnectionString("HostName=a.net;DeviceId=dev;SharedAccessKey=c2VjcmV0"),
+		WithFailoverHostnames("b.net"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.connect(context.Background(), WithConnBackoff(backoff.Constant(0))); err != nil {
+		t.Fatal(err)
+	}
+
+	tr.down = map[string]bool{"a.net": true}
+	creds, err := NewSASCredentials("HostName=a.net;DeviceId=dev;SharedAccessKey=a2V5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.UpdateCredentials(context.Background(), creds); err != errTestNetwork {
+		t.Fatalf("UpdateCredentials() = %v, want %v", err, errTestNetwork)
+	}
+	select {
+	case host := <-tr.done:
+		if host != "b.net" {
+			t.Fatalf("reconnected to %q, want %q", host, "b.net")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not reconnected")
+	}
+}
+
+// subscribeTransport accepts all subscriptions.
+type subscribeTransport struct {
+	connectTransport
+}
+
+func (tr *subscribeTransport) SubscribeEvents(ctx context.Context, mux transport.MessageDispatcher) error {
+	return nil
+}
+
+func TestSubscribeEventsContext(t *testing.T) {
+	t.Parallel()
+
+	c, err := NewClient(
+		WithTransport(&subscribeTransport{}),
+		WithConnectionString("HostName=a.net;DeviceId=dev;SharedAccessKey=c2VjcmV0"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctxc := make(chan context.Context, 2)
+	if err = c.SubscribeEventsContext(ctx, func(ctx context.Context, msg *common.Message) {
+		ctxc <- ctx
+	}); err != nil {
+		t.Fatal(err)
+	}
+	c.cmMux.Dispatch(&common.Message{})
+	hctx := <-ctxc
+	if hctx.Err() != nil {
+		t.Fatal("handler context is canceled")
+	}
+
+	cancel()
+	<-hctx.Done()
+	for i := 0; ; i++ {
+		c.cmMux.mu.RLock()
+		n := len(c.cmMux.s)
+		c.cmMux.mu.RUnlock()
+		if n == 0 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("handler is not unsubscribed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSubscribeEventsChan(t *testing.T) {
+	t.Parallel()
+
+	c, err := NewClient(
+		WithTransport(&subscribeTransport{}),
+		WithConnectionString("HostName=a.net;DeviceId=dev;SharedAccessKey=c2VjcmV0"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s, err := c.SubscribeEventsChan(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nobody reads the channel, but handlers can still be registered
+	go c.cmMux.Dispatch(&common.Message{})
+	done := make(chan struct{})
+	go func() {
+		c.cmMux.add(func(*common.Message) {})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("blocked delivery blocks subscribing")
+	}
+
+	cancel()
+	for range s.C() {
+	}
+}
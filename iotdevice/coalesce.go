@@ -0,0 +1,102 @@
+package iotdevice
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+)
+
+// coalesceTimeout bounds merged updates when the client has no default timeout.
+const coalesceTimeout = 30 * time.Second
+
+// twinCoalescer merges reported state updates made within an interval.
+type twinCoalescer struct {
+	interval time.Duration
+	send     func(ctx context.Context, s TwinState) (int, error)
+	context  func() (context.Context, context.CancelFunc) // bounds send calls
+
+	mu      sync.Mutex
+	pending TwinState
+	waiters []chan coalesceResult
+}
+
+type coalesceResult struct {
+	ver int
+	err error
+}
+
+// update adds s to the pending patch and waits until it's sent.
+func (c *twinCoalescer) update(ctx context.Context, s TwinState) (int, error) {
+	ch := make(chan coalesceResult, 1)
+	c.mu.Lock()
+	if c.pending == nil {
+		c.pending = TwinState{}
+		time.AfterFunc(c.interval, c.flush)
+	}
+	mergePatch(c.pending, s)
+	c.waiters = append(c.waiters, ch)
+	c.mu.Unlock()
+
+	select {
+	case r := <-ch:
+		return r.ver, r.err
+	case <-ctx.Done():
+		// the update is still going to be sent
+		return 0, ctx.Err()
+	}
+}
+
+func (c *twinCoalescer) flush() {
+	c.mu.Lock()
+	s, waiters := c.pending, c.waiters
+	c.pending, c.waiters = nil, nil
+	c.mu.Unlock()
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if c.context != nil {
+		ctx, cancel = c.context()
+	} else {
+		ctx, cancel = context.WithTimeout(context.Background(), coalesceTimeout)
+	}
+	defer cancel()
+	ver, err := c.send(ctx, s)
+	for _, ch := range waiters {
+		ch <- coalesceResult{ver: ver, err: err}
+	}
+}
+
+// mergePatch merges src patch into dst, unlike mergeState
+// it keeps nil values since they remove reported properties.
+func mergePatch(dst, src map[string]interface{}) {
+	for k, v := range src {
+		sm, ok := v.(map[string]interface{})
+		if !ok {
+			dst[k] = v
+			continue
+		}
+		dm, ok := dst[k].(map[string]interface{})
+		if !ok {
+			dm = map[string]interface{}{}
+			dst[k] = dm
+		}
+		mergePatch(dm, sm)
+	}
+}
+
+// coalesceContext returns the context of merged twin updates that's
+// canceled when the client is closed or the update takes too long.
+func (c *Client) coalesceContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := common.WithDone(context.Background(), c.done)
+	d := c.timeout
+	if d == 0 {
+		d = coalesceTimeout
+	}
+	ctx, cancelTimeout := context.WithTimeout(ctx, d)
+	return ctx, func() {
+		cancelTimeout()
+		cancel()
+	}
+}
@@ -0,0 +1,67 @@
+package iotdevice
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTwinCoalescer(t *testing.T) {
+	t.Parallel()
+
+	var sent []TwinState
+	c := &twinCoalescer{
+		interval: 50 * time.Millisecond,
+		send: func(ctx context.Context, s TwinState) (int, error) {
+			sent = append(sent, s)
+			return len(sent), nil
+		},
+	}
+
+	wg := sync.WaitGroup{}
+	for _, s := range []TwinState{
+		{"a": map[string]interface{}{"b": 1.0}},
+		{"a": map[string]interface{}{"c": 2.0}},
+		{"d": nil},
+	} {
+		wg.Add(1)
+		go func(s TwinState) {
+			defer wg.Done()
+			ver, err := c.update(context.Background(), s)
+			if err != nil {
+				t.Error(err)
+			} else if ver != 1 {
+				t.Errorf("version = %d, want 1", ver)
+			}
+		}(s)
+	}
+	wg.Wait()
+
+	want := []TwinState{{
+		"a": map[string]interface{}{"b": 1.0, "c": 2.0},
+		"d": nil,
+	}}
+	if !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent = %v, want %v", sent, want)
+	}
+}
+
+func TestTwinCoalescer_Timeout(t *testing.T) {
+	t.Parallel()
+
+	c := &twinCoalescer{
+		interval: time.Millisecond,
+		send: func(ctx context.Context, s TwinState) (int, error) {
+			<-ctx.Done() // the hub never responds
+			return 0, ctx.Err()
+		},
+		context: func() (context.Context, context.CancelFunc) {
+			return context.WithTimeout(context.Background(), 10*time.Millisecond)
+		},
+	}
+	if _, err := c.update(context.Background(), TwinState{"a": 1}); err != context.DeadlineExceeded {
+		t.Fatalf("update() = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,26 @@
+package iotdevice
+
+import "github.com/amenzhinsky/golang-iothub/common"
+
+// TwinCodec encodes and decodes twin documents and typed messages, see Send.
+type TwinCodec = common.Codec
+
+// WithCodec sets the codec of twin documents and typed messages, see Send,
+// default is encoding/json. A faster one reduces CPU usage on large twins,
+// e.g. in gateways.
+func WithCodec(codec common.Codec) ClientOption {
+	if codec == nil {
+		panic("codec is nil")
+	}
+	return func(c *Client) error {
+		c.codec = codec
+		return nil
+	}
+}
+
+// WithTwinCodec is an alias of WithCodec.
+//
+// Deprecated: use WithCodec, the codec isn't limited to twins.
+func WithTwinCodec(codec TwinCodec) ClientOption {
+	return WithCodec(codec)
+}
@@ -0,0 +1,60 @@
+package iotdevice
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+)
+
+// countingCodec counts decoded documents.
+type countingCodec struct {
+	common.JSONCodec
+	n int
+}
+
+func (c *countingCodec) Unmarshal(b []byte, v interface{}) error {
+	c.n++
+	return c.JSONCodec.Unmarshal(b, v)
+}
+
+func TestWithTwinCodec(t *testing.T) {
+	t.Parallel()
+
+	codec := &countingCodec{}
+	c := &Client{}
+	if err := WithTwinCodec(codec)(c); err != nil {
+		t.Fatal(err)
+	}
+
+	var got TwinState
+	m := &stateMux{codec: c.codec}
+	m.add(func(s TwinState) {
+		got = s
+	})
+	m.Dispatch([]byte(`{"a":1,"$version":2}`))
+	if want := (TwinState{"a": 1.0, "$version": 2.0}); !reflect.DeepEqual(got, want) {
+		t.Errorf("state = %v, want %v", got, want)
+	}
+	if codec.n != 1 {
+		t.Errorf("codec is called %d times, want 1", codec.n)
+	}
+}
+
+func TestWithCodec(t *testing.T) {
+	t.Parallel()
+
+	codec := &countingCodec{}
+	for name, opt := range map[string]ClientOption{
+		"WithCodec":     WithCodec(codec),
+		"WithTwinCodec": WithTwinCodec(codec),
+	} {
+		c := &Client{}
+		if err := opt(c); err != nil {
+			t.Fatal(err)
+		}
+		if c.codec != codec {
+			t.Errorf("%s: codec is not set", name)
+		}
+	}
+}
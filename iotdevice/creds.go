@@ -3,6 +3,7 @@ package iotdevice
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"time"
 
@@ -30,15 +31,27 @@ func (c *sasCreds) Hostname() string {
 	return c.creds.HostName
 }
 
+func (c *sasCreds) ModuleID() string {
+	return c.creds.ModuleID
+}
+
+func (c *sasCreds) GatewayHostname() string {
+	return c.creds.GatewayHostName
+}
+
 func (c *sasCreds) IsSAS() bool {
 	return true
 }
 
 func (c *sasCreds) TLSConfig() *tls.Config {
-	return &tls.Config{
-		ServerName: c.creds.HostName,
-		RootCAs:    common.RootCAs(),
+	host := c.creds.HostName
+	if c.creds.GatewayHostName != "" {
+		host = c.creds.GatewayHostName
 	}
+	return common.FIPSTLSConfig(&tls.Config{
+		ServerName: host,
+		RootCAs:    common.RootCAs(),
+	})
 }
 
 func (c *sasCreds) Token(ctx context.Context, uri string, d time.Duration) (string, error) {
@@ -72,13 +85,100 @@ func (c *x509Creds) IsSAS() bool {
 }
 
 func (c *x509Creds) TLSConfig() *tls.Config {
-	return &tls.Config{
+	return common.FIPSTLSConfig(&tls.Config{
 		ServerName:   c.hostname,
 		Certificates: []tls.Certificate{*c.certificate},
 		RootCAs:      common.RootCAs(),
-	}
+	})
 }
 
 func (c *x509Creds) Token(ctx context.Context, uri string, d time.Duration) (string, error) {
 	return "", errors.New("not supported")
 }
+
+// tlsCredentials wraps creds to override root CAs when roots is not nil,
+// pin server certificates and check their revocation status,
+// module credentials remain module credentials.
+func tlsCredentials(
+	creds transport.Credentials,
+	roots *x509.CertPool,
+	pins []string,
+	crl *common.CRLChecker,
+) transport.Credentials {
+	tc := &tlsCreds{Credentials: creds, roots: roots, pins: pins, crl: crl}
+	if mc, ok := creds.(transport.ModuleCredentials); ok {
+		return &tlsModuleCreds{tlsCreds: tc, mc: mc}
+	}
+	return tc
+}
+
+type tlsCreds struct {
+	transport.Credentials
+	roots *x509.CertPool
+	pins  []string
+	crl   *common.CRLChecker
+}
+
+func (c *tlsCreds) TLSConfig() *tls.Config {
+	cfg := c.Credentials.TLSConfig()
+	if c.roots != nil {
+		cfg = cfg.Clone()
+		cfg.RootCAs = c.roots
+	}
+	return c.crl.TLSConfig(common.PinTLSConfig(cfg, c.pins))
+}
+
+type tlsModuleCreds struct {
+	*tlsCreds
+	mc transport.ModuleCredentials
+}
+
+func (c *tlsModuleCreds) ModuleID() string {
+	return c.mc.ModuleID()
+}
+
+func (c *tlsModuleCreds) GatewayHostname() string {
+	return c.mc.GatewayHostname()
+}
+
+// hostCredentials makes creds connect to the given hub hostname,
+// SAS tokens are issued for it since transports derive token
+// audiences from Hostname.
+func hostCredentials(creds transport.Credentials, hostname string) transport.Credentials {
+	hc := &hostCreds{Credentials: creds, hostname: hostname}
+	if mc, ok := creds.(transport.ModuleCredentials); ok {
+		return &hostModuleCreds{hostCreds: hc, mc: mc}
+	}
+	return hc
+}
+
+type hostCreds struct {
+	transport.Credentials
+	hostname string
+}
+
+func (c *hostCreds) Hostname() string {
+	return c.hostname
+}
+
+func (c *hostCreds) TLSConfig() *tls.Config {
+	cfg := c.Credentials.TLSConfig()
+	if cfg.ServerName == c.Credentials.Hostname() {
+		cfg = cfg.Clone()
+		cfg.ServerName = c.hostname
+	}
+	return cfg
+}
+
+type hostModuleCreds struct {
+	*hostCreds
+	mc transport.ModuleCredentials
+}
+
+func (c *hostModuleCreds) ModuleID() string {
+	return c.mc.ModuleID()
+}
+
+func (c *hostModuleCreds) GatewayHostname() string {
+	return c.mc.GatewayHostname()
+}
@@ -0,0 +1,35 @@
+package iotdevice
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"testing"
+
+	"github.com/amenzhinsky/golang-iothub/iotdevice/transport"
+)
+
+func TestTLSCredentials(t *testing.T) {
+	t.Parallel()
+
+	creds, err := NewSASCredentials(
+		"HostName=test.azure-devices.net;DeviceId=dev;ModuleId=mod;SharedAccessKey=c2VjcmV0",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	pc := tlsCredentials(creds, roots, []string{base64.StdEncoding.EncodeToString(make([]byte, 32))}, nil)
+	if g := transport.ModuleID(pc); g != "mod" {
+		t.Errorf("ModuleID() = %q, want %q", g, "mod")
+	}
+	cfg := pc.TLSConfig()
+	if cfg.VerifyPeerCertificate == nil {
+		t.Error("VerifyPeerCertificate is not set")
+	}
+	if cfg.RootCAs != roots {
+		t.Error("RootCAs is not overridden")
+	}
+	if cfg.ServerName != "test.azure-devices.net" {
+		t.Errorf("ServerName = %q, want test.azure-devices.net", cfg.ServerName)
+	}
+}
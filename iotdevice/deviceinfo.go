@@ -0,0 +1,68 @@
+package iotdevice
+
+import (
+	"context"
+	"runtime"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+	"github.com/amenzhinsky/golang-iothub/iotdevice/transport"
+)
+
+// DeviceInfoKey is the reported property that device info is published under,
+// e.g. to query a fleet inventory:
+//
+//	SELECT deviceId FROM devices WHERE properties.reported.deviceInfo.firmware = '1.0'
+const DeviceInfoKey = "deviceInfo"
+
+// DeviceInfo is the standard device metadata.
+type DeviceInfo struct {
+	SDK        string `json:"sdk"`
+	SDKVersion string `json:"sdkVersion"`
+	Protocol   string `json:"protocol,omitempty"`
+	OS         string `json:"os"`
+	Arch       string `json:"arch"`
+	GoVersion  string `json:"goVersion"`
+	Firmware   string `json:"firmware,omitempty"`
+}
+
+// WithDeviceInfo makes the client report device info at connect time,
+// firmware is the device firmware version and can be empty.
+func WithDeviceInfo(firmware string) ClientOption {
+	return func(c *Client) error {
+		c.info = &DeviceInfo{Firmware: firmware}
+		return nil
+	}
+}
+
+// DeviceInfo returns the device metadata with the given firmware version.
+func (c *Client) DeviceInfo(firmware string) *DeviceInfo {
+	info := &DeviceInfo{
+		SDK:        common.SDKName,
+		SDKVersion: common.SDKVersion,
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		GoVersion:  runtime.Version(),
+		Firmware:   firmware,
+	}
+	if n, ok := c.tr.(transport.ProtocolNamer); ok {
+		info.Protocol = n.ProtocolName()
+	}
+	return info
+}
+
+// ReportDeviceInfo publishes the device info as the DeviceInfoKey reported property.
+func (c *Client) ReportDeviceInfo(ctx context.Context, info *DeviceInfo) error {
+	if info == nil {
+		panic("info is nil")
+	}
+	_, err := c.UpdateTwinStateFrom(ctx, map[string]interface{}{DeviceInfoKey: info})
+	return err
+}
+
+// reportDeviceInfo reports device info when it's enabled.
+func (c *Client) reportDeviceInfo(ctx context.Context) error {
+	if c.info == nil {
+		return nil
+	}
+	return c.ReportDeviceInfo(ctx, c.DeviceInfo(c.info.Firmware))
+}
@@ -0,0 +1,102 @@
+package iotdevice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"runtime"
+	"testing"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+	"github.com/amenzhinsky/golang-iothub/iotdevice/transport"
+)
+
+// reportTransport records reported properties patches.
+type reportTransport struct {
+	connectTransport
+	patches [][]byte
+}
+
+func (tr *reportTransport) UpdateTwinProperties(ctx context.Context, b []byte) (int, error) {
+	tr.patches = append(tr.patches, b)
+	return len(tr.patches), nil
+}
+
+func (tr *reportTransport) ProtocolName() string {
+	return "test"
+}
+
+var _ transport.ProtocolNamer = (*reportTransport)(nil)
+
+func TestWithDeviceInfo(t *testing.T) {
+	t.Parallel()
+
+	tr := &reportTransport{}
+	c, err := NewClient(
+		WithTransport(tr),
+		WithConnectionString("HostName=a.net;DeviceId=dev;SharedAccessKey=c2VjcmV0"),
+		WithDeviceInfo("1.2.3"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(tr.patches) != 1 {
+		t.Fatalf("patches = %d, want 1", len(tr.patches))
+	}
+
+	var v map[string]*DeviceInfo
+	if err = json.Unmarshal(tr.patches[0], &v); err != nil {
+		t.Fatal(err)
+	}
+	info := v[DeviceInfoKey]
+	if info == nil {
+		t.Fatalf("%s is missing in %s", DeviceInfoKey, tr.patches[0])
+	}
+	w := DeviceInfo{
+		SDK:        common.SDKName,
+		SDKVersion: common.SDKVersion,
+		Protocol:   "test",
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		GoVersion:  runtime.Version(),
+		Firmware:   "1.2.3",
+	}
+	if *info != w {
+		t.Errorf("info = %+v, want %+v", *info, w)
+	}
+}
+
+// failingReportTransport fails reporting properties.
+type failingReportTransport struct {
+	connectTransport
+}
+
+func (tr *failingReportTransport) UpdateTwinProperties(ctx context.Context, b []byte) (int, error) {
+	return 0, errors.New("report error")
+}
+
+func TestWithDeviceInfo_ReportError(t *testing.T) {
+	t.Parallel()
+
+	var reported error
+	c, err := NewClient(
+		WithTransport(&failingReportTransport{}),
+		WithConnectionString("HostName=a.net;DeviceId=dev;SharedAccessKey=c2VjcmV0"),
+		WithDeviceInfo(""),
+		WithErrorHandler(func(err error) {
+			reported = err
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Connect(context.Background()); err != nil {
+		t.Fatalf("Connect() = %v, want nil", err)
+	}
+	if reported == nil {
+		t.Error("report error is not passed to the error handler")
+	}
+}
@@ -0,0 +1,274 @@
+// Package gateway provides building blocks for gateways that bridge
+// devices unable to talk to IoT Hub themselves, e.g. non-IP sensors.
+//
+// A gateway created with New translates identities: every child gets
+// its own hub identity, twin and methods, at the cost of a connection
+// per child, because MQTT authenticates a single identity per connection.
+//
+// A gateway created with NewMultiplexed sends messages of all children
+// over the gateway's own connection annotating them with
+// ConnectionDeviceIDProperty, children then don't need hub identities.
+package gateway
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+	"github.com/amenzhinsky/golang-iothub/iotdevice"
+	"github.com/amenzhinsky/golang-iothub/iotdevice/transport"
+)
+
+// GatewayIDProperty is the application property that messages sent
+// on behalf of child devices are annotated with, it contains
+// the gateway id so consumers can tell bridged messages apart.
+const GatewayIDProperty = "gatewayDeviceId"
+
+// ConnectionDeviceIDProperty is the application property that messages
+// sent on behalf of child devices are annotated with, it contains
+// the child device id, because the hub's iothub-connection-device-id
+// system property names the gateway itself when multiplexing.
+const ConnectionDeviceIDProperty = "connectionDeviceId"
+
+// ErrMultiplexed is returned by Gateway.Client when children
+// are multiplexed and have no clients of their own.
+var ErrMultiplexed = errors.New("gateway: children are multiplexed")
+
+// DeriveDeviceKey derives a child device symmetric key from a group key
+// the same way the device provisioning service does for group enrollments,
+// so the gateway can authenticate any child knowing only the group key.
+func DeriveDeviceKey(groupKey, deviceID string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(groupKey)
+	if err != nil {
+		return "", err
+	}
+	h := hmac.New(sha256.New, b)
+	if _, err = h.Write([]byte(deviceID)); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// CredentialsFunc returns credentials of the named child device.
+type CredentialsFunc func(ctx context.Context, deviceID string) (transport.Credentials, error)
+
+// GroupKeyCredentials returns a CredentialsFunc that authenticates
+// children with keys derived from the given group key, see DeriveDeviceKey.
+func GroupKeyCredentials(hostname, groupKey string) CredentialsFunc {
+	return func(ctx context.Context, deviceID string) (transport.Credentials, error) {
+		key, err := DeriveDeviceKey(groupKey, deviceID)
+		if err != nil {
+			return nil, err
+		}
+		return iotdevice.NewSASCredentials(fmt.Sprintf(
+			"HostName=%s;DeviceId=%s;SharedAccessKey=%s", hostname, deviceID, key,
+		))
+	}
+}
+
+// Option is a gateway configuration option.
+type Option func(g *Gateway)
+
+// WithClientOptions sets options applied to every child client.
+func WithClientOptions(opts ...iotdevice.ClientOption) Option {
+	return func(g *Gateway) {
+		g.opts = append(g.opts, opts...)
+	}
+}
+
+// WithGatewayID enables annotating child messages with GatewayIDProperty.
+func WithGatewayID(id string) Option {
+	return func(g *Gateway) {
+		g.id = id
+	}
+}
+
+// Gateway maintains connected clients of child devices.
+type Gateway struct {
+	creds    CredentialsFunc
+	newTr    func() transport.Transport
+	opts     []iotdevice.ClientOption
+	id       string
+	upstream *iotdevice.Client // not nil when children are multiplexed
+
+	mu sync.Mutex
+	m  map[string]*child
+}
+
+// child is a child client that's connected or being connected.
+type child struct {
+	ready chan struct{} // closed when connecting is finished
+	c     *iotdevice.Client
+	err   error
+}
+
+// client returns the child client when it's connected.
+func (ch *child) client() *iotdevice.Client {
+	select {
+	case <-ch.ready:
+		return ch.c
+	default:
+		return nil
+	}
+}
+
+// New creates a gateway, newTransport is called for every child identity.
+func New(creds CredentialsFunc, newTransport func() transport.Transport, opts ...Option) *Gateway {
+	if creds == nil {
+		panic("creds is nil")
+	}
+	if newTransport == nil {
+		panic("newTransport is nil")
+	}
+	g := &Gateway{creds: creds, newTr: newTransport, m: map[string]*child{}}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// NewMultiplexed creates a gateway that sends messages of all children
+// over the given connected client of the gateway's own identity,
+// WithClientOptions has no effect on it.
+func NewMultiplexed(upstream *iotdevice.Client, opts ...Option) *Gateway {
+	if upstream == nil {
+		panic("upstream is nil")
+	}
+	g := &Gateway{upstream: upstream, m: map[string]*child{}}
+	for _, opt := range opts {
+		opt(g)
+	}
+	return g
+}
+
+// Client returns the named child client connecting it on the first use,
+// concurrent calls for the same child share a single connection attempt.
+//
+// It returns ErrMultiplexed when the gateway is created with NewMultiplexed.
+func (g *Gateway) Client(ctx context.Context, deviceID string) (*iotdevice.Client, error) {
+	if deviceID == "" {
+		return nil, errors.New("deviceID is empty")
+	}
+	if g.upstream != nil {
+		return nil, ErrMultiplexed
+	}
+	g.mu.Lock()
+	if ch, ok := g.m[deviceID]; ok {
+		g.mu.Unlock()
+		select {
+		case <-ch.ready:
+			return ch.c, ch.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	ch := &child{ready: make(chan struct{})}
+	g.m[deviceID] = ch
+	g.mu.Unlock()
+
+	// connecting is done without holding the lock
+	// so slow children don't block other ones
+	ch.c, ch.err = g.connect(ctx, deviceID)
+	g.mu.Lock()
+	if g.m[deviceID] != ch {
+		// removed while connecting
+		if ch.err == nil {
+			ch.c.Close()
+			ch.c, ch.err = nil, errors.New("gateway: child is removed")
+		}
+	} else if ch.err != nil {
+		delete(g.m, deviceID)
+	}
+	g.mu.Unlock()
+	close(ch.ready)
+	return ch.c, ch.err
+}
+
+func (g *Gateway) connect(ctx context.Context, deviceID string) (*iotdevice.Client, error) {
+	creds, err := g.creds(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	c, err := iotdevice.NewClient(append([]iotdevice.ClientOption{
+		iotdevice.WithCredentials(creds),
+		iotdevice.WithTransport(g.newTr()),
+	}, g.opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.Connect(ctx); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// SendEvent sends a device-to-cloud message on behalf of the named child.
+func (g *Gateway) SendEvent(ctx context.Context, deviceID string, payload []byte, opts ...iotdevice.SendOption) error {
+	if deviceID == "" {
+		return errors.New("deviceID is empty")
+	}
+	c := g.upstream
+	if c == nil {
+		var err error
+		if c, err = g.Client(ctx, deviceID); err != nil {
+			return err
+		}
+	}
+	return c.SendEvent(ctx, payload, append(opts[:len(opts):len(opts)], g.annotate(deviceID))...)
+}
+
+func (g *Gateway) annotate(deviceID string) iotdevice.SendOption {
+	return func(msg *common.Message) error {
+		if msg.Properties == nil {
+			msg.Properties = map[string]string{}
+		}
+		msg.Properties[ConnectionDeviceIDProperty] = deviceID
+		if g.id != "" {
+			msg.Properties[GatewayIDProperty] = g.id
+		}
+		return nil
+	}
+}
+
+// Remove disconnects the named child,
+// a child that's still connecting is disconnected once it's connected.
+func (g *Gateway) Remove(deviceID string) error {
+	g.mu.Lock()
+	ch, ok := g.m[deviceID]
+	delete(g.m, deviceID)
+	g.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	if c := ch.client(); c != nil {
+		return c.Close()
+	}
+	return nil
+}
+
+// Close disconnects all children, the upstream client
+// passed to NewMultiplexed is left to the caller to close.
+func (g *Gateway) Close() error {
+	g.mu.Lock()
+	m := g.m
+	g.m = map[string]*child{}
+	g.mu.Unlock()
+
+	var err error
+	for _, ch := range m {
+		c := ch.client()
+		if c == nil {
+			continue
+		}
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,124 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+	"github.com/amenzhinsky/golang-iothub/iotdevice"
+	"github.com/amenzhinsky/golang-iothub/iotdevice/transport"
+)
+
+func TestDeriveDeviceKey(t *testing.T) {
+	t.Parallel()
+
+	g, err := DeriveDeviceKey("c2VjcmV0", "leaf1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := "Rz4qZL56FfQGyer7VpX1Ko27W3toUIChnPSzofi//vQ="
+	if g != w {
+		t.Errorf("DeriveDeviceKey() = %q, want %q", g, w)
+	}
+	if _, err = DeriveDeviceKey("not base64", "leaf1"); err == nil {
+		t.Error("malformed group key is accepted")
+	}
+}
+
+func TestGatewayClient_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	var (
+		calls   int32
+		release = make(chan struct{})
+		errSlow = errors.New("slow")
+	)
+	g := New(func(ctx context.Context, deviceID string) (transport.Credentials, error) {
+		if deviceID == "fast" {
+			return nil, errors.New("fast")
+		}
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return nil, errSlow
+	}, func() transport.Transport { return nil })
+	defer g.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := g.Client(context.Background(), "slow"); err != errSlow {
+				t.Errorf("Client() = %v, want %v", err, errSlow)
+			}
+		}()
+	}
+
+	// a pending child mustn't block other ones
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := g.Client(context.Background(), "fast"); err == nil || err.Error() != "fast" {
+		t.Errorf("Client(fast) = %v, want fast", err)
+	}
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("credentials requested %d times, want 1", n)
+	}
+}
+
+// sendTransport records sent messages.
+type sendTransport struct {
+	transport.Transport
+	msgs []*common.Message
+}
+
+func (tr *sendTransport) Connect(ctx context.Context, creds transport.Credentials) error {
+	return nil
+}
+
+func (tr *sendTransport) Send(ctx context.Context, msg *common.Message) error {
+	tr.msgs = append(tr.msgs, msg)
+	return nil
+}
+
+func TestNewMultiplexed(t *testing.T) {
+	t.Parallel()
+
+	tr := &sendTransport{}
+	c, err := iotdevice.NewClient(
+		iotdevice.WithTransport(tr),
+		iotdevice.WithConnectionString("HostName=a.net;DeviceId=gw;SharedAccessKey=c2VjcmV0"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	g := NewMultiplexed(c, WithGatewayID("gw"))
+	defer g.Close()
+
+	for _, id := range []string{"leaf1", "leaf2"} {
+		if err = g.SendEvent(context.Background(), id, []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(tr.msgs) != 2 {
+		t.Fatalf("sent %d messages, want 2", len(tr.msgs))
+	}
+	for i, id := range []string{"leaf1", "leaf2"} {
+		p := tr.msgs[i].Properties
+		if p[ConnectionDeviceIDProperty] != id || p[GatewayIDProperty] != "gw" {
+			t.Errorf("message %d properties = %v", i, p)
+		}
+	}
+	if _, err = g.Client(context.Background(), "leaf1"); err != ErrMultiplexed {
+		t.Errorf("Client() = %v, want %v", err, ErrMultiplexed)
+	}
+}
@@ -0,0 +1,225 @@
+package iotdevice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+	"github.com/amenzhinsky/golang-iothub/iotdevice/transport"
+)
+
+// ModuleID returns the edge module id when the client is created
+// with a module connection string, it's empty for devices.
+func (c *Client) ModuleID() string {
+	return transport.ModuleID(c.credentials())
+}
+
+// WithSendOutput sends the message to the named edge module output.
+func WithSendOutput(name string) SendOption {
+	return func(msg *common.Message) error {
+		msg.OutputName = name
+		return nil
+	}
+}
+
+// SendOutput sends a message to the named output of the module,
+// so it can be routed by the edge hub to other modules or upstream.
+func (c *Client) SendOutput(ctx context.Context, output string, payload []byte, opts ...SendOption) error {
+	if output == "" {
+		return errors.New("output is empty")
+	}
+	if c.ModuleID() == "" {
+		return errors.New("outputs are available only for modules")
+	}
+	return c.SendEvent(ctx, payload, append(opts[:len(opts):len(opts)], WithSendOutput(output))...)
+}
+
+// SubscribeInput registers fn as the named module input messages handler,
+// returns an error when the input already has a handler.
+func (c *Client) SubscribeInput(ctx context.Context, input string, fn MessageHandler) error {
+	if input == "" {
+		return errors.New("input is empty")
+	}
+	if err := c.ConnectionError(ctx); err != nil {
+		return err
+	}
+	mt, ok := c.tr.(transport.ModuleTransport)
+	if !ok {
+		return errors.New("transport doesn't support module inputs")
+	}
+	if err := c.inMux.once(func() error {
+		return mt.SubscribeInputs(ctx, &c.inMux)
+	}); err != nil {
+		return err
+	}
+	return c.inMux.handle(input, fn)
+}
+
+// UnsubscribeInput removes the named input handler.
+func (c *Client) UnsubscribeInput(input string) {
+	c.inMux.remove(input)
+}
+
+// MethodResult is a direct method invocation result.
+type MethodResult struct {
+	Status  int                    `json:"status,omitempty"`
+	Payload map[string]interface{} `json:"payload,omitempty"`
+}
+
+// InvokeMethod calls the named direct method of a device, or of its
+// module when moduleID isn't empty, through the edge hub the module
+// is connected to, e.g. a supervisor module restarting a worker.
+//
+// The response timeout is taken from ctx deadline when it's set, invocations
+// that time out return errors matching context.DeadlineExceeded no matter
+// whether ctx or the hub timeout expires first.
+func (c *Client) InvokeMethod(
+	ctx context.Context,
+	deviceID, moduleID, methodName string,
+	payload map[string]interface{},
+) (*MethodResult, error) {
+	if deviceID == "" {
+		return nil, errors.New("deviceID is empty")
+	}
+	if methodName == "" {
+		return nil, errors.New("methodName is empty")
+	}
+	mc, ok := c.credentials().(transport.ModuleCredentials)
+	if !ok || mc.ModuleID() == "" || mc.GatewayHostname() == "" {
+		return nil, errors.New("methods can be invoked only by modules connected to an edge hub")
+	}
+
+	v := struct {
+		MethodName      string                 `json:"methodName"`
+		ResponseTimeout int                    `json:"responseTimeoutInSeconds,omitempty"`
+		Payload         map[string]interface{} `json:"payload"`
+	}{MethodName: methodName, Payload: payload}
+	d, derived := ctx.Deadline()
+	if derived {
+		// the hub accepts timeouts from 5s to 300s
+		v.ResponseTimeout = int(time.Until(d) / time.Second)
+		if v.ResponseTimeout < 5 {
+			v.ResponseTimeout = 5
+		} else if v.ResponseTimeout > 300 {
+			v.ResponseTimeout = 300
+		}
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	p := "/twins/" + url.PathEscape(deviceID)
+	if moduleID != "" {
+		p += "/modules/" + url.PathEscape(moduleID)
+	}
+	req, err := http.NewRequest(http.MethodPost,
+		"https://"+mc.GatewayHostname()+p+"/methods?api-version="+common.APIVersion,
+		bytes.NewReader(b),
+	)
+	if err != nil {
+		return nil, err
+	}
+	sas, err := mc.Token(ctx,
+		mc.Hostname()+"/devices/"+mc.DeviceID()+"/modules/"+mc.ModuleID(), time.Hour,
+	)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", sas)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", common.UserAgent(c.product))
+	req.Header.Set("x-ms-edge-moduleId", mc.DeviceID()+"/"+mc.ModuleID())
+
+	res, err := c.httpClient().Do(req.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	defer res.Body.Close()
+	b, err = ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("code = %d, desc = %q", res.StatusCode, string(b))
+		if derived && res.StatusCode == http.StatusGatewayTimeout {
+			return nil, fmt.Errorf("%w: %w", context.DeadlineExceeded, err)
+		}
+		return nil, err
+	}
+	r := &MethodResult{}
+	if err = json.Unmarshal(b, r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// httpClient returns the client used for HTTPS requests.
+func (c *Client) httpClient() *http.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.http == nil {
+		c.http = &http.Client{
+			Transport: &http.Transport{TLSClientConfig: c.credentials().TLSConfig()},
+		}
+	}
+	return c.http
+}
+
+// inputMux routes module input messages by input name.
+type inputMux struct {
+	on uint32
+	mu sync.RWMutex
+	m  map[string]MessageHandler
+
+	onPanic func(err error) // reports handler panics
+}
+
+func (m *inputMux) once(fn func() error) error {
+	return once(&m.on, &m.mu, fn)
+}
+
+func (m *inputMux) handle(input string, fn MessageHandler) error {
+	if fn == nil {
+		panic("fn is nil")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.m == nil {
+		m.m = map[string]MessageHandler{}
+	}
+	if _, ok := m.m[input]; ok {
+		return fmt.Errorf("input %q is already subscribed", input)
+	}
+	m.m[input] = fn
+	return nil
+}
+
+func (m *inputMux) remove(input string) {
+	m.mu.Lock()
+	delete(m.m, input)
+	m.mu.Unlock()
+}
+
+// Dispatch delivers msg to its input handler, other messages are dropped.
+func (m *inputMux) Dispatch(msg *common.Message) {
+	m.mu.RLock()
+	fn, ok := m.m[msg.InputName]
+	m.mu.RUnlock()
+	if ok {
+		common.Guard(m.onPanic, func() {
+			fn(msg)
+		})
+	}
+}
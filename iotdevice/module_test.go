@@ -0,0 +1,162 @@
+package iotdevice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInvokeMethod(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range map[string]struct {
+		moduleID   string
+		timeout    time.Duration
+		status     int
+		path       string
+		minTimeout int
+		maxTimeout int
+		err        error
+	}{
+		"device": {
+			status: http.StatusOK,
+			path:   "/twins/dev",
+		},
+		"module": {
+			moduleID: "worker",
+			status:   http.StatusOK,
+			path:     "/twins/dev/modules/worker",
+		},
+		"timeout": {
+			timeout:    30 * time.Second,
+			status:     http.StatusOK,
+			path:       "/twins/dev",
+			minTimeout: 25,
+			maxTimeout: 30,
+		},
+		"timeout below minimum": {
+			timeout:    time.Second,
+			status:     http.StatusOK,
+			path:       "/twins/dev",
+			minTimeout: 5,
+			maxTimeout: 5,
+		},
+		"timeout above maximum": {
+			timeout:    time.Hour,
+			status:     http.StatusOK,
+			path:       "/twins/dev",
+			minTimeout: 300,
+			maxTimeout: 300,
+		},
+		"not found": {
+			status: http.StatusNotFound,
+			path:   "/twins/dev",
+			err:    errors.New(`code = 404, desc = "{\"message\":\"failed\"}"`),
+		},
+		"gateway timeout": {
+			timeout:    10 * time.Second,
+			status:     http.StatusGatewayTimeout,
+			path:       "/twins/dev",
+			minTimeout: 5,
+			maxTimeout: 10,
+			err:        context.DeadlineExceeded,
+		},
+	} {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != tc.path+"/methods" {
+					t.Errorf("path = %q, want %q", r.URL.Path, tc.path+"/methods")
+				}
+				if g := r.Header.Get("x-ms-edge-moduleId"); g != "dev/mod" {
+					t.Errorf("x-ms-edge-moduleId = %q, want %q", g, "dev/mod")
+				}
+				sas, err := url.ParseQuery(strings.TrimPrefix(
+					r.Header.Get("Authorization"), "SharedAccessSignature "),
+				)
+				if err != nil {
+					t.Error(err)
+				}
+				if g := sas.Get("sr"); g != "hub.net/devices/dev/modules/mod" {
+					t.Errorf("token audience = %q, want %q", g, "hub.net/devices/dev/modules/mod")
+				}
+				var v struct {
+					MethodName      string                 `json:"methodName"`
+					ResponseTimeout int                    `json:"responseTimeoutInSeconds"`
+					Payload         map[string]interface{} `json:"payload"`
+				}
+				if err = json.NewDecoder(r.Body).Decode(&v); err != nil {
+					t.Error(err)
+				}
+				if v.MethodName != "restart" {
+					t.Errorf("methodName = %q, want %q", v.MethodName, "restart")
+				}
+				if v.ResponseTimeout < tc.minTimeout || v.ResponseTimeout > tc.maxTimeout {
+					t.Errorf("responseTimeoutInSeconds = %d, want [%d, %d]",
+						v.ResponseTimeout, tc.minTimeout, tc.maxTimeout)
+				}
+				w.WriteHeader(tc.status)
+				if tc.status != http.StatusOK {
+					w.Write([]byte(`{"message":"failed"}`))
+					return
+				}
+				w.Write([]byte(`{"status":200,"payload":{"ok":true}}`))
+			}))
+			defer s.Close()
+
+			c, err := NewClient(
+				WithTransport(&connectTransport{}),
+				WithConnectionString("HostName=hub.net;DeviceId=dev;ModuleId=mod;SharedAccessKey=c2VjcmV0;GatewayHostName="+
+					strings.TrimPrefix(s.URL, "https://")),
+				WithHTTPClient(s.Client()),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.Background()
+			if tc.timeout != 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tc.timeout)
+				defer cancel()
+			}
+			r, err := c.InvokeMethod(ctx, "dev", tc.moduleID, "restart", map[string]interface{}{"delay": 1})
+			if tc.err != nil {
+				if err == nil || !errors.Is(err, tc.err) && err.Error() != tc.err.Error() {
+					t.Fatalf("InvokeMethod() error = %v, want %v", err, tc.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := &MethodResult{Status: 200, Payload: map[string]interface{}{"ok": true}}
+			if !reflect.DeepEqual(r, want) {
+				t.Errorf("InvokeMethod() = %+v, want %+v", r, want)
+			}
+		})
+	}
+}
+
+func TestInvokeMethod_NotModule(t *testing.T) {
+	t.Parallel()
+
+	c, err := NewClient(
+		WithTransport(&connectTransport{}),
+		WithConnectionString("HostName=hub.net;DeviceId=dev;SharedAccessKey=c2VjcmV0"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.InvokeMethod(context.Background(), "dev", "", "restart", nil); err == nil {
+		t.Fatal("devices without an edge hub can invoke methods")
+	}
+}
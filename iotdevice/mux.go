@@ -1,6 +1,7 @@
 package iotdevice
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -15,7 +16,10 @@ import (
 type messageMux struct {
 	on uint32
 	mu sync.RWMutex
-	s  []MessageHandler
+	s  []*messageHandler
+	c  []*EventSubscription
+
+	onPanic func(err error) // reports handler panics
 }
 
 func (m *messageMux) once(fn func() error) error {
@@ -41,45 +45,104 @@ func once(i *uint32, mu *sync.RWMutex, fn func() error) error {
 	return nil
 }
 
+// messageHandler is a registered handler, its address identifies the registration.
+type messageHandler struct {
+	fn MessageHandler
+}
+
 // add adds the given handler to the handlers list.
 func (m *messageMux) add(fn MessageHandler) {
 	if fn == nil {
 		panic("fn is nil")
 	}
 	m.mu.Lock()
-	m.s = append(m.s, fn)
+	m.s = append(m.s, &messageHandler{fn: fn})
 	m.mu.Unlock()
 }
 
+// subscribe adds the given handler even if it's already added
+// and returns the function that removes only this registration.
+func (m *messageMux) subscribe(fn MessageHandler) func() {
+	if fn == nil {
+		panic("fn is nil")
+	}
+	h := &messageHandler{fn: fn}
+	m.mu.Lock()
+	m.s = append(m.s, h)
+	m.mu.Unlock()
+	return func() {
+		m.mu.Lock()
+		for i := len(m.s) - 1; i >= 0; i-- {
+			if m.s[i] == h {
+				m.s = append(m.s[:i], m.s[i+1:]...)
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
 // remove removes all matched handlers from the handlers list.
 func (m *messageMux) remove(fn MessageHandler) {
-	m.mu.RLock()
+	m.mu.Lock()
 	for i := len(m.s) - 1; i >= 0; i-- {
-		if ptreq(m.s[i], fn) {
+		if ptreq(m.s[i].fn, fn) {
 			m.s = append(m.s[:i], m.s[i+1:]...)
 		}
 	}
-	m.mu.RUnlock()
+	m.mu.Unlock()
+}
+
+// addSub adds the given subscription to the subscriptions list.
+func (m *messageMux) addSub(s *EventSubscription) {
+	m.mu.Lock()
+	m.c = append(m.c, s)
+	m.mu.Unlock()
+}
+
+// removeSub removes the given subscription from the subscriptions list.
+func (m *messageMux) removeSub(s *EventSubscription) {
+	m.mu.Lock()
+	for i := len(m.c) - 1; i >= 0; i-- {
+		if m.c[i] == s {
+			m.c = append(m.c[:i], m.c[i+1:]...)
+		}
+	}
+	m.mu.Unlock()
 }
 
 func ptreq(v1, v2 interface{}) bool {
 	return reflect.ValueOf(v1).Pointer() == reflect.ValueOf(v2).Pointer()
 }
 
-// Dispatch handles every handler in its own goroutine to prevent blocking.
+// Dispatch calls handlers and delivers msg to subscriptions one by one,
+// it's done without holding the lock, so a slow consumer doesn't block
+// other goroutines subscribing or unsubscribing meanwhile.
 func (m *messageMux) Dispatch(msg *common.Message) {
 	m.mu.RLock()
-	for _, fn := range m.s {
-		fn(msg)
-	}
+	hs := append([]*messageHandler(nil), m.s...)
+	cs := append([]*EventSubscription(nil), m.c...)
 	m.mu.RUnlock()
+	for _, h := range hs {
+		common.Guard(m.onPanic, func() {
+			h.fn(msg)
+		})
+	}
+	for _, s := range cs {
+		s.deliver(msg)
+	}
 }
 
 // methodMux is direct-methods dispatcher.
 type methodMux struct {
-	on uint32
-	mu sync.RWMutex
-	m  map[string]DirectMethodHandler
+	on   uint32
+	mu   sync.RWMutex
+	m    map[string]DirectMethodContextHandler
+	done <-chan struct{} // cancels handlers contexts
+
+	// audit reports invocations when not nil
+	audit func(method string, rc int, err error)
+
+	onPanic func(err error) // reports handler panics
 }
 
 func (m *methodMux) once(fn func() error) error {
@@ -88,12 +151,38 @@ func (m *methodMux) once(fn func() error) error {
 
 // handle registers the given direct-method handler.
 func (m *methodMux) handle(method string, fn DirectMethodHandler) error {
+	return m.handleContext(method, wrapMethodHandler(fn))
+}
+
+// wrapMethodHandler converts a map-based handler to a raw one.
+func wrapMethodHandler(fn DirectMethodHandler) DirectMethodContextHandler {
+	if fn == nil {
+		panic("fn is nil")
+	}
+	return func(_ context.Context, b []byte) ([]byte, error) {
+		var v map[string]interface{}
+		if err := json.Unmarshal(b, &v); err != nil {
+			return nil, err
+		}
+		v, err := fn(v)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			v = map[string]interface{}{}
+		}
+		return json.Marshal(v)
+	}
+}
+
+// handleContext registers the given raw direct-method handler.
+func (m *methodMux) handleContext(method string, fn DirectMethodContextHandler) error {
 	if fn == nil {
 		panic("fn is nil")
 	}
 	m.mu.Lock()
 	if m.m == nil {
-		m.m = map[string]DirectMethodHandler{}
+		m.m = map[string]DirectMethodContextHandler{}
 	}
 	if _, ok := m.m[method]; ok {
 		m.mu.Unlock()
@@ -104,17 +193,40 @@ func (m *methodMux) handle(method string, fn DirectMethodHandler) error {
 	return nil
 }
 
-// remove deregisters the named method.
-func (m *methodMux) remove(method string) {
+// replace registers the given handler replacing the current one if any.
+func (m *methodMux) replace(method string, fn DirectMethodContextHandler) {
+	if fn == nil {
+		panic("fn is nil")
+	}
 	m.mu.Lock()
-	if m.m != nil {
-		delete(m.m, method)
+	if m.m == nil {
+		m.m = map[string]DirectMethodContextHandler{}
 	}
+	m.m[method] = fn
 	m.mu.Unlock()
 }
 
+// remove deregisters the named method, reporting whether it was registered.
+func (m *methodMux) remove(method string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.m[method]; !ok {
+		return false
+	}
+	delete(m.m, method)
+	return true
+}
+
 // Dispatch dispatches the named method, error is not nil only when dispatching fails.
 func (m *methodMux) Dispatch(method string, b []byte) (int, []byte, error) {
+	rc, b, err := m.dispatch(method, b)
+	if m.audit != nil {
+		m.audit(method, rc, err)
+	}
+	return rc, b, err
+}
+
+func (m *methodMux) dispatch(method string, b []byte) (int, []byte, error) {
 	m.mu.RLock()
 	f, ok := m.m[method]
 	m.mu.RUnlock()
@@ -122,62 +234,123 @@ func (m *methodMux) Dispatch(method string, b []byte) (int, []byte, error) {
 		return 0, nil, fmt.Errorf("method %q is not registered", method)
 	}
 
-	var v map[string]interface{}
-	if err := json.Unmarshal(b, &v); err != nil {
-		return jsonErr(err)
-	}
-	v, err := f(v)
+	ctx, cancel := common.WithDone(context.Background(), m.done)
+	defer cancel()
+
+	b, err := m.call(ctx, f, b)
 	if err != nil {
 		return jsonErr(err)
 	}
-	if v == nil {
-		v = map[string]interface{}{}
-	}
-	b, err = json.Marshal(v)
-	if err != nil {
-		return jsonErr(err)
+	if b == nil {
+		b = []byte("{}")
 	}
 	return 200, b, nil
 }
 
+// call calls f, its panic is returned as an error.
+func (m *methodMux) call(ctx context.Context, f DirectMethodContextHandler, b []byte) (res []byte, err error) {
+	defer common.Recover(func(perr error) {
+		err = perr
+		if m.onPanic != nil {
+			m.onPanic(perr)
+		}
+	})
+	return f(ctx, b)
+}
+
 func jsonErr(err error) (int, []byte, error) {
 	return 500, []byte(fmt.Sprintf(`{"error":%q}`, err.Error())), nil
 }
 
 // mostly copy-paste of messageRouter
 type stateMux struct {
-	on uint32
-	mu sync.RWMutex
-	s  []TwinUpdateHandler
+	on    uint32
+	mu    sync.RWMutex
+	s     []*twinHandler
+	w     []twinPatcher
+	codec TwinCodec
+
+	onPanic func(err error) // reports handler panics
 }
 
 func (m *stateMux) once(fn func() error) error {
 	return once(&m.on, &m.mu, fn)
 }
 
+// twinHandler is a registered handler, its address identifies the registration.
+type twinHandler struct {
+	fn TwinUpdateHandler
+}
+
 func (m *stateMux) add(fn TwinUpdateHandler) {
 	if fn == nil {
 		panic("fn is nil")
 	}
 	m.mu.Lock()
-	m.s = append(m.s, fn)
+	m.s = append(m.s, &twinHandler{fn: fn})
 	m.mu.Unlock()
 }
 
+// subscribe adds the given handler even if it's already added
+// and returns the function that removes only this registration.
+func (m *stateMux) subscribe(fn TwinUpdateHandler) func() {
+	if fn == nil {
+		panic("fn is nil")
+	}
+	h := &twinHandler{fn: fn}
+	m.mu.Lock()
+	m.s = append(m.s, h)
+	m.mu.Unlock()
+	return func() {
+		m.mu.Lock()
+		for i := len(m.s) - 1; i >= 0; i-- {
+			if m.s[i] == h {
+				m.s = append(m.s[:i], m.s[i+1:]...)
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
 func (m *stateMux) remove(fn TwinUpdateHandler) {
-	m.mu.RLock()
+	m.mu.Lock()
 	for i := len(m.s) - 1; i >= 0; i-- {
-		if ptreq(m.s[i], fn) {
+		if ptreq(m.s[i].fn, fn) {
 			m.s = append(m.s[:i], m.s[i+1:]...)
 		}
 	}
-	m.mu.RUnlock()
+	m.mu.Unlock()
+}
+
+// twinPatcher is an internal desired state patches consumer.
+type twinPatcher interface {
+	apply(patch TwinState)
+}
+
+func (m *stateMux) addWatcher(w twinPatcher) {
+	m.mu.Lock()
+	m.w = append(m.w, w)
+	m.mu.Unlock()
+}
+
+func (m *stateMux) removeWatcher(w twinPatcher) {
+	m.mu.Lock()
+	for i := len(m.w) - 1; i >= 0; i-- {
+		if m.w[i] == w {
+			m.w = append(m.w[:i], m.w[i+1:]...)
+		}
+	}
+	m.mu.Unlock()
 }
 
 // blocks until all handlers return
 func (m *stateMux) Dispatch(b []byte) {
+	codec := m.codec
+	if codec == nil {
+		codec = common.JSONCodec{}
+	}
 	var v TwinState
-	if err := json.Unmarshal(b, &v); err != nil {
+	if err := codec.Unmarshal(b, &v); err != nil {
 		log.Printf("unmarshal error: %s", err)
 		return
 	}
@@ -185,11 +358,15 @@ func (m *stateMux) Dispatch(b []byte) {
 	w := sync.WaitGroup{}
 	m.mu.RLock()
 	w.Add(len(m.s))
-	for _, fn := range m.s {
+	for _, h := range m.s {
 		go func(f TwinUpdateHandler) {
+			defer w.Done()
+			defer common.Recover(m.onPanic)
 			f(v)
-			w.Done()
-		}(fn)
+		}(h.fn)
+	}
+	for _, pw := range m.w {
+		pw.apply(v)
 	}
 	m.mu.RUnlock()
 	w.Wait()
@@ -2,9 +2,14 @@ package iotdevice
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
+	"reflect"
+	"strings"
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/amenzhinsky/golang-iothub/common"
 )
@@ -84,3 +89,286 @@ func TestMethodMux(t *testing.T) {
 		t.Errorf("data = %q, want %q", data, w)
 	}
 }
+
+func TestMethodMux_Replace(t *testing.T) {
+	t.Parallel()
+
+	m := methodMux{}
+	reply := func(s string) DirectMethodContextHandler {
+		return func(context.Context, []byte) ([]byte, error) {
+			return []byte(s), nil
+		}
+	}
+	if err := m.handleContext("m", reply("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.handleContext("m", reply("2")); err == nil {
+		t.Fatal("registered twice")
+	}
+	m.replace("m", reply("2"))
+	if _, b, _ := m.Dispatch("m", nil); string(b) != "2" {
+		t.Errorf("data = %s, want 2", b)
+	}
+	if !m.remove("m") {
+		t.Error("remove(m) = false, want true")
+	}
+	if m.remove("m") {
+		t.Error("remove(m) = true after removal")
+	}
+	if _, _, err := m.Dispatch("m", nil); err == nil {
+		t.Error("removed method is dispatched")
+	}
+}
+
+func TestMethodMux_Audit(t *testing.T) {
+	t.Parallel()
+
+	var got []string
+	m := methodMux{audit: func(method string, rc int, err error) {
+		got = append(got, fmt.Sprintf("%s %d %t", method, rc, err != nil))
+	}}
+	if err := m.handleContext("fail", func(context.Context, []byte) ([]byte, error) {
+		return nil, errors.New("failed")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	m.Dispatch("fail", nil)
+	m.Dispatch("missing", nil)
+	if w := []string{"fail 500 false", "missing 0 true"}; !reflect.DeepEqual(got, w) {
+		t.Errorf("audited = %v, want %v", got, w)
+	}
+}
+
+func TestMethodMux_Context(t *testing.T) {
+	t.Parallel()
+
+	done := make(chan struct{})
+	m := methodMux{done: done}
+	if err := m.handleContext("echo", func(ctx context.Context, b []byte) ([]byte, error) {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return b, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for p, w := range map[string]string{
+		`[1,2]`: `[1,2]`,
+		`"str"`: `"str"`,
+	} {
+		rc, data, err := m.Dispatch("echo", []byte(p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rc != 200 || string(data) != w {
+			t.Errorf("Dispatch(%s) = %d, %s, want 200, %s", p, rc, data, w)
+		}
+	}
+}
+
+func TestMessageMux_Sub(t *testing.T) {
+	t.Parallel()
+
+	m := &messageMux{}
+	s := &EventSubscription{
+		mux:  m,
+		ch:   make(chan *common.Message, 1),
+		done: make(chan struct{}),
+	}
+	m.addSub(s)
+
+	w := &common.Message{MessageID: "1"}
+	m.Dispatch(w)
+	if g := <-s.C(); g != w {
+		t.Errorf("C() = %v, want %v", g, w)
+	}
+
+	// fill the buffer and block delivery
+	m.Dispatch(w)
+	go m.Dispatch(w)
+
+	s.Unsubscribe()
+	n := 0
+	for range s.C() {
+		n++
+	}
+	if n > 1 {
+		t.Errorf("received %d messages after unsubscribing, want at most 1", n)
+	}
+}
+
+func TestLookupPath(t *testing.T) {
+	t.Parallel()
+
+	m := map[string]interface{}{
+		"a": map[string]interface{}{
+			"b": 1.0,
+		},
+		"c": nil,
+	}
+	for p, w := range map[string]struct {
+		v  interface{}
+		ok bool
+	}{
+		"a.b":   {1.0, true},
+		"a.x":   {nil, false},
+		"c.d":   {nil, true},
+		"x":     {nil, false},
+		"a.b.c": {nil, true},
+	} {
+		v, ok := lookupPath(m, strings.Split(p, "."))
+		if v != w.v || ok != w.ok {
+			t.Errorf("lookupPath(%q) = %v, %t, want %v, %t", p, v, ok, w.v, w.ok)
+		}
+	}
+}
+
+func TestStateMux_Watcher(t *testing.T) {
+	t.Parallel()
+
+	var calls [][2]interface{}
+	w := &propertyWatcher{
+		path: []string{"a", "b"},
+		fn: func(old, new interface{}) {
+			calls = append(calls, [2]interface{}{old, new})
+		},
+	}
+	w.init(TwinState{"a": map[string]interface{}{"b": 1.0}})
+
+	m := &stateMux{}
+	m.addWatcher(w)
+	for _, b := range []string{
+		`{"a":{"b":1},"$version":2}`, // same value
+		`{"x":1,"$version":3}`,       // unrelated
+		`{"a":{"b":2},"$version":4}`,
+		`{"a":null,"$version":5}`,
+	} {
+		m.Dispatch([]byte(b))
+	}
+	want := [][2]interface{}{{1.0, 2.0}, {2.0, nil}}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %v, want %v", calls, want)
+	}
+}
+
+func TestInputMux(t *testing.T) {
+	t.Parallel()
+
+	var got []string
+	m := inputMux{}
+	if err := m.handle("in1", func(msg *common.Message) {
+		got = append(got, msg.MessageID)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.handle("in1", func(*common.Message) {}); err == nil {
+		t.Fatal("input is subscribed twice")
+	}
+	m.Dispatch(&common.Message{InputName: "in1", MessageID: "1"})
+	m.Dispatch(&common.Message{InputName: "in2", MessageID: "2"})
+	m.remove("in1")
+	m.Dispatch(&common.Message{InputName: "in1", MessageID: "3"})
+	if !reflect.DeepEqual(got, []string{"1"}) {
+		t.Errorf("delivered = %v, want [1]", got)
+	}
+}
+
+func TestMux_Panic(t *testing.T) {
+	t.Parallel()
+
+	var panics []error
+	onPanic := func(err error) {
+		panics = append(panics, err)
+	}
+
+	var i uint32
+	m := &messageMux{onPanic: onPanic}
+	m.add(func(*common.Message) {
+		panic("boom")
+	})
+	m.add(func(*common.Message) {
+		atomic.AddUint32(&i, 1)
+	})
+	testRecvNum(t, m, &i, 1)
+
+	mm := &methodMux{onPanic: onPanic}
+	if err := mm.handleContext("m", func(context.Context, []byte) ([]byte, error) {
+		panic("boom")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	rc, _, err := mm.Dispatch("m", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rc != 500 {
+		t.Errorf("rc = %d, want 500", rc)
+	}
+
+	var pe *common.PanicError
+	if len(panics) != 2 || !errors.As(panics[1], &pe) {
+		t.Errorf("panics = %v, want 2 *common.PanicError", panics)
+	}
+}
+
+func TestMessageMux_Subscribe(t *testing.T) {
+	t.Parallel()
+
+	var i uint32
+	m := &messageMux{}
+	handler := func() MessageHandler {
+		return func(*common.Message) {
+			atomic.AddUint32(&i, 1)
+		}
+	}
+
+	// closures of the same literal are independent registrations
+	unsubscribe := m.subscribe(handler())
+	m.subscribe(handler())
+	testRecvNum(t, m, &i, 2)
+
+	unsubscribe()
+	testRecvNum(t, m, &i, 1)
+}
+
+func TestMux_Closures(t *testing.T) {
+	t.Parallel()
+
+	// closures of the same literal share the code pointer,
+	// but they are different handlers all of which are called
+	var i uint32
+	m := &messageMux{}
+	for n := 0; n < 3; n++ {
+		m.add(func(*common.Message) {
+			atomic.AddUint32(&i, 1)
+		})
+	}
+	testRecvNum(t, m, &i, 3)
+
+	called := make(chan struct{}, 2)
+	s := &stateMux{}
+	for n := 0; n < 2; n++ {
+		s.add(func(TwinState) {
+			called <- struct{}{}
+		})
+	}
+	s.Dispatch([]byte(`{"$version":1}`))
+	for n := 0; n < 2; n++ {
+		select {
+		case <-called:
+		case <-time.After(time.Second):
+			t.Fatalf("twin handlers called = %d, want 2", n)
+		}
+	}
+
+	d := &methodMux{}
+	for n := 0; n < 2; n++ {
+		err := d.handleContext("m", func(context.Context, []byte) ([]byte, error) {
+			return nil, nil
+		})
+		if n == 1 && err == nil {
+			t.Error("another closure replaced the registered method")
+		}
+	}
+}
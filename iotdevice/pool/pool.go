@@ -0,0 +1,279 @@
+// Package pool manages a large number of device clients, e.g. for device
+// simulators and protocol gateways, creating them on demand, evicting
+// the least recently used ones and replacing unhealthy ones.
+//
+// Clients are independent, each of them holds a connection of its own,
+// so WithMaxClients should be chosen with the host's connection limits
+// in mind, see the gateway package for sharing a single connection.
+package pool
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/amenzhinsky/golang-iothub/iotdevice"
+)
+
+// NewClientFunc creates a connected client of the named device,
+// it's called again when the client needs to be reauthenticated.
+type NewClientFunc func(ctx context.Context, deviceID string) (*iotdevice.Client, error)
+
+// HealthFunc reports an error when the given client is unusable.
+type HealthFunc func(ctx context.Context, c *iotdevice.Client) error
+
+// ConnectionHealth is a HealthFunc that reports background connection errors.
+func ConnectionHealth(ctx context.Context, c *iotdevice.Client) error {
+	// don't wait long for a connection that's still pending
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := c.ConnectionError(ctx); err != nil && err != context.DeadlineExceeded {
+		return err
+	}
+	return nil
+}
+
+// EvictHandler is called after a client is evicted from the pool,
+// err is nil when it's evicted to make room for another client.
+type EvictHandler func(deviceID string, err error)
+
+// Option is a pool configuration option.
+type Option func(p *Pool)
+
+// WithMaxClients limits the number of clients,
+// the least recently used ones are closed to stay within it.
+func WithMaxClients(n int) Option {
+	return func(p *Pool) {
+		p.max = n
+	}
+}
+
+// WithHealthCheck enables checking health of all clients every interval,
+// clients that fail it are closed and recreated on the next use.
+func WithHealthCheck(interval time.Duration, fn HealthFunc) Option {
+	return func(p *Pool) {
+		p.interval = interval
+		p.health = fn
+	}
+}
+
+// WithEvictHandler sets the eviction handler.
+func WithEvictHandler(fn EvictHandler) Option {
+	return func(p *Pool) {
+		p.onEvict = fn
+	}
+}
+
+// ErrClosed is returned by a closed pool.
+var ErrClosed = errors.New("pool: closed")
+
+// Pool is a set of device clients keyed by device id.
+type Pool struct {
+	newClient NewClientFunc
+	max       int
+	interval  time.Duration
+	health    HealthFunc
+	onEvict   EvictHandler
+
+	mu      sync.Mutex
+	m       map[string]*list.Element
+	pending map[string]*call // clients being created
+	lru     *list.List       // front is the most recently used
+	closed  bool
+	done    chan struct{}
+}
+
+// call is a client creation shared by concurrent Get calls.
+type call struct {
+	done chan struct{}
+	c    *iotdevice.Client
+	err  error
+}
+
+type entry struct {
+	deviceID string
+	client   *iotdevice.Client
+}
+
+// New creates a pool and starts health checking when it's enabled.
+func New(newClient NewClientFunc, opts ...Option) *Pool {
+	if newClient == nil {
+		panic("newClient is nil")
+	}
+	p := &Pool{
+		newClient: newClient,
+		m:         map[string]*list.Element{},
+		pending:   map[string]*call{},
+		lru:       list.New(),
+		done:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	if p.interval > 0 && p.health != nil {
+		go p.checkHealth()
+	}
+	return p
+}
+
+// Get returns the named device client creating it when it's missing,
+// concurrent calls for the same device share a single creation.
+func (p *Pool) Get(ctx context.Context, deviceID string) (*iotdevice.Client, error) {
+	if deviceID == "" {
+		return nil, errors.New("deviceID is empty")
+	}
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if el, ok := p.m[deviceID]; ok {
+		p.lru.MoveToFront(el)
+		p.mu.Unlock()
+		return el.Value.(*entry).client, nil
+	}
+	if cl, ok := p.pending[deviceID]; ok {
+		p.mu.Unlock()
+		select {
+		case <-cl.done:
+			return cl.c, cl.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	cl := &call{done: make(chan struct{})}
+	p.pending[deviceID] = cl
+	p.mu.Unlock()
+
+	// clients are created without holding the lock so
+	// slow connections don't block access to other clients
+	cl.c, cl.err = p.newClient(ctx, deviceID)
+
+	p.mu.Lock()
+	delete(p.pending, deviceID)
+	if cl.err == nil && p.closed {
+		cl.c.Close()
+		cl.c, cl.err = nil, ErrClosed
+	}
+	var evicted []*entry
+	if cl.err == nil {
+		p.m[deviceID] = p.lru.PushFront(&entry{deviceID: deviceID, client: cl.c})
+		for p.max > 0 && p.lru.Len() > p.max {
+			evicted = append(evicted, p.remove(p.lru.Back()))
+		}
+	}
+	p.mu.Unlock()
+	close(cl.done)
+
+	for _, e := range evicted {
+		p.evict(e, nil)
+	}
+	return cl.c, cl.err
+}
+
+// Evict closes and removes the named client.
+func (p *Pool) Evict(deviceID string) error {
+	p.mu.Lock()
+	el, ok := p.m[deviceID]
+	if !ok {
+		p.mu.Unlock()
+		return nil
+	}
+	e := p.remove(el)
+	p.mu.Unlock()
+	return p.evict(e, nil)
+}
+
+// Reauth replaces the named client with a new one, e.g.
+// after its credentials are rotated in the registry.
+func (p *Pool) Reauth(ctx context.Context, deviceID string) (*iotdevice.Client, error) {
+	if err := p.Evict(deviceID); err != nil {
+		return nil, err
+	}
+	return p.Get(ctx, deviceID)
+}
+
+// Len returns the number of clients in the pool.
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lru.Len()
+}
+
+// remove removes the list element, p.mu must be held.
+func (p *Pool) remove(el *list.Element) *entry {
+	e := p.lru.Remove(el).(*entry)
+	delete(p.m, e.deviceID)
+	return e
+}
+
+func (p *Pool) evict(e *entry, reason error) error {
+	err := e.client.Close()
+	if p.onEvict != nil {
+		p.onEvict(e.deviceID, reason)
+	}
+	return err
+}
+
+func (p *Pool) checkHealth() {
+	t := time.NewTicker(p.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-p.done:
+			return
+		}
+
+		p.mu.Lock()
+		entries := make([]*entry, 0, p.lru.Len())
+		for el := p.lru.Front(); el != nil; el = el.Next() {
+			entries = append(entries, el.Value.(*entry))
+		}
+		p.mu.Unlock()
+
+		for _, e := range entries {
+			ctx, cancel := context.WithTimeout(context.Background(), p.interval)
+			err := p.health(ctx, e.client)
+			cancel()
+			if err == nil {
+				continue
+			}
+			p.mu.Lock()
+			el, ok := p.m[e.deviceID]
+			if !ok || el.Value.(*entry) != e {
+				p.mu.Unlock()
+				continue // already replaced
+			}
+			p.remove(el)
+			p.mu.Unlock()
+			p.evict(e, err)
+		}
+	}
+}
+
+// Close closes all clients in the pool.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	entries := make([]*entry, 0, p.lru.Len())
+	for p.lru.Len() != 0 {
+		entries = append(entries, p.remove(p.lru.Front()))
+	}
+	p.mu.Unlock()
+
+	var err error
+	for _, e := range entries {
+		if cerr := e.client.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,127 @@
+package pool
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/amenzhinsky/golang-iothub/iotdevice"
+	"github.com/amenzhinsky/golang-iothub/iotdevice/transport/mqtt"
+)
+
+func newClient(ctx context.Context, deviceID string) (*iotdevice.Client, error) {
+	creds, err := iotdevice.NewSASCredentials(
+		"HostName=test.azure-devices.net;DeviceId=" + deviceID + ";SharedAccessKey=c2VjcmV0",
+	)
+	if err != nil {
+		return nil, err
+	}
+	return iotdevice.NewClient(
+		iotdevice.WithCredentials(creds),
+		iotdevice.WithTransport(mqtt.New()),
+	)
+}
+
+func TestPoolLRU(t *testing.T) {
+	t.Parallel()
+
+	var evicted []string
+	p := New(newClient, WithMaxClients(2), WithEvictHandler(func(id string, err error) {
+		evicted = append(evicted, id)
+	}))
+	defer p.Close()
+
+	ctx := context.Background()
+	for _, id := range []string{"a", "b", "a", "c", "d"} {
+		if _, err := p.Get(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w := []string{"b", "a"}; !reflect.DeepEqual(evicted, w) {
+		t.Errorf("evicted = %v, want %v", evicted, w)
+	}
+	if p.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", p.Len())
+	}
+
+	c, err := p.Get(ctx, "d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := p.Reauth(ctx, "d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r == c {
+		t.Error("Reauth returned the same client")
+	}
+}
+
+func TestPoolHealthCheck(t *testing.T) {
+	t.Parallel()
+
+	errc := make(chan error, 1)
+	p := New(newClient,
+		WithHealthCheck(10*time.Millisecond, func(ctx context.Context, c *iotdevice.Client) error {
+			return errors.New("unhealthy")
+		}),
+		WithEvictHandler(func(id string, err error) {
+			select {
+			case errc <- err:
+			default:
+			}
+		}),
+	)
+	defer p.Close()
+
+	if _, err := p.Get(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errc:
+		if err == nil || err.Error() != "unhealthy" {
+			t.Errorf("eviction reason = %v, want unhealthy", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("unhealthy client is not evicted")
+	}
+}
+
+func TestPoolConcurrentGet(t *testing.T) {
+	t.Parallel()
+
+	var calls int32
+	p := New(func(ctx context.Context, deviceID string) (*iotdevice.Client, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(10 * time.Millisecond) // let other calls catch up
+		return newClient(ctx, deviceID)
+	})
+	defer p.Close()
+
+	var wg sync.WaitGroup
+	clients := make([]*iotdevice.Client, 8)
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c, err := p.Get(context.Background(), "a")
+			if err != nil {
+				t.Error(err)
+			}
+			clients[i] = c
+		}(i)
+	}
+	wg.Wait()
+	for _, c := range clients[1:] {
+		if c != clients[0] {
+			t.Fatal("concurrent Get returned different clients")
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("created %d clients, want 1", n)
+	}
+}
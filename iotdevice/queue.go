@@ -0,0 +1,193 @@
+package iotdevice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+	"github.com/amenzhinsky/golang-iothub/common/backoff"
+	"github.com/amenzhinsky/golang-iothub/iotutil"
+)
+
+// Priority is an outgoing message priority class.
+type Priority int
+
+// Priority classes, messages of higher classes are sent first.
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+)
+
+// ErrQueueFull is returned by EnqueueEvent when the send queue is full.
+var ErrQueueFull = errors.New("send queue is full")
+
+// EnqueueEvent puts a device-to-cloud message into the send queue
+// enabled by WithSendQueue and returns without waiting for it to be sent.
+//
+// Messages are sent in priority order, FIFO within the same class,
+// they stay in the queue while sending is suspended,
+// sending failures caused by network errors or the client being offline
+// are retried, other errors are logged and the message is dropped.
+//
+// Resent messages keep their MessageID and common.DedupeKeyProperty,
+// that defaults to it, so consumers can drop duplicates.
+func (c *Client) EnqueueEvent(prio Priority, payload []byte, opts ...SendOption) error {
+	if c.queue == nil {
+		return errors.New("send queue is not enabled")
+	}
+	if prio < PriorityLow || prio > PriorityHigh {
+		return errors.New("invalid priority")
+	}
+	msg, err := c.newMessage(payload, opts)
+	if err != nil {
+		return err
+	}
+	if err = stampDedupeKey(msg); err != nil {
+		return err
+	}
+	return c.queue.push(prio, msg)
+}
+
+// stampDedupeKey makes sure msg has an id and a dedupe key, the message is
+// built only once, so both of them are preserved when it's resent.
+func stampDedupeKey(msg *common.Message) error {
+	if msg.MessageID == "" {
+		var err error
+		if msg.MessageID, err = iotutil.UUID(); err != nil {
+			return err
+		}
+	}
+	if msg.Properties[common.DedupeKeyProperty] == "" {
+		if msg.Properties == nil {
+			msg.Properties = map[string]string{}
+		}
+		msg.Properties[common.DedupeKeyProperty] = msg.MessageID
+	}
+	return nil
+}
+
+// QueueLen returns the number of messages waiting in the send queue.
+func (c *Client) QueueLen() int {
+	if c.queue == nil {
+		return 0
+	}
+	return c.queue.len()
+}
+
+func (c *Client) drainQueue() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-c.done
+		cancel()
+	}()
+
+	var attempt int
+	for {
+		msg, ok := c.queue.peek(c.done)
+		if !ok {
+			return
+		}
+		if c.waitResumed(ctx) != nil {
+			return
+		}
+		err := c.ConnectionError(ctx)
+		if err == nil {
+			err = c.send(ctx, msg)
+		}
+		switch {
+		case err == nil:
+			attempt = 0
+			c.queue.pop(msg)
+		case err == errNotConnected || isTransient(c.tr, err):
+			attempt++
+			c.logf("queued message send failed (attempt %d): %s", attempt, err)
+			if backoff.Wait(ctx, backoff.Delay(backoff.Default, attempt, err)) != nil {
+				return
+			}
+		default:
+			attempt = 0
+			c.queue.pop(msg)
+			if ctx.Err() != nil {
+				return
+			}
+			c.reportError(fmt.Errorf("queued message dropped: %w", err))
+		}
+	}
+}
+
+// sendQueue is a bounded multi-class priority FIFO.
+type sendQueue struct {
+	mu     sync.Mutex
+	size   int
+	n      int
+	q      [PriorityHigh + 1][]*common.Message
+	notify chan struct{}
+}
+
+func newSendQueue(size int) *sendQueue {
+	return &sendQueue{size: size, notify: make(chan struct{}, 1)}
+}
+
+func (q *sendQueue) push(prio Priority, msg *common.Message) error {
+	q.mu.Lock()
+	if q.n >= q.size {
+		q.mu.Unlock()
+		return ErrQueueFull
+	}
+	q.q[prio] = append(q.q[prio], msg)
+	q.n++
+	q.mu.Unlock()
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// peek blocks until the queue is not empty and returns
+// the highest priority message without removing it.
+func (q *sendQueue) peek(done <-chan struct{}) (*common.Message, bool) {
+	for {
+		q.mu.Lock()
+		for p := PriorityHigh; p >= PriorityLow; p-- {
+			if len(q.q[p]) != 0 {
+				msg := q.q[p][0]
+				q.mu.Unlock()
+				return msg, true
+			}
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.notify:
+		case <-done:
+			return nil, false
+		}
+	}
+}
+
+// pop removes msg returned by peek, higher priority
+// messages pushed in between are left intact.
+func (q *sendQueue) pop(msg *common.Message) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for p := PriorityHigh; p >= PriorityLow; p-- {
+		if len(q.q[p]) != 0 && q.q[p][0] == msg {
+			q.q[p][0] = nil
+			q.q[p] = q.q[p][1:]
+			q.n--
+			return
+		}
+	}
+}
+
+func (q *sendQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.n
+}
@@ -0,0 +1,85 @@
+package iotdevice
+
+import (
+	"testing"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+)
+
+func TestSendQueue(t *testing.T) {
+	t.Parallel()
+
+	q := newSendQueue(3)
+	for _, m := range []struct {
+		prio Priority
+		id   string
+	}{
+		{PriorityLow, "bulk"},
+		{PriorityNormal, "telemetry"},
+		{PriorityHigh, "alarm"},
+	} {
+		if err := q.push(m.prio, &common.Message{MessageID: m.id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.push(PriorityHigh, &common.Message{}); err != ErrQueueFull {
+		t.Fatalf("push to full queue = %v, want %v", err, ErrQueueFull)
+	}
+
+	done := make(chan struct{})
+	first, _ := q.peek(done)
+
+	// a higher priority message pushed after peek doesn't get popped
+	q.pop(first)
+	_ = q.push(PriorityHigh, &common.Message{MessageID: "alarm2"})
+	second, _ := q.peek(done)
+	q.pop(&common.Message{})
+	q.pop(second)
+
+	var ids []string
+	for _, m := range []*common.Message{first, second} {
+		ids = append(ids, m.MessageID)
+	}
+	for q.len() != 0 {
+		m, _ := q.peek(done)
+		q.pop(m)
+		ids = append(ids, m.MessageID)
+	}
+	want := []string{"alarm", "alarm2", "telemetry", "bulk"}
+	if len(ids) != len(want) {
+		t.Fatalf("ids = %v, want %v", ids, want)
+	}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Fatalf("ids = %v, want %v", ids, want)
+		}
+	}
+
+	close(done)
+	if _, ok := q.peek(done); ok {
+		t.Error("peek on empty closed queue returned a message")
+	}
+}
+
+func TestStampDedupeKey(t *testing.T) {
+	t.Parallel()
+
+	msg := &common.Message{}
+	if err := stampDedupeKey(msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.MessageID == "" || msg.DedupeKey() != msg.MessageID {
+		t.Errorf("id = %q, dedupe key = %q", msg.MessageID, msg.DedupeKey())
+	}
+
+	msg = &common.Message{
+		MessageID:  "id",
+		Properties: map[string]string{common.DedupeKeyProperty: "key"},
+	}
+	if err := stampDedupeKey(msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.MessageID != "id" || msg.DedupeKey() != "key" {
+		t.Errorf("id = %q, dedupe key = %q", msg.MessageID, msg.DedupeKey())
+	}
+}
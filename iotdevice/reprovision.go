@@ -0,0 +1,115 @@
+package iotdevice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+	"github.com/amenzhinsky/golang-iothub/iotdevice/transport"
+)
+
+// Reprovisioner re-runs device provisioning, e.g. a DPS registration,
+// and returns credentials for the hub the device is assigned to,
+// cause is the error that made the client reprovision.
+type Reprovisioner func(ctx context.Context, cause error) (transport.Credentials, error)
+
+// WithReprovisioning makes the client re-provision and migrate to the newly
+// assigned hub when the current one refuses the device, that's what hubs do
+// once the device is reassigned, or when a lost connection isn't restored
+// within grace, since transports may keep reconnecting to the old hub.
+//
+// Zero grace disables reprovisioning of lost connections.
+func WithReprovisioning(fn Reprovisioner, grace time.Duration) ClientOption {
+	if fn == nil {
+		panic("fn is nil")
+	}
+	return func(c *Client) error {
+		c.reprov = &reprovisioner{fn: fn, grace: grace}
+		return nil
+	}
+}
+
+// reprovisioner re-provisions the device after its connection is lost for too long.
+type reprovisioner struct {
+	fn    Reprovisioner
+	grace time.Duration
+
+	mu    sync.Mutex
+	timer *time.Timer // not nil when the connection is lost
+}
+
+// stateHandler wraps fn to start reprovisioning countdowns on disconnections.
+func (c *Client) stateHandler(fn transport.ConnectionStateHandler) transport.ConnectionStateHandler {
+	return func(state transport.ConnectionState, err error) {
+		if fn != nil {
+			fn(state, err)
+		}
+		r := c.reprov
+		if r.grace == 0 {
+			return
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if state == transport.Connected {
+			if r.timer != nil {
+				r.timer.Stop()
+				r.timer = nil
+			}
+			return
+		}
+		if r.timer == nil {
+			r.timer = time.AfterFunc(r.grace, func() {
+				r.mu.Lock()
+				r.timer = nil
+				r.mu.Unlock()
+				c.reprovisionLost(err)
+			})
+		}
+	}
+}
+
+// reprovisionLost migrates a connected client whose connection is lost.
+func (c *Client) reprovisionLost(cause error) {
+	ctx, cancel := common.WithDone(context.Background(), c.done)
+	defer cancel()
+	ctx, cancelTimeout := c.withTimeout(ctx)
+	defer cancelTimeout()
+
+	creds, err := c.reprovision(ctx, cause)
+	if err != nil {
+		err = fmt.Errorf("reprovisioning error: %w", err)
+		c.reportError(err)
+		c.fail(err)
+		return
+	}
+	if err = c.UpdateCredentials(ctx, creds); err != nil {
+		err = fmt.Errorf("reconnecting to %s error: %w", creds.Hostname(), err)
+		c.reportError(err)
+		c.fail(err)
+	}
+}
+
+// reprovision runs the reprovisioner.
+func (c *Client) reprovision(ctx context.Context, cause error) (transport.Credentials, error) {
+	c.logf("reprovisioning: %s", cause)
+	creds, err := c.reprov.fn(ctx, cause)
+	if err != nil {
+		return nil, err
+	}
+	if creds == nil {
+		return nil, errors.New("reprovisioning returned no credentials")
+	}
+	if creds.DeviceID() != c.DeviceID() || transport.ModuleID(creds) != c.ModuleID() {
+		return nil, errors.New("reprovisioned to another identity")
+	}
+	c.logf("reprovisioned to %s", creds.Hostname())
+	return creds, nil
+}
+
+// isRefused reports whether err means that the hub refuses the device.
+func isRefused(err error) bool {
+	return errors.Is(err, common.ErrUnauthorized)
+}
@@ -0,0 +1,115 @@
+package iotdevice
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/amenzhinsky/golang-iothub/iotdevice/transport"
+)
+
+// refusingTransport refuses devices at hubs they were moved from.
+type refusingTransport struct {
+	reconnectTransport
+	refused map[string]bool
+}
+
+func (tr *refusingTransport) Connect(ctx context.Context, creds transport.Credentials) error {
+	if err := tr.reconnectTransport.Connect(ctx, creds); err != nil {
+		return err
+	}
+	if tr.refused[creds.Hostname()] {
+		return &transport.ConnectError{Reason: transport.ReasonNotAuthorized, Code: 5}
+	}
+	return nil
+}
+
+func (tr *refusingTransport) Reconnect(ctx context.Context, creds transport.Credentials) error {
+	return tr.Connect(ctx, creds)
+}
+
+func reprovisionTo(hostname string) Reprovisioner {
+	return func(ctx context.Context, cause error) (transport.Credentials, error) {
+		return NewSASCredentials("HostName=" + hostname + ";DeviceId=dev;SharedAccessKey=c2VjcmV0")
+	}
+}
+
+func TestWithReprovisioning(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range map[string]struct {
+		refused map[string]bool
+		hosts   []string
+		err     bool
+	}{
+		"assigned": {
+			hosts: []string{"a.net"},
+		},
+		"reassigned": {
+			refused: map[string]bool{"a.net": true},
+			hosts:   []string{"a.net", "b.net"},
+		},
+		"refused": {
+			refused: map[string]bool{"a.net": true, "b.net": true},
+			hosts:   []string{"a.net", "b.net"},
+			err:     true,
+		},
+	} {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			tr := &refusingTransport{refused: tc.refused}
+			c, err := NewClient(
+				WithTransport(tr),
+				WithConnectionString("HostName=a.net;DeviceId=dev;SharedAccessKey=c2VjcmV0"),
+				WithReprovisioning(reprovisionTo("b.net"), 0),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = c.connect(context.Background()); (err != nil) != tc.err {
+				t.Fatalf("connect() = %v, want error = %t", err, tc.err)
+			}
+			if !reflect.DeepEqual(tr.hosts, tc.hosts) {
+				t.Errorf("hosts = %v, want %v", tr.hosts, tc.hosts)
+			}
+		})
+	}
+}
+
+func TestWithReprovisioning_Lost(t *testing.T) {
+	t.Parallel()
+
+	tr := &refusingTransport{}
+	c, err := NewClient(
+		WithTransport(tr),
+		WithConnectionString("HostName=a.net;DeviceId=dev;SharedAccessKey=c2VjcmV0"),
+		WithReprovisioning(reprovisionTo("b.net"), time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// restored connections are not reprovisioned
+	onState := c.stateHandler(nil)
+	onState(transport.Disconnected, errors.New("lost"))
+	onState(transport.Connected, nil)
+	time.Sleep(10 * time.Millisecond)
+	if c.credentials().Hostname() != "a.net" {
+		t.Fatal("reprovisioned after the connection is restored")
+	}
+
+	onState(transport.Disconnected, errors.New("lost"))
+	for i := 0; c.credentials().Hostname() != "b.net"; i++ {
+		if i == 100 {
+			t.Fatal("not reprovisioned")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,89 @@
+package iotdevice
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/amenzhinsky/golang-iothub/iotdevice/transport"
+)
+
+// Run connects the client and keeps it connected until ctx is done, the
+// client is closed or a fatal error occurs, then it closes the client.
+//
+// With transports that leave restoring lost connections to the client,
+// such as the MQTT one, Run reconnects with backoff trying failover hubs in
+// turn, see WithFailoverHostnames, subscriptions are restored by the
+// transport and every dial issues a fresh token, so expired tokens are
+// renewed by the hub dropping the connection and Run reconnecting.
+// Device info and the twin cache are set up once connected, their
+// errors are passed to the error handler, see WithErrorHandler.
+//
+// Fatal errors are the hub refusing the device, e.g. when it's disabled or
+// its key is revoked, when reprovisioning isn't configured, see
+// WithReprovisioning, and reprovisioning failures, on reconnects as well.
+//
+// It returns nil when ctx is done, so it drops into errgroup-managed
+// services along with subscriptions that share the same ctx:
+//
+//	g, ctx := errgroup.WithContext(ctx)
+//	g.Go(func() error {
+//		return c.Run(ctx)
+//	})
+//	g.Go(func() error {
+//		return c.SubscribeEventsContext(ctx, handler)
+//	})
+//	return g.Wait()
+func (c *Client) Run(ctx context.Context, opts ...ConnOption) error {
+	defer c.Close()
+	atomic.StoreInt32(&c.running, 1)
+	defer atomic.StoreInt32(&c.running, 0)
+
+	if err := c.connect(ctx, opts...); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	c.setup(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-c.done:
+			return nil
+		case err := <-c.fatal:
+			return err
+		case err := <-c.lost:
+			c.logf("connection lost, reconnecting: %s", err)
+			if err = c.reconnect(ctx); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return fmt.Errorf("reconnection error: %w", err)
+			}
+		}
+	}
+}
+
+// fail makes Run return err, errors that come after the first one are dropped.
+func (c *Client) fail(err error) {
+	select {
+	case c.fatal <- err:
+	default:
+	}
+}
+
+// fatalStateHandler wraps fn to fail when the hub refuses the device
+// and there's no reprovisioner that can migrate it to another hub,
+// it's for transports that reconnect on their own.
+func (c *Client) fatalStateHandler(fn transport.ConnectionStateHandler) transport.ConnectionStateHandler {
+	return func(state transport.ConnectionState, err error) {
+		if fn != nil {
+			fn(state, err)
+		}
+		if state == transport.Disconnected && c.reprov == nil && isRefused(err) {
+			c.fail(fmt.Errorf("connection refused: %w", err))
+		}
+	}
+}
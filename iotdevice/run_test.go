@@ -0,0 +1,178 @@
+package iotdevice
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+	"github.com/amenzhinsky/golang-iothub/common/backoff"
+	"github.com/amenzhinsky/golang-iothub/iotdevice/transport"
+)
+
+// closeTransport can be closed and signals connections.
+type closeTransport struct {
+	connectTransport
+	connected chan struct{}
+}
+
+func (tr *closeTransport) Connect(ctx context.Context, creds transport.Credentials) error {
+	if err := tr.connectTransport.Connect(ctx, creds); err != nil {
+		return err
+	}
+	close(tr.connected)
+	return nil
+}
+
+func (tr *closeTransport) Close() error {
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range map[string]struct {
+		err   error
+		fatal bool
+	}{
+		"canceled": {},
+		"lost":     {err: errors.New("lost")},
+		"refused": {
+			err:   &transport.ConnectError{Reason: transport.ReasonNotAuthorized, Code: 5},
+			fatal: true,
+		},
+	} {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			tr := &closeTransport{connected: make(chan struct{})}
+			c, err := NewClient(
+				WithTransport(tr),
+				WithConnectionString("HostName=a.net;DeviceId=dev;SharedAccessKey=c2VjcmV0"),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			errc := make(chan error, 1)
+			go func() {
+				errc <- c.Run(ctx)
+			}()
+			<-tr.connected
+			if tc.err != nil {
+				c.fatalStateHandler(nil)(transport.Disconnected, tc.err)
+			}
+
+			select {
+			case err = <-errc:
+			case <-time.After(50 * time.Millisecond):
+				cancel()
+				err = <-errc
+			}
+			if tc.fatal != (err != nil) {
+				t.Fatalf("Run() = %v, want fatal = %t", err, tc.fatal)
+			}
+			if tc.fatal && !errors.Is(err, common.ErrUnauthorized) {
+				t.Errorf("Run() = %v, want ErrUnauthorized", err)
+			}
+			select {
+			case <-c.done:
+			default:
+				t.Error("client is not closed")
+			}
+		})
+	}
+}
+
+// lossCloseTransport leaves restoring lost connections to the client,
+// reconnects fail with err and are signaled when they succeed.
+type lossCloseTransport struct {
+	closeTransport
+	lost        func(err error)
+	err         error
+	reconnected chan struct{}
+}
+
+func (tr *lossCloseTransport) SetConnectionLossHandler(fn func(err error)) {
+	tr.lost = fn
+}
+
+func (tr *lossCloseTransport) Reconnect(ctx context.Context, creds transport.Credentials) error {
+	if tr.err != nil {
+		return tr.err
+	}
+	tr.reconnected <- struct{}{}
+	return nil
+}
+
+func TestRun_Reconnect(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range map[string]struct {
+		err   error
+		fatal bool
+	}{
+		"reconnected": {},
+		"refused": {
+			err:   &transport.ConnectError{Reason: transport.ReasonNotAuthorized, Code: 5},
+			fatal: true,
+		},
+	} {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			tr := &lossCloseTransport{
+				closeTransport: closeTransport{connected: make(chan struct{})},
+				err:            tc.err,
+				reconnected:    make(chan struct{}, 1),
+			}
+			c, err := NewClient(
+				WithTransport(tr),
+				WithConnectionString("HostName=a.net;DeviceId=dev;SharedAccessKey=c2VjcmV0"),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			errc := make(chan error, 1)
+			go func() {
+				errc <- c.Run(ctx, WithConnBackoff(backoff.Constant(0)))
+			}()
+			<-tr.connected
+			tr.lost(errors.New("lost"))
+
+			if !tc.fatal {
+				select {
+				case <-tr.reconnected:
+				case <-time.After(time.Second):
+					t.Fatal("not reconnected")
+				}
+				c.connMu.RLock()
+				err = c.connErr
+				c.connMu.RUnlock()
+				if err != nil {
+					t.Fatalf("connection error = %v, want nil", err)
+				}
+				cancel()
+			}
+			select {
+			case err = <-errc:
+			case <-time.After(time.Second):
+				t.Fatal("Run() is not returned")
+			}
+			if tc.fatal != (err != nil) {
+				t.Fatalf("Run() = %v, want fatal = %t", err, tc.fatal)
+			}
+			if tc.fatal && !errors.Is(err, common.ErrUnauthorized) {
+				t.Errorf("Run() = %v, want ErrUnauthorized", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+// Package simulator runs fleets of virtual devices for load-testing
+// hub-facing back-ends, every device runs a pluggable behavior that
+// sends telemetry, reports twin properties or handles methods.
+package simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/amenzhinsky/golang-iothub/iotdevice"
+	"github.com/amenzhinsky/golang-iothub/iotdevice/gateway"
+	"github.com/amenzhinsky/golang-iothub/iotdevice/pool"
+	"github.com/amenzhinsky/golang-iothub/iotdevice/transport"
+	"github.com/amenzhinsky/golang-iothub/iotservice"
+)
+
+// Device is a virtual device.
+type Device struct {
+	ID     string
+	Index  int // position in the fleet
+	Client *iotdevice.Client
+}
+
+// Behavior is what a virtual device does, it should return when ctx is done.
+type Behavior func(ctx context.Context, d *Device) error
+
+// Telemetry returns a behavior that sends a message generated
+// by gen every interval, gen may return nil to skip a tick.
+func Telemetry(interval time.Duration, gen func(d *Device) []byte, opts ...iotdevice.SendOption) Behavior {
+	if gen == nil {
+		panic("gen is nil")
+	}
+	return func(ctx context.Context, d *Device) error {
+		if interval <= 0 {
+			return errors.New("interval must be positive")
+		}
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			if b := gen(d); b != nil {
+				if err := d.Client.SendEvent(ctx, b, opts...); err != nil {
+					return err
+				}
+			}
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+// Combine runs the given behaviors concurrently,
+// it returns the first error and cancels the rest.
+func Combine(behaviors ...Behavior) Behavior {
+	return func(ctx context.Context, d *Device) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		errc := make(chan error, len(behaviors))
+		for _, b := range behaviors {
+			go func(b Behavior) {
+				errc <- b(ctx, d)
+			}(b)
+		}
+		var err error
+		for range behaviors {
+			if e := <-errc; e != nil && err == nil {
+				err = e
+				cancel()
+			}
+		}
+		return err
+	}
+}
+
+// DeviceIDs generates n device ids with the given prefix,
+// e.g. sim-0000, sim-0001, etc., for use with DPS group keys.
+func DeviceIDs(prefix string, n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("%s%04d", prefix, i)
+	}
+	return ids
+}
+
+// RegistryCredentials returns credentials of devices listed or exported
+// from the registry, only devices with symmetric keys are supported.
+func RegistryCredentials(c *iotservice.Client, devices []*iotservice.Device) gateway.CredentialsFunc {
+	m := make(map[string]*iotservice.Device, len(devices))
+	for _, d := range devices {
+		m[d.DeviceID] = d
+	}
+	return func(ctx context.Context, deviceID string) (transport.Credentials, error) {
+		d, ok := m[deviceID]
+		if !ok {
+			return nil, fmt.Errorf("device %q is not in the registry", deviceID)
+		}
+		cs, err := c.DeviceConnectionString(d, false)
+		if err != nil {
+			return nil, err
+		}
+		return iotdevice.NewSASCredentials(cs)
+	}
+}
+
+// ClientFunc returns a function that creates connected clients
+// authenticated with creds using a new transport for each of them.
+func ClientFunc(
+	creds gateway.CredentialsFunc,
+	newTransport func() transport.Transport,
+	opts ...iotdevice.ClientOption,
+) pool.NewClientFunc {
+	if creds == nil {
+		panic("creds is nil")
+	}
+	if newTransport == nil {
+		panic("newTransport is nil")
+	}
+	return func(ctx context.Context, deviceID string) (*iotdevice.Client, error) {
+		cr, err := creds(ctx, deviceID)
+		if err != nil {
+			return nil, err
+		}
+		c, err := iotdevice.NewClient(append([]iotdevice.ClientOption{
+			iotdevice.WithCredentials(cr),
+			iotdevice.WithTransport(newTransport()),
+		}, opts...)...)
+		if err != nil {
+			return nil, err
+		}
+		if err = c.Connect(ctx); err != nil {
+			c.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+}
+
+// ErrorHandler is called when a device fails to connect or its behavior fails.
+type ErrorHandler func(deviceID string, err error)
+
+// Option is a simulator configuration option.
+type Option func(s *Simulator)
+
+// WithRampUp spreads devices start evenly over the given duration
+// instead of connecting all of them at once.
+func WithRampUp(d time.Duration) Option {
+	return func(s *Simulator) {
+		s.rampUp = d
+	}
+}
+
+// WithErrorHandler sets the device errors handler.
+func WithErrorHandler(fn ErrorHandler) Option {
+	return func(s *Simulator) {
+		s.onError = fn
+	}
+}
+
+// WithPoolOptions sets options of the underlying clients pool.
+func WithPoolOptions(opts ...pool.Option) Option {
+	return func(s *Simulator) {
+		s.poolOpts = append(s.poolOpts, opts...)
+	}
+}
+
+// Stats is a simulation run summary.
+type Stats struct {
+	Started int // devices that connected and started their behaviors
+	Failed  int // devices that failed to connect or their behaviors failed
+}
+
+// Simulator runs a behavior on every device of a fleet.
+type Simulator struct {
+	ids       []string
+	newClient pool.NewClientFunc
+	behavior  Behavior
+	rampUp    time.Duration
+	onError   ErrorHandler
+	poolOpts  []pool.Option
+}
+
+// New creates a simulator of the given devices, see ClientFunc.
+func New(deviceIDs []string, newClient pool.NewClientFunc, behavior Behavior, opts ...Option) *Simulator {
+	if newClient == nil {
+		panic("newClient is nil")
+	}
+	if behavior == nil {
+		panic("behavior is nil")
+	}
+	s := &Simulator{ids: deviceIDs, newClient: newClient, behavior: behavior}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Run starts all devices and blocks until ctx is done and all
+// behaviors return, then it disconnects devices and returns stats.
+func (s *Simulator) Run(ctx context.Context) (*Stats, error) {
+	if len(s.ids) == 0 {
+		return nil, errors.New("no devices to simulate")
+	}
+	p := pool.New(s.newClient, s.poolOpts...)
+	defer p.Close()
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		stats Stats
+		step  = s.rampUp / time.Duration(len(s.ids))
+	)
+	fail := func(id string, err error) {
+		mu.Lock()
+		stats.Failed++
+		mu.Unlock()
+		if s.onError != nil {
+			s.onError(id, err)
+		}
+	}
+
+	for i, id := range s.ids {
+		if i != 0 && step > 0 {
+			select {
+			case <-time.After(step):
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			c, err := p.Get(ctx, id)
+			if err != nil {
+				fail(id, err)
+				return
+			}
+			mu.Lock()
+			stats.Started++
+			mu.Unlock()
+			if err = s.behavior(ctx, &Device{ID: id, Index: i, Client: c}); err != nil {
+				fail(id, err)
+			}
+		}(i, id)
+	}
+	wg.Wait()
+	return &stats, nil
+}
@@ -0,0 +1,90 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/amenzhinsky/golang-iothub/iotdevice/gateway"
+	"github.com/amenzhinsky/golang-iothub/iotdevice/transport"
+)
+
+func TestDeviceIDs(t *testing.T) {
+	t.Parallel()
+
+	if g, w := DeviceIDs("sim-", 2), []string{"sim-0000", "sim-0001"}; !reflect.DeepEqual(g, w) {
+		t.Errorf("DeviceIDs() = %v, want %v", g, w)
+	}
+}
+
+func TestTelemetry_Interval(t *testing.T) {
+	t.Parallel()
+
+	b := Telemetry(0, func(d *Device) []byte { return nil })
+	if err := b(context.Background(), &Device{}); err == nil {
+		t.Error("zero interval is accepted")
+	}
+}
+
+// nopTransport accepts connections and does nothing else.
+type nopTransport struct {
+	transport.Transport
+}
+
+func (tr *nopTransport) Connect(ctx context.Context, creds transport.Credentials) error {
+	return nil
+}
+
+func (tr *nopTransport) Close() error {
+	return nil
+}
+
+func TestSimulator(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu     sync.Mutex
+		seen   = map[string]int{}
+		failed []string
+	)
+	newClient := ClientFunc(
+		gateway.GroupKeyCredentials("test.azure-devices.net", "c2VjcmV0"),
+		func() transport.Transport { return &nopTransport{} },
+	)
+	s := New(DeviceIDs("sim-", 3), newClient, func(ctx context.Context, d *Device) error {
+		if d.Client.DeviceID() != d.ID {
+			t.Errorf("client device id = %q, want %q", d.Client.DeviceID(), d.ID)
+		}
+		mu.Lock()
+		seen[d.ID] = d.Index
+		mu.Unlock()
+		if d.Index == 2 {
+			return errors.New("boom")
+		}
+		<-ctx.Done()
+		return nil
+	}, WithErrorHandler(func(id string, err error) {
+		mu.Lock()
+		failed = append(failed, id)
+		mu.Unlock()
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	stats, err := s.Run(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := (Stats{Started: 3, Failed: 1}); *stats != w {
+		t.Errorf("stats = %+v, want %+v", *stats, w)
+	}
+	if w := map[string]int{"sim-0000": 0, "sim-0001": 1, "sim-0002": 2}; !reflect.DeepEqual(seen, w) {
+		t.Errorf("seen = %v, want %v", seen, w)
+	}
+	if w := []string{"sim-0002"}; !reflect.DeepEqual(failed, w) {
+		t.Errorf("failed = %v, want %v", failed, w)
+	}
+}
@@ -0,0 +1,91 @@
+package iotdevice
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrSendingSuspended is returned by SendEvent when sending is suspended.
+var ErrSendingSuspended = errors.New("sending is suspended")
+
+// SuspendOption is a SuspendSending configuration option.
+type SuspendOption func(c *Client) error
+
+// WithSuspendBuffering makes SendEvent put messages into the send queue
+// enabled by WithSendQueue with the given priority instead of failing
+// with ErrSendingSuspended, they are sent when sending is resumed.
+func WithSuspendBuffering(prio Priority) SuspendOption {
+	return func(c *Client) error {
+		if c.queue == nil {
+			return errors.New("send queue is not enabled")
+		}
+		if prio < PriorityLow || prio > PriorityHigh {
+			return errors.New("invalid priority")
+		}
+		c.suspBuf = &prio
+		return nil
+	}
+}
+
+// SuspendSending makes SendEvent fail with ErrSendingSuspended until
+// ResumeSending is called, e.g. during hub maintenance windows.
+// Messages put into the send queue are kept until sending is resumed.
+//
+// Calling it again while suspended replaces the options.
+func (c *Client) SuspendSending(opts ...SuspendOption) error {
+	c.suspendMu.Lock()
+	defer c.suspendMu.Unlock()
+	buf := c.suspBuf
+	c.suspBuf = nil
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			c.suspBuf = buf
+			return err
+		}
+	}
+	if c.resume == nil {
+		c.resume = make(chan struct{})
+	}
+	return nil
+}
+
+// ResumeSending resumes sending suspended with SuspendSending.
+func (c *Client) ResumeSending() {
+	c.suspendMu.Lock()
+	if c.resume != nil {
+		close(c.resume)
+		c.resume = nil
+	}
+	c.suspBuf = nil
+	c.suspendMu.Unlock()
+}
+
+// IsSendingSuspended reports whether sending is suspended.
+func (c *Client) IsSendingSuspended() bool {
+	ok, _ := c.suspended()
+	return ok
+}
+
+// suspended reports whether sending is suspended and the priority
+// suspended sends are queued with, nil when they're rejected.
+func (c *Client) suspended() (bool, *Priority) {
+	c.suspendMu.Lock()
+	defer c.suspendMu.Unlock()
+	return c.resume != nil, c.suspBuf
+}
+
+// waitResumed blocks while sending is suspended.
+func (c *Client) waitResumed(ctx context.Context) error {
+	c.suspendMu.Lock()
+	ch := c.resume
+	c.suspendMu.Unlock()
+	if ch == nil {
+		return nil
+	}
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,70 @@
+package iotdevice
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSuspendSending(t *testing.T) {
+	t.Parallel()
+
+	c := &Client{}
+	if err := c.SuspendSending(); err != nil {
+		t.Fatal(err)
+	}
+	if !c.IsSendingSuspended() {
+		t.Fatal("sending is not suspended")
+	}
+	if err := c.SendEvent(context.Background(), []byte("x")); err != ErrSendingSuspended {
+		t.Fatalf("SendEvent() = %v, want %v", err, ErrSendingSuspended)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.waitResumed(context.Background())
+	}()
+	select {
+	case <-errc:
+		t.Fatal("waitResumed returned while suspended")
+	case <-time.After(50 * time.Millisecond):
+	}
+	c.ResumeSending()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if c.IsSendingSuspended() {
+		t.Fatal("sending is suspended after ResumeSending")
+	}
+}
+
+func TestSuspendSending_Buffering(t *testing.T) {
+	t.Parallel()
+
+	c := &Client{}
+	if err := c.SuspendSending(WithSuspendBuffering(PriorityLow)); err == nil {
+		t.Fatal("expected an error without a send queue")
+	}
+	if c.IsSendingSuspended() {
+		t.Fatal("sending is suspended after a failed SuspendSending")
+	}
+
+	c.queue = newSendQueue(2)
+	if err := c.SuspendSending(WithSuspendBuffering(PriorityHigh)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SendEvent(context.Background(), []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if n := c.QueueLen(); n != 1 {
+		t.Fatalf("QueueLen() = %d, want 1", n)
+	}
+
+	// suspending again without options turns buffering off
+	if err := c.SuspendSending(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SendEvent(context.Background(), []byte("x")); err != ErrSendingSuspended {
+		t.Fatalf("SendEvent() = %v, want %v", err, ErrSendingSuspended)
+	}
+}
@@ -0,0 +1,52 @@
+package transport
+
+import "time"
+
+// AuditEvent is a type of security-relevant event.
+type AuditEvent string
+
+const (
+	// AuditAuthAttempt is an authentication attempt, Err is its result.
+	AuditAuthAttempt AuditEvent = "auth-attempt"
+
+	// AuditTokenIssued is issuing of a SAS token, tokens are issued
+	// when connections are dialed, e.g. on reconnects with new credentials,
+	// they are not renewed on established connections.
+	AuditTokenIssued AuditEvent = "token-issued"
+
+	// AuditConnected is connection establishment including reconnects.
+	AuditConnected AuditEvent = "connected"
+
+	// AuditConnectionLost is loss of connection, Err is the reason.
+	AuditConnectionLost AuditEvent = "connection-lost"
+
+	// AuditMethodInvoked is a direct method invocation.
+	AuditMethodInvoked AuditEvent = "method-invoked"
+)
+
+// AuditRecord is a security-relevant event record.
+type AuditRecord struct {
+	Event    AuditEvent
+	Time     time.Time
+	DeviceID string
+	ModuleID string
+
+	// AuthMethod is either "sas" or "x509", set for authentication attempts.
+	AuthMethod string
+
+	// Method and StatusCode are the direct method name
+	// and its response status, set for method invocations.
+	Method     string
+	StatusCode int
+
+	Err error
+}
+
+// AuditHandler handles audit records, it's called
+// from different goroutines so it has to be safe for concurrent use.
+type AuditHandler func(r *AuditRecord)
+
+// Auditor is implemented by transports that report security-relevant events.
+type Auditor interface {
+	SetAuditHandler(fn AuditHandler)
+}
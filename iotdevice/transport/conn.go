@@ -0,0 +1,160 @@
+package transport
+
+import (
+	"fmt"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+)
+
+// ConnectReason is a reason of a connection rejection or loss.
+type ConnectReason int
+
+const (
+	// ReasonUnknown is a reason that transports cannot tell.
+	ReasonUnknown ConnectReason = iota
+
+	// ReasonBadProtocolVersion means the hub doesn't support the protocol.
+	ReasonBadProtocolVersion
+
+	// ReasonIdentifierRejected means the client id is invalid.
+	ReasonIdentifierRejected
+
+	// ReasonServerUnavailable means the hub is down or too busy.
+	ReasonServerUnavailable
+
+	// ReasonBadCredentials means the token is malformed or expired,
+	// usually it's fixed by issuing a new one.
+	ReasonBadCredentials
+
+	// ReasonNotAuthorized means the identity is unknown, disabled
+	// or its credentials don't match the registry.
+	ReasonNotAuthorized
+
+	// ReasonBanned means the client is banned by the broker,
+	// it's reported only by MQTT 5 brokers.
+	ReasonBanned
+
+	// ReasonNetworkError is a network failure, e.g. a connection reset.
+	ReasonNetworkError
+
+	// ReasonProtocolViolation means the hub received a malformed packet.
+	ReasonProtocolViolation
+
+	// ReasonThrottled means the hub refuses connections
+	// because a quota or the connection rate is exceeded.
+	ReasonThrottled
+)
+
+var reasonNames = map[ConnectReason]string{
+	ReasonUnknown:            "unknown",
+	ReasonBadProtocolVersion: "bad protocol version",
+	ReasonIdentifierRejected: "identifier rejected",
+	ReasonServerUnavailable:  "server unavailable",
+	ReasonBadCredentials:     "bad credentials",
+	ReasonNotAuthorized:      "not authorized",
+	ReasonBanned:             "banned",
+	ReasonNetworkError:       "network error",
+	ReasonProtocolViolation:  "protocol violation",
+	ReasonThrottled:          "throttled",
+}
+
+func (r ConnectReason) String() string {
+	if s, ok := reasonNames[r]; ok {
+		return s
+	}
+	return fmt.Sprintf("reason(%d)", int(r))
+}
+
+// ConnackReason maps an MQTT CONNACK return code or an MQTT 5 reason code to its reason.
+func ConnackReason(code byte) ConnectReason {
+	switch code {
+	case 0x01, 0x84:
+		return ReasonBadProtocolVersion
+	case 0x02, 0x85:
+		return ReasonIdentifierRejected
+	case 0x03, 0x88, 0x89:
+		return ReasonServerUnavailable
+	case 0x97, 0x9F:
+		return ReasonThrottled
+	case 0x04, 0x86:
+		return ReasonBadCredentials
+	case 0x05, 0x87:
+		return ReasonNotAuthorized
+	case 0x8A:
+		return ReasonBanned
+	case 0xFE:
+		return ReasonNetworkError
+	case 0xFF, 0x81, 0x82:
+		return ReasonProtocolViolation
+	default:
+		return ReasonUnknown
+	}
+}
+
+// ConnectError is a connection rejection or loss error.
+//
+// It matches common.ErrUnauthorized for credentials errors and
+// common.ErrThrottled when the hub is throttling with errors.Is,
+// so devices can tell when to rotate credentials from when to wait.
+type ConnectError struct {
+	Reason ConnectReason
+	Code   int // protocol-specific code, -1 when there's none
+	Err    error
+}
+
+func (e *ConnectError) Error() string {
+	if e.Err == nil {
+		return "connection error: " + e.Reason.String()
+	}
+	return fmt.Sprintf("connection error: %s: %s", e.Reason, e.Err)
+}
+
+func (e *ConnectError) Unwrap() error {
+	return e.Err
+}
+
+// Is implements errors.Is interface.
+func (e *ConnectError) Is(target error) bool {
+	switch target {
+	case common.ErrUnauthorized:
+		return e.Reason == ReasonBadCredentials || e.Reason == ReasonNotAuthorized
+	case common.ErrThrottled:
+		return e.Reason == ReasonThrottled
+	default:
+		return false
+	}
+}
+
+// ConnectionState is the state of the transport connection.
+type ConnectionState int
+
+const (
+	Disconnected ConnectionState = iota
+	Connected
+)
+
+func (s ConnectionState) String() string {
+	if s == Connected {
+		return "connected"
+	}
+	return "disconnected"
+}
+
+// ConnectionStateHandler handles connection state changes,
+// err is a *ConnectError for disconnections and nil otherwise.
+type ConnectionStateHandler func(state ConnectionState, err error)
+
+// ConnectionStateNotifier is implemented by transports
+// that report connection state changes.
+type ConnectionStateNotifier interface {
+	SetConnectionStateHandler(fn ConnectionStateHandler)
+}
+
+// ConnectionLossNotifier is implemented by transports that can leave
+// restoring lost connections to the caller, e.g. to fail over to another
+// hub, instead of reconnecting on their own.
+type ConnectionLossNotifier interface {
+	// SetConnectionLossHandler disables automatic reconnects of the next
+	// connections, fn is called when an established connection is lost.
+	SetConnectionLossHandler(fn func(err error))
+}
@@ -0,0 +1,39 @@
+package transport
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+)
+
+func TestConnectError(t *testing.T) {
+	t.Parallel()
+
+	for code, tc := range map[byte]struct {
+		reason       ConnectReason
+		unauthorized bool
+		throttled    bool
+	}{
+		0x03: {reason: ReasonServerUnavailable},
+		0x97: {reason: ReasonThrottled, throttled: true},
+		0x9F: {reason: ReasonThrottled, throttled: true},
+		0x04: {reason: ReasonBadCredentials, unauthorized: true},
+		0x05: {reason: ReasonNotAuthorized, unauthorized: true},
+		0x8A: {reason: ReasonBanned},
+		0xFE: {reason: ReasonNetworkError},
+		0x42: {reason: ReasonUnknown},
+	} {
+		err := error(&ConnectError{Reason: ConnackReason(code), Code: int(code)})
+		var e *ConnectError
+		if !errors.As(err, &e) || e.Reason != tc.reason {
+			t.Errorf("ConnackReason(%#x) = %s, want %s", code, e.Reason, tc.reason)
+		}
+		if g := errors.Is(err, common.ErrUnauthorized); g != tc.unauthorized {
+			t.Errorf("%#x: errors.Is(ErrUnauthorized) = %t, want %t", code, g, tc.unauthorized)
+		}
+		if g := errors.Is(err, common.ErrThrottled); g != tc.throttled {
+			t.Errorf("%#x: errors.Is(ErrThrottled) = %t, want %t", code, g, tc.throttled)
+		}
+	}
+}
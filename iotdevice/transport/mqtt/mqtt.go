@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
@@ -13,12 +14,18 @@ import (
 	"time"
 
 	"github.com/amenzhinsky/golang-iothub/common"
+	"github.com/amenzhinsky/golang-iothub/common/backoff"
 	"github.com/amenzhinsky/golang-iothub/iotdevice/transport"
+	"github.com/amenzhinsky/golang-iothub/iotutil"
 	"github.com/eclipse/paho.mqtt.golang"
 )
 
 const defaultQoS = 1
 
+// defaultThrottleDelay is the retry-after hint of throttling errors,
+// MQTT 3.1.1 has no way for the hub to tell it explicitly.
+const defaultThrottleDelay = 10 * time.Second
+
 // TransportOption is a transport configuration option.
 type TransportOption func(tr *Transport)
 
@@ -28,13 +35,98 @@ func WithLogger(l *log.Logger) TransportOption {
 	}
 }
 
+// WithThrottleDelay sets the minimum delay before retrying
+// a connection or a request rejected by the hub due to throttling.
+func WithThrottleDelay(d time.Duration) TransportOption {
+	return func(tr *Transport) {
+		tr.throttle = d
+	}
+}
+
+// WithMaxInFlightRequests limits the number of concurrent twin requests to n,
+// up to queue more requests wait for a free slot and the rest fail with
+// ErrTooManyRequests, default is 64 in-flight and 1024 queued requests.
+func WithMaxInFlightRequests(n, queue int) TransportOption {
+	if n < 1 || queue < 0 {
+		panic("invalid in-flight requests limit")
+	}
+	return func(tr *Transport) {
+		tr.maxInFlight = n
+		tr.maxQueued = int32(queue)
+	}
+}
+
+// WithRequestTimeout sets how long twin requests wait for responses, default is 30s.
+func WithRequestTimeout(d time.Duration) TransportOption {
+	return func(tr *Transport) {
+		tr.reqTimeout = d
+	}
+}
+
+// WithMethodResponseTimeout sets how long publishing of a direct method
+// response is retried, default is 30s that is the hub's default method timeout,
+// zero means no limit.
+func WithMethodResponseTimeout(d time.Duration) TransportOption {
+	return func(tr *Transport) {
+		tr.respTimeout = d
+	}
+}
+
+// WithMethodResponseRetry sets the delay policy and the maximum number of
+// attempts to publish direct method responses, zero attempts means retrying
+// until the response timeout and one disables retries, default policy is
+// backoff.Default.
+func WithMethodResponseRetry(p backoff.Policy, attempts int) TransportOption {
+	if p == nil {
+		panic("p is nil")
+	}
+	if attempts < 0 {
+		panic("attempts is negative")
+	}
+	return func(tr *Transport) {
+		tr.respRetry = p
+		tr.respAttempts = attempts
+	}
+}
+
+// DeadLetterHandler receives raw messages that cannot be parsed,
+// err tells why, so applications can log or recover their data.
+type DeadLetterHandler func(topic string, payload []byte, err error)
+
+// WithDeadLetterHandler sets the handler of cloud-to-device and module input
+// messages with malformed topics, by default they're logged and dropped.
+func WithDeadLetterHandler(fn DeadLetterHandler) TransportOption {
+	if fn == nil {
+		panic("fn is nil")
+	}
+	return func(tr *Transport) {
+		tr.deadLetter = fn
+	}
+}
+
+// ErrTooManyRequests is returned when both in-flight
+// twin requests limit and its queue are exhausted.
+var ErrTooManyRequests = errors.New("too many in-flight requests")
+
+var errNotConnected = errors.New("not connected")
+
 // New returns new Transport transport.
 // See more: https://docs.microsoft.com/en-us/azure/iot-hub/iot-hub-mqtt-support
 func New(opts ...TransportOption) transport.Transport {
-	tr := &Transport{done: make(chan struct{})}
+	tr := &Transport{
+		rid:         requestID{epoch: uint32(time.Now().UnixNano())},
+		done:        make(chan struct{}),
+		throttle:    defaultThrottleDelay,
+		maxInFlight: 64,
+		maxQueued:   1024,
+		reqTimeout:  30 * time.Second,
+		respTimeout: 30 * time.Second,
+		respRetry:   backoff.Default,
+	}
 	for _, opt := range opts {
 		opt(tr)
 	}
+	tr.inFlight = make(chan struct{}, tr.maxInFlight)
 	return tr
 }
 
@@ -42,13 +134,36 @@ type Transport struct {
 	mu   sync.RWMutex
 	conn mqtt.Client
 
-	did string // device id
-	rid uint32 // request id, incremented each request
+	ua  string    // user agent
+	did string    // device id
+	mid string    // module id, empty for devices
+	rid requestID // last request id
+
+	done chan struct{}            // closed when the transport is closed
+	resp map[requestID]chan *resp // responses from iothub
+
+	twinRes mqtt.MessageHandler // twin responses handler, nil until subscribed
+
+	subMu sync.Mutex // serializes subscribing
+	subs  map[string]*subscription
 
-	done chan struct{}         // closed when the transport is closed
-	resp map[uint32]chan *resp // responses from iothub
+	inFlight    chan struct{} // twin requests semaphore
+	queued      int32         // number of requests waiting for inFlight
+	maxInFlight int
+	maxQueued   int32
+	reqTimeout  time.Duration
 
-	logger *log.Logger
+	logger   *log.Logger
+	audit    transport.AuditHandler // nil when auditing is disabled
+	throttle time.Duration          // retry-after hint of throttling errors
+	onState  transport.ConnectionStateHandler
+	onLoss   func(err error) // not nil when the caller reconnects
+
+	deadLetter DeadLetterHandler // nil when malformed messages are dropped
+
+	respTimeout  time.Duration  // of direct method responses
+	respRetry    backoff.Policy // direct method responses retry policy
+	respAttempts int            // zero means until respTimeout
 }
 
 type resp struct {
@@ -60,68 +175,372 @@ type resp struct {
 
 func (tr *Transport) logf(format string, v ...interface{}) {
 	if tr.logger != nil {
-		tr.logger.Printf(format, v...)
+		tr.logger.Print(iotutil.Redact(fmt.Sprintf(format, v...)))
 	}
 }
 
+// SetConnectionStateHandler sets the handler of connection state changes,
+// it takes effect on the next Connect call.
+func (tr *Transport) SetConnectionStateHandler(fn transport.ConnectionStateHandler) {
+	tr.mu.Lock()
+	tr.onState = fn
+	tr.mu.Unlock()
+}
+
+// SetConnectionLossHandler makes the next connections leave reconnecting
+// to the caller, fn is called when an established connection is lost.
+func (tr *Transport) SetConnectionLossHandler(fn func(err error)) {
+	tr.mu.Lock()
+	tr.onLoss = fn
+	tr.mu.Unlock()
+}
+
+// SetAuditHandler sets the handler of security-relevant events.
+func (tr *Transport) SetAuditHandler(fn transport.AuditHandler) {
+	tr.mu.Lock()
+	tr.audit = fn
+	tr.mu.Unlock()
+}
+
+// auditf reports an audit record of the given identity.
+func auditf(fn transport.AuditHandler, did, mid string, r *transport.AuditRecord) {
+	if fn == nil {
+		return
+	}
+	r.Time = time.Now()
+	r.DeviceID, r.ModuleID = did, mid
+	fn(r)
+}
+
+// ProtocolName returns "mqtt".
+func (tr *Transport) ProtocolName() string {
+	return "mqtt"
+}
+
+// SetUserAgent sets the user agent reported in the MQTT username.
+func (tr *Transport) SetUserAgent(ua string) {
+	tr.mu.Lock()
+	tr.ua = ua
+	tr.mu.Unlock()
+}
+
 func (tr *Transport) Connect(ctx context.Context, creds transport.Credentials) error {
 	tr.mu.Lock()
 	defer tr.mu.Unlock()
 	if tr.conn != nil {
 		return errors.New("already connected")
 	}
+	c, mid, err := tr.dial(ctx, creds)
+	if err != nil {
+		return err
+	}
+	tr.did = creds.DeviceID()
+	tr.mid = mid
+	tr.conn = c
+	return nil
+}
+
+// Reconnect disconnects and connects again with the given credentials,
+// subscriptions are restored on the new connection. The connection is
+// closed before dialing so the hub doesn't see two clients with the same
+// id and drop one of them, which would trigger automatic reconnects.
+//
+// When it fails the transport stays disconnected and operations fail
+// until a subsequent Reconnect succeeds, it's also how connections lost
+// with a connection loss handler set are restored.
+func (tr *Transport) Reconnect(ctx context.Context, creds transport.Credentials) error {
+	tr.subMu.Lock()
+	defer tr.subMu.Unlock()
+
+	tr.mu.Lock()
+	old := tr.conn
+	if tr.did == "" {
+		tr.mu.Unlock()
+		return errNotConnected
+	}
+	// topics of existing subscriptions contain the identity
+	if creds.DeviceID() != tr.did || transport.ModuleID(creds) != tr.mid {
+		tr.mu.Unlock()
+		return errors.New("cannot reconnect as another identity")
+	}
+	tr.conn = nil
+	tr.mu.Unlock()
+	if old != nil && old.IsConnected() {
+		old.Disconnect(250)
+	}
+
+	c, _, err := tr.dial(ctx, creds)
+	if err != nil {
+		return err
+	}
+	for topic, s := range tr.subs {
+		if err = contextToken(ctx, c.Subscribe(topic, defaultQoS, s.handler)); err != nil {
+			c.Disconnect(250)
+			return err
+		}
+	}
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if tr.twinRes != nil {
+		if err = contextToken(ctx, c.Subscribe(
+			"$iothub/twin/res/#", defaultQoS, tr.twinRes,
+		)); err != nil {
+			c.Disconnect(250)
+			return err
+		}
+	}
+	// the connection may have been lost while restoring subscriptions,
+	// its loss handler ignored that because it wasn't current yet
+	if !c.IsConnected() {
+		return errNotConnected
+	}
+	tr.conn = c
+	tr.rid = requestID{epoch: tr.rid.epoch + 1}
+	return nil
+}
 
+// dial connects to the hub returning the client and the module id.
+func (tr *Transport) dial(ctx context.Context, creds transport.Credentials) (mqtt.Client, string, error) {
 	o := mqtt.NewClientOptions()
 	o.SetTLSConfig(creds.TLSConfig())
 
+	// modules are identified as {device}/{module} and authenticated
+	// with tokens scoped to their identity, they can also connect
+	// to an edge hub instead of the iothub itself.
+	cid, uri, broker := creds.DeviceID(), creds.Hostname(), creds.Hostname()
+	mid := transport.ModuleID(creds)
+	if mid != "" {
+		cid = creds.DeviceID() + "/" + mid
+		uri = creds.Hostname() + "/devices/" + creds.DeviceID() + "/modules/" + mid
+		if gw := creds.(transport.ModuleCredentials).GatewayHostname(); gw != "" {
+			broker = gw
+		}
+	}
+
+	audit, did, method := tr.audit, creds.DeviceID(), "x509"
 	if creds.IsSAS() {
-		pwd, err := creds.Token(ctx, creds.Hostname(), time.Hour)
+		method = "sas"
+		pwd, err := creds.Token(ctx, uri, time.Hour)
 		if err != nil {
-			return err
+			return nil, "", err
 		}
+		auditf(audit, did, mid, &transport.AuditRecord{Event: transport.AuditTokenIssued})
 		o.SetPassword(pwd)
 	}
 
-	o.AddBroker("tls://" + creds.Hostname() + ":8883")
-	o.SetClientID(creds.DeviceID())
-	o.SetUsername(creds.Hostname() + "/" + creds.DeviceID() + "/api-version=" + common.APIVersion)
-	o.SetAutoReconnect(true)
+	// the broker is dialed by its hostname on every (re)connect with
+	// net.Dialer, so addresses are re-resolved after failovers and
+	// dual-stack hosts fall back from IPv6 to IPv4 and vice versa.
+	o.AddBroker("tls://" + broker + ":8883")
+	o.SetClientID(cid)
+	user := creds.Hostname() + "/" + cid + "/api-version=" + common.APIVersion
+	if tr.ua != "" {
+		user += "&DeviceClientType=" + url.QueryEscape(tr.ua)
+	}
+	o.SetUsername(user)
+	onState, onLoss := tr.onState, tr.onLoss
+	o.SetAutoReconnect(onLoss == nil)
 	o.SetOnConnectHandler(func(_ mqtt.Client) {
 		tr.logf("connection established")
+		auditf(audit, did, mid, &transport.AuditRecord{Event: transport.AuditConnected})
+		if onState != nil {
+			onState(transport.Connected, nil)
+		}
 	})
-	o.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+	o.SetConnectionLostHandler(func(c mqtt.Client, err error) {
+		tr.mu.RLock()
+		current := c == tr.conn
+		tr.mu.RUnlock()
+		if !current {
+			return // replaced by Reconnect
+		}
+
+		// MQTT 3.1.1 has no disconnect reason codes,
+		// the hub just drops the connection.
+		err = &transport.ConnectError{Reason: transport.ReasonNetworkError, Code: -1, Err: err}
 		tr.logf("connection lost: %v", err)
+		tr.nextEpoch()
+		if onState != nil {
+			onState(transport.Disconnected, err)
+		}
+		auditf(audit, did, mid, &transport.AuditRecord{
+			Event: transport.AuditConnectionLost,
+			Err:   err,
+		})
+		if onLoss != nil {
+			onLoss(err)
+		}
 	})
 
 	c := mqtt.NewClient(o)
-	if err := contextToken(ctx, c.Connect()); err != nil {
-		return err
+	t := c.Connect()
+	err := contextToken(ctx, t)
+	if err != nil && err != ctx.Err() {
+		err = &transport.ConnectError{
+			Reason: transport.ConnackReason(t.(*mqtt.ConnectToken).ReturnCode()),
+			Code:   int(t.(*mqtt.ConnectToken).ReturnCode()),
+			Err:    err,
+		}
+		if onState != nil {
+			onState(transport.Disconnected, err)
+		}
+	}
+	auditf(audit, did, mid, &transport.AuditRecord{
+		Event:      transport.AuditAuthAttempt,
+		AuthMethod: method,
+		Err:        err,
+	})
+	if err != nil {
+		return nil, "", tr.throttled(err)
 	}
+	return c, mid, nil
+}
 
-	tr.did = creds.DeviceID()
-	tr.conn = c
-	return nil
+// prefix is the identity topics prefix.
+func (tr *Transport) prefix() string {
+	if tr.mid != "" {
+		return "devices/" + tr.did + "/modules/" + tr.mid
+	}
+	return "devices/" + tr.did
+}
+
+// SubscribeInputs subscribes to edge module inputs.
+func (tr *Transport) SubscribeInputs(ctx context.Context, mux transport.MessageDispatcher) error {
+	if tr.mid == "" {
+		return errors.New("inputs are available only for modules")
+	}
+	prefix := tr.prefix() + "/inputs/"
+	return tr.subscribe(ctx, prefix+"#", mux, true, func(m mqtt.Message, muxes []interface{}) {
+		msg, err := parseInputMessage(prefix, m.Topic(), m.Payload())
+		if err != nil {
+			tr.reject(m, err)
+			return
+		}
+		for _, mux := range muxes {
+			mux.(transport.MessageDispatcher).Dispatch(msg)
+		}
+	})
+}
+
+// devices/{device}/modules/{module}/inputs/{input}/{properties}
+func parseInputMessage(prefix, s string, b []byte) (*common.Message, error) {
+	if !strings.HasPrefix(s, prefix) {
+		return nil, errors.New("malformed input topic")
+	}
+	s = s[len(prefix):]
+	i := strings.Index(s, "/")
+	if i < 1 {
+		return nil, errors.New("malformed input topic")
+	}
+	msg := &common.Message{Payload: b, Properties: map[string]string{}}
+	if err := common.DecodeMQTTProperties(msg, s[i+1:]); err != nil {
+		return nil, err
+	}
+	msg.InputName = s[:i]
+	return msg, nil
 }
 
 func (tr *Transport) SubscribeEvents(ctx context.Context, mux transport.MessageDispatcher) error {
-	return contextToken(ctx, tr.conn.Subscribe(
-		"devices/"+tr.did+"/messages/devicebound/#", defaultQoS, func(_ mqtt.Client, m mqtt.Message) {
-			msg, err := parseEventMessage(m)
-			if err != nil {
-				tr.logf("parse error: %s", err)
-				return
-			}
-			mux.Dispatch(msg)
-		},
-	))
+	topic := tr.prefix() + "/messages/devicebound/#"
+	return tr.subscribe(ctx, topic, mux, true, func(m mqtt.Message, muxes []interface{}) {
+		msg, err := parseEventMessage(m)
+		if err != nil {
+			tr.reject(m, err)
+			return
+		}
+		for _, mux := range muxes {
+			mux.(transport.MessageDispatcher).Dispatch(msg)
+		}
+	})
 }
 
 func (tr *Transport) SubscribeTwinUpdates(ctx context.Context, mux transport.TwinStateDispatcher) error {
-	return contextToken(ctx, tr.conn.Subscribe(
-		"$iothub/twin/PATCH/properties/desired/#", defaultQoS, func(_ mqtt.Client, m mqtt.Message) {
-			mux.Dispatch(m.Payload())
-		},
-	))
+	topic := "$iothub/twin/PATCH/properties/desired/#"
+	return tr.subscribe(ctx, topic, mux, true, func(m mqtt.Message, muxes []interface{}) {
+		for _, mux := range muxes {
+			mux.(transport.TwinStateDispatcher).Dispatch(m.Payload())
+		}
+	})
+}
+
+// subscription is a topic subscription shared by its dispatchers.
+type subscription struct {
+	mu      sync.RWMutex
+	muxes   []interface{}
+	handler mqtt.MessageHandler // to resubscribe on reconnects
+}
+
+func (s *subscription) dispatchers() []interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.muxes
+}
+
+// subscribe subscribes to the topic only once, repeated calls with the
+// same dispatcher are no-ops and other dispatchers are added to the
+// existing subscription when merge is true, otherwise it's an error.
+// fn is called for every message with the current dispatchers list.
+func (tr *Transport) subscribe(
+	ctx context.Context,
+	topic string,
+	mux interface{},
+	merge bool,
+	fn func(m mqtt.Message, muxes []interface{}),
+) error {
+	tr.subMu.Lock()
+	defer tr.subMu.Unlock()
+	if s, ok := tr.subs[topic]; ok {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, d := range s.muxes {
+			if d == mux {
+				return nil
+			}
+		}
+		if !merge {
+			return fmt.Errorf("%s is already subscribed by another dispatcher", topic)
+		}
+		s.muxes = append(s.muxes[:len(s.muxes):len(s.muxes)], mux)
+		return nil
+	}
+
+	s := &subscription{muxes: []interface{}{mux}}
+	s.handler = func(_ mqtt.Client, m mqtt.Message) {
+		fn(m, s.dispatchers())
+	}
+	tr.mu.RLock()
+	conn := tr.conn
+	tr.mu.RUnlock()
+	if conn == nil {
+		return errNotConnected
+	}
+	if err := contextToken(ctx, conn.Subscribe(topic, defaultQoS, s.handler)); err != nil {
+		return err
+	}
+	if tr.subs == nil {
+		tr.subs = map[string]*subscription{}
+	}
+	tr.subs[topic] = s
+	return nil
+}
+
+// reject passes a message that cannot be parsed to the dead-letter handler.
+func (tr *Transport) reject(m mqtt.Message, err error) {
+	tr.logf("parse error: %s", err)
+	if tr.deadLetter != nil {
+		tr.deadLetter(m.Topic(), m.Payload(), err)
+	}
+}
+
+// throttled attaches a retry-after hint to connection refusals
+// that the hub uses for throttling, so callers back off.
+func (tr *Transport) throttled(err error) error {
+	if !errors.Is(err, common.ErrThrottled) {
+		return err
+	}
+	tr.logf("connection throttled, retrying in at least %s", tr.throttle)
+	return backoff.RetryAfter(err, tr.throttle)
 }
 
 // mqtt library wraps errors with fmt.Errorf.
@@ -129,6 +548,10 @@ func (tr *Transport) IsNetworkError(err error) bool {
 	if err == nil {
 		return false
 	}
+	var e *transport.ConnectError
+	if errors.As(err, &e) {
+		return e.Reason == transport.ReasonNetworkError
+	}
 	return strings.Contains(err.Error(), "Network Error")
 }
 
@@ -137,81 +560,65 @@ func parseEventMessage(m mqtt.Message) (*common.Message, error) {
 	if err != nil {
 		return nil, err
 	}
-	e := &common.Message{
-		Payload:    m.Payload(),
-		Properties: make(map[string]string, len(p)),
-	}
-	for k, v := range p {
-		switch k {
-		case "$.mid":
-			e.MessageID = v
-		case "$.cid":
-			e.CorrelationID = v
-		case "$.uid":
-			e.UserID = v
-		case "$.to":
-			e.To = v
-		case "$.exp":
-			t, err := time.Parse(time.RFC3339, v)
-			if err != nil {
-				return nil, err
-			}
-			e.ExpiryTime = &t
-		default:
-			e.Properties[k] = v
-		}
+	msg := &common.Message{Payload: m.Payload(), Properties: map[string]string{}}
+	if err = common.DecodeMQTTProperties(msg, p); err != nil {
+		return nil, err
 	}
-	return e, nil
+	return msg, nil
 }
 
+// parseCloudToDeviceTopic returns the property bag of a cloud-to-device topic:
 // devices/{device}/messages/devicebound/%24.to=%2Fdevices%2F{device}%2Fmessages%2FdeviceBound&a=b&b=c
-func parseCloudToDeviceTopic(s string) (map[string]string, error) {
-	s, err := url.QueryUnescape(s)
-	if err != nil {
-		return nil, err
-	}
-
-	// attributes prefixed with $.,
-	// e.g. `messageId` becomes `$.mid`, `to` becomes `$.to`, etc.
-	i := strings.Index(s, "$.")
+func parseCloudToDeviceTopic(s string) (string, error) {
+	const sep = "/messages/devicebound/"
+	i := strings.Index(s, sep)
 	if i == -1 {
-		return nil, errors.New("malformed cloud-to-device topic name")
+		return "", errors.New("malformed cloud-to-device topic name")
 	}
-	q, err := url.ParseQuery(s[i:])
-	if err != nil {
-		return nil, err
-	}
-
-	p := make(map[string]string, len(q))
-	for k, v := range q {
-		if len(v) != 1 {
-			return nil, fmt.Errorf("unexpected number of property values: %d", len(q))
-		}
-		p[k] = v[0]
-	}
-	return p, nil
+	return s[i+len(sep):], nil
 }
 
 func (tr *Transport) RegisterDirectMethods(ctx context.Context, mux transport.MethodDispatcher) error {
-	return contextToken(ctx, tr.conn.Subscribe(
-		"$iothub/methods/POST/#", defaultQoS, func(_ mqtt.Client, m mqtt.Message) {
-			method, rid, err := parseDirectMethodTopic(m.Topic())
-			if err != nil {
-				tr.logf("parse error: %s", err)
-				return
-			}
-			rc, b, err := mux.Dispatch(method, m.Payload())
-			if err != nil {
-				tr.logf("dispatch error: %s", err)
-				return
-			}
-			dst := fmt.Sprintf("$iothub/methods/res/%d/?$rid=%d", rc, rid)
-			if err = tr.send(ctx, dst, defaultQoS, b); err != nil {
+	// method calls are responded once, so dispatchers cannot be merged
+	return tr.subscribe(ctx, "$iothub/methods/POST/#", mux, false, func(m mqtt.Message, muxes []interface{}) {
+		method, rid, err := parseDirectMethodTopic(m.Topic())
+		if err != nil {
+			tr.logf("parse error: %s", err)
+			return
+		}
+		rc, b, err := muxes[0].(transport.MethodDispatcher).Dispatch(method, m.Payload())
+		if err != nil {
+			tr.logf("dispatch error: %s", err)
+			return
+		}
+		// handlers are called in order by the client's router, so waiting
+		// for the publication here would block delivery of its ack
+		dst := fmt.Sprintf("$iothub/methods/res/%d/?$rid=%d", rc, rid)
+		go func() {
+			if err := tr.respond(dst, b); err != nil {
 				tr.logf("method response error: %s", err)
-				return
 			}
-		},
-	))
+		}()
+	})
+}
+
+// respond publishes a direct method response retrying transient failures,
+// otherwise the service caller would wait until its own timeout.
+func (tr *Transport) respond(topic string, b []byte) error {
+	ctx, cancel := common.WithDone(context.Background(), tr.done)
+	defer cancel()
+	if tr.respTimeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, tr.respTimeout)
+		defer cancelTimeout()
+	}
+	return backoff.Retry(ctx, tr.respRetry, tr.respAttempts, func(attempt int) error {
+		err := tr.send(ctx, topic, defaultQoS, b)
+		if err != nil && ctx.Err() == nil {
+			tr.logf("method response error (attempt %d): %s", attempt, err)
+		}
+		return err
+	})
 }
 
 // returns method name and rid
@@ -245,7 +652,7 @@ func parseDirectMethodTopic(s string) (string, int, error) {
 }
 
 func (tr *Transport) RetrieveTwinProperties(ctx context.Context) ([]byte, error) {
-	r, err := tr.request(ctx, "$iothub/twin/GET/?$rid=%d", nil)
+	r, err := tr.request(ctx, "$iothub/twin/GET/?$rid=%s", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -253,7 +660,7 @@ func (tr *Transport) RetrieveTwinProperties(ctx context.Context) ([]byte, error)
 }
 
 func (tr *Transport) UpdateTwinProperties(ctx context.Context, b []byte) (int, error) {
-	r, err := tr.request(ctx, "$iothub/twin/PATCH/properties/reported/?$rid=%d", b)
+	r, err := tr.request(ctx, "$iothub/twin/PATCH/properties/reported/?$rid=%s", b)
 	if err != nil {
 		return 0, err
 	}
@@ -264,12 +671,17 @@ func (tr *Transport) request(ctx context.Context, topic string, b []byte) (*resp
 	if err := tr.enableTwinResponses(ctx); err != nil {
 		return nil, err
 	}
-	rid := atomic.AddUint32(&tr.rid, 1) // increment rid counter
-	dst := fmt.Sprintf(topic, rid)
+	if err := tr.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer func() { <-tr.inFlight }()
+
 	rch := make(chan *resp, 1)
 	tr.mu.Lock()
+	rid := tr.nextRID()
 	tr.resp[rid] = rch
 	tr.mu.Unlock()
+	dst := fmt.Sprintf(topic, rid)
 	defer func() {
 		tr.mu.Lock()
 		delete(tr.resp, rid)
@@ -280,19 +692,48 @@ func (tr *Transport) request(ctx context.Context, topic string, b []byte) (*resp
 		return nil, err
 	}
 
+	t := time.NewTimer(tr.reqTimeout)
+	defer t.Stop()
+
 	select {
 	case r := <-rch:
-		if r.code < 200 && r.code > 299 {
-			return nil, fmt.Errorf("request failed with %d response code", r.code)
+		if r.code < 200 || r.code > 299 {
+			err := fmt.Errorf("request failed with %d response code", r.code)
+			if r.code == http.StatusTooManyRequests {
+				return nil, backoff.RetryAfter(fmt.Errorf("%w: %w", common.ErrThrottled, err), tr.throttle)
+			}
+			return nil, err
 		}
 		return r, nil
-	case <-time.After(30 * time.Second):
-		return nil, errors.New("request timed out")
+	case <-t.C:
+		return nil, fmt.Errorf("request %s timed out: %w", rid, common.ErrTimeout)
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	}
 }
 
+// acquire takes an in-flight request slot waiting for it in the queue.
+func (tr *Transport) acquire(ctx context.Context) error {
+	select {
+	case tr.inFlight <- struct{}{}:
+		return nil
+	default:
+	}
+	if atomic.AddInt32(&tr.queued, 1) > tr.maxQueued {
+		atomic.AddInt32(&tr.queued, -1)
+		return ErrTooManyRequests
+	}
+	defer atomic.AddInt32(&tr.queued, -1)
+	select {
+	case tr.inFlight <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-tr.done:
+		return errors.New("transport is closed")
+	}
+}
+
 func (tr *Transport) enableTwinResponses(ctx context.Context) error {
 	tr.mu.Lock()
 	defer tr.mu.Unlock()
@@ -302,73 +743,122 @@ func (tr *Transport) enableTwinResponses(ctx context.Context) error {
 		return nil
 	}
 
-	if err := contextToken(ctx, tr.conn.Subscribe(
-		"$iothub/twin/res/#", defaultQoS, func(_ mqtt.Client, m mqtt.Message) {
-			rc, rid, ver, err := parseTwinPropsTopic(m.Topic())
-			if err != nil {
-				// TODO
-				fmt.Printf("error: %s", err)
-				return
-			}
+	h := func(_ mqtt.Client, m mqtt.Message) {
+		rc, rid, ver, err := parseTwinPropsTopic(m.Topic())
+		if err != nil {
+			tr.logf("parse error: %s", err)
+			return
+		}
 
-			tr.mu.RLock()
-			defer tr.mu.RUnlock()
-			for r, rch := range tr.resp {
-				if int(r) != rid {
-					continue
-				}
-				select {
-				case rch <- &resp{code: rc, ver: ver, body: m.Payload()}:
-				default:
-					// we cannot allow blocking here,
-					// buffered channel should solve it.
-					panic("response sending blocked")
-				}
-				return
-			}
-			tr.logf("unknown rid: %q", rid)
-		},
+		tr.mu.RLock()
+		rch, ok := tr.resp[rid]
+		tr.mu.RUnlock()
+		if !ok {
+			// the request has timed out or been canceled,
+			// or it's from a previous epoch
+			tr.logf("unknown rid: %s", rid)
+			return
+		}
+		select {
+		case rch <- &resp{code: rc, ver: ver, body: m.Payload()}:
+		default:
+			// channels are buffered for a single response,
+			// so it's a duplicate delivery of a QoS 1 message
+			tr.logf("duplicate response for rid: %s", rid)
+		}
+	}
+	if tr.conn == nil {
+		return errNotConnected
+	}
+	if err := contextToken(ctx, tr.conn.Subscribe(
+		"$iothub/twin/res/#", defaultQoS, h,
 	)); err != nil {
 		return err
 	}
 
-	tr.resp = make(map[uint32]chan *resp)
+	tr.twinRes = h
+	tr.resp = make(map[requestID]chan *resp)
 	return nil
 }
 
+// requestID is a twin request id, the epoch changes when the sequence
+// wraps around and when the connection is lost, so late responses to
+// requests that timed out are never matched with recycled ids.
+type requestID struct {
+	epoch uint32
+	seq   uint32
+}
+
+func (id requestID) String() string {
+	return strconv.FormatUint(uint64(id.epoch), 10) + "-" + strconv.FormatUint(uint64(id.seq), 10)
+}
+
+func parseRequestID(s string) (requestID, error) {
+	i := strings.IndexByte(s, '-')
+	if i == -1 {
+		return requestID{}, errors.New("epoch is missing")
+	}
+	epoch, err := strconv.ParseUint(s[:i], 10, 32)
+	if err != nil {
+		return requestID{}, err
+	}
+	seq, err := strconv.ParseUint(s[i+1:], 10, 32)
+	if err != nil {
+		return requestID{}, err
+	}
+	return requestID{epoch: uint32(epoch), seq: uint32(seq)}, nil
+}
+
+// nextRID returns a new request id, tr.mu has to be locked.
+func (tr *Transport) nextRID() requestID {
+	tr.rid.seq++
+	if tr.rid.seq == 0 {
+		tr.rid.epoch++
+		tr.rid.seq = 1
+	}
+	return tr.rid
+}
+
+// nextEpoch starts a new request ids epoch.
+func (tr *Transport) nextEpoch() {
+	tr.mu.Lock()
+	tr.rid = requestID{epoch: tr.rid.epoch + 1}
+	tr.mu.Unlock()
+}
+
 // parseTwinPropsTopic parses the given topic name into rc, rid and ver.
 // $iothub/twin/res/{rc}/?$rid={rid}(&$version={ver})?
-func parseTwinPropsTopic(s string) (int, int, int, error) {
+func parseTwinPropsTopic(s string) (int, requestID, int, error) {
 	const prefix = "$iothub/twin/res/"
 
 	u, err := url.Parse(s)
 	if err != nil {
-		return 0, 0, 0, err
+		return 0, requestID{}, 0, err
 	}
 
 	p := strings.Trim(u.Path, "/")
 	if !strings.HasPrefix(p, prefix) {
-		return 0, 0, 0, errors.New("malformed twin response topic")
+		return 0, requestID{}, 0, errors.New("malformed twin response topic")
 	}
 	rc, err := strconv.Atoi(p[len(prefix):])
 	if err != nil {
-		return 0, 0, 0, err
+		return 0, requestID{}, 0, err
 	}
 
 	q := u.Query()
 	if len(q["$rid"]) != 1 {
-		return 0, 0, 0, errors.New("$rid is not available")
+		return 0, requestID{}, 0, errors.New("$rid is not available")
 	}
-	rid, err := strconv.Atoi(q["$rid"][0])
+	rid, err := parseRequestID(q["$rid"][0])
 	if err != nil {
-		return 0, 0, 0, fmt.Errorf("$rid parse error: %s", err)
+		return 0, requestID{}, 0, fmt.Errorf("$rid parse error: %s", err)
 	}
 
 	var ver int // version is available only for update responses
 	if len(q["$version"]) == 1 {
 		ver, err = strconv.Atoi(q["$version"][0])
 		if err != nil {
-			return 0, 0, 0, err
+			return 0, requestID{}, 0, err
 		}
 	}
 	return rc, rid, ver, nil
@@ -378,27 +868,7 @@ func (tr *Transport) Send(ctx context.Context, msg *common.Message) error {
 	// this is just copying functionality from the nodejs sdk, but
 	// seems like adding meta attributes does nothing or in some cases,
 	// e.g. when $.exp is set the cloud just disconnects.
-	u := make(url.Values, len(msg.Properties)+5)
-	if msg.MessageID != "" {
-		u["$.mid"] = []string{msg.MessageID}
-	}
-	if msg.CorrelationID != "" {
-		u["$.cid"] = []string{msg.CorrelationID}
-	}
-	if msg.UserID != "" {
-		u["$.uid"] = []string{msg.UserID}
-	}
-	if msg.To != "" {
-		u["$.to"] = []string{msg.To}
-	}
-	if msg.ExpiryTime != nil && !msg.ExpiryTime.IsZero() {
-		u["$.exp"] = []string{msg.ExpiryTime.UTC().Format(time.RFC3339)}
-	}
-	for k, v := range msg.Properties {
-		u[k] = []string{v}
-	}
-
-	dst := "devices/" + tr.did + "/messages/events/" + u.Encode()
+	dst := tr.prefix() + "/messages/events/" + common.EncodeMQTTProperties(msg)
 	qos := defaultQoS
 	if q, ok := msg.TransportOptions["qos"]; ok {
 		qos = q.(int)
@@ -410,7 +880,7 @@ func (tr *Transport) send(ctx context.Context, topic string, qos int, b []byte)
 	tr.mu.RLock()
 	defer tr.mu.RUnlock()
 	if tr.conn == nil {
-		return errors.New("not connected")
+		return errNotConnected
 	}
 	return contextToken(ctx, tr.conn.Publish(topic, defaultQoS, false, b))
 }
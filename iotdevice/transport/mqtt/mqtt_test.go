@@ -1,26 +1,43 @@
 package mqtt
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"reflect"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+	"github.com/amenzhinsky/golang-iothub/common/backoff"
+	"github.com/amenzhinsky/golang-iothub/iotdevice/transport"
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/eclipse/paho.mqtt.golang/packets"
 )
 
-func TestParseCloudToDeviceTopic(t *testing.T) {
+func TestParseEventMessage(t *testing.T) {
 	t.Parallel()
 
-	s := "devices/mydev/messages/devicebound/%24.to=%2Fdevices%2Fmydev%2Fmessages%2FdeviceBound&a[]=b&b=c"
-	g, err := parseCloudToDeviceTopic(s)
+	s := "devices/mydev/messages/devicebound/%24.to=%2Fdevices%2Fmydev%2Fmessages%2FdeviceBound&a%5B%5D=b&b=c%26d%3De%2525"
+	p, err := parseCloudToDeviceTopic(s)
 	if err != nil {
 		t.Fatal(err)
 	}
+	msg := &common.Message{Properties: map[string]string{}}
+	if err = common.DecodeMQTTProperties(msg, p); err != nil {
+		t.Fatal(err)
+	}
 
 	w := map[string]string{
-		"$.to": "/devices/mydev/messages/deviceBound",
-		"a[]":  "b",
-		"b":    "c",
+		"a[]": "b",
+		"b":   "c&d=e%25",
+	}
+	if msg.To != "/devices/mydev/messages/deviceBound" || !reflect.DeepEqual(msg.Properties, w) {
+		t.Errorf("parsed %q into to = %q, properties = %v, want %v", s, msg.To, msg.Properties, w)
 	}
-	if !reflect.DeepEqual(g, w) {
-		t.Errorf("parseCloudToDeviceTopic(%q) = %v, _, want %v", s, g, w)
+	if _, err = parseCloudToDeviceTopic("devices/mydev/messages/events/a=b"); err == nil {
+		t.Error("non cloud-to-device topic is accepted")
 	}
 }
 
@@ -33,19 +50,313 @@ func TestParseDirectMethodTopic(t *testing.T) {
 		t.Fatal(err)
 	}
 	if m != "add" || r != 666 {
-		t.Errorf("parseDirectMethodTopic(%q) = %q, %q, want %q, %q", s, m, r, "add", 666)
+		t.Errorf("parseDirectMethodTopic(%q) = %q, %d, want %q, %d", s, m, r, "add", 666)
 	}
 }
 
 func TestParseTwinPropsTopic(t *testing.T) {
 	t.Parallel()
 
-	s := "$iothub/twin/res/200/?$rid=12&$version=4"
+	s := "$iothub/twin/res/200/?$rid=3-12&$version=4"
 	c, r, v, err := parseTwinPropsTopic(s)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if c != 200 || r != 12 || v != 4 {
-		t.Errorf("ParseTwinPropsTopic(%q) = %d, %q, %d, _, want %d, %q, %d, _", s, c, r, v, 200, 12, 4)
+	rid := requestID{epoch: 3, seq: 12}
+	if c != 200 || r != rid || v != 4 {
+		t.Errorf("ParseTwinPropsTopic(%q) = %d, %s, %d, _, want %d, %s, %d, _", s, c, r, v, 200, rid, 4)
+	}
+
+	// ids without epochs are never issued
+	if _, _, _, err = parseTwinPropsTopic("$iothub/twin/res/200/?$rid=12"); err == nil {
+		t.Error("rid without epoch is accepted")
+	}
+}
+
+func TestNextRID(t *testing.T) {
+	t.Parallel()
+
+	tr := New().(*Transport)
+	tr.rid = requestID{epoch: 7, seq: ^uint32(0) - 1}
+	var got []requestID
+	for i := 0; i < 2; i++ {
+		got = append(got, tr.nextRID())
+	}
+	tr.nextEpoch()
+	got = append(got, tr.nextRID())
+
+	want := []requestID{{7, ^uint32(0)}, {8, 1}, {9, 1}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("rids = %v, want %v", got, want)
+	}
+	for _, rid := range got {
+		if r, err := parseRequestID(rid.String()); err != nil || r != rid {
+			t.Errorf("parseRequestID(%q) = %v, %v, want %v", rid, r, err, rid)
+		}
+	}
+}
+
+func TestParseInputMessage(t *testing.T) {
+	t.Parallel()
+
+	const prefix = "devices/edge/modules/mod/inputs/"
+	s := prefix + "input1/%24.mid=1&%24.cdid=leaf&a=b"
+	msg, err := parseInputMessage(prefix, s, []byte("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.InputName != "input1" || msg.MessageID != "1" ||
+		msg.ConnectionDeviceID != "leaf" || msg.Properties["a"] != "b" {
+		t.Errorf("parseInputMessage(%q) = %+v", s, msg)
+	}
+	if _, err = parseInputMessage(prefix, prefix+"input1", nil); err == nil {
+		t.Error("topic without properties separator is accepted")
+	}
+}
+
+func TestThrottled(t *testing.T) {
+	t.Parallel()
+
+	tr := New(WithThrottleDelay(time.Minute)).(*Transport)
+	err := tr.throttled(&transport.ConnectError{
+		Reason: transport.ReasonThrottled,
+		Code:   0x9F,
+	})
+	if !errors.Is(err, common.ErrThrottled) {
+		t.Errorf("errors.Is(%v, ErrThrottled) = false", err)
+	}
+	if d, ok := backoff.RetryAfterHint(err); !ok || d != time.Minute {
+		t.Errorf("RetryAfterHint() = %s, %t, want %s, true", d, ok, time.Minute)
+	}
+
+	for _, code := range []byte{packets.ErrRefusedServerUnavailable, packets.ErrRefusedNotAuthorised} {
+		err = &transport.ConnectError{
+			Reason: transport.ConnackReason(code),
+			Code:   int(code),
+			Err:    packets.ConnErrors[code],
+		}
+		if g := tr.throttled(err); g != err {
+			t.Errorf("throttled(%v) = %v, want unchanged", err, g)
+		}
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	t.Parallel()
+
+	tr := New(WithMaxInFlightRequests(1, 1)).(*Transport)
+	ctx := context.Background()
+	if err := tr.acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second request is queued, the third one is rejected
+	errc := make(chan error, 1)
+	go func() {
+		errc <- tr.acquire(ctx)
+	}()
+	for atomic.LoadInt32(&tr.queued) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := tr.acquire(ctx); err != ErrTooManyRequests {
+		t.Fatalf("acquire() = %v, want %v", err, ErrTooManyRequests)
+	}
+
+	<-tr.inFlight
+	if err := <-errc; err != nil {
+		t.Fatalf("queued acquire() = %v", err)
+	}
+}
+
+// testMessage is an incoming mqtt message.
+type testMessage struct {
+	topic   string
+	payload []byte
+}
+
+func (m *testMessage) Duplicate() bool   { return false }
+func (m *testMessage) Qos() byte         { return defaultQoS }
+func (m *testMessage) Retained() bool    { return false }
+func (m *testMessage) Topic() string     { return m.topic }
+func (m *testMessage) MessageID() uint16 { return 1 }
+func (m *testMessage) Payload() []byte   { return m.payload }
+
+func TestWithDeadLetterHandler(t *testing.T) {
+	t.Parallel()
+
+	var topic, payload string
+	tr := New(WithDeadLetterHandler(func(t string, b []byte, err error) {
+		topic, payload = t, string(b)
+	})).(*Transport)
+
+	m := &testMessage{topic: "devices/mydev/messages/devicebound/%zz", payload: []byte("data")}
+	if _, err := parseEventMessage(m); err == nil {
+		t.Fatal("malformed topic is accepted")
+	} else {
+		tr.reject(m, err)
+	}
+	if topic != m.topic || payload != "data" {
+		t.Errorf("dead letter = %q %q, want %q %q", topic, payload, m.topic, "data")
+	}
+}
+
+func TestRespond(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range map[string]struct {
+		opts []TransportOption
+		max  time.Duration
+	}{
+		"no retry": {
+			opts: []TransportOption{WithMethodResponseRetry(backoff.Constant(time.Hour), 1)},
+			max:  time.Second,
+		},
+		"timeout": {
+			opts: []TransportOption{
+				WithMethodResponseRetry(backoff.Constant(time.Millisecond), 0),
+				WithMethodResponseTimeout(20 * time.Millisecond),
+			},
+			max: time.Second,
+		},
+	} {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			// not connected transports fail every attempt
+			tr := New(tc.opts...).(*Transport)
+			start := time.Now()
+			if err := tr.respond("$iothub/methods/res/200/?$rid=1", nil); err == nil {
+				t.Fatal("respond() = nil, want an error")
+			}
+			if d := time.Since(start); d > tc.max {
+				t.Errorf("respond() took %s, want less than %s", d, tc.max)
+			}
+		})
+	}
+}
+
+type testCredentials struct {
+	transport.Credentials
+	host string
+}
+
+func (c *testCredentials) DeviceID() string       { return "dev" }
+func (c *testCredentials) Hostname() string       { return c.host }
+func (c *testCredentials) TLSConfig() *tls.Config { return &tls.Config{ServerName: c.host} }
+func (c *testCredentials) IsSAS() bool            { return true }
+func (c *testCredentials) Token(ctx context.Context, uri string, d time.Duration) (string, error) {
+	return "token", nil
+}
+
+func TestReconnect(t *testing.T) {
+	t.Parallel()
+
+	tr := New().(*Transport)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	creds := &testCredentials{host: "127.0.0.1"}
+	if err := tr.Reconnect(ctx, creds); err != errNotConnected {
+		t.Fatalf("Reconnect() = %v, want %v", err, errNotConnected)
+	}
+
+	// nothing listens on the port, so the transport stays disconnected
+	tr.did = "dev"
+	if err := tr.Reconnect(ctx, creds); err == nil {
+		t.Fatal("Reconnect() = nil, want an error")
+	}
+	if err := tr.send(ctx, "devices/dev/messages/events/", 1, nil); err != errNotConnected {
+		t.Fatalf("send() = %v, want %v", err, errNotConnected)
+	}
+}
+
+// testClient records subscriptions and publications,
+// publications complete when ack is closed.
+type testClient struct {
+	mqtt.Client
+	subs map[string]mqtt.MessageHandler
+	pubs chan string
+	ack  chan struct{}
+}
+
+func (c *testClient) IsConnected() bool       { return true }
+func (c *testClient) Disconnect(quiesce uint) {}
+
+func (c *testClient) Subscribe(topic string, qos byte, fn mqtt.MessageHandler) mqtt.Token {
+	c.subs[topic] = fn
+	return &testToken{}
+}
+
+func (c *testClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.pubs <- topic
+	return &testToken{ack: c.ack}
+}
+
+// testToken completes when ack is closed or right away if it's nil.
+type testToken struct {
+	mqtt.Token
+	ack chan struct{}
+}
+
+func (t *testToken) Wait() bool   { return t.WaitTimeout(time.Hour) }
+func (t *testToken) Error() error { return nil }
+
+func (t *testToken) WaitTimeout(d time.Duration) bool {
+	if t.ack == nil {
+		return true
+	}
+	select {
+	case <-t.ack:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+type testMethodDispatcher struct{}
+
+func (testMethodDispatcher) Dispatch(method string, b []byte) (int, []byte, error) {
+	return 200, b, nil
+}
+
+func TestRegisterDirectMethods(t *testing.T) {
+	t.Parallel()
+
+	c := &testClient{
+		subs: map[string]mqtt.MessageHandler{},
+		pubs: make(chan string, 1),
+		ack:  make(chan struct{}),
+	}
+	tr := New().(*Transport)
+	tr.conn = c
+	defer tr.Close()
+	defer close(c.ack)
+	if err := tr.RegisterDirectMethods(context.Background(), testMethodDispatcher{}); err != nil {
+		t.Fatal(err)
+	}
+	fn := c.subs["$iothub/methods/POST/#"]
+	if fn == nil {
+		t.Fatal("methods are not subscribed")
+	}
+
+	// the handler returns before the response is acknowledged,
+	// otherwise it would block the router that delivers the ack
+	done := make(chan struct{})
+	go func() {
+		fn(c, &testMessage{topic: "$iothub/methods/POST/ping/?$rid=7", payload: []byte("{}")})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handler is blocked by the response")
+	}
+	select {
+	case topic := <-c.pubs:
+		if w := "$iothub/methods/res/200/?$rid=7"; topic != w {
+			t.Errorf("response topic = %q, want %q", topic, w)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("response is not published")
 	}
 }
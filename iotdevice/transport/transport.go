@@ -44,3 +44,44 @@ type Credentials interface {
 	IsSAS() bool
 	Token(ctx context.Context, uri string, d time.Duration) (string, error)
 }
+
+// ModuleCredentials is implemented by credentials of edge module identities.
+type ModuleCredentials interface {
+	Credentials
+	ModuleID() string
+
+	// GatewayHostname is the edge hub hostname,
+	// empty means connecting directly to the hub.
+	GatewayHostname() string
+}
+
+// ModuleID returns module id of the given credentials,
+// it's empty when they don't belong to a module.
+func ModuleID(creds Credentials) string {
+	if mc, ok := creds.(ModuleCredentials); ok {
+		return mc.ModuleID()
+	}
+	return ""
+}
+
+// ModuleTransport is implemented by transports that support edge module inputs.
+type ModuleTransport interface {
+	SubscribeInputs(ctx context.Context, mux MessageDispatcher) error
+}
+
+// Reconnector is implemented by transports that can reconnect
+// with new credentials keeping subscriptions of the current connection.
+type Reconnector interface {
+	Reconnect(ctx context.Context, creds Credentials) error
+}
+
+// ProtocolNamer is implemented by transports that tell their protocol name.
+type ProtocolNamer interface {
+	ProtocolName() string
+}
+
+// UserAgentSetter is implemented by transports that
+// report the client's user agent to the hub.
+type UserAgentSetter interface {
+	SetUserAgent(ua string)
+}
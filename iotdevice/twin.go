@@ -0,0 +1,291 @@
+package iotdevice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// TwinMeta can be embedded into user structs passed to RetrieveTwinStateInto
+// to access version and metadata blocks of a twin section.
+type TwinMeta struct {
+	Version  int                    `json:"$version,omitempty"`
+	Metadata map[string]interface{} `json:"$metadata,omitempty"`
+}
+
+// Metadata returns the $metadata block of the state.
+func (s TwinState) Metadata() map[string]interface{} {
+	m, _ := s["$metadata"].(map[string]interface{})
+	return m
+}
+
+// RetrieveTwinStateInto retrieves the twin and unmarshals its desired and
+// reported sections into the given values, nil values are skipped.
+func (c *Client) RetrieveTwinStateInto(ctx context.Context, desired, reported interface{}) error {
+	if err := c.ConnectionError(ctx); err != nil {
+		return err
+	}
+	b, err := c.tr.RetrieveTwinProperties(ctx)
+	if err != nil {
+		return err
+	}
+	return unmarshalTwin(c.codec, b, desired, reported)
+}
+
+// UpdateTwinStateFrom updates reported properties with the given value
+// that is marshaled to a JSON object, $-prefixed keys like the ones
+// of TwinMeta are omitted. Returns the new version.
+func (c *Client) UpdateTwinStateFrom(ctx context.Context, v interface{}) (int, error) {
+	b, err := marshalTwinPatch(c.codec, v)
+	if err != nil {
+		return 0, err
+	}
+	var s TwinState
+	if err = c.codec.Unmarshal(b, &s); err != nil {
+		return 0, err
+	}
+	return c.UpdateTwinState(ctx, s)
+}
+
+func unmarshalTwin(codec TwinCodec, b []byte, desired, reported interface{}) error {
+	var v struct {
+		Desired  json.RawMessage `json:"desired"`
+		Reported json.RawMessage `json:"reported"`
+	}
+	if err := codec.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	if desired != nil && v.Desired != nil {
+		if err := codec.Unmarshal(v.Desired, desired); err != nil {
+			return err
+		}
+	}
+	if reported != nil && v.Reported != nil {
+		if err := codec.Unmarshal(v.Reported, reported); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func marshalTwinPatch(codec TwinCodec, v interface{}) ([]byte, error) {
+	b, err := codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err = codec.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	for k := range m {
+		if strings.HasPrefix(k, "$") {
+			delete(m, k)
+		}
+	}
+	return codec.Marshal(m)
+}
+
+// Get returns value at the given dot-separated path.
+func (s TwinState) Get(path string) (interface{}, bool) {
+	var v interface{} = map[string]interface{}(s)
+	for _, k := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[k]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// GetString returns string value at the given path.
+func (s TwinState) GetString(path string) (string, bool) {
+	v, _ := s.Get(path)
+	str, ok := v.(string)
+	return str, ok
+}
+
+// GetFloat returns numeric value at the given path.
+func (s TwinState) GetFloat(path string) (float64, bool) {
+	v, _ := s.Get(path)
+	f, ok := v.(float64)
+	return f, ok
+}
+
+// GetBool returns boolean value at the given path.
+func (s TwinState) GetBool(path string) (bool, bool) {
+	v, _ := s.Get(path)
+	b, ok := v.(bool)
+	return b, ok
+}
+
+// Twin returns the local twin cache or nil when it's not enabled, see WithTwinCache.
+func (c *Client) Twin() *TwinCache {
+	return c.twin
+}
+
+// SyncTwin retrieves the current twin state and replaces the cache contents,
+// it's called by Connect and when a desired state patch version gap is detected.
+func (c *Client) SyncTwin(ctx context.Context) error {
+	if c.twin == nil {
+		return errors.New("twin cache is not enabled")
+	}
+	if err := c.ConnectionError(ctx); err != nil {
+		return err
+	}
+	if err := c.tuMux.once(func() error {
+		return c.tr.SubscribeTwinUpdates(ctx, &c.tuMux)
+	}); err != nil {
+		return err
+	}
+	c.twin.attach(c)
+
+	desired, reported, err := c.RetrieveTwinState(ctx)
+	if err != nil {
+		return err
+	}
+	c.twin.reset(desired, reported)
+	return nil
+}
+
+// TwinCache keeps the latest known twin state up to date
+// by applying desired state patches and reported state updates.
+type TwinCache struct {
+	mu       sync.RWMutex
+	desired  TwinState
+	reported TwinState
+	synced   bool
+	fns      []TwinUpdateHandler
+
+	attachOnce sync.Once
+	c          *Client
+}
+
+func newTwinCache() *TwinCache {
+	return &TwinCache{desired: TwinState{}, reported: TwinState{}}
+}
+
+// Desired returns a copy of the cached desired state.
+func (t *TwinCache) Desired() TwinState {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return copyState(t.desired)
+}
+
+// Reported returns a copy of the cached reported state.
+func (t *TwinCache) Reported() TwinState {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return copyState(t.reported)
+}
+
+// Notify registers fn to be called with the desired
+// state every time it changes in the cache.
+func (t *TwinCache) Notify(fn TwinUpdateHandler) {
+	if fn == nil {
+		panic("fn is nil")
+	}
+	t.mu.Lock()
+	t.fns = append(t.fns, fn)
+	t.mu.Unlock()
+}
+
+func (t *TwinCache) attach(c *Client) {
+	t.attachOnce.Do(func() {
+		t.c = c
+		c.tuMux.addWatcher(t)
+	})
+}
+
+func (t *TwinCache) reset(desired, reported TwinState) {
+	if desired == nil {
+		desired = TwinState{}
+	}
+	if reported == nil {
+		reported = TwinState{}
+	}
+	t.mu.Lock()
+	t.desired, t.reported, t.synced = desired, reported, true
+	t.mu.Unlock()
+	t.notify()
+}
+
+func (t *TwinCache) apply(patch TwinState) {
+	t.mu.Lock()
+	if !t.synced {
+		t.mu.Unlock()
+		return
+	}
+	if ver := patch.Version(); ver != 0 && ver != t.desired.Version()+1 {
+		// some patches are missed, so the whole state is refreshed
+		t.synced = false
+		t.mu.Unlock()
+		if t.c != nil {
+			go t.resync()
+		}
+		return
+	}
+	mergeState(t.desired, patch)
+	t.mu.Unlock()
+	t.notify()
+}
+
+func (t *TwinCache) resync() {
+	if err := t.c.SyncTwin(context.Background()); err != nil {
+		t.c.reportError(fmt.Errorf("twin cache sync error: %w", err))
+	}
+}
+
+func (t *TwinCache) report(patch TwinState, ver int) {
+	t.mu.Lock()
+	mergeState(t.reported, patch)
+	t.reported["$version"] = float64(ver)
+	t.mu.Unlock()
+}
+
+func (t *TwinCache) notify() {
+	t.mu.RLock()
+	fns := t.fns
+	s := copyState(t.desired)
+	t.mu.RUnlock()
+	for _, fn := range fns {
+		fn(s)
+	}
+}
+
+// mergeState applies the patch to dst, nil values remove keys.
+func mergeState(dst, patch map[string]interface{}) {
+	for k, v := range patch {
+		if v == nil {
+			delete(dst, k)
+			continue
+		}
+		pm, ok := v.(map[string]interface{})
+		if !ok {
+			dst[k] = v
+			continue
+		}
+		dm, ok := dst[k].(map[string]interface{})
+		if !ok {
+			dm = map[string]interface{}{}
+			dst[k] = dm
+		}
+		mergeState(dm, pm)
+	}
+}
+
+func copyState(s map[string]interface{}) TwinState {
+	c := make(TwinState, len(s))
+	for k, v := range s {
+		if m, ok := v.(map[string]interface{}); ok {
+			v = map[string]interface{}(copyState(m))
+		}
+		c[k] = v
+	}
+	return c
+}
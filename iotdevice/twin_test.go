@@ -0,0 +1,104 @@
+package iotdevice
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+)
+
+type testConfig struct {
+	TwinMeta
+	Interval int `json:"interval"`
+}
+
+func TestUnmarshalTwin(t *testing.T) {
+	t.Parallel()
+
+	var d, r testConfig
+	if err := unmarshalTwin(common.JSONCodec{}, []byte(`{
+		"desired":{"interval":5,"$version":3},
+		"reported":{"interval":1,"$version":7,"$metadata":{"$lastUpdated":"x"}}
+	}`), &d, &r); err != nil {
+		t.Fatal(err)
+	}
+	if d.Interval != 5 || d.Version != 3 {
+		t.Errorf("desired = %+v", d)
+	}
+	if r.Interval != 1 || r.Version != 7 || r.Metadata["$lastUpdated"] != "x" {
+		t.Errorf("reported = %+v", r)
+	}
+}
+
+func TestMarshalTwinPatch(t *testing.T) {
+	t.Parallel()
+
+	b, err := marshalTwinPatch(common.JSONCodec{}, &testConfig{
+		TwinMeta: TwinMeta{Version: 3},
+		Interval: 5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"interval":5}` {
+		t.Errorf("patch = %s, want %s", b, `{"interval":5}`)
+	}
+}
+
+func TestTwinState_Get(t *testing.T) {
+	t.Parallel()
+
+	s := TwinState{
+		"config": map[string]interface{}{
+			"name":    "x",
+			"enabled": true,
+			"n":       1.0,
+		},
+	}
+	if v, ok := s.GetString("config.name"); !ok || v != "x" {
+		t.Errorf("GetString = %q, %t", v, ok)
+	}
+	if v, ok := s.GetBool("config.enabled"); !ok || !v {
+		t.Errorf("GetBool = %t, %t", v, ok)
+	}
+	if v, ok := s.GetFloat("config.n"); !ok || v != 1 {
+		t.Errorf("GetFloat = %f, %t", v, ok)
+	}
+	if _, ok := s.Get("config.name.x"); ok {
+		t.Error("Get(config.name.x) is found")
+	}
+}
+
+func TestTwinCache(t *testing.T) {
+	t.Parallel()
+
+	c := newTwinCache()
+	var n int
+	c.Notify(func(TwinState) { n++ })
+
+	c.apply(TwinState{"a": 1.0, "$version": 2.0}) // ignored until synced
+	c.reset(TwinState{
+		"a":        map[string]interface{}{"b": 1.0, "c": 2.0},
+		"$version": 1.0,
+	}, nil)
+	c.apply(TwinState{
+		"a":        map[string]interface{}{"b": nil, "d": 3.0},
+		"$version": 2.0,
+	})
+
+	want := TwinState{
+		"a":        map[string]interface{}{"c": 2.0, "d": 3.0},
+		"$version": 2.0,
+	}
+	if d := c.Desired(); !reflect.DeepEqual(d, want) {
+		t.Errorf("Desired() = %v, want %v", d, want)
+	}
+	if n != 2 {
+		t.Errorf("notified %d times, want 2", n)
+	}
+
+	c.report(TwinState{"x": 1.0}, 5)
+	if r := c.Reported(); r.Version() != 5 || r["x"] != 1.0 {
+		t.Errorf("Reported() = %v", r)
+	}
+}
@@ -0,0 +1,21 @@
+package iotdevice
+
+import (
+	"context"
+	"fmt"
+)
+
+// Send encodes v with the client's codec, see WithCodec, and sends it as
+// a device-to-cloud message marked as routable JSON, opts can override that.
+//
+// It's a function because Go doesn't support type parameters on methods.
+func Send[T any](ctx context.Context, c *Client, v T, opts ...SendOption) error {
+	if c == nil {
+		panic("c is nil")
+	}
+	b, err := c.codec.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encode error: %w", err)
+	}
+	return c.SendEvent(ctx, b, append([]SendOption{WithSendRoutableJSON()}, opts...)...)
+}
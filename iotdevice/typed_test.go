@@ -0,0 +1,59 @@
+package iotdevice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+)
+
+// sendTransport records sent messages.
+type sendTransport struct {
+	connectTransport
+	msgs []*common.Message
+}
+
+func (tr *sendTransport) Send(ctx context.Context, msg *common.Message) error {
+	tr.msgs = append(tr.msgs, msg)
+	return nil
+}
+
+func TestSend(t *testing.T) {
+	t.Parallel()
+
+	tr := &sendTransport{}
+	c, err := NewClient(
+		WithTransport(tr),
+		WithConnectionString("HostName=a.net;DeviceId=dev;SharedAccessKey=c2VjcmV0"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	type telemetry struct {
+		Temperature float64 `json:"temperature"`
+	}
+	if err = Send(context.Background(), c, telemetry{Temperature: 21.5},
+		WithSendContentEncoding("utf-16"),
+	); err != nil {
+		t.Fatal(err)
+	}
+	if len(tr.msgs) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(tr.msgs))
+	}
+	msg := tr.msgs[0]
+	if w := `{"temperature":21.5}`; string(msg.Payload) != w {
+		t.Errorf("payload = %s, want %s", msg.Payload, w)
+	}
+	if msg.ContentType != "application/json" || msg.ContentEncoding != "utf-16" {
+		t.Errorf("content = %q %q, want options to override defaults",
+			msg.ContentType, msg.ContentEncoding)
+	}
+
+	if err = Send(context.Background(), c, func() {}); err == nil {
+		t.Error("Send(func) = nil, want an encode error")
+	}
+}
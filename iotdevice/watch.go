@@ -0,0 +1,109 @@
+package iotdevice
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// DesiredPropertyHandler handles a desired property change,
+// nil value means that the property is absent or removed.
+type DesiredPropertyHandler func(old, new interface{})
+
+// WatchDesiredProperty calls fn every time the desired property at
+// the given dot-separated path changes, e.g. config.reportingInterval,
+// until ctx is done. It blocks only until the current value is retrieved.
+func (c *Client) WatchDesiredProperty(ctx context.Context, path string, fn DesiredPropertyHandler) error {
+	if path == "" {
+		return errors.New("path is empty")
+	}
+	if fn == nil {
+		panic("fn is nil")
+	}
+	if err := c.ConnectionError(ctx); err != nil {
+		return err
+	}
+	if err := c.tuMux.once(func() error {
+		return c.tr.SubscribeTwinUpdates(ctx, &c.tuMux)
+	}); err != nil {
+		return err
+	}
+
+	w := &propertyWatcher{path: strings.Split(path, "."), fn: fn}
+	c.tuMux.addWatcher(w) // add it first not to miss updates
+
+	desired, _, err := c.RetrieveTwinState(ctx)
+	if err != nil {
+		c.tuMux.removeWatcher(w)
+		return err
+	}
+	w.init(desired)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-c.done:
+		}
+		c.tuMux.removeWatcher(w)
+	}()
+	return nil
+}
+
+// propertyWatcher tracks a single property value applying twin patches.
+type propertyWatcher struct {
+	path []string
+	fn   DesiredPropertyHandler
+
+	mu    sync.Mutex
+	ready bool
+	val   interface{}
+}
+
+// init sets the initial value unless a patch has already been applied.
+func (w *propertyWatcher) init(s TwinState) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.ready {
+		w.val, _ = lookupPath(s, w.path)
+		w.ready = true
+	}
+}
+
+// apply calls the handler if the given patch changes the property.
+func (w *propertyWatcher) apply(patch TwinState) {
+	v, ok := lookupPath(patch, w.path)
+	if !ok {
+		return
+	}
+	w.mu.Lock()
+	old := w.val
+	w.val = v
+	ready := w.ready
+	w.ready = true
+	w.mu.Unlock()
+	if ready && reflect.DeepEqual(old, v) {
+		return
+	}
+	w.fn(old, v)
+}
+
+// lookupPath returns value at the given path in a twin patch and
+// whether the patch affects it, removing or replacing
+// a parent object with a scalar means the value is removed.
+func lookupPath(m map[string]interface{}, path []string) (interface{}, bool) {
+	for i, k := range path {
+		v, ok := m[k]
+		if !ok {
+			return nil, false
+		}
+		if i == len(path)-1 {
+			return v, true
+		}
+		if m, ok = v.(map[string]interface{}); !ok {
+			return nil, true
+		}
+	}
+	return nil, false
+}
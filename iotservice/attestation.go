@@ -0,0 +1,197 @@
+package iotservice
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Attestation is authentication material metadata of a device identity,
+// it never contains the secret keys themselves.
+type Attestation struct {
+	DeviceID            string
+	Type                AuthType
+	PrimaryThumbprint   string
+	SecondaryThumbprint string
+	HasPrimaryKey       bool
+	HasSecondaryKey     bool
+
+	// GenerationID changes every time the identity is recreated,
+	// the registry doesn't keep track of separate key generation times,
+	// so StatusUpdatedTime is the closest approximation of when
+	// the credentials were last modified through an update.
+	GenerationID      string
+	StatusUpdatedTime string
+	ETag              string
+}
+
+// NewAttestation extracts authentication metadata from the given device.
+func NewAttestation(device *Device) *Attestation {
+	a := &Attestation{
+		DeviceID:          device.DeviceID,
+		GenerationID:      device.GenerationID,
+		StatusUpdatedTime: device.StatusUpdatedTime,
+		ETag:              device.ETag,
+	}
+	if device.Authentication == nil {
+		return a
+	}
+	a.Type = device.Authentication.Type
+	if tp := device.Authentication.X509Thumbprint; tp != nil {
+		a.PrimaryThumbprint = tp.PrimaryThumbprint
+		a.SecondaryThumbprint = tp.SecondaryThumbprint
+	}
+	if sk := device.Authentication.SymmetricKey; sk != nil {
+		a.HasPrimaryKey = sk.PrimaryKey != ""
+		a.HasSecondaryKey = sk.SecondaryKey != ""
+	}
+	return a
+}
+
+// GetAttestation returns authentication metadata of the named device.
+func (c *Client) GetAttestation(ctx context.Context, deviceID string) (*Attestation, error) {
+	device, err := c.GetDevice(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	return NewAttestation(device), nil
+}
+
+// Thumbprint returns the hex encoded SHA-1 thumbprint of the certificate,
+// the format self-signed device certificates are registered with by default
+// and shown in the portal. SHA-1 only identifies the certificate here,
+// the hub verifies the full certificate presented in the TLS handshake,
+// use Thumbprint256 where SHA-1 is not allowed at all.
+func Thumbprint(crt *x509.Certificate) string {
+	h := sha1.Sum(crt.Raw)
+	return strings.ToUpper(hex.EncodeToString(h[:]))
+}
+
+// Thumbprint256 returns the hex encoded SHA-256 thumbprint of the certificate,
+// the hub accepts it in place of the SHA-1 one when registering devices.
+func Thumbprint256(crt *x509.Certificate) string {
+	h := sha256.Sum256(crt.Raw)
+	return strings.ToUpper(hex.EncodeToString(h[:]))
+}
+
+// SetThumbprints replaces X.509 thumbprints of the named self-signed device.
+//
+// The update is conditional on the device's etag so concurrent
+// modifications of the identity are not overwritten.
+func (c *Client) SetThumbprints(
+	ctx context.Context, deviceID, primary, secondary string,
+) (*Device, error) {
+	device, err := c.GetDevice(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	return c.setThumbprints(ctx, device, primary, secondary)
+}
+
+// RotateThumbprint makes the given thumbprint primary and demotes
+// the current primary thumbprint to secondary, so devices still using
+// the old certificate can connect until they pick up the new one.
+func (c *Client) RotateThumbprint(ctx context.Context, deviceID, thumbprint string) (*Device, error) {
+	device, err := c.GetDevice(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	a := NewAttestation(device)
+	if strings.EqualFold(a.PrimaryThumbprint, thumbprint) {
+		return nil, errors.New("thumbprint is already primary")
+	}
+	return c.setThumbprints(ctx, device, thumbprint, a.PrimaryThumbprint)
+}
+
+func (c *Client) setThumbprints(
+	ctx context.Context, device *Device, primary, secondary string,
+) (*Device, error) {
+	if primary == "" {
+		return nil, errors.New("primary thumbprint is empty")
+	}
+	if device.Authentication == nil || device.Authentication.Type != AuthSelfSigned {
+		return nil, errors.New("device is not authenticated with a self-signed certificate")
+	}
+	device.Authentication.X509Thumbprint = &X509Thumbprint{
+		PrimaryThumbprint:   primary,
+		SecondaryThumbprint: secondary,
+	}
+	return c.UpdateDevice(ctx, device)
+}
+
+// RotateCertificate performs zero-downtime certificate rotation
+// of the named self-signed device.
+//
+// The new thumbprint is registered as secondary first, then the registry
+// is polled every interval until the device reconnects, after which
+// the new thumbprint is promoted to primary and the old one becomes
+// secondary. The registry doesn't report which certificate a device
+// authenticated with, so the reconnection may have used the old one,
+// call RemoveSecondaryThumbprint once the device is known to use
+// the new certificate to stop accepting the old one.
+func (c *Client) RotateCertificate(
+	ctx context.Context,
+	deviceID, thumbprint string,
+	interval time.Duration,
+) (*Device, error) {
+	if interval <= 0 {
+		panic("interval must be positive")
+	}
+	device, err := c.GetDevice(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	a := NewAttestation(device)
+	if strings.EqualFold(a.PrimaryThumbprint, thumbprint) {
+		return nil, errors.New("thumbprint is already primary")
+	}
+	if !strings.EqualFold(a.SecondaryThumbprint, thumbprint) {
+		if device, err = c.setThumbprints(
+			ctx, device, a.PrimaryThumbprint, thumbprint,
+		); err != nil {
+			return nil, err
+		}
+	}
+	if device, err = c.waitReconnect(ctx, device, interval); err != nil {
+		return nil, err
+	}
+	return c.setThumbprints(ctx, device, thumbprint, a.PrimaryThumbprint)
+}
+
+// RemoveSecondaryThumbprint removes the secondary thumbprint of the named
+// self-signed device, e.g. to finish rotation started with RotateCertificate.
+func (c *Client) RemoveSecondaryThumbprint(ctx context.Context, deviceID string) (*Device, error) {
+	device, err := c.GetDevice(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	return c.setThumbprints(ctx, device, NewAttestation(device).PrimaryThumbprint, "")
+}
+
+// waitReconnect polls the registry until the given device
+// connects again after its last connection state change.
+func (c *Client) waitReconnect(ctx context.Context, device *Device, interval time.Duration) (*Device, error) {
+	since := device.ConnectionStateUpdatedTime
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		d, err := c.GetDevice(ctx, device.DeviceID)
+		if err != nil {
+			return nil, err
+		}
+		if d.ConnectionState == ConnectionStateConnected &&
+			d.ConnectionStateUpdatedTime != since {
+			return d, nil
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package iotservice
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRotateThumbprint(t *testing.T) {
+	t.Parallel()
+
+	var updated *Device
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Write([]byte(`{"deviceId":"dev","etag":"e1","authentication":{` +
+				`"type":"selfSigned","x509Thumbprint":{"primaryThumbprint":"AA","secondaryThumbprint":"BB"}}}`))
+		case http.MethodPut:
+			if r.Header.Get("If-Match") != "e1" {
+				t.Errorf("If-Match = %q, want %q", r.Header.Get("If-Match"), "e1")
+			}
+			updated = &Device{}
+			if err := json.NewDecoder(r.Body).Decode(updated); err != nil {
+				t.Error(err)
+			}
+			json.NewEncoder(w).Encode(updated)
+		}
+	})
+	if _, err := c.RotateThumbprint(context.Background(), "dev", "aa"); err == nil {
+		t.Fatal("expected rotating to the current primary thumbprint to fail")
+	}
+	if _, err := c.RotateThumbprint(context.Background(), "dev", "CC"); err != nil {
+		t.Fatal(err)
+	}
+	a := NewAttestation(updated)
+	if a.PrimaryThumbprint != "CC" || a.SecondaryThumbprint != "AA" || a.Type != AuthSelfSigned {
+		t.Errorf("attestation = %+v, want CC/AA selfSigned", a)
+	}
+}
+
+func TestRotateCertificate(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu    sync.Mutex
+		polls int
+		dev   = &Device{
+			DeviceID:                   "dev",
+			ConnectionState:            "Connected",
+			ConnectionStateUpdatedTime: "t0",
+			Authentication: &Authentication{
+				Type:           AuthSelfSigned,
+				X509Thumbprint: &X509Thumbprint{PrimaryThumbprint: "AA"},
+			},
+		}
+		updates []X509Thumbprint
+	)
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodGet:
+			// the device reconnects on the second poll
+			if polls++; polls == 3 {
+				dev.ConnectionStateUpdatedTime = "t1"
+			}
+		case http.MethodPut:
+			v := &Device{}
+			if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+				t.Error(err)
+			}
+			dev.Authentication = v.Authentication
+			updates = append(updates, *v.Authentication.X509Thumbprint)
+		}
+		json.NewEncoder(w).Encode(dev)
+	})
+	if _, err := c.RotateCertificate(context.Background(), "dev", "CC", time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []X509Thumbprint{
+		{PrimaryThumbprint: "AA", SecondaryThumbprint: "CC"},
+		{PrimaryThumbprint: "CC", SecondaryThumbprint: "AA"},
+	}
+	if len(updates) != len(want) || updates[0] != want[0] || updates[1] != want[1] {
+		t.Errorf("updates = %v, want %v", updates, want)
+	}
+	if polls != 3 {
+		t.Errorf("polls = %d, want 3", polls)
+	}
+	mu.Unlock()
+
+	if _, err := c.RemoveSecondaryThumbprint(context.Background(), "dev"); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	if w := (X509Thumbprint{PrimaryThumbprint: "CC"}); updates[len(updates)-1] != w {
+		t.Errorf("update = %v, want %v", updates[len(updates)-1], w)
+	}
+}
@@ -0,0 +1,164 @@
+// Package bridge republishes device-to-cloud events to external messaging
+// systems, it's a supported integration point instead of custom glue code
+// between a hub subscription and a message broker.
+//
+// Broker clients are not dependencies of this package, sinks for them
+// accept functions with the shape of the clients' publishing methods,
+// e.g. NATSSink(nc.Publish, "telemetry").
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+	"github.com/amenzhinsky/golang-iothub/iotservice"
+)
+
+// Sink is a destination of events.
+type Sink interface {
+	Publish(ctx context.Context, msg *common.Message) error
+}
+
+// SinkFunc is a function sink.
+type SinkFunc func(ctx context.Context, msg *common.Message) error
+
+// Publish calls fn.
+func (fn SinkFunc) Publish(ctx context.Context, msg *common.Message) error {
+	return fn(ctx, msg)
+}
+
+// ChanSink returns a sink that sends events to the given channel.
+func ChanSink(ch chan<- *common.Message) Sink {
+	return SinkFunc(func(ctx context.Context, msg *common.Message) error {
+		select {
+		case ch <- msg:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+// Encode encodes events as JSON documents with base64 encoded payloads,
+// it's the format used by NATSSink and KafkaSink.
+func Encode(msg *common.Message) ([]byte, error) {
+	return json.Marshal(msg)
+}
+
+// NATSSink returns a sink that publishes events to the subject
+// with the sending device id appended, e.g. telemetry.{device},
+// publish is usually the Publish method of a NATS connection.
+func NATSSink(publish func(subject string, data []byte) error, subject string) Sink {
+	if publish == nil {
+		panic("publish is nil")
+	}
+	return SinkFunc(func(ctx context.Context, msg *common.Message) error {
+		b, err := Encode(msg)
+		if err != nil {
+			return err
+		}
+		return publish(subject+"."+msg.ConnectionDeviceID, b)
+	})
+}
+
+// KafkaSink returns a sink that writes events keyed by the sending device id,
+// so events of the same device end up in the same partition in order.
+// write usually wraps a Kafka writer or producer.
+func KafkaSink(write func(ctx context.Context, key, value []byte) error) Sink {
+	if write == nil {
+		panic("write is nil")
+	}
+	return SinkFunc(func(ctx context.Context, msg *common.Message) error {
+		b, err := Encode(msg)
+		if err != nil {
+			return err
+		}
+		return write(ctx, []byte(msg.ConnectionDeviceID), b)
+	})
+}
+
+// ErrorHandler handles events that couldn't be published.
+type ErrorHandler func(msg *common.Message, err error)
+
+// Option is a bridge configuration option.
+type Option func(b *Bridge)
+
+// WithSubscribeOptions sets options of the underlying events subscription.
+func WithSubscribeOptions(opts ...iotservice.SubscribeOption) Option {
+	return func(b *Bridge) {
+		b.subOpts = append(b.subOpts, opts...)
+	}
+}
+
+// WithErrorHandler makes the bridge report publishing errors
+// and carry on, by default the first error stops it.
+func WithErrorHandler(fn ErrorHandler) Option {
+	return func(b *Bridge) {
+		b.onError = fn
+	}
+}
+
+// WithBufferSize sets the number of events buffered between
+// the subscription and the sink, the default is 64.
+func WithBufferSize(n int) Option {
+	return func(b *Bridge) {
+		b.size = n
+	}
+}
+
+// Bridge forwards device-to-cloud events of a hub to a sink.
+type Bridge struct {
+	c       *iotservice.Client
+	sink    Sink
+	subOpts []iotservice.SubscribeOption
+	onError ErrorHandler
+	size    int
+}
+
+// New creates a bridge that forwards events of the client's hub to sink.
+func New(c *iotservice.Client, sink Sink, opts ...Option) *Bridge {
+	if c == nil {
+		panic("client is nil")
+	}
+	if sink == nil {
+		panic("sink is nil")
+	}
+	b := &Bridge{c: c, sink: sink, size: 64}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Run forwards events in order until ctx is done or an error occurs.
+func (b *Bridge) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	sub, err := b.c.NewEventSubscription(ctx,
+		append([]iotservice.SubscribeOption{iotservice.WithChanBuffer(b.size)}, b.subOpts...)...,
+	)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+	if err = b.forward(ctx, sub.C()); err != nil {
+		return err
+	}
+	if err = sub.Err(); err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+func (b *Bridge) forward(ctx context.Context, ch <-chan *common.Message) error {
+	for msg := range ch {
+		if err := b.sink.Publish(ctx, msg); err != nil {
+			if b.onError == nil || ctx.Err() != nil {
+				return err
+			}
+			b.onError(msg, err)
+		}
+	}
+	return nil
+}
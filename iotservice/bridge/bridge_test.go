@@ -0,0 +1,80 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+)
+
+func TestSinks(t *testing.T) {
+	t.Parallel()
+
+	msg := &common.Message{ConnectionDeviceID: "dev", Payload: []byte("hello")}
+	var subject, key string
+	var data []byte
+	for name, s := range map[string]Sink{
+		"nats": NATSSink(func(s string, b []byte) error {
+			subject, data = s, b
+			return nil
+		}, "telemetry"),
+		"kafka": KafkaSink(func(_ context.Context, k, v []byte) error {
+			key, data = string(k), v
+			return nil
+		}),
+	} {
+		data = nil
+		if err := s.Publish(context.Background(), msg); err != nil {
+			t.Fatal(err)
+		}
+		var g common.Message
+		if err := json.Unmarshal(data, &g); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if string(g.Payload) != "hello" || g.ConnectionDeviceID != "dev" {
+			t.Errorf("%s: decoded message = %+v", name, g)
+		}
+	}
+	if subject != "telemetry.dev" {
+		t.Errorf("subject = %q, want telemetry.dev", subject)
+	}
+	if key != "dev" {
+		t.Errorf("key = %q, want dev", key)
+	}
+}
+
+func TestForward(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *common.Message, 3)
+	for _, p := range []string{"a", "fail", "b"} {
+		in <- &common.Message{Payload: []byte(p)}
+	}
+	close(in)
+
+	out := make(chan *common.Message, 3)
+	sink := SinkFunc(func(ctx context.Context, msg *common.Message) error {
+		if string(msg.Payload) == "fail" {
+			return errors.New("unavailable")
+		}
+		return ChanSink(out).Publish(ctx, msg)
+	})
+
+	var failed int
+	b := &Bridge{sink: sink, onError: func(msg *common.Message, err error) {
+		failed++
+	}}
+	if err := b.forward(context.Background(), in); err != nil {
+		t.Fatal(err)
+	}
+	close(out)
+	var got string
+	for msg := range out {
+		got += string(msg.Payload)
+	}
+	if got != "ab" || failed != 1 {
+		t.Errorf("forwarded %q with %d failures, want %q with 1", got, failed, "ab")
+	}
+}
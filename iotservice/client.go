@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/rand"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -18,8 +19,11 @@ import (
 	"time"
 
 	"github.com/amenzhinsky/golang-iothub/common"
+	"github.com/amenzhinsky/golang-iothub/common/backoff"
 	"github.com/amenzhinsky/golang-iothub/common/commonamqp"
 	"github.com/amenzhinsky/golang-iothub/eventhub"
+	"github.com/amenzhinsky/golang-iothub/iotservice/models"
+	"github.com/amenzhinsky/golang-iothub/iotutil"
 	"pack.ag/amqp"
 )
 
@@ -46,6 +50,69 @@ func WithCredentials(creds *common.Credentials) ClientOption {
 	}
 }
 
+// WithMessageIDGenerator makes the client generate message ids with fn
+// for sent messages that don't have one, e.g. iotutil.UUID or iotutil.ULID.
+// It's useful when feedback is requested to correlate it with messages.
+func WithMessageIDGenerator(fn iotutil.IDGenerator) ClientOption {
+	return func(c *Client) error {
+		c.midgen = fn
+		return nil
+	}
+}
+
+// WithDefaultTimeout bounds duration of REST requests, sending messages,
+// connecting and closing when the passed context has no deadline.
+// Long-running subscriptions are not affected.
+func WithDefaultTimeout(d time.Duration) ClientOption {
+	return func(c *Client) error {
+		if d < 0 {
+			return errors.New("timeout is negative")
+		}
+		c.timeout = d
+		return nil
+	}
+}
+
+// WithFeedbackTTL sets how long futures returned by SendEventAsync
+// wait for feedback before they expire, it defaults to two hours.
+func WithFeedbackTTL(d time.Duration) ClientOption {
+	return func(c *Client) error {
+		if d <= 0 {
+			return errors.New("feedback ttl must be positive")
+		}
+		c.fbTTL = d
+		return nil
+	}
+}
+
+// WithProductInfo adds the application name and version to the HTTP
+// User-Agent and AMQP connection properties, so hub-side
+// diagnostics can identify which application a connection belongs to.
+func WithProductInfo(name, version string) ClientOption {
+	return func(c *Client) error {
+		if name == "" {
+			return errors.New("name is empty")
+		}
+		c.product = name + "/" + version
+		return nil
+	}
+}
+
+// WithRetryPolicy makes the client retry REST requests failed with network
+// errors or throttling and server-side error codes making at most
+// maxAttempts attempts, zero means no limit. By default requests aren't retried.
+//
+// Only throttled requests are retried regardless of the method, other
+// failures are retried only for idempotent methods and conditional writes,
+// because e.g. a timed out direct-method call may have already run.
+func WithRetryPolicy(p backoff.Policy, maxAttempts int) ClientOption {
+	return func(c *Client) error {
+		c.retry = p
+		c.retryAttempts = maxAttempts
+		return nil
+	}
+}
+
 // WithHTTPClient changes default http rest client.
 func WithHTTPClient(client *http.Client) ClientOption {
 	return func(c *Client) error {
@@ -54,7 +121,52 @@ func WithHTTPClient(client *http.Client) ClientOption {
 	}
 }
 
-// WithLogger sets client logger.
+// WithDialer sets the dialer of AMQP connections, default is common.DefaultDialer.
+// It doesn't affect the client set with WithHTTPClient.
+func WithDialer(d *common.Dialer) ClientOption {
+	return func(c *Client) error {
+		c.dialer = d
+		return nil
+	}
+}
+
+// WithPutTokenHandler sets the handler of AMQP claims-based security
+// put-token outcomes, such as status codes and descriptions of rejected
+// tokens, it's useful for counting authentication failures.
+func WithPutTokenHandler(fn eventhub.PutTokenHandler) ClientOption {
+	return func(c *Client) error {
+		c.onPutToken = fn
+		return nil
+	}
+}
+
+// WithErrorHandler sets the handler of errors that occur in library-owned
+// goroutines, e.g. feedback resubscriptions, decompression failures and
+// panics of user handlers that are reported as *common.PanicError instead
+// of crashing the process. It's called synchronously, so it must not block.
+func WithErrorHandler(fn func(err error)) ClientOption {
+	if fn == nil {
+		panic("fn is nil")
+	}
+	return func(c *Client) error {
+		c.onError = fn
+		return nil
+	}
+}
+
+// WithCodec sets the codec of typed events, see Subscribe,
+// default is encoding/json.
+func WithCodec(codec common.Codec) ClientOption {
+	if codec == nil {
+		panic("codec is nil")
+	}
+	return func(c *Client) error {
+		c.codec = codec
+		return nil
+	}
+}
+
+// WithLogger sets client logger, nil disables logging.
 func WithLogger(l *log.Logger) ClientOption {
 	return func(c *Client) error {
 		c.logger = l
@@ -62,10 +174,113 @@ func WithLogger(l *log.Logger) ClientOption {
 	}
 }
 
-// WithDebug enables or disables debug mode.
+// LogLevel is a logging verbosity level.
+type LogLevel int
+
+const (
+	// LogLevelError logs only errors.
+	LogLevelError LogLevel = iota
+
+	// LogLevelInfo logs errors and informational messages, it's the default.
+	LogLevelInfo
+
+	// LogLevelDebug additionally dumps REST requests and responses.
+	LogLevelDebug
+)
+
+// WithLogLevel sets logging verbosity.
+func WithLogLevel(l LogLevel) ClientOption {
+	return func(c *Client) error {
+		if l < LogLevelError || l > LogLevelDebug {
+			return fmt.Errorf("unknown log level %d", l)
+		}
+		c.level = l
+		return nil
+	}
+}
+
+// WithDebug enables or disables debug mode,
+// it's the same as setting LogLevelDebug or LogLevelInfo.
 func WithDebug(d bool) ClientOption {
+	if d {
+		return WithLogLevel(LogLevelDebug)
+	}
+	return WithLogLevel(LogLevelInfo)
+}
+
+// WithLogRedaction enables or disables masking of SAS signatures and
+// keys in log messages, it's enabled by default and should be turned off
+// only when debugging authentication issues in a trusted environment.
+func WithLogRedaction(enabled bool) ClientOption {
+	return func(c *Client) error {
+		c.noRedact = !enabled
+		return nil
+	}
+}
+
+// WithSenderLinks sets the number of parallel links cloud-to-device messages
+// are sent through in round-robin order, a single link serializes sends
+// that caps throughput of busy back-ends. The default is 1.
+func WithSenderLinks(n int) ClientOption {
+	return func(c *Client) error {
+		if n < 1 {
+			return errors.New("number of sender links must be positive")
+		}
+		c.nsender = n
+		return nil
+	}
+}
+
+// WithRootCAs overrides the root CA certificates server certificates
+// are validated against, see common.BundledRootCAs.
+func WithRootCAs(p *x509.CertPool) ClientOption {
 	return func(c *Client) error {
-		c.debug = d
+		if p == nil {
+			return errors.New("pool is nil")
+		}
+		c.roots = p
+		return nil
+	}
+}
+
+// WithSystemCertPool makes the client trust the host's root CA certificates
+// in addition to the bundled ones, so rotation of Azure roots can be
+// handled by updating the operating system certificate store.
+func WithSystemCertPool() ClientOption {
+	return func(c *Client) error {
+		p, err := common.SystemRootCAs()
+		if err != nil {
+			return err
+		}
+		c.roots = p
+		return nil
+	}
+}
+
+// WithRevocationCheck enables checking revocation status of server
+// certificates with the given checker, see common.NewCRLChecker.
+func WithRevocationCheck(ch *common.CRLChecker) ClientOption {
+	return func(c *Client) error {
+		if ch == nil {
+			return errors.New("checker is nil")
+		}
+		c.crl = ch
+		return nil
+	}
+}
+
+// WithPinnedServerCertificates requires the hub server certificate chain
+// to contain one of the given public keys in addition to the regular CA
+// validation, pins are base64 encoded SHA-256 hashes of subject public key
+// info, see common.SPKIHash. It applies to the event hub endpoint events
+// are read from too, so pinning CA keys both endpoints chain to is advised.
+// It doesn't affect the client set with WithHTTPClient.
+func WithPinnedServerCertificates(pins ...string) ClientOption {
+	return func(c *Client) error {
+		if err := common.ValidatePins(pins); err != nil {
+			return err
+		}
+		c.pins = pins
 		return nil
 	}
 }
@@ -73,7 +288,10 @@ func WithDebug(d bool) ClientOption {
 // NewClient creates new iothub service client.
 func NewClient(opts ...ClientOption) (*Client, error) {
 	c := &Client{
-		done: make(chan struct{}),
+		done:  make(chan struct{}),
+		fatal: make(chan error, 1),
+		codec: common.JSONCodec{},
+		level: LogLevelInfo,
 	}
 	for _, opt := range opts {
 		if err := opt(c); err != nil {
@@ -84,6 +302,10 @@ func NewClient(opts ...ClientOption) (*Client, error) {
 	if c.creds == nil {
 		return nil, errors.New("credentials are missing, consider using `WithCredentials` or `WithConnectionString` option")
 	}
+	if c.nsender == 0 {
+		c.nsender = 1
+	}
+	c.senders = make([]senderLink, c.nsender)
 
 	// set the default rest client, it uses only bundled ca-certificates
 	// it's useful when the ca-certificates package is not present on
@@ -91,41 +313,178 @@ func NewClient(opts ...ClientOption) (*Client, error) {
 	if c.http == nil {
 		c.http = &http.Client{
 			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{
-					RootCAs: common.RootCAs(),
-				},
+				TLSClientConfig: c.tlsConfig(""),
 			},
 		}
 	}
 	return c, nil
 }
 
+// Client is an IoT Hub service client.
+//
+// It's safe for concurrent use by multiple goroutines: REST calls such as
+// InvokeMethod are independent requests, all AMQP operations share a single
+// lazily established connection and session, cloud-to-device messages are
+// sent through sender links owned by the client, sends over each link are
+// serialized, see WithSenderLinks, and every subscription opens
+// its own receiver link.
+// A connection an operation finds broken is replaced on the next one.
 type Client struct {
-	mu     sync.Mutex
-	conn   *eventhub.Client
-	done   chan struct{}
-	creds  *common.Credentials
-	logger *log.Logger
-	debug  bool
-	http   *http.Client // REST client
+	mu      sync.Mutex
+	conn    *eventhub.Client
+	dialing *dialCall
+	senders []senderLink
+	nsender int
+	next    uint32 // round-robin counter
+	done    chan struct{}
+	fatal   chan error // see Run
+	creds   *common.Credentials
+	http    *http.Client // REST client
+	midgen  iotutil.IDGenerator
+
+	fbMu      sync.Mutex
+	fbOn      bool
+	fbPending map[string]*pendingFeedback
+	fbTTL     time.Duration
+
+	retry         backoff.Policy
+	retryAttempts int
+	timeout       time.Duration
+	product       string
+	pins          []string
+	roots         *x509.CertPool
+	crl           *common.CRLChecker
+	dialer        *common.Dialer
+	onPutToken    eventhub.PutTokenHandler
+	onError       func(err error)
+	codec         common.Codec
+
+	logger   *log.Logger
+	level    LogLevel
+	noRedact bool
 }
 
+// userAgentProperty is the AMQP connection property carrying the user agent.
+const userAgentProperty = "com.microsoft:client-version"
+
+// ErrClosed is returned by operations on a closed client.
+var ErrClosed = errors.New("iotservice: closed")
+
 // Connect connects to AMQP broker, it's done automatically before
-// publishing events or subscribing to the feedback topic.
+// publishing events or subscribing to the feedback topic,
+// so calling it is needed only to establish connection in advance.
 func (c *Client) Connect(ctx context.Context) error {
+	_, err := c.connect(ctx)
+	return err
+}
+
+// dialCall is an in-flight connection attempt shared by concurrent callers.
+type dialCall struct {
+	done chan struct{}
+	err  error
+}
+
+// connect returns the AMQP connection establishing it when needed,
+// concurrent callers wait for the same connection attempt
+// and each of them can stop waiting when its ctx is done.
+func (c *Client) connect(ctx context.Context) (*eventhub.Client, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
 	c.mu.Lock()
-	defer c.mu.Unlock()
 	if c.conn != nil {
-		return nil // already connected
+		conn := c.conn
+		c.mu.Unlock()
+		return conn, nil
+	}
+	select {
+	case <-c.done:
+		c.mu.Unlock()
+		return nil, ErrClosed
+	default:
+	}
+	call := c.dialing
+	if call == nil {
+		call = &dialCall{done: make(chan struct{})}
+		c.dialing = call
+		go c.dial(call)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		if call.err != nil {
+			return nil, call.err
+		}
+		return c.connect(ctx)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Client) dial(call *dialCall) {
+	// ctx outlives the call because the connection renews
+	// its tokens with it, it's canceled when the client is closed
+	ctx, cancel := common.WithDone(context.Background(), c.done)
+	eh, err := c.dialEventHub(ctx)
+	c.mu.Lock()
+	select {
+	case <-c.done:
+		if err == nil {
+			eh.Close()
+		}
+		err = ErrClosed
+	default:
+		if err == nil {
+			c.conn = eh
+		}
+	}
+	c.dialing = nil
+	c.mu.Unlock()
+	if err != nil {
+		cancel()
+	}
+	call.err = err
+	close(call.done)
+}
+
+// tlsConfig returns TLS configuration of connections to the hub
+// and to the event hub endpoint events are read from.
+func (c *Client) tlsConfig(serverName string) *tls.Config {
+	roots := c.roots
+	if roots == nil {
+		roots = common.RootCAs()
+	}
+	return c.crl.TLSConfig(common.PinTLSConfig(common.FIPSTLSConfig(&tls.Config{
+		ServerName: serverName,
+		RootCAs:    roots,
+	}), c.pins))
+}
+
+// putTokenHandler logs claims-based security outcomes
+// and passes them to the handler set with WithPutTokenHandler.
+func (c *Client) putTokenHandler(r *eventhub.PutTokenResult) {
+	if r.Err != nil {
+		c.errorf("put token for %s failed in %s: code = %d, description = %q: %s",
+			r.Audience, r.Duration, r.StatusCode, r.Description, r.Err,
+		)
+	} else {
+		c.debugf("put token for %s succeeded in %s: code = %d, description = %q",
+			r.Audience, r.Duration, r.StatusCode, r.Description,
+		)
+	}
+	if c.onPutToken != nil {
+		c.onPutToken(r)
 	}
+}
 
+func (c *Client) dialEventHub(ctx context.Context) (*eventhub.Client, error) {
 	c.debugf("connecting to %s", c.creds.HostName)
-	eh, err := eventhub.Dial(c.creds.HostName, &tls.Config{
-		ServerName: c.creds.HostName,
-		RootCAs:    common.RootCAs(),
-	})
+	eh, err := eventhub.DialContext(ctx, c.dialer, c.creds.HostName, c.tlsConfig(c.creds.HostName),
+		amqp.ConnProperty(userAgentProperty, common.UserAgent(c.product)),
+	)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer func() {
 		if err != nil {
@@ -133,15 +492,15 @@ func (c *Client) Connect(ctx context.Context) error {
 		}
 	}()
 
+	eh.SetPutTokenHandler(c.putTokenHandler)
 	sas, err := c.creds.SAS(c.creds.HostName, time.Hour)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if err = eh.PutTokenContinuously(ctx, c.creds.HostName, sas, c.done); err != nil {
-		return err
+		return nil, err
 	}
-	c.conn = eh
-	return nil
+	return eh, nil
 }
 
 // Subscribing to C2D events requires connection to an eventhub instance,
@@ -155,8 +514,10 @@ func (c *Client) connectToEventHub(ctx context.Context) (*amqp.Client, string, e
 		return nil, "", err
 	}
 
-	addr := "amqps://" + c.creds.HostName
-	conn, err := amqp.Dial(addr, amqp.ConnSASLPlain(user, pass))
+	conn, err := commonamqp.Dial(ctx, c.dialer, c.creds.HostName, c.tlsConfig(c.creds.HostName),
+		amqp.ConnSASLPlain(user, pass),
+		amqp.ConnProperty(userAgentProperty, common.UserAgent(c.product)),
+	)
 	if err != nil {
 		return nil, "", err
 	}
@@ -193,8 +554,10 @@ func (c *Client) connectToEventHub(ctx context.Context) (*amqp.Client, string, e
 	group := rerr.RemoteError.Info["address"].(string)
 	group = group[strings.Index(group, ":5671/")+6 : len(group)-1]
 
-	addr = "amqps://" + rerr.RemoteError.Info["hostname"].(string)
-	conn, err = amqp.Dial(addr, amqp.ConnSASLPlain(c.creds.SharedAccessKeyName, c.creds.SharedAccessKey))
+	host := rerr.RemoteError.Info["hostname"].(string)
+	conn, err = commonamqp.Dial(ctx, c.dialer, host, c.tlsConfig(host),
+		amqp.ConnSASLPlain(c.creds.SharedAccessKeyName, c.creds.SharedAccessKey),
+	)
 	if err != nil {
 		return nil, "", err
 	}
@@ -207,7 +570,7 @@ type MessageHandler func(e *common.Message)
 // SubscribeEvents subscribes to device events.
 // No need to call Connect first, because this method different connect
 // method that dials an eventhub instance first opposed to SendEvent func.
-func (c *Client) SubscribeEvents(ctx context.Context, fn MessageHandler) error {
+func (c *Client) SubscribeEvents(ctx context.Context, fn MessageHandler, opts ...SubscribeOption) error {
 	conn, group, err := c.connectToEventHub(ctx)
 	if err != nil {
 		return err
@@ -220,11 +583,63 @@ func (c *Client) SubscribeEvents(ctx context.Context, fn MessageHandler) error {
 	}
 	defer sess.Close()
 
+	o := c.newSubscribeOptions(opts)
+	deliver := func(msg *common.Message) {
+		go func() {
+			defer common.Recover(c.reportError)
+			fn(msg)
+		}()
+	}
+	if o.workers > 0 {
+		d := newOrderedDispatcher(o.workers, fn, c.reportError)
+		defer d.close()
+		deliver = d.dispatch
+	}
+	h := o.filter(deliver)
 	return eventhub.SubscribePartitions(ctx, sess, group, "$Default", func(msg *amqp.Message) {
-		go fn(commonamqp.FromAMQPMessage(msg))
+		h(c.fromAMQPMessage(msg, o.lazy))
 	})
 }
 
+// MessageContextHandler handles incoming events,
+// the context is canceled when the subscription ends.
+type MessageContextHandler func(ctx context.Context, e *common.Message)
+
+// SubscribeEventsContext is like SubscribeEvents but fn is called with a context
+// derived from ctx that's canceled when the subscription ends or the client is closed.
+func (c *Client) SubscribeEventsContext(ctx context.Context, fn MessageContextHandler, opts ...SubscribeOption) error {
+	if fn == nil {
+		panic("fn is nil")
+	}
+	hctx, cancel := common.WithDone(ctx, c.done)
+	defer cancel()
+	return c.SubscribeEvents(hctx, func(msg *common.Message) {
+		fn(hctx, msg)
+	}, opts...)
+}
+
+// fromAMQPMessage converts msg transparently decompressing its payload
+// when it's compressed with one of the iotutil supported encodings,
+// properties are parsed on the first access when lazy is true.
+func (c *Client) fromAMQPMessage(msg *amqp.Message, lazy bool) *common.Message {
+	if !lazy {
+		return c.decompress(commonamqp.FromAMQPMessage(msg))
+	}
+	return c.decompress(commonamqp.FromAMQPMessageLazy(msg))
+}
+
+func (c *Client) decompress(m *common.Message) *common.Message {
+	if iotutil.IsCompressed(m.ContentEncoding) {
+		b, err := iotutil.Decompress(m.ContentEncoding, m.Payload)
+		if err != nil {
+			c.reportError(fmt.Errorf("decompress error: %w", err))
+			return m
+		}
+		m.Payload, m.ContentEncoding = b, ""
+	}
+	return m
+}
+
 // SendOption is a send option.
 type SendOption func(msg *common.Message) error
 
@@ -289,6 +704,42 @@ func WithSentExpiryTime(t time.Time) SendOption {
 	}
 }
 
+// WithSendTTL sets message time-to-live, when it's elapsed before
+// the device completes the message it expires and a negative feedback
+// record is produced if it was requested with `WithSendAck`.
+//
+// Lock duration and the maximum delivery count are configured
+// on the hub level and cannot be changed per message.
+func WithSendTTL(d time.Duration) SendOption {
+	return func(msg *common.Message) error {
+		if d <= 0 {
+			return errors.New("ttl must be positive")
+		}
+		setTransportOption(msg, commonamqp.TTLOption, d)
+		return nil
+	}
+}
+
+// WithSendAnnotation sets an AMQP message annotation.
+func WithSendAnnotation(k string, v interface{}) SendOption {
+	return func(msg *common.Message) error {
+		a, _ := msg.TransportOptions[commonamqp.AnnotationsOption].(map[string]interface{})
+		if a == nil {
+			a = map[string]interface{}{}
+			setTransportOption(msg, commonamqp.AnnotationsOption, a)
+		}
+		a[k] = v
+		return nil
+	}
+}
+
+func setTransportOption(msg *common.Message, k string, v interface{}) {
+	if msg.TransportOptions == nil {
+		msg.TransportOptions = map[string]interface{}{}
+	}
+	msg.TransportOptions[k] = v
+}
+
 // WithSendProperty sets a message property.
 func WithSendProperty(k, v string) SendOption {
 	return func(msg *common.Message) error {
@@ -313,6 +764,20 @@ func WithSendProperties(m map[string]string) SendOption {
 	}
 }
 
+// WithSendModule addresses the message to the named module of the device,
+// it's delivered to module clients subscribed to cloud-to-device messages
+// and the destination is available to them in the message To field.
+func WithSendModule(moduleID string) SendOption {
+	return func(msg *common.Message) error {
+		if moduleID == "" {
+			return errors.New("moduleID is empty")
+		}
+		msg.To = strings.TrimSuffix(msg.To, "/messages/devicebound") +
+			"/modules/" + moduleID + "/messages/devicebound"
+		return nil
+	}
+}
+
 // SendEvent sends the given cloud-to-device message and returns its id.
 // Panics when event is nil.
 func (c *Client) SendEvent(
@@ -321,79 +786,173 @@ func (c *Client) SendEvent(
 	payload []byte,
 	opts ...SendOption,
 ) error {
+	msg, err := c.newMessage(deviceID, payload, opts)
+	if err != nil {
+		return err
+	}
+	return c.sendMessage(ctx, msg)
+}
+
+// newMessage creates a cloud-to-device message applying the given options.
+func (c *Client) newMessage(deviceID string, payload []byte, opts []SendOption) (*common.Message, error) {
 	if deviceID == "" {
-		return errors.New("device id is empty")
+		return nil, errors.New("device id is empty")
 	}
 	if payload == nil {
-		return errors.New("payload is nil")
-	}
-
-	if err := c.Connect(ctx); err != nil {
-		return err
+		return nil, errors.New("payload is nil")
 	}
-
 	msg := &common.Message{
 		Payload: payload,
 		To:      "/devices/" + deviceID + "/messages/devicebound",
 	}
 	for _, opt := range opts {
 		if err := opt(msg); err != nil {
-			return err
+			return nil, err
 		}
 	}
+	if msg.MessageID == "" && c.midgen != nil {
+		var err error
+		if msg.MessageID, err = c.midgen(); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
 
-	// opening a new link for every message is not the most efficient way
-	send, err := c.conn.Sess().NewSender(
-		amqp.LinkTargetAddress("/messages/devicebound"),
-	)
+func (c *Client) sendMessage(ctx context.Context, msg *common.Message) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	conn, err := c.connect(ctx)
 	if err != nil {
 		return err
 	}
-	defer send.Close()
-	return send.Send(ctx, commonamqp.ToAMQPMessage(msg))
+
+	return c.checkConn(conn, c.nextSender().transmit(ctx, conn, commonamqp.ToAMQPMessage(msg)))
 }
 
 // FeedbackHandler handles message feedback.
 type FeedbackHandler func(f *Feedback)
 
 // SubscribeFeedback subscribes to feedback of messages that ack was requested.
+//
+// The feedback endpoint is a single queue shared by all receivers of the hub,
+// so concurrent subscribers compete for feedback records rather than each
+// of them getting a copy, and records that are not received within the hub's
+// feedback TTL are dropped, i.e. feedback is best-effort and may be lost.
+// The function blocks until ctx is done or an error occurs.
 func (c *Client) SubscribeFeedback(ctx context.Context, fn FeedbackHandler) error {
-	if err := c.Connect(ctx); err != nil {
+	return c.receiveFeedback(ctx, func(f *Feedback) bool {
+		go func() {
+			defer common.Recover(c.reportError)
+			fn(f)
+		}()
+		return true
+	})
+}
+
+// receiveFeedback calls fn for each received feedback record
+// until fn returns false, ctx is done or an error occurs.
+func (c *Client) receiveFeedback(ctx context.Context, fn func(f *Feedback) bool) error {
+	conn, err := c.connect(ctx)
+	if err != nil {
 		return err
 	}
-	recv, err := c.conn.Sess().NewReceiver(
+	recv, err := conn.Sess().NewReceiver(
 		amqp.LinkSourceAddress("/messages/servicebound/feedback"),
 	)
 	if err != nil {
-		return err
+		return c.checkConn(conn, err)
 	}
 	defer recv.Close()
 
 	for {
 		msg, err := recv.Receive(ctx)
 		if err != nil {
+			return c.checkConn(conn, err)
+		}
+		ok, err := dispatchFeedback(msg.GetData(), fn)
+		if err != nil {
+			msg.Accept() // it cannot be parsed anyway
 			return err
 		}
+		if !ok {
+			// records are accepted in batches, so the whole
+			// batch is redelivered to the next receiver
+			msg.Release()
+			return nil
+		}
 		msg.Accept()
+	}
+}
 
-		var v []*Feedback
-		if err = json.Unmarshal(msg.Data[0], &v); err != nil {
-			return err
-		}
-		for _, f := range v {
-			go fn(f)
+// dispatchFeedback calls fn for each record of the feedback batch b
+// until it returns false, it reports whether all of them are consumed.
+func dispatchFeedback(b []byte, fn func(f *Feedback) bool) (bool, error) {
+	var v []*Feedback
+	if err := json.Unmarshal(b, &v); err != nil {
+		return false, err
+	}
+	for _, f := range v {
+		if !fn(f) {
+			return false, nil
 		}
 	}
+	return true, nil
 }
 
+// FeedbackContextHandler handles message feedback,
+// the context is canceled when the subscription ends.
+type FeedbackContextHandler func(ctx context.Context, f *Feedback)
+
+// SubscribeFeedbackContext is like SubscribeFeedback but fn is called with a context
+// derived from ctx that's canceled when the subscription ends or the client is closed.
+func (c *Client) SubscribeFeedbackContext(ctx context.Context, fn FeedbackContextHandler) error {
+	if fn == nil {
+		panic("fn is nil")
+	}
+	hctx, cancel := common.WithDone(ctx, c.done)
+	defer cancel()
+	return c.SubscribeFeedback(hctx, func(f *Feedback) {
+		fn(hctx, f)
+	})
+}
+
+// FeedbackStatus is the outcome of a cloud-to-device message delivery.
+type FeedbackStatus string
+
+// Feedback statuses.
+const (
+	// FeedbackSuccess the message is completed by the device.
+	FeedbackSuccess FeedbackStatus = "Success"
+
+	// FeedbackExpired the message expired before it's completed.
+	FeedbackExpired FeedbackStatus = "Expired"
+
+	// FeedbackDeliveryCountExceeded the message is abandoned by
+	// the device more times than the hub's max delivery count.
+	FeedbackDeliveryCountExceeded FeedbackStatus = "DeliveryCountExceeded"
+
+	// FeedbackRejected the message is rejected by the device.
+	FeedbackRejected FeedbackStatus = "Rejected"
+
+	// FeedbackPurged the message is purged from the device queue.
+	FeedbackPurged FeedbackStatus = "Purged"
+)
+
 // Feedback is message feedback.
 type Feedback struct {
-	OriginalMessageID  string    `json:"originalMessageId"`
-	Description        string    `json:"description"`
-	DeviceGenerationID string    `json:"deviceGenerationId"`
-	DeviceID           string    `json:"deviceId"`
-	EnqueuedTimeUTC    time.Time `json:"enqueuedTimeUtc"`
-	StatusCode         string    `json:"statusCode"`
+	OriginalMessageID  string         `json:"originalMessageId"`
+	Description        string         `json:"description"`
+	DeviceGenerationID string         `json:"deviceGenerationId"`
+	DeviceID           string         `json:"deviceId"`
+	EnqueuedTimeUTC    time.Time      `json:"enqueuedTimeUtc"`
+	StatusCode         FeedbackStatus `json:"statusCode"`
+}
+
+// IsSuccess reports whether the message is successfully delivered.
+func (f *Feedback) IsSuccess() bool {
+	return f.StatusCode == FeedbackSuccess
 }
 
 // HostName returns service's hostname.
@@ -448,26 +1007,98 @@ type call struct {
 	Payload         map[string]interface{} `json:"payload"`
 }
 
+// MethodCallOptions are direct-method invocation options,
+// timeouts are rounded up to whole seconds.
+type MethodCallOptions struct {
+	// ConnectTimeout is how long the hub waits for the device to connect,
+	// zero means that the device has to be connected already.
+	ConnectTimeout time.Duration
+
+	// ResponseTimeout is how long the hub waits for the result,
+	// zero means the hub's default that is 30s.
+	ResponseTimeout time.Duration
+}
+
+// Hub limits of direct-method invocation timeouts.
+const (
+	MaxCallConnectTimeout  = 300 * time.Second
+	MinCallResponseTimeout = 5 * time.Second
+	MaxCallResponseTimeout = 300 * time.Second
+)
+
+// Validate checks that timeouts are within the hub limits.
+func (o *MethodCallOptions) Validate() error {
+	if o.ConnectTimeout < 0 || o.ConnectTimeout > MaxCallConnectTimeout {
+		return fmt.Errorf("connect timeout %s is out of [0, %s]",
+			o.ConnectTimeout, MaxCallConnectTimeout)
+	}
+	if o.ResponseTimeout != 0 &&
+		(o.ResponseTimeout < MinCallResponseTimeout || o.ResponseTimeout > MaxCallResponseTimeout) {
+		return fmt.Errorf("response timeout %s is out of [%s, %s]",
+			o.ResponseTimeout, MinCallResponseTimeout, MaxCallResponseTimeout)
+	}
+	return nil
+}
+
+// withDeadline sets the response timeout to the time left until
+// the ctx deadline when it's not set explicitly, so the hub gives up
+// before ctx expires. Reports whether the timeout is taken from ctx.
+func (o *MethodCallOptions) withDeadline(ctx context.Context) bool {
+	d, ok := ctx.Deadline()
+	if !ok || o.ResponseTimeout != 0 {
+		return false
+	}
+	left := time.Until(d).Truncate(time.Second) - o.ConnectTimeout
+	if left < MinCallResponseTimeout {
+		left = MinCallResponseTimeout
+	} else if left > MaxCallResponseTimeout {
+		left = MaxCallResponseTimeout
+	}
+	o.ResponseTimeout = left
+	return true
+}
+
+// seconds rounds d up to whole seconds.
+func seconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
 // CallOption is a direct-method invocation option.
-type CallOption func(c *call) error
+type CallOption func(o *MethodCallOptions) error
 
-// ConnectTimeout is connection timeout in seconds.
+// WithCallOptions sets all invocation options at once.
+func WithCallOptions(opts MethodCallOptions) CallOption {
+	return func(o *MethodCallOptions) error {
+		*o = opts
+		return nil
+	}
+}
+
+// WithCallConnectTimeout sets connection timeout in seconds.
+//
+// Deprecated: use WithCallOptions that takes durations instead.
 func WithCallConnectTimeout(seconds int) CallOption {
-	return func(c *call) error {
-		c.ConnectTimeout = seconds
+	return func(o *MethodCallOptions) error {
+		o.ConnectTimeout = time.Duration(seconds) * time.Second
 		return nil
 	}
 }
 
-// ResponseTimeout is response timeout in seconds.
+// WithCallResponseTimeout sets response timeout in seconds.
+//
+// Deprecated: use WithCallOptions that takes durations instead.
 func WithCallResponseTimeout(seconds int) CallOption {
-	return func(c *call) error {
-		c.ResponseTimeout = seconds
+	return func(o *MethodCallOptions) error {
+		o.ResponseTimeout = time.Duration(seconds) * time.Second
 		return nil
 	}
 }
 
 // Call calls the named direct method on with the given parameters.
+//
+// When the response timeout isn't set it's derived from the ctx deadline,
+// errors of invocations that time out match context.DeadlineExceeded
+// no matter whether ctx or the hub timeout expires first.
 func (c *Client) Call(
 	ctx context.Context,
 	deviceID string,
@@ -485,23 +1116,42 @@ func (c *Client) Call(
 		return nil, errors.New("payload is empty")
 	}
 
-	v := &call{
-		MethodName: methodName,
-		Payload:    payload,
-	}
+	o := &MethodCallOptions{}
 	for _, opt := range opts {
-		if err := opt(v); err != nil {
+		if err := opt(o); err != nil {
 			return nil, err
 		}
 	}
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	derived := o.withDeadline(ctx)
+	v := &call{
+		MethodName:      methodName,
+		ConnectTimeout:  seconds(o.ConnectTimeout),
+		ResponseTimeout: seconds(o.ResponseTimeout),
+		Payload:         payload,
+	}
 
 	r := &Result{}
 	if err := c.call(ctx, http.MethodPost, "twins/"+url.PathEscape(deviceID)+"/methods", nil, v, r); err != nil {
-		return nil, err
+		return nil, callError(ctx, err, derived)
 	}
 	return r, nil
 }
 
+// callError returns ctx's error when it's done, hub timeouts
+// derived from ctx are reported as context.DeadlineExceeded too.
+func callError(ctx context.Context, err error, derived bool) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if derived && errors.Is(err, common.ErrTimeout) {
+		return fmt.Errorf("%w: %w", context.DeadlineExceeded, err)
+	}
+	return err
+}
+
 // GetDevice retrieves the named device.
 func (c *Client) GetDevice(ctx context.Context, deviceID string) (*Device, error) {
 	if deviceID == "" {
@@ -529,8 +1179,9 @@ func (c *Client) CreateDevice(ctx context.Context, device *Device) (*Device, err
 	return d, nil
 }
 
-// UpdateDevice updates the named device.
-func (c *Client) UpdateDevice(ctx context.Context, device *Device) (*Device, error) {
+// UpdateDevice updates the named device, when device.ETag is set
+// the update fails if the device has been changed since it's retrieved.
+func (c *Client) UpdateDevice(ctx context.Context, device *Device, opts ...WriteOption) (*Device, error) {
 	if device == nil {
 		panic("device is nil")
 	}
@@ -538,32 +1189,99 @@ func (c *Client) UpdateDevice(ctx context.Context, device *Device) (*Device, err
 		return nil, errors.New("deviceID is empty")
 	}
 	d := &Device{}
-	if err := c.call(ctx, http.MethodPut, "devices/"+url.PathEscape(device.DeviceID), http.Header{
-		"If-Match": {"*"},
-	}, device, d); err != nil {
+	if err := c.call(ctx, http.MethodPut, "devices/"+url.PathEscape(device.DeviceID),
+		precondition(device.ETag, opts), device, d,
+	); err != nil {
 		return nil, err
 	}
 	return d, nil
 }
 
-func (c *Client) DeleteDevice(ctx context.Context, deviceID string) error {
+// DeleteDevice deletes the named device, it's unconditional
+// unless an etag is passed with WithIfMatch.
+func (c *Client) DeleteDevice(ctx context.Context, deviceID string, opts ...WriteOption) error {
 	if deviceID == "" {
 		return errors.New("deviceID is empty")
 	}
-	return c.call(ctx, http.MethodDelete, "devices/"+url.PathEscape(deviceID), http.Header{
-		"If-Match": {"*"},
-	}, nil, nil)
+	return c.call(ctx, http.MethodDelete, "devices/"+url.PathEscape(deviceID),
+		precondition("", opts), nil, nil,
+	)
+}
+
+// EnableDevice allows the named device to connect to the hub.
+func (c *Client) EnableDevice(ctx context.Context, deviceID string) (*Device, error) {
+	return c.setDeviceStatus(ctx, deviceID, DeviceEnabled, "")
+}
+
+// DisableDevice prevents the named device from connecting to the hub,
+// reason is stored in the device's statusReason and can be empty.
+func (c *Client) DisableDevice(ctx context.Context, deviceID, reason string) (*Device, error) {
+	return c.setDeviceStatus(ctx, deviceID, DeviceDisabled, reason)
+}
+
+// setDeviceStatus updates the device status, the update fails
+// if the device is changed concurrently between get and update.
+func (c *Client) setDeviceStatus(ctx context.Context, deviceID, status, reason string) (*Device, error) {
+	device, err := c.GetDevice(ctx, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	device.Status, device.StatusReason = status, reason
+	return c.UpdateDevice(ctx, device)
 }
 
 // ListDevices lists all registered devices.
 func (c *Client) ListDevices(ctx context.Context) ([]*Device, error) {
-	l := make([]*Device, 0)
-	if err := c.call(ctx, http.MethodGet, "devices", nil, nil, &l); err != nil {
+	return c.ListDevicesPager(ctx).All()
+}
+
+// ListDevicesPager returns a pager over the device registry,
+// the registry API isn't paged, so it's always a single page.
+func (c *Client) ListDevicesPager(ctx context.Context) *Pager[*Device] {
+	return NewPager(ctx, func(ctx context.Context, _ string) ([]*Device, string, error) {
+		l := make([]*Device, 0)
+		if err := c.call(ctx, http.MethodGet, "devices", nil, nil, &l); err != nil {
+			return nil, "", err
+		}
+		return l, "", nil
+	})
+}
+
+// ListModules lists all modules of the named device.
+func (c *Client) ListModules(ctx context.Context, deviceID string) ([]*Module, error) {
+	if deviceID == "" {
+		return nil, errors.New("deviceID is empty")
+	}
+	l := make([]*Module, 0)
+	if err := c.call(ctx, http.MethodGet, "devices/"+url.PathEscape(deviceID)+"/modules", nil, nil, &l); err != nil {
 		return nil, err
 	}
 	return l, nil
 }
 
+// PurgeResult is the result of a cloud-to-device queue purge.
+type PurgeResult struct {
+	DeviceID            string `json:"deviceId"`
+	ModuleID            string `json:"moduleId,omitempty"`
+	TotalMessagesPurged int    `json:"totalMessagesPurged"`
+}
+
+// PurgeQueue deletes all pending cloud-to-device messages of the named device,
+// feedback of every purged message is reported with FeedbackPurged status.
+//
+// IoT Hub doesn't allow deleting individual messages, so to cancel
+// a command either purge the queue or send it with an expiry time.
+func (c *Client) PurgeQueue(ctx context.Context, deviceID string) (*PurgeResult, error) {
+	if deviceID == "" {
+		return nil, errors.New("deviceID is empty")
+	}
+	v := &PurgeResult{}
+	if err := c.call(ctx, http.MethodDelete, "devices/"+url.PathEscape(deviceID)+"/commands", nil, nil, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
 // GetTwin retrieves the named twin device from the registry.
 func (c *Client) GetTwin(ctx context.Context, deviceID string) (*Twin, error) {
 	t := &Twin{}
@@ -574,27 +1292,178 @@ func (c *Client) GetTwin(ctx context.Context, deviceID string) (*Twin, error) {
 }
 
 // UpdateTwin updates the named twin desired properties.
+// Empty etag means using twin.ETag, see ReplaceTwin.
 func (c *Client) UpdateTwin(
 	ctx context.Context,
 	deviceID string,
 	twin *Twin,
 	etag string,
+	opts ...WriteOption,
+) (*Twin, error) {
+	if deviceID == "" {
+		return nil, errors.New("deviceID is empty")
+	}
+	if twin == nil {
+		panic("twin is nil")
+	}
+	t := &Twin{}
+	if err := c.call(ctx, http.MethodPatch, "twins/"+url.PathEscape(deviceID),
+		precondition(twinETag(twin, etag), opts), twin, t,
+	); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ReplaceTwin replaces the named twin tags and desired properties
+// entirely, unlike UpdateTwin properties missing in twin are removed.
+// Empty etag means using twin.ETag, when both of them are empty
+// or WithForce is passed the replacement is unconditional.
+func (c *Client) ReplaceTwin(
+	ctx context.Context,
+	deviceID string,
+	twin *Twin,
+	etag string,
+	opts ...WriteOption,
+) (*Twin, error) {
+	if deviceID == "" {
+		return nil, errors.New("deviceID is empty")
+	}
+	if twin == nil {
+		panic("twin is nil")
+	}
+	t := &Twin{}
+	if err := c.call(ctx, http.MethodPut, "twins/"+url.PathEscape(deviceID),
+		precondition(twinETag(twin, etag), opts), twin, t,
+	); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// GetModuleTwin retrieves the named module twin.
+func (c *Client) GetModuleTwin(ctx context.Context, deviceID, moduleID string) (*Twin, error) {
+	if deviceID == "" {
+		return nil, errors.New("deviceID is empty")
+	}
+	if moduleID == "" {
+		return nil, errors.New("moduleID is empty")
+	}
+	t := &Twin{}
+	if err := c.call(ctx, http.MethodGet, moduleTwinPath(deviceID, moduleID), nil, nil, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// UpdateModuleTwin updates the named module twin desired properties.
+func (c *Client) UpdateModuleTwin(
+	ctx context.Context,
+	deviceID, moduleID string,
+	twin *Twin,
+	etag string,
+	opts ...WriteOption,
+) (*Twin, error) {
+	return c.putModuleTwin(ctx, http.MethodPatch, deviceID, moduleID, twin, etag, opts)
+}
+
+// ReplaceModuleTwin is the same as ReplaceTwin but for module twins.
+func (c *Client) ReplaceModuleTwin(
+	ctx context.Context,
+	deviceID, moduleID string,
+	twin *Twin,
+	etag string,
+	opts ...WriteOption,
+) (*Twin, error) {
+	return c.putModuleTwin(ctx, http.MethodPut, deviceID, moduleID, twin, etag, opts)
+}
+
+func (c *Client) putModuleTwin(
+	ctx context.Context,
+	method, deviceID, moduleID string,
+	twin *Twin,
+	etag string,
+	opts []WriteOption,
 ) (*Twin, error) {
 	if deviceID == "" {
 		return nil, errors.New("deviceID is empty")
 	}
+	if moduleID == "" {
+		return nil, errors.New("moduleID is empty")
+	}
 	if twin == nil {
 		panic("twin is nil")
 	}
 	t := &Twin{}
-	if err := c.call(ctx, http.MethodPatch, "twins/"+url.PathEscape(deviceID), http.Header{
-		"If-Match": []string{etag},
-	}, twin, t); err != nil {
+	if err := c.call(ctx, method, moduleTwinPath(deviceID, moduleID),
+		precondition(twinETag(twin, etag), opts), twin, t,
+	); err != nil {
 		return nil, err
 	}
 	return t, nil
 }
 
+func moduleTwinPath(deviceID, moduleID string) string {
+	return "twins/" + url.PathEscape(deviceID) + "/modules/" + url.PathEscape(moduleID)
+}
+
+// ifMatch returns the If-Match header value for the given etag,
+// empty etag matches any resource version.
+func ifMatch(etag string) string {
+	if etag == "" {
+		return "*"
+	}
+	return etag
+}
+
+// WriteOption is a registry write operation option.
+type WriteOption func(o *writeOptions)
+
+type writeOptions struct {
+	force bool
+	etag  string
+}
+
+// WithForce makes the operation unconditional ignoring etags,
+// so it overwrites changes made by others concurrently.
+func WithForce() WriteOption {
+	return func(o *writeOptions) {
+		o.force = true
+	}
+}
+
+// WithIfMatch makes the operation succeed only when the
+// resource etag matches, it takes precedence over etags
+// of the passed entities but not over WithForce.
+func WithIfMatch(etag string) WriteOption {
+	return func(o *writeOptions) {
+		o.etag = etag
+	}
+}
+
+// precondition returns the If-Match header for the given entity etag and options.
+func precondition(etag string, opts []WriteOption) http.Header {
+	o := &writeOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	switch {
+	case o.force:
+		etag = "*"
+	case o.etag != "":
+		etag = o.etag
+	}
+	return http.Header{"If-Match": {ifMatch(etag)}}
+}
+
+// twinETag returns the explicitly passed etag falling back to twin's one.
+func twinETag(twin *Twin, etag string) string {
+	if etag != "" {
+		return etag
+	}
+	return twin.ETag
+}
+
 // Stats retrieves the device registry statistic.
 func (c *Client) Stats(ctx context.Context) (*Stats, error) {
 	v := &Stats{}
@@ -608,13 +1477,13 @@ func (c *Client) ImportDevicesFromBlob(
 	ctx context.Context,
 	inputBlobURL string,
 	outputBlobURL string,
-) (map[string]interface{}, error) {
-	var v map[string]interface{}
-	if err := c.call(ctx, http.MethodGet, "jobs/create", nil, map[string]interface{}{
-		"type":                   "import",
-		"inputBlobContainerUri":  inputBlobURL,
-		"outputBlobContainerUri": outputBlobURL,
-	}, &v); err != nil {
+) (*Job, error) {
+	v := &Job{}
+	if err := c.call(ctx, http.MethodGet, "jobs/create", nil, &Job{
+		Type:                   models.JobImport,
+		InputBlobContainerURI:  inputBlobURL,
+		OutputBlobContainerURI: outputBlobURL,
+	}, v); err != nil {
 		return nil, err
 	}
 	return v, nil
@@ -624,37 +1493,66 @@ func (c *Client) ExportDevicesToBlob(
 	ctx context.Context,
 	outputBlobURL string,
 	excludeKeys bool,
-) (map[string]interface{}, error) {
-	var v map[string]interface{}
-	if err := c.call(ctx, http.MethodGet, "jobs/create", nil, map[string]interface{}{
-		"type": "export",
-		"outputBlobContainerUri": outputBlobURL,
-		"excludeKeysInExport":    excludeKeys,
-	}, &v); err != nil {
+) (*Job, error) {
+	v := &Job{}
+	if err := c.call(ctx, http.MethodGet, "jobs/create", nil, &Job{
+		Type:                   models.JobExport,
+		OutputBlobContainerURI: outputBlobURL,
+		ExcludeKeysInExport:    excludeKeys,
+	}, v); err != nil {
 		return nil, err
 	}
 	return v, nil
 }
 
-func (c *Client) ListJobs(ctx context.Context) ([]map[string]interface{}, error) {
-	var v []map[string]interface{}
+// QueryJobs returns scheduled jobs of the given type and status,
+// empty values match any type and status.
+func (c *Client) QueryJobs(ctx context.Context, jobType, status string) ([]*Job, error) {
+	return c.QueryJobsPager(ctx, jobType, status).All()
+}
+
+// QueryJobsPager returns a pager over scheduled jobs, see QueryJobs.
+func (c *Client) QueryJobsPager(ctx context.Context, jobType, status string) *Pager[*Job] {
+	q := url.Values{}
+	if jobType != "" {
+		q.Set("jobType", jobType)
+	}
+	if status != "" {
+		q.Set("jobStatus", status)
+	}
+	path := "jobs/v2/query"
+	if len(q) != 0 {
+		path += "?" + q.Encode()
+	}
+	return NewPager(ctx, func(ctx context.Context, token string) ([]*Job, string, error) {
+		var v []*Job
+		res, err := c.do(ctx, http.MethodGet, path, pageHeader(token), nil, &v)
+		if err != nil {
+			return nil, "", err
+		}
+		return v, res.Get("X-Ms-Continuation"), nil
+	})
+}
+
+func (c *Client) ListJobs(ctx context.Context) ([]*Job, error) {
+	var v []*Job
 	if err := c.call(ctx, http.MethodGet, "jobs", nil, nil, &v); err != nil {
 		return nil, err
 	}
 	return v, nil
 }
 
-func (c *Client) GetJob(ctx context.Context, jobID string) (map[string]interface{}, error) {
-	var v map[string]interface{}
-	if err := c.call(ctx, http.MethodGet, "jobs/"+url.PathEscape(jobID), nil, nil, &v); err != nil {
+func (c *Client) GetJob(ctx context.Context, jobID string) (*Job, error) {
+	v := &Job{}
+	if err := c.call(ctx, http.MethodGet, "jobs/"+url.PathEscape(jobID), nil, nil, v); err != nil {
 		return nil, err
 	}
 	return v, nil
 }
 
-func (c *Client) CancelJob(ctx context.Context, jobID string) (map[string]interface{}, error) {
-	var v map[string]interface{}
-	if err := c.call(ctx, http.MethodDelete, "jobs/"+url.PathEscape(jobID), nil, nil, &v); err != nil {
+func (c *Client) CancelJob(ctx context.Context, jobID string) (*Job, error) {
+	v := &Job{}
+	if err := c.call(ctx, http.MethodDelete, "jobs/"+url.PathEscape(jobID), nil, nil, v); err != nil {
 		return nil, err
 	}
 	return v, nil
@@ -669,34 +1567,122 @@ func (c *Client) call(
 	headers http.Header,
 	r, v interface{}, // request and response objects
 ) error {
+	_, err := c.do(ctx, method, path, headers, r, v)
+	return err
+}
+
+// do is the same as call but also returns response headers.
+func (c *Client) do(
+	ctx context.Context, method, path string,
+	headers http.Header,
+	r, v interface{}, // request and response objects
+) (http.Header, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
 	var b []byte
 	if r != nil {
 		var err error
 		b, err = json.Marshal(r)
 		if err != nil {
+			return nil, err
+		}
+	}
+
+	uri := "https://" + c.creds.HostName + "/" + path
+	if strings.ContainsRune(path, '?') {
+		uri += "&api-version=" + common.APIVersion
+	} else {
+		uri += "?api-version=" + common.APIVersion
+	}
+
+	var res *http.Response
+	var body []byte
+	policy, attempts := c.retry, c.retryAttempts
+	if policy == nil {
+		policy, attempts = backoff.Constant(0), 1
+	}
+	safe := isIdempotent(method, headers)
+	if err := backoff.Retry(ctx, policy, attempts, func(attempt int) error {
+		var err error
+		res, body, err = c.roundTrip(ctx, method, uri, headers, b)
+		if err != nil {
+			if ctx.Err() != nil || !safe {
+				return backoff.Permanent(err)
+			}
 			return err
 		}
+		switch res.StatusCode {
+		case http.StatusTooManyRequests:
+			return errRetryableStatus
+		case http.StatusInternalServerError,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			if safe {
+				return errRetryableStatus
+			}
+		}
+		return nil
+	}); err != nil && err != errRetryableStatus {
+		return nil, err
+	}
+	c.debugf("%s %s %d:\n%s\n%s", method, uri, res.StatusCode, prefix(b, "> "), prefix(body, "< "))
+	info := &RequestInfo{
+		Method:     method,
+		Path:       path,
+		StatusCode: res.StatusCode,
+		RequestID:  res.Header.Get("X-Ms-Request-Id"),
+		ErrorCode:  res.Header.Get("Iothub-Errorcode"),
+	}
+	if fn, ok := ctx.Value(requestCallbackKey{}).(func(*RequestInfo)); ok {
+		fn(info)
 	}
+	if v == nil && res.StatusCode == http.StatusNoContent {
+		return res.Header, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, &RequestError{RequestInfo: *info, Body: string(body)}
+	}
+	return res.Header, json.Unmarshal(body, v)
+}
+
+var errRetryableStatus = errors.New("retryable status code")
+
+// isIdempotent reports whether repeating the request cannot change
+// the outcome, that's true for conditional writes of any method.
+func isIdempotent(method string, headers http.Header) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions,
+		http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return headers.Get("If-Match") != ""
+}
 
-	uri := "https://" + c.creds.HostName + "/" + path + "?api-version=" + common.APIVersion
+// roundTrip makes a single authorized request and reads the response body.
+func (c *Client) roundTrip(
+	ctx context.Context, method, uri string,
+	headers http.Header,
+	b []byte,
+) (*http.Response, []byte, error) {
 	req, err := http.NewRequest(method, uri, bytes.NewReader(b))
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	sas, err := c.creds.SAS(c.creds.HostName, time.Hour)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	rid, err := eventhub.RandString()
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	req = req.WithContext(ctx)
 	req.Header.Set("Content-Type", "application/json; charset=utf-8")
 	req.Header.Set("Authorization", sas)
 	req.Header.Set("Request-Id", rid)
+	req.Header.Set("User-Agent", common.UserAgent(c.product))
 	if headers != nil {
 		for k, v := range headers {
 			if len(v) != 1 {
@@ -708,22 +1694,15 @@ func (c *Client) call(
 
 	res, err := c.http.Do(req)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	defer res.Body.Close()
 
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		return err
-	}
-	c.debugf("%s %s %d:\n%s\n%s", method, uri, res.StatusCode, prefix(b, "> "), prefix(body, "< "))
-	if v == nil && res.StatusCode == http.StatusNoContent {
-		return nil
-	}
-	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("code = %d, desc = %q", res.StatusCode, string(body))
+		return nil, nil, err
 	}
-	return json.Unmarshal(body, v)
+	return res, body, nil
 }
 
 func prefix(s []byte, prefix string) string {
@@ -738,20 +1717,69 @@ func prefix(s []byte, prefix string) string {
 	return b.String()
 }
 
-func (c *Client) logf(format string, v ...interface{}) {
-	if c.logger != nil {
-		c.logger.Printf(format, v...)
+func (c *Client) logAt(l LogLevel, format string, v ...interface{}) {
+	if c.logger == nil || c.level < l {
+		return
+	}
+	s := fmt.Sprintf(format, v...)
+	if !c.noRedact {
+		s = iotutil.Redact(s)
 	}
+	c.logger.Print(s)
+}
+
+// reportError logs errors of library-owned goroutines
+// and passes them to the error handler when it's set.
+func (c *Client) reportError(err error) {
+	c.errorf("%s", err)
+	if c.onError != nil {
+		c.onError(err)
+	}
+}
+
+func (c *Client) errorf(format string, v ...interface{}) {
+	c.logAt(LogLevelError, format, v...)
+}
+
+func (c *Client) logf(format string, v ...interface{}) {
+	c.logAt(LogLevelInfo, format, v...)
 }
 
 func (c *Client) debugf(format string, v ...interface{}) {
-	if c.debug {
-		c.logf(format, v...)
+	c.logAt(LogLevelDebug, format, v...)
+}
+
+// withTimeout applies the default timeout to ctx if it has no deadline.
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || c.timeout == 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, c.timeout)
 }
 
-// Close closes transport.
+// Close closes transport, it's bounded by the default timeout if it's set.
 func (c *Client) Close() error {
+	ctx, cancel := c.withTimeout(context.Background())
+	defer cancel()
+	return c.CloseWithContext(ctx)
+}
+
+// CloseWithContext closes transport but stops waiting for
+// the connection to be closed gracefully when ctx is done.
+func (c *Client) CloseWithContext(ctx context.Context) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.close()
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Client) close() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	select {
@@ -0,0 +1,333 @@
+package iotservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+	"github.com/amenzhinsky/golang-iothub/common/backoff"
+)
+
+// newTestClient returns a client which REST requests are served by h.
+func newTestClient(t *testing.T, h http.HandlerFunc, opts ...ClientOption) *Client {
+	t.Helper()
+	s := httptest.NewTLSServer(h)
+	t.Cleanup(s.Close)
+	c, err := NewClient(append([]ClientOption{
+		WithConnectionString("HostName=" + strings.TrimPrefix(s.URL, "https://") +
+			";SharedAccessKeyName=owner;SharedAccessKey=c2VjcmV0"),
+		WithHTTPClient(s.Client()),
+	}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestPrecondition(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		etag string
+		opts []WriteOption
+		want string
+	}{
+		{"", nil, "*"},
+		{"e1", nil, "e1"},
+		{"e1", []WriteOption{WithIfMatch("e2")}, "e2"},
+		{"e1", []WriteOption{WithIfMatch("e2"), WithForce()}, "*"},
+	} {
+		if g := precondition(tc.etag, tc.opts).Get("If-Match"); g != tc.want {
+			t.Errorf("precondition(%q) = %q, want %q", tc.etag, g, tc.want)
+		}
+	}
+}
+
+func TestFeedback(t *testing.T) {
+	t.Parallel()
+
+	var f Feedback
+	if err := json.Unmarshal([]byte(`{
+		"originalMessageId": "1",
+		"statusCode": "DeliveryCountExceeded",
+		"enqueuedTimeUtc": "2018-06-01T10:20:30.123Z"
+	}`), &f); err != nil {
+		t.Fatal(err)
+	}
+	if f.StatusCode != FeedbackDeliveryCountExceeded || f.IsSuccess() {
+		t.Errorf("StatusCode = %q", f.StatusCode)
+	}
+	if w := time.Date(2018, 6, 1, 10, 20, 30, 123e6, time.UTC); !f.EnqueuedTimeUTC.Equal(w) {
+		t.Errorf("EnqueuedTimeUTC = %s, want %s", f.EnqueuedTimeUTC, w)
+	}
+}
+
+func TestFeedbackFuture(t *testing.T) {
+	t.Parallel()
+
+	c := &Client{fbPending: map[string]*pendingFeedback{}}
+	f := &FeedbackFuture{c: c, mid: "1", ch: make(chan *Feedback, 1)}
+	c.fbPending[f.mid] = &pendingFeedback{ch: f.ch, expires: time.Now().Add(time.Hour)}
+
+	c.dispatchFeedback(&Feedback{OriginalMessageID: "2"})
+	c.dispatchFeedback(&Feedback{OriginalMessageID: "1", StatusCode: FeedbackSuccess})
+	c.dispatchFeedback(&Feedback{OriginalMessageID: "1"}) // duplicate
+
+	fb, err := f.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fb.IsSuccess() {
+		t.Errorf("StatusCode = %q, want %q", fb.StatusCode, FeedbackSuccess)
+	}
+	if len(c.fbPending) != 0 {
+		t.Errorf("%d futures are pending", len(c.fbPending))
+	}
+}
+
+func TestFeedbackFuture_Expire(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	c := &Client{fbPending: map[string]*pendingFeedback{}}
+	f := &FeedbackFuture{c: c, mid: "1", ch: make(chan *Feedback, 1)}
+	c.fbPending[f.mid] = &pendingFeedback{ch: f.ch, expires: now}
+	c.fbPending["2"] = &pendingFeedback{ch: make(chan *Feedback, 1), expires: now.Add(time.Hour)}
+
+	c.sweepFeedback(now.Add(time.Second))
+	if _, err := f.Wait(context.Background()); err != ErrFeedbackExpired {
+		t.Errorf("Wait() = %v, want %v", err, ErrFeedbackExpired)
+	}
+	if _, ok := c.fbPending["2"]; !ok || len(c.fbPending) != 1 {
+		t.Errorf("pending = %v, want only the unexpired future", c.fbPending)
+	}
+}
+
+func TestRequestError(t *testing.T) {
+	t.Parallel()
+
+	err := &RequestError{
+		RequestInfo: RequestInfo{
+			StatusCode: 404,
+			RequestID:  "rid",
+			ErrorCode:  "DeviceNotFound",
+		},
+		Body: "not found",
+	}
+	w := `code = 404, desc = "not found", errorcode = DeviceNotFound, request-id = rid`
+	if err.Error() != w {
+		t.Errorf("Error() = %q, want %q", err.Error(), w)
+	}
+	if !errors.Is(err, common.ErrNotFound) || errors.Is(err, common.ErrThrottled) {
+		t.Errorf("error class of %d is not %v", err.StatusCode, common.ErrNotFound)
+	}
+}
+
+func TestNextSender(t *testing.T) {
+	t.Parallel()
+
+	c := &Client{senders: make([]senderLink, 3)}
+	for i := 0; i < 6; i++ {
+		if g, w := c.nextSender(), &c.senders[i%3]; g != w {
+			t.Errorf("nextSender() #%d returned link %p, want %p", i, g, w)
+		}
+	}
+
+	// the counter wraps around without going out of range on 32-bit platforms
+	c.next = 1<<32 - 2
+	for i, w := range []int{2, 0, 0} {
+		if g := c.nextSender(); g != &c.senders[w] {
+			t.Errorf("nextSender() after wraparound #%d returned link %p, want %p", i, g, &c.senders[w])
+		}
+	}
+	if err := WithSenderLinks(0)(c); err == nil {
+		t.Error("WithSenderLinks(0) error is nil")
+	}
+}
+
+// blockingResolver fails lookups once it's released.
+type blockingResolver struct {
+	calls   int32
+	release chan struct{}
+}
+
+func (r *blockingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	atomic.AddInt32(&r.calls, 1)
+	<-r.release
+	return nil, errors.New("no such host")
+}
+
+func TestConnect_Concurrent(t *testing.T) {
+	t.Parallel()
+
+	r := &blockingResolver{release: make(chan struct{})}
+	c, err := NewClient(
+		WithConnectionString("HostName=a.net;SharedAccessKeyName=owner;SharedAccessKey=c2VjcmV0"),
+		WithDialer(&common.Dialer{Resolver: r}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var wg, started sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		started.Add(1)
+		go func() {
+			defer wg.Done()
+			started.Done()
+			if err := c.Connect(context.Background()); err == nil {
+				t.Error("Connect() = nil, want an error")
+			}
+		}()
+	}
+
+	started.Wait()
+
+	// a waiter that gives up doesn't affect the others
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err = c.Connect(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Connect() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	close(r.release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&r.calls); n != 1 {
+		t.Errorf("dialed %d times, want 1", n)
+	}
+}
+
+func TestMethodCallOptions(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		opts MethodCallOptions
+		ok   bool
+	}{
+		{MethodCallOptions{}, true},
+		{MethodCallOptions{ConnectTimeout: time.Minute, ResponseTimeout: 5 * time.Second}, true},
+		{MethodCallOptions{ConnectTimeout: -time.Second}, false},
+		{MethodCallOptions{ConnectTimeout: 301 * time.Second}, false},
+		{MethodCallOptions{ResponseTimeout: time.Second}, false},
+		{MethodCallOptions{ResponseTimeout: time.Hour}, false},
+	} {
+		if err := tc.opts.Validate(); (err == nil) != tc.ok {
+			t.Errorf("Validate(%+v) = %v, want ok = %t", tc.opts, err, tc.ok)
+		}
+	}
+
+	// deprecated options take seconds
+	o := &MethodCallOptions{}
+	for _, opt := range []CallOption{WithCallConnectTimeout(10), WithCallResponseTimeout(20)} {
+		if err := opt(o); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if o.ConnectTimeout != 10*time.Second || o.ResponseTimeout != 20*time.Second {
+		t.Errorf("options = %+v", o)
+	}
+	if g := seconds(1500 * time.Millisecond); g != 2 {
+		t.Errorf("seconds(1.5s) = %d, want 2", g)
+	}
+}
+
+func TestMethodCallOptions_Deadline(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute+time.Second/2)
+	defer cancel()
+
+	o := &MethodCallOptions{ConnectTimeout: 10 * time.Second}
+	if !o.withDeadline(ctx) {
+		t.Fatal("withDeadline() = false, want true")
+	}
+	if o.ResponseTimeout != 50*time.Second {
+		t.Errorf("ResponseTimeout = %s, want 50s", o.ResponseTimeout)
+	}
+
+	// explicit timeouts aren't overridden
+	o = &MethodCallOptions{ResponseTimeout: 30 * time.Second}
+	if o.withDeadline(ctx) || o.ResponseTimeout != 30*time.Second {
+		t.Errorf("ResponseTimeout = %s, want 30s", o.ResponseTimeout)
+	}
+
+	// the hub doesn't accept timeouts less than 5s
+	short, cancelShort := context.WithTimeout(context.Background(), time.Second)
+	defer cancelShort()
+	o = &MethodCallOptions{}
+	if !o.withDeadline(short) || o.ResponseTimeout != MinCallResponseTimeout {
+		t.Errorf("ResponseTimeout = %s, want %s", o.ResponseTimeout, MinCallResponseTimeout)
+	}
+
+	err := fmt.Errorf("code = 504: %w", common.ErrTimeout)
+	if g := callError(ctx, err, true); !errors.Is(g, context.DeadlineExceeded) || !errors.Is(g, common.ErrTimeout) {
+		t.Errorf("callError(derived) = %v, want DeadlineExceeded", g)
+	}
+	if g := callError(ctx, err, false); g != err {
+		t.Errorf("callError() = %v, want %v", g, err)
+	}
+	cancel()
+	if g := callError(ctx, err, true); g != context.Canceled {
+		t.Errorf("callError(canceled) = %v, want %v", g, context.Canceled)
+	}
+}
+
+func TestDisableDevice(t *testing.T) {
+	t.Parallel()
+
+	var updated *Device
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			w.Write([]byte(`{"deviceId":"dev","etag":"e1","status":"enabled"}`))
+		case http.MethodPut:
+			if r.Header.Get("If-Match") != "e1" {
+				t.Errorf("If-Match = %q, want %q", r.Header.Get("If-Match"), "e1")
+			}
+			updated = &Device{}
+			if err := json.NewDecoder(r.Body).Decode(updated); err != nil {
+				t.Error(err)
+			}
+			json.NewEncoder(w).Encode(updated)
+		}
+	})
+	d, err := c.DisableDevice(context.Background(), "dev", "compromised")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Status != DeviceDisabled || d.StatusReason != "compromised" {
+		t.Errorf("status = %q %q, want %q %q", d.Status, d.StatusReason, DeviceDisabled, "compromised")
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	t.Parallel()
+
+	for method, want := range map[string]int32{
+		http.MethodGet:  3,
+		http.MethodPost: 1, // not idempotent
+	} {
+		var n int32
+		c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&n, 1)
+			w.WriteHeader(http.StatusGatewayTimeout)
+		}, WithRetryPolicy(backoff.Constant(time.Millisecond), 3))
+		if err := c.call(context.Background(), method, "devices", nil, nil, nil); !errors.Is(err, common.ErrTimeout) {
+			t.Fatalf("%s: err = %v, want ErrTimeout", method, err)
+		}
+		if g := atomic.LoadInt32(&n); g != want {
+			t.Errorf("%s: attempts = %d, want %d", method, g, want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package iotservice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+	"github.com/amenzhinsky/golang-iothub/common/backoff"
+)
+
+// CommandMode is how commands are delivered to devices.
+type CommandMode int
+
+const (
+	// CommandMessage sends commands as cloud-to-device messages with
+	// the command name in CommandNameProperty, devices reply with
+	// device-to-cloud messages carrying the same correlation id.
+	CommandMessage CommandMode = iota
+
+	// CommandMethod invokes a direct method named after the command,
+	// the method result is the reply.
+	CommandMethod
+)
+
+// Properties of command messages and their replies.
+const (
+	CommandNameProperty  = "command"
+	CommandErrorProperty = "command-error" // set on failed replies
+)
+
+// CommandError is a command failure reported by a device.
+type CommandError struct {
+	Status  int // direct method status, zero for messages
+	Message string
+}
+
+func (e *CommandError) Error() string {
+	if e.Status != 0 {
+		return fmt.Sprintf("command failed: status = %d, %s", e.Status, e.Message)
+	}
+	return "command failed: " + e.Message
+}
+
+// CommanderOption is a commander configuration option.
+type CommanderOption func(cm *Commander)
+
+// WithCommandMode sets the commands delivery mode, the default is CommandMessage.
+func WithCommandMode(mode CommandMode) CommanderOption {
+	return func(cm *Commander) {
+		cm.mode = mode
+	}
+}
+
+// WithCommandTimeout sets how long a single attempt waits for a reply,
+// the default is 30 seconds.
+func WithCommandTimeout(d time.Duration) CommanderOption {
+	return func(cm *Commander) {
+		cm.timeout = d
+	}
+}
+
+// WithCommandRetries sets the number of additional attempts made
+// when a device doesn't reply in time, the default is 0.
+func WithCommandRetries(n int, p backoff.Policy) CommanderOption {
+	return func(cm *Commander) {
+		cm.retries = n
+		cm.backoff = p
+	}
+}
+
+// Commander implements the request-reply command pattern on top of
+// cloud-to-device messages or direct methods with JSON payloads.
+//
+// In CommandMessage mode replies are matched only when incoming events
+// are passed to Dispatch, e.g. by using it as a SubscribeEvents handler:
+//
+//	cm := iotservice.NewCommander(c)
+//	go c.SubscribeEvents(ctx, cm.Dispatch)
+//
+//	var res Status
+//	if err := cm.Send(ctx, "mydevice", "reboot", &Reboot{Delay: 5}, &res); err != nil {
+//		return err
+//	}
+type Commander struct {
+	c       *Client
+	r       *Correlator
+	mode    CommandMode
+	timeout time.Duration
+	retries int
+	backoff backoff.Policy
+}
+
+// NewCommander creates a commander on top of the given client.
+func NewCommander(c *Client, opts ...CommanderOption) *Commander {
+	cm := &Commander{
+		c:       c,
+		r:       NewCorrelator(c),
+		timeout: 30 * time.Second,
+		backoff: backoff.Default,
+	}
+	for _, opt := range opts {
+		opt(cm)
+	}
+	return cm
+}
+
+// Dispatch delivers command replies, see Correlator.Dispatch.
+func (cm *Commander) Dispatch(msg *common.Message) {
+	cm.r.Dispatch(msg)
+}
+
+// Send sends the named command with req encoded as JSON and waits for
+// the reply decoding it into res unless it's nil. Attempts that time out
+// are retried, failures reported by the device are returned as *CommandError.
+func (cm *Commander) Send(ctx context.Context, deviceID, name string, req, res interface{}) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	var reply []byte
+	if err = backoff.Retry(ctx, cm.backoff, cm.retries+1, func(int) error {
+		actx, cancel := context.WithTimeout(ctx, cm.timeout)
+		defer cancel()
+		if cm.mode == CommandMethod {
+			reply, err = cm.call(actx, deviceID, name, b)
+		} else {
+			reply, err = cm.send(actx, deviceID, name, b)
+		}
+		if err != nil {
+			if _, ok := err.(*CommandError); ok || ctx.Err() != nil {
+				return backoff.Permanent(err)
+			}
+		}
+		return err
+	}); err != nil {
+		return err
+	}
+	if res == nil || len(reply) == 0 {
+		return nil
+	}
+	return json.Unmarshal(reply, res)
+}
+
+func (cm *Commander) send(ctx context.Context, deviceID, name string, b []byte) ([]byte, error) {
+	deadline, _ := ctx.Deadline()
+	cid, err := cm.r.SendEvent(ctx, deviceID, b,
+		WithSendProperty(CommandNameProperty, name),
+		WithSentExpiryTime(deadline),
+	)
+	if err != nil {
+		return nil, err
+	}
+	msg, err := cm.r.Await(ctx, cid)
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := msg.Property(CommandErrorProperty); ok {
+		return nil, &CommandError{Message: s}
+	}
+	return msg.Payload, nil
+}
+
+func (cm *Commander) call(ctx context.Context, deviceID, name string, b []byte) ([]byte, error) {
+	var p map[string]interface{}
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("direct method payload must be a JSON object: %s", err)
+	}
+	if p == nil {
+		p = map[string]interface{}{}
+	}
+
+	// Call is not used because it rejects empty payloads
+	res := &Result{}
+	if err := cm.c.call(ctx, http.MethodPost, "twins/"+url.PathEscape(deviceID)+"/methods", nil, &call{
+		MethodName:      name,
+		Payload:         p,
+		ResponseTimeout: int(cm.timeout / time.Second),
+	}, res); err != nil {
+		return nil, err
+	}
+	if res.Status < 200 || res.Status > 299 {
+		msg, _ := json.Marshal(res.Payload)
+		return nil, &CommandError{Status: res.Status, Message: string(msg)}
+	}
+	return json.Marshal(res.Payload)
+}
@@ -0,0 +1,61 @@
+package iotservice
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/amenzhinsky/golang-iothub/common/backoff"
+)
+
+func TestCommanderMethod(t *testing.T) {
+	t.Parallel()
+
+	var attempts int32
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var v call
+		if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+			t.Error(err)
+		}
+		switch {
+		case atomic.AddInt32(&attempts, 1) == 1:
+			w.WriteHeader(http.StatusGatewayTimeout)
+			w.Write([]byte(`{"message":"timeout"}`))
+		case v.MethodName == "fail":
+			w.Write([]byte(`{"status":500,"payload":{"error":"boom"}}`))
+		default:
+			w.Write([]byte(`{"status":200,"payload":{"delay":` +
+				strings.TrimSpace(string(mustMarshal(t, v.Payload["delay"]))) + `}}`))
+		}
+	})
+	cm := NewCommander(c,
+		WithCommandMode(CommandMethod),
+		WithCommandTimeout(time.Second),
+		WithCommandRetries(1, backoff.Constant(time.Millisecond)),
+	)
+
+	var res struct{ Delay int }
+	if err := cm.Send(context.Background(), "dev", "reboot", map[string]int{"delay": 5}, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Delay != 5 || atomic.LoadInt32(&attempts) != 2 {
+		t.Errorf("delay = %d after %d attempts, want 5 after 2", res.Delay, attempts)
+	}
+
+	err := cm.Send(context.Background(), "dev", "fail", map[string]int{}, nil)
+	if ce, ok := err.(*CommandError); !ok || ce.Status != 500 {
+		t.Errorf("Send(fail) error = %v, want a command error with status 500", err)
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,138 @@
+package iotservice
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+// GetConfiguration retrieves the named configuration.
+func (c *Client) GetConfiguration(ctx context.Context, configID string) (*Configuration, error) {
+	if configID == "" {
+		return nil, errors.New("configID is empty")
+	}
+	v := &Configuration{}
+	if err := c.call(ctx, http.MethodGet, "configurations/"+url.PathEscape(configID), nil, nil, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// ListConfigurations lists all configurations.
+func (c *Client) ListConfigurations(ctx context.Context) ([]*Configuration, error) {
+	return c.ListConfigurationsPager(ctx).All()
+}
+
+// ListConfigurationsPager returns a pager over configurations,
+// the API isn't paged, so it's always a single page.
+func (c *Client) ListConfigurationsPager(ctx context.Context) *Pager[*Configuration] {
+	return NewPager(ctx, func(ctx context.Context, _ string) ([]*Configuration, string, error) {
+		l := make([]*Configuration, 0)
+		if err := c.call(ctx, http.MethodGet, "configurations", nil, nil, &l); err != nil {
+			return nil, "", err
+		}
+		return l, "", nil
+	})
+}
+
+// CreateConfiguration creates a new configuration.
+func (c *Client) CreateConfiguration(ctx context.Context, config *Configuration) (*Configuration, error) {
+	if config == nil {
+		panic("config is nil")
+	}
+	if config.ID == "" {
+		return nil, errors.New("configID is empty")
+	}
+	v := &Configuration{}
+	if err := c.call(ctx, http.MethodPut, "configurations/"+url.PathEscape(config.ID), nil, config, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// UpdateConfiguration updates the given configuration, only its
+// labels and metrics can be changed after it's created.
+func (c *Client) UpdateConfiguration(ctx context.Context, config *Configuration, opts ...WriteOption) (*Configuration, error) {
+	if config == nil {
+		panic("config is nil")
+	}
+	if config.ID == "" {
+		return nil, errors.New("configID is empty")
+	}
+	v := &Configuration{}
+	if err := c.call(ctx, http.MethodPut, "configurations/"+url.PathEscape(config.ID),
+		precondition(config.ETag, opts), config, v,
+	); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// DeleteConfiguration deletes the named configuration,
+// it's unconditional unless an etag is passed with WithIfMatch.
+func (c *Client) DeleteConfiguration(ctx context.Context, configID string, opts ...WriteOption) error {
+	if configID == "" {
+		return errors.New("configID is empty")
+	}
+	return c.call(ctx, http.MethodDelete, "configurations/"+url.PathEscape(configID),
+		precondition("", opts), nil, nil,
+	)
+}
+
+// System metrics names that every configuration has.
+const (
+	MetricTargetedCount = "targetedCount"
+	MetricAppliedCount  = "appliedCount"
+)
+
+// ConfigurationReport is the on-demand evaluation of configuration metrics.
+type ConfigurationReport struct {
+	ConfigurationID string
+	Targeted        int
+	Applied         int
+
+	// SystemMetrics and Metrics hold the number of devices
+	// matched by every system and custom metric query.
+	SystemMetrics map[string]int
+	Metrics       map[string]int
+}
+
+// EvaluateConfigurationMetrics runs the named configuration's system and
+// custom metric queries right away instead of waiting for the hub to
+// refresh them, every metric value is the number of matched devices.
+func (c *Client) EvaluateConfigurationMetrics(ctx context.Context, configID string) (*ConfigurationReport, error) {
+	config, err := c.GetConfiguration(ctx, configID)
+	if err != nil {
+		return nil, err
+	}
+	r := &ConfigurationReport{ConfigurationID: config.ID}
+	if config.SystemMetrics != nil {
+		if r.SystemMetrics, err = c.countQueries(ctx, config.SystemMetrics.Queries); err != nil {
+			return nil, err
+		}
+	}
+	if config.Metrics != nil {
+		if r.Metrics, err = c.countQueries(ctx, config.Metrics.Queries); err != nil {
+			return nil, err
+		}
+	}
+	r.Targeted = r.SystemMetrics[MetricTargetedCount]
+	r.Applied = r.SystemMetrics[MetricAppliedCount]
+	return r, nil
+}
+
+func (c *Client) countQueries(ctx context.Context, queries map[string]string) (map[string]int, error) {
+	m := make(map[string]int, len(queries))
+	for name, q := range queries {
+		var n int
+		if err := c.QueryFunc(ctx, q, func(map[string]interface{}) error {
+			n++
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+		m[name] = n
+	}
+	return m, nil
+}
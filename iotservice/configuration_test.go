@@ -0,0 +1,92 @@
+package iotservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestEvaluateConfigurationMetrics(t *testing.T) {
+	t.Parallel()
+
+	const (
+		targeted = "SELECT deviceId FROM devices WHERE tags.env = 'prod'"
+		applied  = "SELECT deviceId FROM devices WHERE configurations.[[cfg]].status = 'Applied'"
+		custom   = "SELECT deviceId FROM devices WHERE properties.reported.fw = '2.0'"
+		bad      = "SELECT FROM"
+	)
+	rows := map[string]string{
+		targeted: `[{"deviceId":"a"},{"deviceId":"b"},{"deviceId":"c"}]`,
+		applied:  `[{"deviceId":"a"}]`,
+		custom:   `[]`,
+	}
+	for name, tc := range map[string]struct {
+		metrics map[string]string
+		want    *ConfigurationReport
+	}{
+		"ok": {
+			metrics: map[string]string{"fw": custom},
+			want: &ConfigurationReport{
+				ConfigurationID: "cfg",
+				Targeted:        3,
+				Applied:         1,
+				SystemMetrics:   map[string]int{MetricTargetedCount: 3, MetricAppliedCount: 1},
+				Metrics:         map[string]int{"fw": 0},
+			},
+		},
+		"malformed query": {
+			metrics: map[string]string{"fw": custom, "bad": bad},
+		},
+	} {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/configurations/cfg":
+					json.NewEncoder(w).Encode(&Configuration{
+						ID: "cfg",
+						SystemMetrics: &ConfigurationMetrics{Queries: map[string]string{
+							MetricTargetedCount: targeted,
+							MetricAppliedCount:  applied,
+						}},
+						Metrics: &ConfigurationMetrics{Queries: tc.metrics},
+					})
+				case "/devices/query":
+					var v struct{ Query string }
+					if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+						t.Error(err)
+					}
+					b, ok := rows[v.Query]
+					if !ok {
+						w.WriteHeader(http.StatusBadRequest)
+						w.Write([]byte(`{"Message":"ErrorCode:SqlQueryInvalid"}`))
+						return
+					}
+					w.Write([]byte(b))
+				default:
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+					w.WriteHeader(http.StatusNotFound)
+				}
+			})
+			r, err := c.EvaluateConfigurationMetrics(context.Background(), "cfg")
+			if tc.want == nil {
+				var re *RequestError
+				if !errors.As(err, &re) || re.StatusCode != http.StatusBadRequest {
+					t.Fatalf("err = %v, want a %d request error", err, http.StatusBadRequest)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(r, tc.want) {
+				t.Errorf("EvaluateConfigurationMetrics() = %+v, want %+v", r, tc.want)
+			}
+		})
+	}
+}
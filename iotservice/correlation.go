@@ -0,0 +1,155 @@
+package iotservice
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+	"github.com/amenzhinsky/golang-iothub/eventhub"
+)
+
+// CorrelationIDKey is the direct-method payload key that
+// Correlator.Call stores the request correlation id under.
+const CorrelationIDKey = "$correlationId"
+
+// Correlator stamps outgoing cloud-to-device messages and direct-method
+// calls with unique correlation ids and matches device-to-cloud replies
+// carrying the same CorrelationID with them.
+//
+// Replies are matched only when incoming events are passed to Dispatch,
+// e.g. by using it as a SubscribeEvents handler:
+//
+//	r := iotservice.NewCorrelator(c)
+//	go c.SubscribeEvents(ctx, r.Dispatch)
+//
+//	cid, err := r.SendEvent(ctx, "mydevice", []byte(`ping`))
+//	if err != nil {
+//		return err
+//	}
+//	msg, err := r.Await(ctx, cid)
+type Correlator struct {
+	c  *Client
+	mu sync.Mutex
+	m  map[string]chan *common.Message
+}
+
+// NewCorrelator creates a new correlator on top of the given client.
+func NewCorrelator(c *Client) *Correlator {
+	if c == nil {
+		panic("client is nil")
+	}
+	return &Correlator{
+		c: c,
+		m: map[string]chan *common.Message{},
+	}
+}
+
+// SendEvent sends a cloud-to-device message with a newly generated
+// correlation id that is returned to be passed to Await.
+func (r *Correlator) SendEvent(
+	ctx context.Context,
+	deviceID string,
+	payload []byte,
+	opts ...SendOption,
+) (string, error) {
+	cid, err := r.track()
+	if err != nil {
+		return "", err
+	}
+	if err = r.c.SendEvent(ctx, deviceID, payload,
+		append(opts[:len(opts):len(opts)], WithSendCorrelationID(cid))...,
+	); err != nil {
+		r.Forget(cid)
+		return "", err
+	}
+	return cid, nil
+}
+
+// Call invokes the named direct method adding a newly generated correlation id
+// to the payload under CorrelationIDKey, so the device is able to send
+// an asynchronous reply later as a device-to-cloud message with it.
+func (r *Correlator) Call(
+	ctx context.Context,
+	deviceID string,
+	methodName string,
+	payload map[string]interface{},
+	opts ...CallOption,
+) (string, *Result, error) {
+	cid, err := r.track()
+	if err != nil {
+		return "", nil, err
+	}
+	p := make(map[string]interface{}, len(payload)+1)
+	for k, v := range payload {
+		p[k] = v
+	}
+	p[CorrelationIDKey] = cid
+	res, err := r.c.Call(ctx, deviceID, methodName, p, opts...)
+	if err != nil {
+		r.Forget(cid)
+		return "", nil, err
+	}
+	return cid, res, nil
+}
+
+// ErrUnknownCorrelationID is returned by Await when the given
+// correlation id is not tracked or has been already awaited.
+var ErrUnknownCorrelationID = errors.New("unknown correlation id")
+
+// Await blocks until a reply with the given correlation id is dispatched
+// or ctx is done, in both cases the id stops being tracked.
+func (r *Correlator) Await(ctx context.Context, cid string) (*common.Message, error) {
+	r.mu.Lock()
+	ch, ok := r.m[cid]
+	r.mu.Unlock()
+	if !ok {
+		return nil, ErrUnknownCorrelationID
+	}
+	defer r.Forget(cid)
+
+	select {
+	case msg := <-ch:
+		return msg, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Forget stops tracking the given correlation id,
+// it's needed when a reply is not going to be awaited.
+func (r *Correlator) Forget(cid string) {
+	r.mu.Lock()
+	delete(r.m, cid)
+	r.mu.Unlock()
+}
+
+// Dispatch delivers msg to the corresponding Await call,
+// messages with unknown correlation ids are ignored.
+func (r *Correlator) Dispatch(msg *common.Message) {
+	if msg.CorrelationID == "" {
+		return
+	}
+	r.mu.Lock()
+	ch, ok := r.m[msg.CorrelationID]
+	r.mu.Unlock()
+	if !ok {
+		return
+	}
+	// the buffer holds only the first reply, duplicates are dropped
+	select {
+	case ch <- msg:
+	default:
+	}
+}
+
+func (r *Correlator) track() (string, error) {
+	cid, err := eventhub.RandString()
+	if err != nil {
+		return "", err
+	}
+	r.mu.Lock()
+	r.m[cid] = make(chan *common.Message, 1)
+	r.mu.Unlock()
+	return cid, nil
+}
@@ -0,0 +1,35 @@
+package iotservice
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+)
+
+func TestCorrelator(t *testing.T) {
+	t.Parallel()
+
+	r := NewCorrelator(&Client{})
+	cid, err := r.track()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Dispatch(&common.Message{CorrelationID: "unknown"})
+	go r.Dispatch(&common.Message{CorrelationID: cid, Payload: []byte("pong")})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	msg, err := r.Await(ctx, cid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.Payload) != "pong" {
+		t.Errorf("Payload = %q, want %q", msg.Payload, "pong")
+	}
+	if _, err = r.Await(ctx, cid); err != ErrUnknownCorrelationID {
+		t.Errorf("Await() error = %v, want %v", err, ErrUnknownCorrelationID)
+	}
+}
@@ -0,0 +1,52 @@
+package iotservice
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// dedupeFilter remembers keys within a sliding time window.
+type dedupeFilter struct {
+	window time.Duration
+
+	mu   sync.Mutex
+	keys map[string]*list.Element
+	lru  *list.List // oldest keys at the front
+}
+
+type dedupeEntry struct {
+	key  string
+	time time.Time
+}
+
+func newDedupeFilter(window time.Duration) *dedupeFilter {
+	return &dedupeFilter{
+		window: window,
+		keys:   map[string]*list.Element{},
+		lru:    list.New(),
+	}
+}
+
+// seen reports whether the key has been seen within the window
+// before now and remembers it otherwise, empty keys are never seen.
+func (f *dedupeFilter) seen(key string, now time.Time) bool {
+	if key == "" {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for el := f.lru.Front(); el != nil; el = f.lru.Front() {
+		e := el.Value.(*dedupeEntry)
+		if now.Sub(e.time) < f.window {
+			break
+		}
+		f.lru.Remove(el)
+		delete(f.keys, e.key)
+	}
+	if _, ok := f.keys[key]; ok {
+		return true
+	}
+	f.keys[key] = f.lru.PushBack(&dedupeEntry{key: key, time: now})
+	return false
+}
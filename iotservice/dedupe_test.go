@@ -0,0 +1,30 @@
+package iotservice
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDedupeFilter(t *testing.T) {
+	t.Parallel()
+
+	f := newDedupeFilter(time.Minute)
+	now := time.Now()
+	for i, tc := range []struct {
+		key   string
+		after time.Duration
+		seen  bool
+	}{
+		{"a", 0, false},
+		{"a", time.Second, true},
+		{"b", 2 * time.Second, false},
+		{"", 2 * time.Second, false},
+		{"", 2 * time.Second, false},
+		{"a", time.Minute, false}, // expired
+		{"b", time.Minute, true},
+	} {
+		if g := f.seen(tc.key, now.Add(tc.after)); g != tc.seen {
+			t.Errorf("#%d: seen(%q) = %t, want %t", i, tc.key, g, tc.seen)
+		}
+	}
+}
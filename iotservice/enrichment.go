@@ -0,0 +1,38 @@
+package iotservice
+
+import (
+	"mime"
+	"strings"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+)
+
+// Enrichments returns values of the named message enrichments that
+// were applied by IoT Hub routing to the given device-to-cloud message.
+//
+// Enrichments are delivered as application properties indistinguishable
+// from the device-set ones, so keys have to match the hub configuration.
+func Enrichments(msg *common.Message, keys ...string) map[string]string {
+	m := make(map[string]string, len(keys))
+	for _, k := range keys {
+		if v, ok := msg.Property(k); ok {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+// IsRoutable reports whether routing queries can access the message body,
+// which is possible only for UTF-8 (or -16, -32) encoded JSON payloads.
+func IsRoutable(msg *common.Message) bool {
+	mt, _, err := mime.ParseMediaType(msg.ContentType)
+	if err != nil || mt != "application/json" {
+		return false
+	}
+	for _, enc := range []string{"utf-8", "utf-16", "utf-32"} {
+		if strings.EqualFold(msg.ContentEncoding, enc) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,28 @@
+package iotservice
+
+import (
+	"testing"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+)
+
+func TestIsRoutable(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		ct, ce string
+		want   bool
+	}{
+		{"application/json", "utf-8", true},
+		{"Application/JSON", "Utf-16", true},
+		{"application/json; charset=utf-8", "UTF-32", true},
+		{"application/json", "", false},
+		{"text/plain", "utf-8", false},
+		{"", "utf-8", false},
+	} {
+		msg := &common.Message{ContentType: tc.ct, ContentEncoding: tc.ce}
+		if g := IsRoutable(msg); g != tc.want {
+			t.Errorf("IsRoutable(%q, %q) = %t, want %t", tc.ct, tc.ce, g, tc.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package iotservice
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+)
+
+// RequestInfo describes a completed REST request,
+// request and error ids are needed for support cases.
+type RequestInfo struct {
+	Method     string
+	Path       string
+	StatusCode int
+	RequestID  string // x-ms-request-id header
+	ErrorCode  string // iothub-errorcode header
+}
+
+// RequestError is returned when a REST request fails with an unexpected status code.
+type RequestError struct {
+	RequestInfo
+	Body string
+}
+
+func (e *RequestError) Error() string {
+	s := fmt.Sprintf("code = %d, desc = %q", e.StatusCode, e.Body)
+	if e.ErrorCode != "" {
+		s += ", errorcode = " + e.ErrorCode
+	}
+	if e.RequestID != "" {
+		s += ", request-id = " + e.RequestID
+	}
+	return s
+}
+
+// Is reports whether the error belongs to the given
+// error class, e.g. errors.Is(err, common.ErrNotFound).
+func (e *RequestError) Is(target error) bool {
+	class := common.StatusClass(e.StatusCode)
+	return class != nil && class == target
+}
+
+type requestCallbackKey struct{}
+
+// WithRequestCallback returns a context that makes REST operations
+// called with it report every request they make to fn, e.g.:
+//
+//	ctx = iotservice.WithRequestCallback(ctx, func(ri *iotservice.RequestInfo) {
+//		log.Printf("%s %s: %d %s", ri.Method, ri.Path, ri.StatusCode, ri.RequestID)
+//	})
+//	d, err := c.GetDevice(ctx, "mydevice")
+func WithRequestCallback(ctx context.Context, fn func(ri *RequestInfo)) context.Context {
+	if fn == nil {
+		panic("fn is nil")
+	}
+	return context.WithValue(ctx, requestCallbackKey{}, fn)
+}
@@ -0,0 +1,186 @@
+package iotservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+	"github.com/amenzhinsky/golang-iothub/common/backoff"
+	"github.com/amenzhinsky/golang-iothub/iotutil"
+)
+
+// defaultFeedbackTTL is twice the hub's default cloud-to-device
+// message time-to-live leaving room for delayed feedback batches.
+const defaultFeedbackTTL = 2 * time.Hour
+
+// ErrFeedbackExpired is returned by FeedbackFuture.Wait when the message
+// feedback hasn't arrived within the TTL set with WithFeedbackTTL.
+var ErrFeedbackExpired = errors.New("feedback expired")
+
+// FeedbackFuture is a pending cloud-to-device message delivery outcome.
+type FeedbackFuture struct {
+	c   *Client
+	mid string
+	ch  chan *Feedback
+}
+
+// pendingFeedback is a future's channel tracked until it expires.
+type pendingFeedback struct {
+	ch      chan *Feedback
+	expires time.Time
+}
+
+// MessageID returns id of the sent message.
+func (f *FeedbackFuture) MessageID() string {
+	return f.mid
+}
+
+// C returns the channel that receives the message feedback once,
+// it's closed without receiving anything when the future expires.
+func (f *FeedbackFuture) C() <-chan *Feedback {
+	return f.ch
+}
+
+// Wait blocks until the message feedback arrives or ctx is done,
+// in the latter case the message stops being tracked.
+func (f *FeedbackFuture) Wait(ctx context.Context) (*Feedback, error) {
+	select {
+	case fb, ok := <-f.ch:
+		if !ok {
+			return nil, ErrFeedbackExpired
+		}
+		return fb, nil
+	case <-ctx.Done():
+		f.c.forgetFeedback(f.mid)
+		return nil, ctx.Err()
+	}
+}
+
+// SendEventAsync sends a cloud-to-device message requesting full
+// acknowledgement, unless WithSendAck is passed, and returns
+// a future that's completed when the message feedback arrives.
+//
+// The first call starts receiving feedback in the background until
+// the client is closed, since the feedback endpoint is shared it
+// shouldn't be combined with SubscribeFeedback calls on the same hub.
+// Feedback is best-effort, so futures should be waited with a deadline,
+// futures that get no feedback expire after the TTL set with WithFeedbackTTL.
+func (c *Client) SendEventAsync(
+	ctx context.Context,
+	deviceID string,
+	payload []byte,
+	opts ...SendOption,
+) (*FeedbackFuture, error) {
+	msg, err := c.newMessage(deviceID, payload,
+		append([]SendOption{WithSendAck(AckFull)}, opts...),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if msg.MessageID == "" {
+		if msg.MessageID, err = iotutil.UUID(); err != nil {
+			return nil, err
+		}
+	}
+
+	c.startFeedback()
+	f := &FeedbackFuture{c: c, mid: msg.MessageID, ch: make(chan *Feedback, 1)}
+	c.fbMu.Lock()
+	c.fbPending[f.mid] = &pendingFeedback{ch: f.ch, expires: time.Now().Add(c.fbTTL)}
+	c.fbMu.Unlock()
+	if err = c.sendMessage(ctx, msg); err != nil {
+		c.forgetFeedback(f.mid)
+		return nil, err
+	}
+	return f, nil
+}
+
+// startFeedback starts receiving feedback for futures once.
+func (c *Client) startFeedback() {
+	c.fbMu.Lock()
+	defer c.fbMu.Unlock()
+	if c.fbOn {
+		return
+	}
+	c.fbOn = true
+	if c.fbPending == nil {
+		c.fbPending = map[string]*pendingFeedback{}
+	}
+	if c.fbTTL == 0 {
+		c.fbTTL = defaultFeedbackTTL
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-c.done
+		cancel()
+	}()
+	go c.expireFeedback(ctx)
+	go func() {
+		for attempt := 1; ; attempt++ {
+			err := c.SubscribeFeedback(ctx, c.dispatchFeedback)
+			if ctx.Err() != nil {
+				return
+			}
+			err = fmt.Errorf("feedback subscription error (attempt %d): %w", attempt, err)
+			c.reportError(err)
+			if errors.Is(err, common.ErrUnauthorized) {
+				c.fail(err) // retrying with the same credentials is useless
+				return
+			}
+			if backoff.Wait(ctx, backoff.Default.Delay(attempt)) != nil {
+				return
+			}
+		}
+	}()
+}
+
+// expireFeedback periodically expires futures until ctx is done,
+// so ones consumed only through C() don't pile up forever.
+func (c *Client) expireFeedback(ctx context.Context) {
+	d := time.Minute
+	if c.fbTTL < 2*d {
+		d = c.fbTTL / 2
+	}
+	t := time.NewTicker(d)
+	defer t.Stop()
+	for {
+		select {
+		case now := <-t.C:
+			c.sweepFeedback(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// sweepFeedback closes and forgets futures expired by now.
+func (c *Client) sweepFeedback(now time.Time) {
+	c.fbMu.Lock()
+	defer c.fbMu.Unlock()
+	for mid, p := range c.fbPending {
+		if now.After(p.expires) {
+			delete(c.fbPending, mid)
+			close(p.ch)
+		}
+	}
+}
+
+// dispatchFeedback completes the future of the corresponding message.
+func (c *Client) dispatchFeedback(f *Feedback) {
+	c.fbMu.Lock()
+	p, ok := c.fbPending[f.OriginalMessageID]
+	delete(c.fbPending, f.OriginalMessageID)
+	c.fbMu.Unlock()
+	if ok {
+		p.ch <- f
+	}
+}
+
+func (c *Client) forgetFeedback(mid string) {
+	c.fbMu.Lock()
+	delete(c.fbPending, mid)
+	c.fbMu.Unlock()
+}
@@ -0,0 +1,133 @@
+package iotservice
+
+import (
+	"context"
+	"time"
+)
+
+// LifecycleEventType is a kind of device registry change.
+type LifecycleEventType string
+
+const (
+	LifecycleCreated                LifecycleEventType = "created"
+	LifecycleDeleted                LifecycleEventType = "deleted"
+	LifecycleConnectionStateChanged LifecycleEventType = "connectionStateChanged"
+)
+
+// LifecycleEvent is a device registry change noticed by LifecycleWatcher.
+type LifecycleEvent struct {
+	Type     LifecycleEventType
+	DeviceID string
+	Device   *Device // last known device state
+	Time     time.Time
+}
+
+// LifecycleWatcher periodically lists the device registry and emits
+// events when devices are created, deleted or change connection state.
+//
+// It's a polling substitute for Event Grid lifecycle notifications,
+// changes that happen between two polls and cancel each other out
+// are not noticed.
+type LifecycleWatcher struct {
+	c        *Client
+	interval time.Duration
+	evch     chan *LifecycleEvent
+	devices  map[string]*Device // nil until the first poll
+}
+
+// NewLifecycleWatcher creates a watcher that lists
+// the registry every interval, see Run.
+func NewLifecycleWatcher(c *Client, interval time.Duration) *LifecycleWatcher {
+	if c == nil {
+		panic("client is nil")
+	}
+	if interval <= 0 {
+		panic("interval must be positive")
+	}
+	return &LifecycleWatcher{
+		c:        c,
+		interval: interval,
+		evch:     make(chan *LifecycleEvent, 64),
+	}
+}
+
+// Events returns the registry changes channel, the first poll only
+// records the initial registry state. The channel is closed when Run returns.
+func (w *LifecycleWatcher) Events() <-chan *LifecycleEvent {
+	return w.evch
+}
+
+// Run polls the registry until ctx is done, it can be called only once.
+func (w *LifecycleWatcher) Run(ctx context.Context) error {
+	defer close(w.evch)
+	for {
+		if err := w.poll(ctx); err != nil {
+			return err
+		}
+		select {
+		case <-time.After(w.interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (w *LifecycleWatcher) poll(ctx context.Context) error {
+	l, err := w.c.ListDevices(ctx)
+	if err != nil {
+		return err
+	}
+	devices := make(map[string]*Device, len(l))
+	for _, d := range l {
+		devices[d.DeviceID] = d
+	}
+	prev := w.devices
+	w.devices = devices
+	if prev == nil {
+		return nil
+	}
+
+	now := time.Now()
+	for _, ev := range diffDevices(prev, devices) {
+		ev.Time = now
+		select {
+		case w.evch <- ev:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// diffDevices returns events that turn the prev registry state into next,
+// a device recreated under the same id is reported as deleted and created.
+func diffDevices(prev, next map[string]*Device) []*LifecycleEvent {
+	var evs []*LifecycleEvent
+	for id, p := range prev {
+		if n, ok := next[id]; !ok || n.GenerationID != p.GenerationID {
+			evs = append(evs, &LifecycleEvent{
+				Type:     LifecycleDeleted,
+				DeviceID: id,
+				Device:   p,
+			})
+		}
+	}
+	for id, n := range next {
+		p, ok := prev[id]
+		switch {
+		case !ok || n.GenerationID != p.GenerationID:
+			evs = append(evs, &LifecycleEvent{
+				Type:     LifecycleCreated,
+				DeviceID: id,
+				Device:   n,
+			})
+		case n.ConnectionState != p.ConnectionState:
+			evs = append(evs, &LifecycleEvent{
+				Type:     LifecycleConnectionStateChanged,
+				DeviceID: id,
+				Device:   n,
+			})
+		}
+	}
+	return evs
+}
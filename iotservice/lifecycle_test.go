@@ -0,0 +1,44 @@
+package iotservice
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestDiffDevices(t *testing.T) {
+	t.Parallel()
+
+	prev := map[string]*Device{
+		"kept":      {DeviceID: "kept", GenerationID: "1", ConnectionState: "Disconnected"},
+		"deleted":   {DeviceID: "deleted", GenerationID: "1"},
+		"recreated": {DeviceID: "recreated", GenerationID: "1"},
+		"idle":      {DeviceID: "idle", GenerationID: "1"},
+	}
+	next := map[string]*Device{
+		"kept":      {DeviceID: "kept", GenerationID: "1", ConnectionState: "Connected"},
+		"recreated": {DeviceID: "recreated", GenerationID: "2"},
+		"idle":      {DeviceID: "idle", GenerationID: "1"},
+		"created":   {DeviceID: "created", GenerationID: "1"},
+	}
+
+	var got []string
+	for _, ev := range diffDevices(prev, next) {
+		got = append(got, ev.DeviceID+":"+string(ev.Type))
+	}
+	sort.Strings(got)
+	want := []string{
+		"created:created",
+		"deleted:deleted",
+		"kept:connectionStateChanged",
+		"recreated:created",
+		"recreated:deleted",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("events = %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package iotservice
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/amenzhinsky/golang-iothub/eventhub"
+	"pack.ag/amqp"
+)
+
+// senderLink is a cloud-to-device sender link reused across sends,
+// it's not closed explicitly since links go away with the connection.
+//
+// amqp.Sender is not safe for concurrent use, so sends
+// through the same link are serialized by mu.
+type senderLink struct {
+	mu   sync.Mutex
+	conn *eventhub.Client // connection the link belongs to
+	send *amqp.Sender
+}
+
+// transmit sends msg over conn opening a new link when there's no link yet or
+// it belongs to another connection, a link is discarded after a failed send.
+func (l *senderLink) transmit(ctx context.Context, conn *eventhub.Client, msg *amqp.Message) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.send == nil || l.conn != conn {
+		l.reset()
+		send, err := conn.Sess().NewSender(
+			amqp.LinkTargetAddress("/messages/devicebound"),
+		)
+		if err != nil {
+			return err
+		}
+		l.conn, l.send = conn, send
+	}
+	if err := l.send.Send(ctx, msg); err != nil {
+		l.reset()
+		return err
+	}
+	return nil
+}
+
+// reset discards the link, it's closed in the background
+// since closing waits for the peer and mustn't block other sends.
+func (l *senderLink) reset() {
+	if l.send != nil {
+		go l.send.Close()
+	}
+	l.conn, l.send = nil, nil
+}
+
+// nextSender picks a sender link in round-robin order.
+func (c *Client) nextSender() *senderLink {
+	n := atomic.AddUint32(&c.next, 1)
+	return &c.senders[int((n-1)%uint32(len(c.senders)))]
+}
+
+// checkConn drops conn when err means it's broken and returns err.
+func (c *Client) checkConn(conn *eventhub.Client, err error) error {
+	if _, ok := err.(net.Error); ok || err == amqp.ErrConnClosed {
+		c.dropConn(conn)
+	}
+	return err
+}
+
+// dropConn forgets the given broken connection so the next
+// operation establishes a new one, unless it's been replaced already.
+func (c *Client) dropConn(conn *eventhub.Client) {
+	c.mu.Lock()
+	if c.conn != conn {
+		c.mu.Unlock()
+		return
+	}
+	c.conn = nil
+	c.mu.Unlock()
+	c.debugf("dropping broken connection")
+	conn.Close()
+}
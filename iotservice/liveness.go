@@ -0,0 +1,201 @@
+package iotservice
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ConnectionStateConnected is the device connection state
+// reported by the registry when a device is online.
+const ConnectionStateConnected = "Connected"
+
+// LivenessEvent is a device online/offline state transition.
+type LivenessEvent struct {
+	DeviceID         string
+	Online           bool
+	LastActivityTime string
+	Time             time.Time // when the transition is noticed
+}
+
+// LivenessWatcher periodically polls connection state of the watched
+// devices and emits an event each time a device goes online or offline.
+type LivenessWatcher struct {
+	c        *Client
+	interval time.Duration
+	evch     chan *LivenessEvent
+
+	mu    sync.Mutex
+	state map[string]*bool // nil means state is not known yet
+}
+
+// NewLivenessWatcher creates a watcher that polls
+// the registry every interval, see Run.
+func NewLivenessWatcher(c *Client, interval time.Duration) *LivenessWatcher {
+	if c == nil {
+		panic("client is nil")
+	}
+	if interval <= 0 {
+		panic("interval must be positive")
+	}
+	return &LivenessWatcher{
+		c:        c,
+		interval: interval,
+		evch:     make(chan *LivenessEvent, 64),
+		state:    map[string]*bool{},
+	}
+}
+
+// Watch adds the named devices to the watched set.
+func (w *LivenessWatcher) Watch(deviceIDs ...string) {
+	w.mu.Lock()
+	for _, id := range deviceIDs {
+		if _, ok := w.state[id]; !ok {
+			w.state[id] = nil
+		}
+	}
+	w.mu.Unlock()
+}
+
+// Unwatch removes the named devices from the watched set.
+func (w *LivenessWatcher) Unwatch(deviceIDs ...string) {
+	w.mu.Lock()
+	for _, id := range deviceIDs {
+		delete(w.state, id)
+	}
+	w.mu.Unlock()
+}
+
+// Events returns the transitions channel, the first observed state
+// of a device is also reported. The channel is closed when Run returns.
+func (w *LivenessWatcher) Events() <-chan *LivenessEvent {
+	return w.evch
+}
+
+// Run polls devices until ctx is done, it can be called only once.
+// Devices are polled with queries, a failed poll is logged and retried
+// on the next tick, devices missing from the registry are offline.
+func (w *LivenessWatcher) Run(ctx context.Context) error {
+	defer close(w.evch)
+	for {
+		if err := w.poll(ctx); err != nil {
+			return err
+		}
+		select {
+		case <-time.After(w.interval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// livenessBatch is the maximum number of devices polled with one query.
+const livenessBatch = 100
+
+// livenessState is a device's connection state as it's queried.
+type livenessState struct {
+	DeviceID         string `json:"deviceId"`
+	ConnectionState  string `json:"connectionState"`
+	LastActivityTime string `json:"lastActivityTime"`
+}
+
+func (w *LivenessWatcher) poll(ctx context.Context) error {
+	w.mu.Lock()
+	ids := make([]string, 0, len(w.state))
+	for id := range w.state {
+		ids = append(ids, id)
+	}
+	w.mu.Unlock()
+	sort.Strings(ids)
+
+	for len(ids) != 0 {
+		n := len(ids)
+		if n > livenessBatch {
+			n = livenessBatch
+		}
+		batch := ids[:n]
+		ids = ids[n:]
+
+		states, err := w.query(ctx, batch)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			w.c.errorf("liveness: %s", err)
+			continue
+		}
+		for _, id := range batch {
+			st, ok := states[id]
+			if !ok {
+				st = &livenessState{DeviceID: id} // removed from the registry
+			}
+			if err = w.update(ctx, st); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// query returns connection states of the given devices by their ids.
+func (w *LivenessWatcher) query(ctx context.Context, ids []string) (map[string]*livenessState, error) {
+	lits := make([]string, 0, len(ids))
+	for _, id := range ids {
+		lit, err := queryLiteral(id)
+		if err != nil {
+			return nil, err
+		}
+		lits = append(lits, lit)
+	}
+	states := make(map[string]*livenessState, len(ids))
+	if err := w.c.QueryFunc(ctx,
+		"SELECT deviceId, connectionState, lastActivityTime FROM devices WHERE deviceId IN ["+
+			strings.Join(lits, ", ")+"]",
+		func(v map[string]interface{}) error {
+			b, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			st := &livenessState{}
+			if err = json.Unmarshal(b, st); err != nil {
+				return err
+			}
+			states[st.DeviceID] = st
+			return nil
+		},
+	); err != nil {
+		return nil, err
+	}
+	return states, nil
+}
+
+// update records the device state emitting an event when it's changed.
+func (w *LivenessWatcher) update(ctx context.Context, st *livenessState) error {
+	online := st.ConnectionState == ConnectionStateConnected
+
+	w.mu.Lock()
+	s, ok := w.state[st.DeviceID]
+	changed := ok && (s == nil || *s != online)
+	if changed {
+		w.state[st.DeviceID] = &online
+	}
+	w.mu.Unlock()
+	if !changed {
+		return nil
+	}
+
+	select {
+	case w.evch <- &LivenessEvent{
+		DeviceID:         st.DeviceID,
+		Online:           online,
+		LastActivityTime: st.LastActivityTime,
+		Time:             time.Now(),
+	}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,99 @@
+package iotservice
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLivenessWatcher(t *testing.T) {
+	t.Parallel()
+
+	online, offline := true, false
+	for name, tc := range map[string]struct {
+		state  *bool
+		rows   string // query response, empty means an error
+		events []bool
+	}{
+		"first poll": {
+			rows:   `[{"deviceId":"dev","connectionState":"Connected","lastActivityTime":"t1"}]`,
+			events: []bool{true},
+		},
+		"online to offline": {
+			state:  &online,
+			rows:   `[{"deviceId":"dev","connectionState":"Disconnected","lastActivityTime":"t1"}]`,
+			events: []bool{false},
+		},
+		"offline to online": {
+			state:  &offline,
+			rows:   `[{"deviceId":"dev","connectionState":"Connected","lastActivityTime":"t1"}]`,
+			events: []bool{true},
+		},
+		"unchanged": {
+			state: &online,
+			rows:  `[{"deviceId":"dev","connectionState":"Connected","lastActivityTime":"t1"}]`,
+		},
+		"removed": {
+			state:  &online,
+			rows:   `[]`,
+			events: []bool{false},
+		},
+		"query error": {
+			state: &online,
+		},
+	} {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var queries []string
+			c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				b, err := io.ReadAll(r.Body)
+				if err != nil {
+					t.Error(err)
+				}
+				var v struct{ Query string }
+				if err = json.Unmarshal(b, &v); err != nil {
+					t.Error(err)
+				}
+				queries = append(queries, v.Query)
+				if tc.rows == "" {
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte(`{"Message":"ErrorCode:BadRequest"}`))
+					return
+				}
+				w.Write([]byte(tc.rows))
+			})
+
+			w := NewLivenessWatcher(c, time.Hour)
+			w.Watch("dev")
+			w.state["dev"] = tc.state
+			if err := w.poll(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			close(w.evch)
+
+			var events []bool
+			for ev := range w.Events() {
+				if ev.DeviceID != "dev" {
+					t.Errorf("device id = %q, want %q", ev.DeviceID, "dev")
+				}
+				events = append(events, ev.Online)
+			}
+			if !reflect.DeepEqual(events, tc.events) {
+				t.Errorf("events = %v, want %v", events, tc.events)
+			}
+			q := "SELECT deviceId, connectionState, lastActivityTime FROM devices WHERE deviceId IN ['dev']"
+			if len(queries) != 1 || queries[0] != q {
+				t.Errorf("queries = %q, want [%q]", queries, q)
+			}
+			if tc.rows == "" && w.state["dev"] != tc.state {
+				t.Error("state is changed by a failed poll")
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package models
+
+// Configuration is an automatic device management configuration.
+type Configuration struct {
+	ID                 string                `json:"id,omitempty"`
+	SchemaVersion      string                `json:"schemaVersion,omitempty"`
+	Labels             map[string]string     `json:"labels,omitempty"`
+	Content            *ConfigurationContent `json:"content,omitempty"`
+	TargetCondition    string                `json:"targetCondition,omitempty"`
+	CreatedTimeUTC     string                `json:"createdTimeUtc,omitempty"`
+	LastUpdatedTimeUTC string                `json:"lastUpdatedTimeUtc,omitempty"`
+	Priority           int                   `json:"priority,omitempty"`
+	SystemMetrics      *ConfigurationMetrics `json:"systemMetrics,omitempty"`
+	Metrics            *ConfigurationMetrics `json:"metrics,omitempty"`
+	ETag               string                `json:"etag,omitempty"`
+
+	Extra Extra `json:"-"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (c *Configuration) UnmarshalJSON(b []byte) error {
+	type configuration Configuration
+	var err error
+	c.Extra, err = unmarshal(b, (*configuration)(c))
+	return err
+}
+
+// MarshalJSON implements json.Marshaler.
+func (c Configuration) MarshalJSON() ([]byte, error) {
+	type configuration Configuration
+	return marshal((*configuration)(&c), c.Extra)
+}
+
+// ConfigurationContent is the content applied to targeted devices.
+type ConfigurationContent struct {
+	ModulesContent map[string]interface{} `json:"modulesContent,omitempty"`
+	DeviceContent  map[string]interface{} `json:"deviceContent,omitempty"`
+
+	Extra Extra `json:"-"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (c *ConfigurationContent) UnmarshalJSON(b []byte) error {
+	type configurationContent ConfigurationContent
+	var err error
+	c.Extra, err = unmarshal(b, (*configurationContent)(c))
+	return err
+}
+
+// MarshalJSON implements json.Marshaler.
+func (c ConfigurationContent) MarshalJSON() ([]byte, error) {
+	type configurationContent ConfigurationContent
+	return marshal((*configurationContent)(&c), c.Extra)
+}
+
+// ConfigurationMetrics are configuration metric queries and their results.
+type ConfigurationMetrics struct {
+	Results map[string]int    `json:"results,omitempty"`
+	Queries map[string]string `json:"queries,omitempty"`
+}
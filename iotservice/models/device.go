@@ -0,0 +1,126 @@
+package models
+
+// Device is a device identity.
+type Device struct {
+	DeviceID                   string                 `json:"deviceId,omitempty"`
+	GenerationID               string                 `json:"generationId,omitempty"`
+	ETag                       string                 `json:"etag,omitempty"`
+	ConnectionState            string                 `json:"connectionState,omitempty"`
+	Status                     string                 `json:"status,omitempty"`
+	StatusReason               string                 `json:"statusReason,omitempty"`
+	ConnectionStateUpdatedTime string                 `json:"connectionStateUpdatedTime,omitempty"`
+	StatusUpdatedTime          string                 `json:"statusUpdatedTime,omitempty"`
+	LastActivityTime           string                 `json:"lastActivityTime,omitempty"`
+	CloudToDeviceMessageCount  int                    `json:"cloudToDeviceMessageCount,omitempty"`
+	Authentication             *Authentication        `json:"authentication,omitempty"`
+	Capabilities               map[string]interface{} `json:"capabilities,omitempty"`
+	DeviceScope                string                 `json:"deviceScope,omitempty"`
+	ParentScopes               []string               `json:"parentScopes,omitempty"`
+
+	Extra Extra `json:"-"`
+}
+
+// Device statuses, disabled devices cannot connect to the hub.
+const (
+	DeviceEnabled  = "enabled"
+	DeviceDisabled = "disabled"
+)
+
+// IsEdge reports whether the device is an IoT Edge device.
+func (d *Device) IsEdge() bool {
+	v, _ := d.Capabilities["iotEdge"].(bool)
+	return v
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Device) UnmarshalJSON(b []byte) error {
+	type device Device
+	var err error
+	d.Extra, err = unmarshal(b, (*device)(d))
+	return err
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d Device) MarshalJSON() ([]byte, error) {
+	type device Device
+	return marshal((*device)(&d), d.Extra)
+}
+
+// Module is a module identity of a device.
+type Module struct {
+	ModuleID                   string          `json:"moduleId,omitempty"`
+	DeviceID                   string          `json:"deviceId,omitempty"`
+	GenerationID               string          `json:"generationId,omitempty"`
+	ETag                       string          `json:"etag,omitempty"`
+	ConnectionState            string          `json:"connectionState,omitempty"`
+	ConnectionStateUpdatedTime string          `json:"connectionStateUpdatedTime,omitempty"`
+	LastActivityTime           string          `json:"lastActivityTime,omitempty"`
+	CloudToDeviceMessageCount  int             `json:"cloudToDeviceMessageCount,omitempty"`
+	Authentication             *Authentication `json:"authentication,omitempty"`
+	ManagedBy                  string          `json:"managedBy,omitempty"`
+
+	Extra Extra `json:"-"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (m *Module) UnmarshalJSON(b []byte) error {
+	type module Module
+	var err error
+	m.Extra, err = unmarshal(b, (*module)(m))
+	return err
+}
+
+// MarshalJSON implements json.Marshaler.
+func (m Module) MarshalJSON() ([]byte, error) {
+	type module Module
+	return marshal((*module)(&m), m.Extra)
+}
+
+// Authentication is an identity authentication mechanism.
+type Authentication struct {
+	SymmetricKey   *SymmetricKey   `json:"symmetricKey,omitempty"`
+	X509Thumbprint *X509Thumbprint `json:"x509Thumbprint,omitempty"`
+	Type           AuthType        `json:"type,omitempty"`
+
+	Extra Extra `json:"-"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (a *Authentication) UnmarshalJSON(b []byte) error {
+	type authentication Authentication
+	var err error
+	a.Extra, err = unmarshal(b, (*authentication)(a))
+	return err
+}
+
+// MarshalJSON implements json.Marshaler.
+func (a Authentication) MarshalJSON() ([]byte, error) {
+	type authentication Authentication
+	return marshal((*authentication)(&a), a.Extra)
+}
+
+// AuthType device authentication type.
+type AuthType string
+
+const (
+	// AuthSAS uses symmetric keys to sign requests.
+	AuthSAS = "sas"
+
+	// AuthSelfSigned self signed certificate with a thumbprint.
+	AuthSelfSigned = "selfSigned"
+
+	// AuthCA certificate signed by a registered certificate authority.
+	AuthCA = "certificateAuthority"
+)
+
+// X509Thumbprint is a pair of x509 certificate thumbprints.
+type X509Thumbprint struct {
+	PrimaryThumbprint   string `json:"primaryThumbprint,omitempty"`
+	SecondaryThumbprint string `json:"secondaryThumbprint,omitempty"`
+}
+
+// SymmetricKey is a pair of shared access keys.
+type SymmetricKey struct {
+	PrimaryKey   string `json:"primaryKey,omitempty"`
+	SecondaryKey string `json:"secondaryKey,omitempty"`
+}
@@ -0,0 +1,38 @@
+package models
+
+// Job types.
+const (
+	JobImport = "import"
+	JobExport = "export"
+)
+
+// Job is a device registry import or export job.
+type Job struct {
+	JobID                  string `json:"jobId,omitempty"`
+	Type                   string `json:"type,omitempty"`
+	Status                 string `json:"status,omitempty"`
+	Progress               int    `json:"progress,omitempty"`
+	StartTimeUTC           string `json:"startTimeUtc,omitempty"`
+	EndTimeUTC             string `json:"endTimeUtc,omitempty"`
+	InputBlobContainerURI  string `json:"inputBlobContainerUri,omitempty"`
+	OutputBlobContainerURI string `json:"outputBlobContainerUri,omitempty"`
+	ExcludeKeysInExport    bool   `json:"excludeKeysInExport,omitempty"`
+	FailureReason          string `json:"failureReason,omitempty"`
+	StatusMessage          string `json:"statusMessage,omitempty"`
+
+	Extra Extra `json:"-"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (j *Job) UnmarshalJSON(b []byte) error {
+	type job Job
+	var err error
+	j.Extra, err = unmarshal(b, (*job)(j))
+	return err
+}
+
+// MarshalJSON implements json.Marshaler.
+func (j Job) MarshalJSON() ([]byte, error) {
+	type job Job
+	return marshal((*job)(&j), j.Extra)
+}
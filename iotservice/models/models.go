@@ -0,0 +1,84 @@
+// Package models contains the IoT Hub service REST resources.
+//
+// Every resource keeps JSON fields unknown to this package in its Extra
+// map and writes them back when it's marshaled, so documents fetched from
+// a newer service version survive read-modify-write round trips, e.g.
+// a device update doesn't reset properties that aren't supported yet.
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Extra holds JSON fields that resources don't have.
+type Extra map[string]json.RawMessage
+
+// unmarshal decodes b into v that is a pointer to an alias type of
+// a resource and returns the fields that v doesn't know about.
+func unmarshal(b []byte, v interface{}) (Extra, error) {
+	if err := json.Unmarshal(b, v); err != nil {
+		return nil, err
+	}
+	var m Extra
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	for k := range knownFields(reflect.TypeOf(v).Elem()) {
+		delete(m, k)
+	}
+	if len(m) == 0 {
+		return nil, nil
+	}
+	return m, nil
+}
+
+// marshal encodes v that is a pointer to an alias type of
+// a resource adding the extra fields that v doesn't overwrite.
+func marshal(v interface{}, extra Extra) ([]byte, error) {
+	b, err := json.Marshal(v)
+	if err != nil || len(extra) == 0 {
+		return b, err
+	}
+	var m map[string]json.RawMessage
+	if err = json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	known := knownFields(reflect.TypeOf(v).Elem())
+	for k, v := range extra {
+		if _, ok := known[k]; !ok {
+			m[k] = v
+		}
+	}
+	return json.Marshal(m)
+}
+
+var fieldsCache sync.Map // reflect.Type -> map[string]struct{}
+
+// knownFields returns JSON names of the struct's fields.
+func knownFields(t reflect.Type) map[string]struct{} {
+	if v, ok := fieldsCache.Load(t); ok {
+		return v.(map[string]struct{})
+	}
+	m := make(map[string]struct{}, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			if tag == "-" {
+				continue
+			}
+			if s := strings.Split(tag, ",")[0]; s != "" {
+				name = s
+			}
+		}
+		m[name] = struct{}{}
+	}
+	fieldsCache.Store(t, m)
+	return m
+}
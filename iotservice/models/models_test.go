@@ -0,0 +1,83 @@
+package models
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestUnknownFields(t *testing.T) {
+	t.Parallel()
+
+	for name, v := range map[string]interface{}{
+		"device":        &Device{},
+		"module":        &Module{},
+		"twin":          &Twin{},
+		"configuration": &Configuration{},
+		"job":           &Job{},
+		"statistics":    &Statistics{},
+	} {
+		name, v := name, v
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			b := []byte(`{"newField":{"a":1},"etag":"AAAA","jobId":"j","totalDeviceCount":1}`)
+			if err := json.Unmarshal(b, v); err != nil {
+				t.Fatal(err)
+			}
+			extra := reflect.ValueOf(v).Elem().FieldByName("Extra").Interface().(Extra)
+			if _, ok := extra["newField"]; !ok {
+				t.Fatalf("newField is not retained: %v", extra)
+			}
+
+			g, err := json.Marshal(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var m map[string]interface{}
+			if err = json.Unmarshal(g, &m); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(m["newField"], map[string]interface{}{"a": 1.0}) {
+				t.Errorf("newField = %v, marshaled = %s", m["newField"], g)
+			}
+		})
+	}
+}
+
+func TestUnknownFields_Nested(t *testing.T) {
+	t.Parallel()
+
+	b := []byte(`{"deviceId":"dev","authentication":{"type":"sas","newField":true}}`)
+	var d Device
+	if err := json.Unmarshal(b, &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Extra != nil {
+		t.Errorf("extra = %v, want nil", d.Extra)
+	}
+	d.Authentication.Type = AuthSelfSigned
+
+	g, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := `{"deviceId":"dev","authentication":{"newField":true,"type":"selfSigned"}}`
+	if string(g) != w {
+		t.Errorf("marshaled = %s, want %s", g, w)
+	}
+}
+
+func TestUnknownFields_Overwrite(t *testing.T) {
+	t.Parallel()
+
+	// known fields take precedence over extra ones with the same name
+	d := Device{DeviceID: "dev", Extra: Extra{"deviceId": json.RawMessage(`"old"`)}}
+	b, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := `{"deviceId":"dev"}`; string(b) != w {
+		t.Errorf("marshaled = %s, want %s", b, w)
+	}
+}
@@ -0,0 +1,45 @@
+package models
+
+// Statistics is the device registry statistic.
+type Statistics struct {
+	DisabledDeviceCount int `json:"disabledDeviceCount,omitempty"`
+	EnabledDeviceCount  int `json:"enabledDeviceCount,omitempty"`
+	TotalDeviceCount    int `json:"totalDeviceCount,omitempty"`
+
+	Extra Extra `json:"-"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *Statistics) UnmarshalJSON(b []byte) error {
+	type statistics Statistics
+	var err error
+	s.Extra, err = unmarshal(b, (*statistics)(s))
+	return err
+}
+
+// MarshalJSON implements json.Marshaler.
+func (s Statistics) MarshalJSON() ([]byte, error) {
+	type statistics Statistics
+	return marshal((*statistics)(&s), s.Extra)
+}
+
+// ServiceStatistics is the hub service statistic.
+type ServiceStatistics struct {
+	ConnectedDeviceCount int `json:"connectedDeviceCount"`
+
+	Extra Extra `json:"-"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *ServiceStatistics) UnmarshalJSON(b []byte) error {
+	type serviceStatistics ServiceStatistics
+	var err error
+	s.Extra, err = unmarshal(b, (*serviceStatistics)(s))
+	return err
+}
+
+// MarshalJSON implements json.Marshaler.
+func (s ServiceStatistics) MarshalJSON() ([]byte, error) {
+	type serviceStatistics ServiceStatistics
+	return marshal((*serviceStatistics)(&s), s.Extra)
+}
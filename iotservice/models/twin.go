@@ -0,0 +1,43 @@
+package models
+
+// Twin is a device or module twin.
+type Twin struct {
+	DeviceID                  string                 `json:"deviceId,omitempty"`
+	ModuleID                  string                 `json:"moduleId,omitempty"`
+	ETag                      string                 `json:"etag,omitempty"`
+	DeviceETag                string                 `json:"deviceEtag,omitempty"`
+	Status                    string                 `json:"status,omitempty"`
+	StatusReason              string                 `json:"statusReason,omitempty"`
+	StatusUpdateTime          string                 `json:"statusUpdateTime,omitempty"`
+	ConnectionState           string                 `json:"connectionState,omitempty"`
+	LastActivityTime          string                 `json:"lastActivityTime,omitempty"`
+	CloudToDeviceMessageCount int                    `json:"cloudToDeviceMessageCount,omitempty"`
+	AuthenticationType        string                 `json:"authenticationType,omitempty"`
+	X509Thumbprint            *X509Thumbprint        `json:"x509Thumbprint,omitempty"`
+	Version                   int                    `json:"version,omitempty"`
+	Tags                      map[string]interface{} `json:"tags,omitempty"`
+	Properties                *Properties            `json:"properties,omitempty"`
+	Capabilities              map[string]interface{} `json:"capabilities,omitempty"`
+
+	Extra Extra `json:"-"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (t *Twin) UnmarshalJSON(b []byte) error {
+	type twin Twin
+	var err error
+	t.Extra, err = unmarshal(b, (*twin)(t))
+	return err
+}
+
+// MarshalJSON implements json.Marshaler.
+func (t Twin) MarshalJSON() ([]byte, error) {
+	type twin Twin
+	return marshal((*twin)(&t), t.Extra)
+}
+
+// Properties are twin desired and reported properties.
+type Properties struct {
+	Desired  map[string]interface{} `json:"desired,omitempty"`
+	Reported map[string]interface{} `json:"reported,omitempty"`
+}
@@ -0,0 +1,72 @@
+package iotservice
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+	"pack.ag/amqp"
+)
+
+// FileNotification is sent by the hub when a device completes a file upload.
+type FileNotification struct {
+	DeviceID        string    `json:"deviceId"`
+	BlobURI         string    `json:"blobUri"`
+	BlobName        string    `json:"blobName"`
+	LastUpdatedTime time.Time `json:"lastUpdatedTime"`
+	BlobSizeInBytes int64     `json:"blobSizeInBytes"`
+	EnqueuedTimeUTC time.Time `json:"enqueuedTimeUtc"`
+}
+
+// FileNotificationHandler handles file upload notifications.
+type FileNotificationHandler func(n *FileNotification)
+
+// SubscribeFileNotifications subscribes to file upload notifications,
+// they're only sent when notifications are enabled in the hub's file upload
+// settings and like feedback they're shared by all receivers of the hub.
+// The function blocks until ctx is done or an error occurs.
+func (c *Client) SubscribeFileNotifications(ctx context.Context, fn FileNotificationHandler) error {
+	if fn == nil {
+		panic("fn is nil")
+	}
+	return c.receiveFileNotifications(ctx, func(n *FileNotification) bool {
+		go func() {
+			defer common.Recover(c.reportError)
+			fn(n)
+		}()
+		return true
+	})
+}
+
+// receiveFileNotifications calls fn for each received notification
+// until fn returns false, ctx is done or an error occurs.
+func (c *Client) receiveFileNotifications(ctx context.Context, fn func(n *FileNotification) bool) error {
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return err
+	}
+	recv, err := conn.Sess().NewReceiver(
+		amqp.LinkSourceAddress("/messages/serviceBound/filenotifications"),
+	)
+	if err != nil {
+		return c.checkConn(conn, err)
+	}
+	defer recv.Close()
+
+	for {
+		msg, err := recv.Receive(ctx)
+		if err != nil {
+			return c.checkConn(conn, err)
+		}
+		msg.Accept()
+
+		var n FileNotification
+		if err = json.Unmarshal(msg.GetData(), &n); err != nil {
+			return err
+		}
+		if !fn(&n) {
+			return nil
+		}
+	}
+}
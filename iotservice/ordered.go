@@ -0,0 +1,56 @@
+package iotservice
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+)
+
+// orderedDispatcher runs a handler on workers picked by device id,
+// so messages of the same device are handled sequentially.
+type orderedDispatcher struct {
+	fn MessageHandler
+	qs []chan *common.Message
+	wg sync.WaitGroup
+
+	onPanic func(err error) // reports handler panics
+}
+
+func newOrderedDispatcher(workers int, fn MessageHandler, onPanic func(err error)) *orderedDispatcher {
+	d := &orderedDispatcher{
+		fn:      fn,
+		qs:      make([]chan *common.Message, workers),
+		onPanic: onPanic,
+	}
+	for i := range d.qs {
+		d.qs[i] = make(chan *common.Message, 64)
+		d.wg.Add(1)
+		go d.work(d.qs[i])
+	}
+	return d
+}
+
+func (d *orderedDispatcher) work(q <-chan *common.Message) {
+	defer d.wg.Done()
+	for msg := range q {
+		common.Guard(d.onPanic, func() {
+			d.fn(msg)
+		})
+	}
+}
+
+// dispatch enqueues msg blocking when the device's worker is behind.
+func (d *orderedDispatcher) dispatch(msg *common.Message) {
+	h := fnv.New32a()
+	h.Write([]byte(msg.ConnectionDeviceID))
+	d.qs[h.Sum32()%uint32(len(d.qs))] <- msg
+}
+
+// close waits for workers to handle enqueued messages.
+func (d *orderedDispatcher) close() {
+	for _, q := range d.qs {
+		close(q)
+	}
+	d.wg.Wait()
+}
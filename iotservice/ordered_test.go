@@ -0,0 +1,65 @@
+package iotservice
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+)
+
+func TestOrderedDispatcher(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu  sync.Mutex
+		got = map[string][]string{}
+	)
+	d := newOrderedDispatcher(4, func(msg *common.Message) {
+		mu.Lock()
+		got[msg.ConnectionDeviceID] = append(got[msg.ConnectionDeviceID], msg.MessageID)
+		mu.Unlock()
+	}, nil)
+
+	want := map[string][]string{}
+	for i := 0; i < 100; i++ {
+		did, mid := "dev"+strconv.Itoa(i%7), strconv.Itoa(i)
+		want[did] = append(want[did], mid)
+		d.dispatch(&common.Message{ConnectionDeviceID: did, MessageID: mid})
+	}
+	d.close()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("handled = %v, want %v", got, want)
+	}
+}
+
+func TestOrderedDispatcher_Panic(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu     sync.Mutex
+		got    []string
+		panics int
+	)
+	d := newOrderedDispatcher(1, func(msg *common.Message) {
+		if msg.MessageID == "2" {
+			panic("boom")
+		}
+		mu.Lock()
+		got = append(got, msg.MessageID)
+		mu.Unlock()
+	}, func(err error) {
+		mu.Lock()
+		panics++
+		mu.Unlock()
+	})
+	for _, mid := range []string{"1", "2", "3"} {
+		d.dispatch(&common.Message{MessageID: mid})
+	}
+	d.close()
+
+	if w := []string{"1", "3"}; !reflect.DeepEqual(got, w) || panics != 1 {
+		t.Errorf("handled = %v, panics = %d, want %v and 1 panic", got, panics, w)
+	}
+}
@@ -0,0 +1,80 @@
+package iotservice
+
+import (
+	"context"
+)
+
+// PageFunc fetches the page following the given continuation token,
+// that's empty for the first page, an empty next token means it's the last page.
+type PageFunc[T any] func(ctx context.Context, token string) (items []T, next string, err error)
+
+// Pager iterates over pages of a paged API, it stops when ctx is done.
+//
+// Example:
+//
+//	p := c.QueryPager(ctx, "SELECT * FROM devices")
+//	for p.Next() {
+//		for _, v := range p.Values() {
+//			fmt.Println(v)
+//		}
+//	}
+//	if err := p.Err(); err != nil {
+//		return err
+//	}
+type Pager[T any] struct {
+	ctx   context.Context
+	fetch PageFunc[T]
+	token string
+	items []T
+	done  bool
+	err   error
+}
+
+// NewPager creates a pager that fetches pages with fn.
+func NewPager[T any](ctx context.Context, fn PageFunc[T]) *Pager[T] {
+	if fn == nil {
+		panic("fn is nil")
+	}
+	return &Pager[T]{ctx: ctx, fetch: fn}
+}
+
+// Next fetches the next page, it returns false when there are
+// no more pages or an error occurs that Err returns then.
+func (p *Pager[T]) Next() bool {
+	if p.done {
+		return false
+	}
+	if err := p.ctx.Err(); err != nil {
+		p.items, p.done, p.err = nil, true, err
+		return false
+	}
+	items, next, err := p.fetch(p.ctx, p.token)
+	if err != nil {
+		p.items, p.done, p.err = nil, true, err
+		return false
+	}
+	p.items, p.token, p.done = items, next, next == ""
+	return true
+}
+
+// Values returns items of the current page.
+func (p *Pager[T]) Values() []T {
+	return p.items
+}
+
+// Err returns the error that stopped iteration.
+func (p *Pager[T]) Err() error {
+	return p.err
+}
+
+// All fetches all remaining pages and returns their items.
+func (p *Pager[T]) All() ([]T, error) {
+	l := make([]T, 0)
+	for p.Next() {
+		l = append(l, p.Values()...)
+	}
+	if err := p.Err(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,89 @@
+package iotservice
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// pages is a paged API where tokens are indexes of pages.
+func pages(l ...[]int) PageFunc[int] {
+	return func(ctx context.Context, token string) ([]int, string, error) {
+		i := 0
+		if token != "" {
+			i = int(token[0] - '0')
+		}
+		if i >= len(l) {
+			return nil, "", errors.New("unknown token")
+		}
+		var next string
+		if i+1 < len(l) {
+			next = string(rune('0' + i + 1))
+		}
+		return l[i], next, nil
+	}
+}
+
+func TestPager(t *testing.T) {
+	t.Parallel()
+
+	p := NewPager(context.Background(), pages([]int{1, 2}, []int{}, []int{3}))
+	var g [][]int
+	for p.Next() {
+		g = append(g, p.Values())
+	}
+	if err := p.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if w := [][]int{{1, 2}, {}, {3}}; !reflect.DeepEqual(g, w) {
+		t.Errorf("pages = %v, want %v", g, w)
+	}
+	if p.Next() {
+		t.Error("Next() = true after the last page")
+	}
+}
+
+func TestPager_All(t *testing.T) {
+	t.Parallel()
+
+	l, err := NewPager(context.Background(), pages([]int{1}, []int{2, 3})).All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := []int{1, 2, 3}; !reflect.DeepEqual(l, w) {
+		t.Errorf("All() = %v, want %v", l, w)
+	}
+}
+
+func TestPager_Error(t *testing.T) {
+	t.Parallel()
+
+	errFetch := errors.New("fetch error")
+	p := NewPager(context.Background(), func(ctx context.Context, token string) ([]int, string, error) {
+		return nil, "", errFetch
+	})
+	if p.Next() {
+		t.Fatal("Next() = true, want false")
+	}
+	if err := p.Err(); err != errFetch {
+		t.Fatalf("Err() = %v, want %v", err, errFetch)
+	}
+}
+
+func TestPager_Cancel(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := NewPager(ctx, pages([]int{1}, []int{2}))
+	if !p.Next() {
+		t.Fatal(p.Err())
+	}
+	cancel()
+	if p.Next() {
+		t.Fatal("Next() = true after cancellation")
+	}
+	if err := p.Err(); err != context.Canceled {
+		t.Fatalf("Err() = %v, want %v", err, context.Canceled)
+	}
+}
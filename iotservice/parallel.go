@@ -0,0 +1,92 @@
+package iotservice
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultConcurrency is the number of simultaneous requests
+// used by bulk read operations when concurrency isn't specified.
+const DefaultConcurrency = 10
+
+// DeviceResult is a single device retrieval result of a bulk read.
+type DeviceResult struct {
+	DeviceID string
+	Device   *Device
+	Err      error
+}
+
+// TwinResult is a single twin retrieval result of a bulk read.
+type TwinResult struct {
+	DeviceID string
+	Twin     *Twin
+	Err      error
+}
+
+// GetDevices retrieves the named devices using at most concurrency
+// simultaneous requests, values less than 1 mean DefaultConcurrency.
+//
+// Results are returned in the same order as deviceIDs, an individual
+// request failure is reported in the corresponding result's Err,
+// the returned error is not nil only when ctx is done.
+func (c *Client) GetDevices(ctx context.Context, deviceIDs []string, concurrency int) ([]*DeviceResult, error) {
+	res := make([]*DeviceResult, len(deviceIDs))
+	err := fanOut(ctx, len(deviceIDs), concurrency, func(ctx context.Context, i int) {
+		d, err := c.GetDevice(ctx, deviceIDs[i])
+		res[i] = &DeviceResult{DeviceID: deviceIDs[i], Device: d, Err: err}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// GetTwins retrieves twins of the named devices the same way as GetDevices does.
+func (c *Client) GetTwins(ctx context.Context, deviceIDs []string, concurrency int) ([]*TwinResult, error) {
+	res := make([]*TwinResult, len(deviceIDs))
+	err := fanOut(ctx, len(deviceIDs), concurrency, func(ctx context.Context, i int) {
+		t, err := c.GetTwin(ctx, deviceIDs[i])
+		res[i] = &TwinResult{DeviceID: deviceIDs[i], Twin: t, Err: err}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// fanOut calls fn for every index in [0, n) using a pool of workers
+// and blocks until all of them return or ctx is done.
+func fanOut(ctx context.Context, n, concurrency int, fn func(ctx context.Context, i int)) error {
+	if concurrency < 1 {
+		concurrency = DefaultConcurrency
+	}
+	if concurrency > n {
+		concurrency = n
+	}
+
+	idx := make(chan int)
+	wg := sync.WaitGroup{}
+	wg.Add(concurrency)
+	for w := 0; w < concurrency; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range idx {
+				fn(ctx, i)
+			}
+		}()
+	}
+
+	var err error
+Loop:
+	for i := 0; i < n; i++ {
+		select {
+		case idx <- i:
+		case <-ctx.Done():
+			err = ctx.Err()
+			break Loop
+		}
+	}
+	close(idx)
+	wg.Wait()
+	return err
+}
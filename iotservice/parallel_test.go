@@ -0,0 +1,45 @@
+package iotservice
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFanOut(t *testing.T) {
+	t.Parallel()
+
+	var cur, max int32
+	res := make([]int, 100)
+	if err := fanOut(context.Background(), len(res), 4, func(_ context.Context, i int) {
+		n := atomic.AddInt32(&cur, 1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+		res[i] = i * 2
+		atomic.AddInt32(&cur, -1)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if max > 4 {
+		t.Errorf("concurrency = %d, want <= %d", max, 4)
+	}
+	for i, v := range res {
+		if v != i*2 {
+			t.Fatalf("res[%d] = %d, want %d", i, v, i*2)
+		}
+	}
+}
+
+func TestFanOut_Canceled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := fanOut(ctx, 10, 1, func(context.Context, int) {}); err != context.Canceled {
+		t.Errorf("err = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,162 @@
+package iotservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// QueryPageSize is the maximum number of items requested per page.
+const QueryPageSize = 1000
+
+// Query executes the given IoT Hub query language statement,
+// fetching all pages of results.
+//
+// See: https://docs.microsoft.com/en-us/azure/iot-hub/iot-hub-devguide-query-language
+func (c *Client) Query(ctx context.Context, query string) ([]map[string]interface{}, error) {
+	var res []map[string]interface{}
+	if err := c.QueryFunc(ctx, query, func(v map[string]interface{}) error {
+		res = append(res, v)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// QueryFunc executes the given query calling fn for every result item,
+// it stops and returns fn's error if it's not nil.
+func (c *Client) QueryFunc(
+	ctx context.Context,
+	query string,
+	fn func(v map[string]interface{}) error,
+) error {
+	p := c.QueryPager(ctx, query)
+	for p.Next() {
+		for _, item := range p.Values() {
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+	}
+	return p.Err()
+}
+
+// QueryPager returns a pager over the given query results.
+func (c *Client) QueryPager(ctx context.Context, query string) *Pager[map[string]interface{}] {
+	return NewPager(ctx, func(ctx context.Context, token string) ([]map[string]interface{}, string, error) {
+		if query == "" {
+			return nil, "", errors.New("query is empty")
+		}
+		var v []map[string]interface{}
+		res, err := c.do(ctx, http.MethodPost, "devices/query", pageHeader(token), map[string]string{
+			"query": query,
+		}, &v)
+		if err != nil {
+			return nil, "", err
+		}
+		return v, res.Get("X-Ms-Continuation"), nil
+	})
+}
+
+// pageHeader returns headers of a paged request continuing from token.
+func pageHeader(token string) http.Header {
+	h := http.Header{
+		"X-Ms-Max-Item-Count": {strconv.Itoa(QueryPageSize)},
+	}
+	if token != "" {
+		h.Set("X-Ms-Continuation", token)
+	}
+	return h
+}
+
+// QueryTwins executes the given query against the devices collection
+// decoding results into twins, so it has to select entire documents.
+func (c *Client) QueryTwins(ctx context.Context, query string) ([]*Twin, error) {
+	var res []*Twin
+	if err := c.QueryFunc(ctx, query, func(v map[string]interface{}) error {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		t := &Twin{}
+		if err = json.Unmarshal(b, t); err != nil {
+			return err
+		}
+		res = append(res, t)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// QueryDevicesByTag returns twins of devices that have
+// the tag at the given dot-separated path equal to value.
+func (c *Client) QueryDevicesByTag(ctx context.Context, tagPath string, value interface{}) ([]*Twin, error) {
+	q, err := whereEq("tags", tagPath, value)
+	if err != nil {
+		return nil, err
+	}
+	return c.QueryTwins(ctx, q)
+}
+
+// QueryDevicesByReported returns twins of devices that have
+// the reported property at the given path equal to value.
+func (c *Client) QueryDevicesByReported(ctx context.Context, path string, value interface{}) ([]*Twin, error) {
+	q, err := whereEq("properties.reported", path, value)
+	if err != nil {
+		return nil, err
+	}
+	return c.QueryTwins(ctx, q)
+}
+
+// QueryDevicesByReportedVersion returns twins of devices whose reported
+// properties version is less than the given one, e.g. devices that
+// haven't caught up with the latest configuration yet.
+func (c *Client) QueryDevicesByReportedVersion(ctx context.Context, lessThan int) ([]*Twin, error) {
+	return c.QueryTwins(ctx, "SELECT * FROM devices WHERE properties.reported.$version < "+
+		strconv.Itoa(lessThan))
+}
+
+var queryPathRegexp = regexp.MustCompile(`^[A-Za-z_$][\w$-]*(\.[A-Za-z_$][\w$-]*)*$`)
+
+// whereEq builds a devices query matching the given field to value.
+func whereEq(section, path string, value interface{}) (string, error) {
+	if !queryPathRegexp.MatchString(path) {
+		return "", fmt.Errorf("invalid path %q", path)
+	}
+	lit, err := queryLiteral(value)
+	if err != nil {
+		return "", err
+	}
+	return "SELECT * FROM devices WHERE " + section + "." + path + " = " + lit, nil
+}
+
+// queryLiteral formats value as a query language literal.
+func queryLiteral(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		if strings.ContainsRune(v, '\'') {
+			return "", fmt.Errorf("string %q contains a quote", v)
+		}
+		return "'" + v + "'", nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case nil:
+		return "null", nil
+	default:
+		return "", fmt.Errorf("unsupported value type %T", v)
+	}
+}
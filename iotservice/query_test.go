@@ -0,0 +1,37 @@
+package iotservice
+
+import (
+	"testing"
+)
+
+func TestWhereEq(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		path  string
+		value interface{}
+		want  string // empty means an error
+	}{
+		{"location.region", "us", "SELECT * FROM devices WHERE tags.location.region = 'us'"},
+		{"enabled", true, "SELECT * FROM devices WHERE tags.enabled = true"},
+		{"floor", 3, "SELECT * FROM devices WHERE tags.floor = 3"},
+		{"temp", 20.5, "SELECT * FROM devices WHERE tags.temp = 20.5"},
+		{"name", "o'brien", ""},
+		{"a = 1 OR 1", 1, ""},
+		{"a", []int{1}, ""},
+	} {
+		g, err := whereEq("tags", tc.path, tc.value)
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("whereEq(%q, %v) = %q, want an error", tc.path, tc.value, g)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g != tc.want {
+			t.Errorf("whereEq(%q, %v) = %q, want %q", tc.path, tc.value, g, tc.want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package iotservice
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+)
+
+// Tier is an IoT Hub pricing tier.
+type Tier string
+
+const (
+	TierF1 Tier = "F1"
+	TierB1 Tier = "B1"
+	TierB2 Tier = "B2"
+	TierB3 Tier = "B3"
+	TierS1 Tier = "S1"
+	TierS2 Tier = "S2"
+	TierS3 Tier = "S3"
+)
+
+// Limits is the tier-dependent quota and throttling limits of a hub,
+// zero rates mean that the operation is not available on the tier.
+//
+// See: https://docs.microsoft.com/en-us/azure/iot-hub/iot-hub-devguide-quotas-throttling
+type Limits struct {
+	MessagesPerDay              int
+	DeviceToCloudPerSec         float64
+	CloudToDeviceSendsPerSec    float64
+	CloudToDeviceReceivesPerSec float64
+	TwinReadsPerSec             float64
+	TwinUpdatesPerSec           float64
+	DirectMethodBytesPerSec     float64
+}
+
+// TierLimits returns limits of a hub with the given tier and number of units.
+func TierLimits(tier Tier, units int) (*Limits, error) {
+	if units < 1 {
+		return nil, fmt.Errorf("invalid number of units: %d", units)
+	}
+	u := float64(units)
+	switch tier {
+	case TierF1:
+		if units != 1 {
+			return nil, fmt.Errorf("%s tier is limited to one unit", tier)
+		}
+		return &Limits{
+			MessagesPerDay:              8000,
+			DeviceToCloudPerSec:         100,
+			CloudToDeviceSendsPerSec:    100.0 / 60,
+			CloudToDeviceReceivesPerSec: 1000.0 / 60,
+			TwinReadsPerSec:             100,
+			TwinUpdatesPerSec:           50,
+			DirectMethodBytesPerSec:     160 << 10,
+		}, nil
+	case TierB1, TierS1:
+		l := &Limits{
+			MessagesPerDay:      400000 * units,
+			DeviceToCloudPerSec: math.Max(100, 12*u),
+		}
+		if tier == TierS1 {
+			l.CloudToDeviceSendsPerSec = 100.0 / 60 * u
+			l.CloudToDeviceReceivesPerSec = 1000.0 / 60 * u
+			l.TwinReadsPerSec = 100
+			l.TwinUpdatesPerSec = 50
+			l.DirectMethodBytesPerSec = (160 << 10) * u
+		}
+		return l, nil
+	case TierB2, TierS2:
+		l := &Limits{
+			MessagesPerDay:      6000000 * units,
+			DeviceToCloudPerSec: 120 * u,
+		}
+		if tier == TierS2 {
+			l.CloudToDeviceSendsPerSec = 100.0 / 60 * u
+			l.CloudToDeviceReceivesPerSec = 1000.0 / 60 * u
+			l.TwinReadsPerSec = math.Max(100, 10*u)
+			l.TwinUpdatesPerSec = math.Max(50, 5*u)
+			l.DirectMethodBytesPerSec = (480 << 10) * u
+		}
+		return l, nil
+	case TierB3, TierS3:
+		l := &Limits{
+			MessagesPerDay:      300000000 * units,
+			DeviceToCloudPerSec: 6000 * u,
+		}
+		if tier == TierS3 {
+			l.CloudToDeviceSendsPerSec = 5000.0 / 60 * u
+			l.CloudToDeviceReceivesPerSec = 50000.0 / 60 * u
+			l.TwinReadsPerSec = 500 * u
+			l.TwinUpdatesPerSec = 250 * u
+			l.DirectMethodBytesPerSec = (24 << 20) * u
+		}
+		return l, nil
+	default:
+		return nil, fmt.Errorf("unknown tier %q", tier)
+	}
+}
+
+// ServiceStats retrieves the service statistic.
+func (c *Client) ServiceStats(ctx context.Context) (*ServiceStats, error) {
+	v := &ServiceStats{}
+	if err := c.call(ctx, http.MethodGet, "statistics/service", nil, nil, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Quota is the hub limits along with its current consumption.
+type Quota struct {
+	Tier   Tier
+	Units  int
+	Limits *Limits
+
+	TotalDevices     int
+	EnabledDevices   int
+	ConnectedDevices int
+}
+
+// Quota reports limits of the hub and the currently available consumption
+// figures. The tier and number of units have to be provided by the caller
+// because the service API doesn't expose them, as well as the daily message
+// usage that's available only through the Azure resource manager.
+func (c *Client) Quota(ctx context.Context, tier Tier, units int) (*Quota, error) {
+	l, err := TierLimits(tier, units)
+	if err != nil {
+		return nil, err
+	}
+	ds, err := c.Stats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ss, err := c.ServiceStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Quota{
+		Tier:             tier,
+		Units:            units,
+		Limits:           l,
+		TotalDevices:     ds.TotalDeviceCount,
+		EnabledDevices:   ds.EnabledDeviceCount,
+		ConnectedDevices: ss.ConnectedDeviceCount,
+	}, nil
+}
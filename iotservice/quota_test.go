@@ -0,0 +1,57 @@
+package iotservice
+
+import "testing"
+
+func TestTierLimits(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range map[string]struct {
+		tier          Tier
+		units         int
+		reads, writes float64
+	}{
+		"F1":       {tier: TierF1, units: 1, reads: 100, writes: 50},
+		"B1":       {tier: TierB1, units: 4},
+		"S1 1":     {tier: TierS1, units: 1, reads: 100, writes: 50},
+		"S1 20":    {tier: TierS1, units: 20, reads: 100, writes: 50},
+		"S2 1":     {tier: TierS2, units: 1, reads: 100, writes: 50},
+		"S2 10":    {tier: TierS2, units: 10, reads: 100, writes: 50},
+		"S2 20":    {tier: TierS2, units: 20, reads: 200, writes: 100},
+		"S3 1":     {tier: TierS3, units: 1, reads: 500, writes: 250},
+		"S3 10":    {tier: TierS3, units: 10, reads: 5000, writes: 2500},
+		"B3 units": {tier: TierB3, units: 2},
+	} {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			l, err := TierLimits(tc.tier, tc.units)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if l.TwinReadsPerSec != tc.reads {
+				t.Errorf("twin reads = %v, want %v", l.TwinReadsPerSec, tc.reads)
+			}
+			if l.TwinUpdatesPerSec != tc.writes {
+				t.Errorf("twin updates = %v, want %v", l.TwinUpdatesPerSec, tc.writes)
+			}
+		})
+	}
+}
+
+func TestTierLimits_Invalid(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range map[string]struct {
+		tier  Tier
+		units int
+	}{
+		"no units": {tier: TierS1, units: 0},
+		"F1 units": {tier: TierF1, units: 2},
+		"unknown":  {tier: "X1", units: 1},
+	} {
+		if _, err := TierLimits(tc.tier, tc.units); err == nil {
+			t.Errorf("%s: TierLimits(%q, %d) = nil error", name, tc.tier, tc.units)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package iotservice
+
+import (
+	"context"
+	"time"
+)
+
+// MetricErrorCount is the conventional name of a custom configuration
+// metric that matches devices failed to apply it, when a configuration
+// defines it rollout progress reports the error percentage.
+const MetricErrorCount = "errorCount"
+
+// RolloutProgress is a snapshot of a configuration rollout.
+type RolloutProgress struct {
+	*ConfigurationReport
+	AppliedPercent float64
+	ErrorPercent   float64
+
+	// Converged is true when all targeted devices
+	// either applied the configuration or failed to.
+	Converged bool
+}
+
+func newRolloutProgress(r *ConfigurationReport) *RolloutProgress {
+	p := &RolloutProgress{ConfigurationReport: r}
+	failed := r.Metrics[MetricErrorCount]
+	if r.Targeted > 0 {
+		p.AppliedPercent = 100 * float64(r.Applied) / float64(r.Targeted)
+		p.ErrorPercent = 100 * float64(failed) / float64(r.Targeted)
+	}
+	p.Converged = r.Targeted > 0 && r.Applied+failed >= r.Targeted
+	return p
+}
+
+// WatchRollout evaluates metrics of the named configuration every interval
+// calling fn with the current progress until the rollout converges,
+// in that case the last progress is returned, or ctx is done.
+//
+// To wait for a healthy rollout pass a context with a deadline and
+// check ErrorPercent of the result.
+func (c *Client) WatchRollout(
+	ctx context.Context,
+	configID string,
+	interval time.Duration,
+	fn func(p *RolloutProgress),
+) (*RolloutProgress, error) {
+	if interval <= 0 {
+		panic("interval must be positive")
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		r, err := c.EvaluateConfigurationMetrics(ctx, configID)
+		if err != nil {
+			return nil, err
+		}
+		p := newRolloutProgress(r)
+		if fn != nil {
+			fn(p)
+		}
+		if p.Converged {
+			return p, nil
+		}
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return p, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package iotservice
+
+import "testing"
+
+func TestNewRolloutProgress(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range map[string]struct {
+		report    ConfigurationReport
+		applied   float64
+		errors    float64
+		converged bool
+	}{
+		"no targets": {
+			ConfigurationReport{}, 0, 0, false,
+		},
+		"in progress": {
+			ConfigurationReport{Targeted: 4, Applied: 1}, 25, 0, false,
+		},
+		"applied": {
+			ConfigurationReport{Targeted: 4, Applied: 4}, 100, 0, true,
+		},
+		"with errors": {
+			ConfigurationReport{
+				Targeted: 4,
+				Applied:  3,
+				Metrics:  map[string]int{MetricErrorCount: 1},
+			}, 75, 25, true,
+		},
+	} {
+		r := tc.report
+		p := newRolloutProgress(&r)
+		if p.AppliedPercent != tc.applied || p.ErrorPercent != tc.errors || p.Converged != tc.converged {
+			t.Errorf("%s: progress = (%v, %v, %t), want (%v, %v, %t)", name,
+				p.AppliedPercent, p.ErrorPercent, p.Converged,
+				tc.applied, tc.errors, tc.converged,
+			)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package iotservice
+
+import (
+	"context"
+)
+
+// Run establishes the AMQP connection and keeps the client running until
+// ctx is done, the client is closed or a fatal error occurs, then it closes
+// the client. Connections are re-established on demand and feedback of
+// futures, see SendEventAsync, is resubscribed in the background,
+// Run only watches for errors they cannot recover from, e.g. the hub
+// rejecting the client's credentials.
+//
+// It returns nil when ctx is done, so it drops into errgroup-managed
+// services along with subscriptions that share the same ctx:
+//
+//	g, ctx := errgroup.WithContext(ctx)
+//	g.Go(func() error {
+//		return c.Run(ctx)
+//	})
+//	g.Go(func() error {
+//		return c.SubscribeEventsContext(ctx, handler)
+//	})
+//	return g.Wait()
+func (c *Client) Run(ctx context.Context) error {
+	defer c.Close()
+	if _, err := c.connect(ctx); err != nil {
+		if ctx.Err() != nil || err == ErrClosed {
+			return nil
+		}
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-c.done:
+		return nil
+	case err := <-c.fatal:
+		return err
+	}
+}
+
+// fail makes Run return err, errors that come after the first one are dropped.
+func (c *Client) fail(err error) {
+	select {
+	case c.fatal <- err:
+	default:
+	}
+}
@@ -0,0 +1,42 @@
+package iotservice
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	for name, stop := range map[string]func(c *Client, cancel context.CancelFunc){
+		"canceled": func(c *Client, cancel context.CancelFunc) {
+			cancel()
+		},
+		"closed": func(c *Client, cancel context.CancelFunc) {
+			c.Close()
+		},
+	} {
+		name, stop := name, stop
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			c, err := NewClient(WithConnectionString(
+				"HostName=localhost;SharedAccessKeyName=iothubowner;SharedAccessKey=c2VjcmV0",
+			))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			stop(c, cancel)
+			if err = c.Run(ctx); err != nil {
+				t.Fatalf("Run() = %v, want nil", err)
+			}
+			select {
+			case <-c.done:
+			default:
+				t.Error("client is not closed")
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package iotservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+)
+
+// SetParent makes the named edge device parent of the named child device.
+//
+// A leaf device inherits the parent's device scope, when
+// the child is an edge device only its parent scopes change.
+func (c *Client) SetParent(ctx context.Context, childID, parentID string) (*Device, error) {
+	if childID == "" {
+		return nil, errors.New("childID is empty")
+	}
+	if parentID == "" {
+		return nil, errors.New("parentID is empty")
+	}
+	parent, err := c.GetDevice(ctx, parentID)
+	if err != nil {
+		return nil, err
+	}
+	if !parent.IsEdge() || parent.DeviceScope == "" {
+		return nil, fmt.Errorf("device %q is not an edge device", parentID)
+	}
+	child, err := c.GetDevice(ctx, childID)
+	if err != nil {
+		return nil, err
+	}
+	if !child.IsEdge() {
+		child.DeviceScope = parent.DeviceScope
+	}
+	child.ParentScopes = []string{parent.DeviceScope}
+	return c.UpdateDevice(ctx, child)
+}
+
+// RemoveParent detaches the named device from its parent.
+func (c *Client) RemoveParent(ctx context.Context, childID string) (*Device, error) {
+	if childID == "" {
+		return nil, errors.New("childID is empty")
+	}
+	child, err := c.GetDevice(ctx, childID)
+	if err != nil {
+		return nil, err
+	}
+	if !child.IsEdge() {
+		child.DeviceScope = ""
+	}
+	child.ParentScopes = []string{}
+	return c.UpdateDevice(ctx, child)
+}
+
+// edgeScopePrefix prefixes device scopes of edge devices,
+// followed by the device id and a generation suffix.
+const edgeScopePrefix = "ms-azure-iot-edge://"
+
+// scopeDeviceID returns id of the edge device that owns the given scope.
+func scopeDeviceID(scope string) (string, bool) {
+	if !strings.HasPrefix(scope, edgeScopePrefix) {
+		return "", false
+	}
+	s := strings.TrimPrefix(scope, edgeScopePrefix)
+	i := strings.LastIndexByte(s, '-')
+	if i <= 0 {
+		return "", false
+	}
+	return s[:i], true
+}
+
+// GetParent returns parent of the named device or nil if it has no parent,
+// including when the parent is deleted or recreated with another scope.
+func (c *Client) GetParent(ctx context.Context, childID string) (*Device, error) {
+	child, err := c.GetDevice(ctx, childID)
+	if err != nil {
+		return nil, err
+	}
+	if len(child.ParentScopes) == 0 {
+		return nil, nil
+	}
+	scope := child.ParentScopes[0]
+	parentID, ok := scopeDeviceID(scope)
+	if !ok {
+		return nil, fmt.Errorf("malformed parent scope %q", scope)
+	}
+	parent, err := c.GetDevice(ctx, parentID)
+	if err != nil {
+		if errors.Is(err, common.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if !parent.IsEdge() || parent.DeviceScope != scope {
+		return nil, nil
+	}
+	return parent, nil
+}
+
+// ListChildren lists all devices that the named edge gateway is parent of.
+//
+// Children are looked up with a query and then retrieved one by one,
+// because query results are twins that lack identity details.
+func (c *Client) ListChildren(ctx context.Context, gatewayID string) ([]*Device, error) {
+	gw, err := c.GetDevice(ctx, gatewayID)
+	if err != nil {
+		return nil, err
+	}
+	if !gw.IsEdge() || gw.DeviceScope == "" {
+		return nil, fmt.Errorf("device %q is not an edge device", gatewayID)
+	}
+	lit, err := queryLiteral(gw.DeviceScope)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	if err = c.QueryFunc(ctx,
+		"SELECT deviceId FROM devices WHERE ARRAY_CONTAINS(parentScopes, "+lit+")",
+		func(v map[string]interface{}) error {
+			id, ok := v["deviceId"].(string)
+			if !ok {
+				return fmt.Errorf("malformed query result %v", v)
+			}
+			ids = append(ids, id)
+			return nil
+		},
+	); err != nil {
+		return nil, err
+	}
+	children := make([]*Device, 0, len(ids))
+	for _, id := range ids {
+		d, err := c.GetDevice(ctx, id)
+		if err != nil {
+			if errors.Is(err, common.ErrNotFound) {
+				continue // deleted meanwhile
+			}
+			return nil, err
+		}
+		children = append(children, d)
+	}
+	return children, nil
+}
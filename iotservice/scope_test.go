@@ -0,0 +1,120 @@
+package iotservice
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// scopeHandler serves the given devices and children queries recording them.
+func scopeHandler(t *testing.T, devices map[string]*Device, query *string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/devices/query" {
+			b, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Error(err)
+			}
+			var v struct{ Query string }
+			if err = json.Unmarshal(b, &v); err != nil {
+				t.Error(err)
+			}
+			*query = v.Query
+			res := []map[string]string{}
+			for id, d := range devices {
+				for _, s := range d.ParentScopes {
+					if strings.Contains(v.Query, "'"+s+"'") {
+						res = append(res, map[string]string{"deviceId": id})
+					}
+				}
+			}
+			json.NewEncoder(w).Encode(res)
+			return
+		}
+		d, ok := devices[strings.TrimPrefix(r.URL.Path, "/devices/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"Message":"ErrorCode:DeviceNotFound"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(d)
+	}
+}
+
+func edgeDevice(id, scope string) *Device {
+	return &Device{
+		DeviceID:     id,
+		DeviceScope:  scope,
+		Capabilities: map[string]interface{}{"iotEdge": true},
+	}
+}
+
+func TestListChildren(t *testing.T) {
+	t.Parallel()
+
+	const scope = "ms-azure-iot-edge://gw-1-637000000000000000"
+	var query string
+	c := newTestClient(t, scopeHandler(t, map[string]*Device{
+		"gw-1":  edgeDevice("gw-1", scope),
+		"leaf":  {DeviceID: "leaf", DeviceScope: scope, ParentScopes: []string{scope}},
+		"other": {DeviceID: "other", ParentScopes: []string{"ms-azure-iot-edge://gw-2-1"}},
+	}, &query))
+
+	children, err := c.ListChildren(context.Background(), "gw-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := "SELECT deviceId FROM devices WHERE ARRAY_CONTAINS(parentScopes, '" + scope + "')"; query != w {
+		t.Errorf("query = %q, want %q", query, w)
+	}
+	var ids []string
+	for _, d := range children {
+		ids = append(ids, d.DeviceID)
+	}
+	if w := []string{"leaf"}; !reflect.DeepEqual(ids, w) {
+		t.Errorf("children = %v, want %v", ids, w)
+	}
+
+	if _, err = c.ListChildren(context.Background(), "leaf"); err == nil {
+		t.Error("children of a leaf device are listed")
+	}
+}
+
+func TestGetParent(t *testing.T) {
+	t.Parallel()
+
+	const scope = "ms-azure-iot-edge://gw-1-637000000000000000"
+	var query string
+	c := newTestClient(t, scopeHandler(t, map[string]*Device{
+		"gw-1":     edgeDevice("gw-1", scope),
+		"leaf":     {DeviceID: "leaf", ParentScopes: []string{scope}},
+		"orphan":   {DeviceID: "orphan", ParentScopes: []string{"ms-azure-iot-edge://gone-1"}},
+		"stale":    {DeviceID: "stale", ParentScopes: []string{"ms-azure-iot-edge://gw-1-1"}},
+		"detached": {DeviceID: "detached"},
+	}, &query))
+
+	for id, want := range map[string]string{
+		"leaf":     "gw-1",
+		"orphan":   "",
+		"stale":    "",
+		"detached": "",
+	} {
+		p, err := c.GetParent(context.Background(), id)
+		if err != nil {
+			t.Fatalf("%s: %s", id, err)
+		}
+		var got string
+		if p != nil {
+			got = p.DeviceID
+		}
+		if got != want {
+			t.Errorf("parent of %s = %q, want %q", id, got, want)
+		}
+	}
+	if query != "" {
+		t.Errorf("parent is looked up with a query %q", query)
+	}
+}
@@ -0,0 +1,100 @@
+package iotservice
+
+import (
+	"context"
+	"errors"
+	"math"
+
+	"github.com/amenzhinsky/golang-iothub/common/ratelimit"
+)
+
+// C2DRate returns the cloud-to-device sends rate limit of a hub.
+func C2DRate(tier Tier, units int) (float64, error) {
+	l, err := TierLimits(tier, units)
+	if err != nil {
+		return 0, err
+	}
+	if l.CloudToDeviceSendsPerSec == 0 {
+		return 0, errors.New("cloud-to-device messages are not available on " + string(tier))
+	}
+	return l.CloudToDeviceSendsPerSec, nil
+}
+
+// ThrottledSender paces cloud-to-device messages to stay
+// within the hub's throttling limits, see C2DRate.
+type ThrottledSender struct {
+	c      *Client
+	bucket *ratelimit.Bucket
+	queue  chan *queuedMessage
+}
+
+type queuedMessage struct {
+	deviceID string
+	payload  []byte
+	opts     []SendOption
+}
+
+// NewThrottledSender creates a sender that sends at most rate messages
+// per second with the given enqueued messages limit.
+func NewThrottledSender(c *Client, rate float64, queueSize int) *ThrottledSender {
+	if c == nil {
+		panic("client is nil")
+	}
+	return &ThrottledSender{
+		c:      c,
+		bucket: ratelimit.New(rate, int(math.Max(1, math.Floor(rate)))),
+		queue:  make(chan *queuedMessage, queueSize),
+	}
+}
+
+// Send waits until sending is allowed and sends the message.
+func (s *ThrottledSender) Send(
+	ctx context.Context,
+	deviceID string,
+	payload []byte,
+	opts ...SendOption,
+) error {
+	if err := s.bucket.Wait(ctx, 1); err != nil {
+		return err
+	}
+	return s.c.SendEvent(ctx, deviceID, payload, opts...)
+}
+
+// ErrQueueFull is returned when the send queue is full.
+var ErrQueueFull = errors.New("queue is full")
+
+// Enqueue adds a message to the queue that's processed by Run.
+func (s *ThrottledSender) Enqueue(deviceID string, payload []byte, opts ...SendOption) error {
+	if deviceID == "" {
+		return errors.New("device id is empty")
+	}
+	select {
+	case s.queue <- &queuedMessage{deviceID: deviceID, payload: payload, opts: opts}:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// SendErrorHandler handles errors of queued messages sending.
+type SendErrorHandler func(deviceID string, err error)
+
+// Run sends enqueued messages until ctx is done,
+// sending failures are reported to fn that can be nil.
+func (s *ThrottledSender) Run(ctx context.Context, fn SendErrorHandler) error {
+	for {
+		select {
+		case m := <-s.queue:
+			if err := s.Send(ctx, m.deviceID, m.payload, m.opts...); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if fn != nil {
+					fn(m.deviceID, err)
+				}
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
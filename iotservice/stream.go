@@ -0,0 +1,76 @@
+package iotservice
+
+import (
+	"context"
+	"iter"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+)
+
+// Events returns an iterator over device events, it's an alternative
+// to SubscribeEvents that delivers events one by one in order.
+//
+// Iteration stops when ctx is done or the loop is broken, a subscription
+// error is yielded last with a nil message, so it can be used like:
+//
+//	for msg, err := range c.Events(ctx) {
+//		if err != nil {
+//			return err
+//		}
+//		fmt.Println(msg)
+//	}
+func (c *Client) Events(ctx context.Context, opts ...SubscribeOption) iter.Seq2[*common.Message, error] {
+	return stream(ctx, func(ctx context.Context, fn func(msg *common.Message) bool) error {
+		s, err := c.NewEventSubscription(ctx, opts...)
+		if err != nil {
+			return err
+		}
+		defer s.Close()
+		for msg := range s.C() {
+			if !fn(msg) {
+				return nil
+			}
+		}
+		<-s.Done()
+		return s.Err()
+	})
+}
+
+// FeedbackRecords returns an iterator over message feedback,
+// see SubscribeFeedback and Events for details.
+//
+// The hub delivers feedback records in batches that are acknowledged
+// once all their records are yielded, breaking the loop in the middle of
+// a batch returns it to the hub, so its records are received again by
+// the next receiver including those already yielded.
+func (c *Client) FeedbackRecords(ctx context.Context) iter.Seq2[*Feedback, error] {
+	return stream(ctx, c.receiveFeedback)
+}
+
+// FileNotifications returns an iterator over file upload notifications,
+// see SubscribeFileNotifications and Events for details.
+func (c *Client) FileNotifications(ctx context.Context) iter.Seq2[*FileNotification, error] {
+	return stream(ctx, c.receiveFileNotifications)
+}
+
+// stream turns recv that calls fn for each received value until it
+// returns false into an iterator, errors caused by ctx are omitted.
+func stream[T any](
+	ctx context.Context,
+	recv func(ctx context.Context, fn func(v T) bool) error,
+) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		stopped := false
+		err := recv(ctx, func(v T) bool {
+			stopped = !yield(v, nil)
+			return !stopped
+		})
+		if err != nil && !stopped && ctx.Err() == nil {
+			var zero T
+			yield(zero, err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package iotservice
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// numbers sends 1..n to fn and returns err at the end.
+func numbers(n int, err error) func(ctx context.Context, fn func(v int) bool) error {
+	return func(ctx context.Context, fn func(v int) bool) error {
+		for i := 1; i <= n; i++ {
+			if !fn(i) {
+				return nil
+			}
+		}
+		if err == nil {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return err
+	}
+}
+
+func TestStream(t *testing.T) {
+	t.Parallel()
+
+	var g []int
+	for v, err := range stream(context.Background(), numbers(5, nil)) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if g = append(g, v); len(g) == 3 {
+			break
+		}
+	}
+	if w := []int{1, 2, 3}; !reflect.DeepEqual(g, w) {
+		t.Errorf("values = %v, want %v", g, w)
+	}
+}
+
+func TestStream_Error(t *testing.T) {
+	t.Parallel()
+
+	errRecv := errors.New("receive error")
+	var g []int
+	var gerr error
+	for v, err := range stream(context.Background(), numbers(2, errRecv)) {
+		if err != nil {
+			gerr = err
+			continue
+		}
+		g = append(g, v)
+	}
+	if w := []int{1, 2}; !reflect.DeepEqual(g, w) {
+		t.Errorf("values = %v, want %v", g, w)
+	}
+	if gerr != errRecv {
+		t.Errorf("err = %v, want %v", gerr, errRecv)
+	}
+}
+
+func TestStream_Cancel(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	n := 0
+	for _, err := range stream(ctx, numbers(2, nil)) {
+		if err != nil {
+			t.Fatalf("err = %v, want nil when ctx is canceled", err)
+		}
+		if n++; n == 2 {
+			cancel()
+		}
+	}
+	if n != 2 {
+		t.Errorf("values = %d, want 2", n)
+	}
+}
+
+func TestDispatchFeedback(t *testing.T) {
+	t.Parallel()
+
+	b := []byte(`[{"originalMessageId":"1"},{"originalMessageId":"2"}]`)
+	for name, tc := range map[string]struct {
+		max int
+		ids []string
+		ok  bool
+	}{
+		"consumed": {max: 3, ids: []string{"1", "2"}, ok: true},
+		"stopped":  {max: 1, ids: []string{"1"}, ok: false},
+	} {
+		name, tc := name, tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var ids []string
+			ok, err := dispatchFeedback(b, func(f *Feedback) bool {
+				ids = append(ids, f.OriginalMessageID)
+				return len(ids) < tc.max
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tc.ok {
+				t.Errorf("consumed = %t, want %t", ok, tc.ok)
+			}
+			if !reflect.DeepEqual(ids, tc.ids) {
+				t.Errorf("ids = %v, want %v", ids, tc.ids)
+			}
+		})
+	}
+}
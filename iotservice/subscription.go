@@ -0,0 +1,240 @@
+package iotservice
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+	"github.com/amenzhinsky/golang-iothub/eventhub"
+	"pack.ag/amqp"
+)
+
+// SubscribeOption is an events subscription option.
+type SubscribeOption func(o *subscribeOptions)
+
+type subscribeOptions struct {
+	dedupe         time.Duration
+	workers        int
+	transforms     []Transform
+	onTransformErr TransformErrorHandler
+	lazy           bool
+	buffer         int
+}
+
+// WithDedupeWindow drops events which dedupe key, see common.Message.DedupeKey,
+// has already been seen within the given window, because at-least-once
+// delivery makes devices resend messages that weren't acknowledged.
+// Events without a message id and a dedupe key are never dropped.
+func WithDedupeWindow(d time.Duration) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.dedupe = d
+	}
+}
+
+// WithOrderedDevices makes SubscribeEvents run handlers on the given number
+// of workers partitioned by ConnectionDeviceID, so events of the same device
+// are handled one by one in order while different devices are handled
+// concurrently. Channel subscriptions always deliver events in order.
+func WithOrderedDevices(workers int) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.workers = workers
+	}
+}
+
+// WithLazyProperties defers parsing of custom message properties until
+// they're accessed with common.Message Property or LoadProperties methods,
+// that saves CPU for consumers that only need system properties and payloads.
+// The Properties field is nil until LoadProperties is called.
+func WithLazyProperties() SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.lazy = true
+	}
+}
+
+// WithChanBuffer sets the buffer size of channels that events are delivered
+// to by SubscribeEventsChan and NewEventSubscription, default is unbuffered.
+func WithChanBuffer(size int) SubscribeOption {
+	if size < 0 {
+		panic("size is negative")
+	}
+	return func(o *subscribeOptions) {
+		o.buffer = size
+	}
+}
+
+func (c *Client) newSubscribeOptions(opts []SubscribeOption) *subscribeOptions {
+	o := &subscribeOptions{
+		onTransformErr: func(msg *common.Message, err error) {
+			c.reportError(fmt.Errorf("transform error: %w", err))
+		},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// filter wraps fn to apply the configured filters and transforms.
+func (o *subscribeOptions) filter(fn func(msg *common.Message)) func(msg *common.Message) {
+	if len(o.transforms) != 0 {
+		t, next := Chain(o.transforms...), fn
+		fn = func(msg *common.Message) {
+			res, err := t(msg)
+			if err != nil {
+				if o.onTransformErr != nil {
+					o.onTransformErr(msg, err)
+				}
+				return
+			}
+			if res != nil {
+				next(res)
+			}
+		}
+	}
+	if o.dedupe > 0 {
+		f := newDedupeFilter(o.dedupe)
+		next := fn
+		fn = func(msg *common.Message) {
+			if f.seen(msg.DedupeKey(), time.Now()) {
+				return
+			}
+			next(msg)
+		}
+	}
+	return fn
+}
+
+// EventSubscription is a channel-based device events subscription.
+type EventSubscription struct {
+	mu     sync.RWMutex
+	ch     chan *common.Message
+	closed bool
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// C returns events channel, it's closed when the subscription stops.
+func (s *EventSubscription) C() <-chan *common.Message {
+	return s.ch
+}
+
+// Done is closed when the subscription stops.
+func (s *EventSubscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the error the subscription stopped with, it's nil when it's
+// stopped by ctx or Close. It should be called only after Done is closed.
+func (s *EventSubscription) Err() error {
+	return s.err
+}
+
+// Close stops the subscription and waits until it's finished.
+func (s *EventSubscription) Close() error {
+	s.cancel()
+	<-s.done
+	return nil
+}
+
+func (s *EventSubscription) send(ctx context.Context, msg *common.Message) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.ch <- msg:
+	case <-ctx.Done():
+	}
+}
+
+func (s *EventSubscription) close(err error) {
+	s.cancel()
+	s.mu.Lock()
+	s.closed = true
+	close(s.ch)
+	s.mu.Unlock()
+	s.err = err
+	close(s.done)
+}
+
+// newEventSubscription starts a subscription that delivers events
+// received by recv until it returns or the subscription is stopped.
+func newEventSubscription(
+	ctx context.Context,
+	size int,
+	filter func(fn func(msg *common.Message)) func(msg *common.Message),
+	recv func(ctx context.Context, fn func(msg *common.Message)) error,
+) *EventSubscription {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &EventSubscription{
+		ch:     make(chan *common.Message, size),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	h := filter(func(msg *common.Message) {
+		s.send(ctx, msg)
+	})
+	go func() {
+		err := recv(ctx, h)
+		if ctx.Err() != nil {
+			err = nil // stopped on purpose
+		}
+		s.close(err)
+	}()
+	return s
+}
+
+// NewEventSubscription subscribes to device events delivering them
+// to the subscription's channel, see WithChanBuffer, it returns when
+// the connection is established leaving consumption in the background.
+//
+// The subscription stops when ctx is done or Close is called, unlike
+// SubscribeEventsChan it tells why events stopped coming, see Err.
+func (c *Client) NewEventSubscription(ctx context.Context, opts ...SubscribeOption) (*EventSubscription, error) {
+	conn, group, err := c.connectToEventHub(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := conn.NewSession()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	o := c.newSubscribeOptions(opts)
+	return newEventSubscription(ctx, o.buffer, o.filter, func(ctx context.Context, fn func(msg *common.Message)) error {
+		defer conn.Close()
+		defer sess.Close()
+		return eventhub.SubscribePartitions(ctx, sess, group, "$Default", func(msg *amqp.Message) {
+			fn(c.fromAMQPMessage(msg, o.lazy))
+		})
+	}), nil
+}
+
+// SubscribeEventsChan is an alternative to SubscribeEvents that delivers
+// device events to a channel, see WithChanBuffer, it returns when the
+// connection is established leaving consumption in the background.
+//
+// The channel is closed when ctx is done or the subscription fails, its
+// error is passed to the error handler, see WithErrorHandler. Consumption
+// can be stopped on its own by canceling a context derived from ctx.
+func (c *Client) SubscribeEventsChan(ctx context.Context, opts ...SubscribeOption) (<-chan *common.Message, error) {
+	s, err := c.NewEventSubscription(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	go c.watchSubscription(s)
+	return s.C(), nil
+}
+
+// watchSubscription reports the error the subscription stops with.
+func (c *Client) watchSubscription(s *EventSubscription) {
+	<-s.Done()
+	if err := s.Err(); err != nil {
+		c.reportError(fmt.Errorf("events subscription error: %w", err))
+	}
+}
@@ -0,0 +1,100 @@
+package iotservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+)
+
+func nopFilter(fn func(msg *common.Message)) func(msg *common.Message) {
+	return fn
+}
+
+// recvMessages delivers n messages and returns err,
+// or waits until ctx is done when err is nil.
+func recvMessages(n int, err error) func(ctx context.Context, fn func(msg *common.Message)) error {
+	return func(ctx context.Context, fn func(msg *common.Message)) error {
+		for i := 0; i < n; i++ {
+			fn(&common.Message{MessageID: string(rune('a' + i))})
+		}
+		if err != nil {
+			return err
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	}
+}
+
+func TestEventSubscription(t *testing.T) {
+	t.Parallel()
+
+	s := newEventSubscription(context.Background(), 2, nopFilter, recvMessages(3, nil))
+	for _, w := range []string{"a", "b", "c"} {
+		select {
+		case msg := <-s.C():
+			if msg.MessageID != w {
+				t.Fatalf("message id = %q, want %q", msg.MessageID, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("message is not delivered")
+		}
+	}
+
+	// stops without canceling the parent context
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-s.C(); ok {
+		t.Error("channel is not closed")
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("Err() = %v, want nil", err)
+	}
+}
+
+func TestEventSubscription_Close(t *testing.T) {
+	t.Parallel()
+
+	// nobody reads the channel, so the sender is blocked
+	s := newEventSubscription(context.Background(), 0, nopFilter, recvMessages(2, nil))
+	done := make(chan struct{})
+	go func() {
+		s.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Close() is blocked by an undelivered message")
+	}
+}
+
+func TestEventSubscription_Err(t *testing.T) {
+	t.Parallel()
+
+	errRecv := errors.New("receiver failure")
+	s := newEventSubscription(context.Background(), 1, nopFilter, recvMessages(1, errRecv))
+	if msg := <-s.C(); msg == nil || msg.MessageID != "a" {
+		t.Fatalf("message = %v, want a", msg)
+	}
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("subscription is not stopped")
+	}
+	if err := s.Err(); err != errRecv {
+		t.Errorf("Err() = %v, want %v", err, errRecv)
+	}
+
+	var reported error
+	c := &Client{onError: func(err error) {
+		reported = err
+	}}
+	c.watchSubscription(s)
+	if !errors.Is(reported, errRecv) {
+		t.Errorf("reported error = %v, want %v", reported, errRecv)
+	}
+}
@@ -0,0 +1,94 @@
+package iotservice
+
+import (
+	"github.com/amenzhinsky/golang-iothub/common"
+)
+
+// Transform is an event pre-processing stage, it can modify the message
+// or return a new one, nil result drops the message.
+type Transform func(msg *common.Message) (*common.Message, error)
+
+// TransformErrorHandler handles messages that failed pre-processing,
+// such messages are not passed to the subscription handler.
+type TransformErrorHandler func(msg *common.Message, err error)
+
+// WithTransforms adds stages that run in order before the subscription
+// handler, e.g. decryption, schema validation and enrichment, so services
+// can share a common pre-processing chain. Compressed payloads are always
+// decompressed before any stage runs.
+func WithTransforms(ts ...Transform) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.transforms = append(o.transforms, ts...)
+	}
+}
+
+// WithTransformErrorHandler sets the handler of pre-processing
+// errors, by default they are logged at the error level.
+func WithTransformErrorHandler(fn TransformErrorHandler) SubscribeOption {
+	return func(o *subscribeOptions) {
+		o.onTransformErr = fn
+	}
+}
+
+// Chain composes the given stages into a single one.
+func Chain(ts ...Transform) Transform {
+	return func(msg *common.Message) (*common.Message, error) {
+		var err error
+		for _, t := range ts {
+			if msg, err = t(msg); err != nil || msg == nil {
+				return nil, err
+			}
+		}
+		return msg, nil
+	}
+}
+
+// PayloadTransform returns a stage that replaces payload with
+// the result of fn, e.g. to decrypt end-to-end encrypted messages.
+func PayloadTransform(fn func(b []byte) ([]byte, error)) Transform {
+	if fn == nil {
+		panic("fn is nil")
+	}
+	return func(msg *common.Message) (*common.Message, error) {
+		b, err := fn(msg.Payload)
+		if err != nil {
+			return nil, err
+		}
+		msg.Payload = b
+		return msg, nil
+	}
+}
+
+// ValidateTransform returns a stage that rejects messages fn fails for.
+func ValidateTransform(fn func(msg *common.Message) error) Transform {
+	if fn == nil {
+		panic("fn is nil")
+	}
+	return func(msg *common.Message) (*common.Message, error) {
+		if err := fn(msg); err != nil {
+			return nil, err
+		}
+		return msg, nil
+	}
+}
+
+// EnrichTransform returns a stage that sets the properties
+// returned by fn on every message overriding existing ones.
+func EnrichTransform(fn func(msg *common.Message) map[string]string) Transform {
+	if fn == nil {
+		panic("fn is nil")
+	}
+	return func(msg *common.Message) (*common.Message, error) {
+		m := fn(msg)
+		if len(m) == 0 {
+			return msg, nil
+		}
+		if msg.Properties == nil {
+			msg.Properties = make(map[string]string, len(m))
+		}
+		for k, v := range m {
+			msg.Properties[k] = v
+		}
+		return msg, nil
+	}
+}
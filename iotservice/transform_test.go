@@ -0,0 +1,59 @@
+package iotservice
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+)
+
+func TestTransforms(t *testing.T) {
+	t.Parallel()
+
+	var (
+		got    []*common.Message
+		failed []error
+	)
+	o := (&Client{}).newSubscribeOptions([]SubscribeOption{
+		WithTransforms(
+			PayloadTransform(func(b []byte) ([]byte, error) {
+				return bytes.ToUpper(b), nil
+			}),
+			ValidateTransform(func(msg *common.Message) error {
+				if len(msg.Payload) == 0 {
+					return errors.New("empty payload")
+				}
+				return nil
+			}),
+			func(msg *common.Message) (*common.Message, error) {
+				if string(msg.Payload) == "DROP" {
+					return nil, nil
+				}
+				return msg, nil
+			},
+			EnrichTransform(func(msg *common.Message) map[string]string {
+				return map[string]string{"site": "a"}
+			}),
+		),
+		WithTransformErrorHandler(func(msg *common.Message, err error) {
+			failed = append(failed, err)
+		}),
+	})
+	h := o.filter(func(msg *common.Message) {
+		got = append(got, msg)
+	})
+	for _, p := range []string{"hello", "", "drop"} {
+		h(&common.Message{Payload: []byte(p)})
+	}
+
+	if len(got) != 1 {
+		t.Fatalf("handled %d messages, want 1", len(got))
+	}
+	if string(got[0].Payload) != "HELLO" || got[0].Properties["site"] != "a" {
+		t.Errorf("message = %q %v, want HELLO with site=a", got[0].Payload, got[0].Properties)
+	}
+	if len(failed) != 1 {
+		t.Errorf("failed %d messages, want 1", len(failed))
+	}
+}
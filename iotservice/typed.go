@@ -0,0 +1,55 @@
+package iotservice
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+)
+
+// TypedMessageHandler handles device events decoded into T.
+type TypedMessageHandler[T any] func(msg *common.Message, v T)
+
+// DecodeErrorHandler handles events that payloads cannot be decoded.
+type DecodeErrorHandler func(msg *common.Message, err error)
+
+// Subscribe is like SubscribeEvents but decodes payloads into T with
+// the client's codec, see WithCodec, before calling fn. Events that cannot
+// be decoded are passed to onErr, when it's nil they're reported to the
+// client's error handler, see WithErrorHandler, and dropped.
+//
+// It's a function because Go doesn't support type parameters on methods.
+func Subscribe[T any](
+	ctx context.Context,
+	c *Client,
+	fn TypedMessageHandler[T],
+	onErr DecodeErrorHandler,
+	opts ...SubscribeOption,
+) error {
+	if c == nil {
+		panic("c is nil")
+	}
+	if fn == nil {
+		panic("fn is nil")
+	}
+	if onErr == nil {
+		onErr = func(msg *common.Message, err error) {
+			c.reportError(err)
+		}
+	}
+	return c.SubscribeEvents(ctx, decodeHandler(c.codec, fn, onErr), opts...)
+}
+
+// decodeHandler returns a handler that decodes payloads into T.
+func decodeHandler[T any](
+	codec common.Codec, fn TypedMessageHandler[T], onErr DecodeErrorHandler,
+) MessageHandler {
+	return func(msg *common.Message) {
+		var v T
+		if err := codec.Unmarshal(msg.Payload, &v); err != nil {
+			onErr(msg, fmt.Errorf("decode error: %w", err))
+			return
+		}
+		fn(msg, v)
+	}
+}
@@ -0,0 +1,31 @@
+package iotservice
+
+import (
+	"testing"
+
+	"github.com/amenzhinsky/golang-iothub/common"
+)
+
+func TestDecodeHandler(t *testing.T) {
+	t.Parallel()
+
+	type telemetry struct {
+		Temperature float64 `json:"temperature"`
+	}
+	var got []telemetry
+	var bad []*common.Message
+	h := decodeHandler(common.JSONCodec{}, func(msg *common.Message, v telemetry) {
+		got = append(got, v)
+	}, func(msg *common.Message, err error) {
+		bad = append(bad, msg)
+	})
+
+	h(&common.Message{Payload: []byte(`{"temperature":21.5}`)})
+	h(&common.Message{Payload: []byte(`not json`)})
+	if len(got) != 1 || got[0].Temperature != 21.5 {
+		t.Errorf("decoded = %v, want [{21.5}]", got)
+	}
+	if len(bad) != 1 || string(bad[0].Payload) != "not json" {
+		t.Errorf("malformed = %v, want the second message", bad)
+	}
+}
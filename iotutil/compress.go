@@ -0,0 +1,64 @@
+package iotutil
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Payload compression content encodings.
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+)
+
+// IsCompressed reports whether the given content encoding
+// is one of supported compression algorithms.
+func IsCompressed(encoding string) bool {
+	return encoding == EncodingGzip || encoding == EncodingDeflate
+}
+
+// Compress compresses b with the named encoding.
+func Compress(encoding string, b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case EncodingGzip:
+		w = gzip.NewWriter(&buf)
+	case EncodingDeflate:
+		var err error
+		if w, err = flate.NewWriter(&buf, flate.DefaultCompression); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress decompresses b compressed with the named encoding.
+func Decompress(encoding string, b []byte) ([]byte, error) {
+	var r io.ReadCloser
+	switch encoding {
+	case EncodingGzip:
+		var err error
+		if r, err = gzip.NewReader(bytes.NewReader(b)); err != nil {
+			return nil, err
+		}
+	case EncodingDeflate:
+		r = flate.NewReader(bytes.NewReader(b))
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
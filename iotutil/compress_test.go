@@ -0,0 +1,31 @@
+package iotutil
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompress(t *testing.T) {
+	t.Parallel()
+
+	b := bytes.Repeat([]byte(`{"temperature":20.5}`), 100)
+	for _, enc := range []string{EncodingGzip, EncodingDeflate} {
+		c, err := Compress(enc, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(c) >= len(b) {
+			t.Errorf("%s: compressed size %d >= %d", enc, len(c), len(b))
+		}
+		d, err := Decompress(enc, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(d, b) {
+			t.Errorf("%s: decompressed payload differs", enc)
+		}
+	}
+	if _, err := Compress("br", b); err == nil {
+		t.Error("unsupported encoding is accepted")
+	}
+}
@@ -0,0 +1,64 @@
+package iotutil
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"time"
+)
+
+// UUID generates a random RFC 4122 version 4 UUID.
+func UUID() (string, error) {
+	var b [16]byte
+	if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // variant 10
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// crockford is the Crockford's base32 alphabet used by ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULID generates a universally unique lexicographically sortable identifier,
+// ids generated in different milliseconds sort by their creation time.
+//
+// See: https://github.com/ulid/spec
+func ULID() (string, error) {
+	return newULID(time.Now())
+}
+
+func newULID(t time.Time) (string, error) {
+	var b [16]byte
+	ms := uint64(t.UnixNano() / int64(time.Millisecond))
+	for i := 5; i >= 0; i-- {
+		b[i] = byte(ms)
+		ms >>= 8
+	}
+	if _, err := io.ReadFull(rand.Reader, b[6:]); err != nil {
+		return "", err
+	}
+
+	// encode 128 bits into 26 characters, 5 bits per character,
+	// the first character holds only the 3 most significant bits.
+	var s [26]byte
+	var acc uint32
+	var bits uint
+	j := len(s) - 1
+	for i := len(b) - 1; i >= 0; i-- {
+		acc |= uint32(b[i]) << bits
+		bits += 8
+		for bits >= 5 {
+			s[j] = crockford[acc&0x1f]
+			acc >>= 5
+			bits -= 5
+			j--
+		}
+	}
+	s[0] = crockford[acc&0x1f]
+	return string(s[:]), nil
+}
+
+// IDGenerator generates message identifiers, e.g. UUID or ULID.
+type IDGenerator func() (string, error)
@@ -0,0 +1,45 @@
+package iotutil
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestUUID(t *testing.T) {
+	t.Parallel()
+
+	re := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	for i := 0; i < 100; i++ {
+		s, err := UUID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !re.MatchString(s) {
+			t.Fatalf("UUID() = %q, doesn't match %s", s, re)
+		}
+	}
+}
+
+func TestULID(t *testing.T) {
+	t.Parallel()
+
+	ts := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	a, err := newULID(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := newULID(ts.Add(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != 26 {
+		t.Errorf("len(ULID()) = %d, want %d", len(a), 26)
+	}
+	if a[:10] != "01C2QG9400" {
+		t.Errorf("ULID() timestamp = %q, want %q", a[:10], "01C2QG9400")
+	}
+	if a >= b {
+		t.Errorf("ULIDs are not sorted by time: %q >= %q", a, b)
+	}
+}
@@ -0,0 +1,119 @@
+package iotutil
+
+import (
+	"encoding/hex"
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PayloadFormat is a payload formatting mode.
+type PayloadFormat string
+
+const (
+	// FormatAuto formats printable UTF-8 payloads as strings
+	// and everything else as hexdump, it's the default.
+	FormatAuto PayloadFormat = "auto"
+
+	// FormatString formats payloads as raw unquoted strings.
+	FormatString PayloadFormat = "string"
+
+	// FormatHex formats payloads as a single-line hex string.
+	FormatHex PayloadFormat = "hex"
+
+	// FormatHexdump formats payloads with offset, hex and ASCII columns
+	// the same way as `hexdump -C` does.
+	FormatHexdump PayloadFormat = "hexdump"
+)
+
+// ParsePayloadFormat parses the given string into a payload format.
+func ParsePayloadFormat(s string) (PayloadFormat, error) {
+	switch f := PayloadFormat(s); f {
+	case FormatAuto, FormatString, FormatHex, FormatHexdump:
+		return f, nil
+	case "":
+		return FormatAuto, nil
+	default:
+		return "", fmt.Errorf("unknown payload format %q", s)
+	}
+}
+
+type payloadFormatter struct {
+	format PayloadFormat
+	max    int
+}
+
+// FormatOption is a FormatPayload option.
+type FormatOption func(f *payloadFormatter)
+
+// WithFormat sets formatting mode.
+func WithFormat(format PayloadFormat) FormatOption {
+	return func(f *payloadFormatter) {
+		f.format = format
+	}
+}
+
+// WithMaxLength truncates payloads longer than n bytes, zero means no limit.
+func WithMaxLength(n int) FormatOption {
+	return func(f *payloadFormatter) {
+		f.max = n
+	}
+}
+
+// FormatPayload formats the given payload to be human-readable.
+func FormatPayload(b []byte, opts ...FormatOption) string {
+	f := &payloadFormatter{format: FormatAuto}
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	var suffix string
+	if n := f.max; n > 0 && len(b) > n {
+		// don't split multi-byte runes when the payload may be printed
+		// as a string, otherwise valid UTF-8 turns into a hexdump
+		if f.format == FormatAuto || f.format == FormatString {
+			for n > 0 && !utf8.RuneStart(b[n]) {
+				n--
+			}
+			if n == 0 {
+				n = f.max
+			}
+		}
+		suffix = fmt.Sprintf("... (%d more bytes)", len(b)-n)
+		b = b[:n]
+	}
+
+	format := f.format
+	if format == FormatAuto {
+		if isPrintable(b) {
+			format = FormatString
+		} else {
+			format = FormatHexdump
+		}
+	}
+	switch format {
+	case FormatString:
+		return string(b) + suffix
+	case FormatHex:
+		return hex.EncodeToString(b) + suffix
+	case FormatHexdump:
+		if suffix != "" {
+			suffix = suffix[4:] + "\n" // strip "... "
+		}
+		return hex.Dump(b) + suffix
+	default:
+		panic("unknown payload format " + string(format))
+	}
+}
+
+func isPrintable(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
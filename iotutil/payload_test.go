@@ -0,0 +1,35 @@
+package iotutil
+
+import "testing"
+
+func TestFormatPayload(t *testing.T) {
+	t.Parallel()
+
+	for _, s := range []struct {
+		b    []byte
+		opts []FormatOption
+		want string
+	}{
+		{[]byte("hello"), nil, "hello"},
+		{[]byte("hello"), []FormatOption{WithFormat(FormatHex)}, "68656c6c6f"},
+		{[]byte("hello"), []FormatOption{WithMaxLength(2)}, "he... (3 more bytes)"},
+		{[]byte("aпривет"), []FormatOption{WithMaxLength(2)}, "a... (12 more bytes)"},
+		{[]byte("aпривет"), []FormatOption{WithMaxLength(4)}, "aп... (10 more bytes)"},
+		{
+			[]byte("aпривет"), []FormatOption{WithMaxLength(2), WithFormat(FormatHex)},
+			"61d0... (11 more bytes)",
+		},
+		{
+			[]byte{0x00, 0x01, 'a'}, nil,
+			"00000000  00 01 61                                          |..a|\n",
+		},
+		{
+			[]byte{0x00, 0x01, 'a'}, []FormatOption{WithMaxLength(1)},
+			"00000000  00                                                |.|\n(2 more bytes)\n",
+		},
+	} {
+		if g := FormatPayload(s.b, s.opts...); g != s.want {
+			t.Errorf("FormatPayload(%q) = %q, want %q", s.b, g, s.want)
+		}
+	}
+}
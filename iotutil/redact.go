@@ -0,0 +1,62 @@
+// Package iotutil contains helpers shared by device and service packages
+// that are useful for applications as well.
+package iotutil
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces secrets in redacted strings.
+const Redacted = "REDACTED"
+
+// RedactConnectionString masks the shared access key of the given connection string.
+func RedactConnectionString(cs string) string {
+	chunks := strings.Split(cs, ";")
+	for i, chunk := range chunks {
+		if strings.HasPrefix(chunk, "SharedAccessKey=") {
+			chunks[i] = "SharedAccessKey=" + Redacted
+		}
+	}
+	return strings.Join(chunks, ";")
+}
+
+// RedactSAS masks signature of the given shared access signature token.
+func RedactSAS(sas string) string {
+	return sigRegexp.ReplaceAllString(sas, "${1}"+Redacted)
+}
+
+// RedactAMQPAddress masks password of the given AMQP address.
+func RedactAMQPAddress(addr string) string {
+	u, err := url.Parse(addr)
+	if err != nil || u.User == nil {
+		return addr
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), Redacted)
+	}
+	return u.String()
+}
+
+// redactRegexps match secrets between the first group and the second
+// one if any, the groups are kept in place.
+var (
+	sigRegexp = regexp.MustCompile(`(sig=)[^&;\s"]+`)
+
+	redactRegexps = []*regexp.Regexp{
+		sigRegexp,
+		regexp.MustCompile(`(SharedAccessKey=)[^;\s"]+`),
+		regexp.MustCompile(`("(?:primaryKey|secondaryKey)":\s*")[^"]*`),
+		regexp.MustCompile(`(amqps?://[^:/@\s]+:)[^@\s/]+(@)`),
+	}
+)
+
+// Redact masks all known secrets in an arbitrary text: SAS signatures,
+// shared access keys, symmetric keys in JSON documents and AMQP passwords.
+func Redact(s string) string {
+	for _, re := range redactRegexps {
+		s = re.ReplaceAllString(s, "${1}"+Redacted+"${2}")
+	}
+	return s
+}
@@ -325,8 +325,8 @@ func testCloudToDevice(t *testing.T, opts ...iotdevice.ClientOption) {
 		// test feedback is received
 		select {
 		case fb := <-fbsc:
-			if fb.StatusCode != "Success" {
-				t.Errorf("feedback status = %q, want %q", fb.StatusCode, "Success")
+			if fb.StatusCode != iotservice.FeedbackSuccess {
+				t.Errorf("feedback status = %q, want %q", fb.StatusCode, iotservice.FeedbackSuccess)
 			}
 		case <-time.After(30 * time.Second):
 			t.Fatal("feedback timed out")
@@ -449,8 +449,9 @@ func testDirectMethod(t *testing.T, opts ...iotdevice.ClientOption) {
 			"a": 1.5,
 			"b": 3,
 		},
-			iotservice.WithCallConnectTimeout(0),
-			iotservice.WithCallResponseTimeout(5),
+			iotservice.WithCallOptions(iotservice.MethodCallOptions{
+				ResponseTimeout: 5 * time.Second,
+			}),
 		)
 		if err != nil {
 			errc <- err